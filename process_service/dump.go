@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"process_service/internal/daemon"
+	"process_service/internal/repository"
+)
+
+// stateDump is the on-box triage snapshot written on SIGUSR1
+type stateDump struct {
+	DumpedAt     time.Time                     `json:"dumped_at"`
+	Accounting   daemon.Accounting             `json:"accounting"`
+	NotProcessed []repository.NotProcessedTask `json:"not_processed"`
+}
+
+// notProcessedLister is the part of repository.Service a dump reads
+type notProcessedLister interface {
+	GetNotProcessedTasks() []repository.NotProcessedTask
+}
+
+// accounter is the part of daemon.Daemon a dump reads
+type accounter interface {
+	Accounting() daemon.Accounting
+}
+
+// dumpState writes the in-memory not processed tasks and the task counts as JSON
+// to a timestamped file in dir, or to stderr when dir is empty
+func dumpState(dir string, repo notProcessedLister, d accounter, now time.Time) error {
+	dump := stateDump{
+		DumpedAt:     now,
+		Accounting:   d.Accounting(),
+		NotProcessed: repo.GetNotProcessedTasks(),
+	}
+	if dir == "" {
+		return writeDump(os.Stderr, dump)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("not_processed_%s.json", now.UTC().Format("20060102T150405Z")))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeDump(f, dump); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"file": path, "not_processed": len(dump.NotProcessed)}).Info("dumped not processed tasks")
+	return nil
+}
+
+func writeDump(w io.Writer, dump stateDump) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
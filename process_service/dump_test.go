@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"process_service/internal/daemon"
+	"process_service/internal/repository"
+)
+
+type fakeNotProcessed []repository.NotProcessedTask
+
+func (f fakeNotProcessed) GetNotProcessedTasks() []repository.NotProcessedTask { return f }
+
+type fakeAccounter daemon.Accounting
+
+func (f fakeAccounter) Accounting() daemon.Accounting { return daemon.Accounting(f) }
+
+func TestDumpState(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	repo := fakeNotProcessed{
+		{TaskID: "a", Reason: "timeout", At: now.Add(-time.Minute)},
+		{TaskID: "b", Reason: "dead letter", At: now},
+	}
+	counts := fakeAccounter{Received: 5, Active: 1, Processed: 2, NotProcessed: 2}
+	want := stateDump{DumpedAt: now, Accounting: daemon.Accounting(counts), NotProcessed: repo}
+
+	check := func(t *testing.T, data []byte) {
+		t.Helper()
+		var got stateDump
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("dump is not JSON: %v\n%s", err, data)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("dump = %+v, want %+v", got, want)
+		}
+	}
+
+	t.Run("file", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := dumpState(dir, repo, counts, now); err != nil {
+			t.Fatalf("dumpState() = %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "not_processed_20250102T150405Z.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, data)
+	})
+
+	t.Run("stderr", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stderr := os.Stderr
+		os.Stderr = w
+		t.Cleanup(func() { os.Stderr = stderr })
+
+		err = dumpState("", repo, counts, now)
+		w.Close()
+		if err != nil {
+			t.Fatalf("dumpState() = %v", err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, data)
+	})
+
+	t.Run("missing dir", func(t *testing.T) {
+		if err := dumpState(filepath.Join(t.TempDir(), "missing"), repo, counts, now); err == nil {
+			t.Error("dumpState() into a missing dir = nil, want an error")
+		}
+	})
+}
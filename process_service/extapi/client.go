@@ -2,14 +2,40 @@ package extapi
 
 import (
 	"context"
+	"errors"
 	"math/rand"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	_ "net/http/pprof"
+
+	"process_service/internal/logctx"
+	"process_service/internal/retry"
 )
 
+type quietSuccessKey struct{}
+
+// WithoutSuccessLog marks the call so its successful completion is not logged,
+// failures and cancellations are logged anyway.
+func WithoutSuccessLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, quietSuccessKey{}, true)
+}
+
+func logSuccess(ctx context.Context) bool {
+	quiet, _ := ctx.Value(quietSuccessKey{}).(bool)
+	return !quiet
+}
+
+// taskLogger is the task's entry from ctx, or one tagged from the call arguments without it
+func taskLogger(ctx context.Context, taskID string, workerID int) *log.Entry {
+	if logger, ok := logctx.LoggerFromContext(ctx); ok {
+		return logger
+	}
+	return log.WithFields(log.Fields{"workerId": workerID, "taskId": taskID})
+}
+
 type CustomError struct {
 	Msg string
 }
@@ -21,28 +47,132 @@ func (e *CustomError) Error() string {
 type ExternalAPIImplementation struct {
 }
 
+type Config struct {
+	// Seed makes simulated failures and latencies reproducible, 0 seeds from the clock
+	Seed int64 `mapstructure:"seed"`
+	// Hosts spreads calls over downstream base URLs by weight, empty calls the single default API
+	Hosts []HostConfig `mapstructure:"hosts"`
+	// UnhealthyAfter errors in a row take a host out of rotation for RecoverAfter
+	UnhealthyAfter int           `mapstructure:"unhealthy_after"`
+	RecoverAfter   time.Duration `mapstructure:"recover_after"`
+	// Retry retries failed calls, each attempt may go to another host. Unset calls once.
+	Retry retry.Policy `mapstructure:"retry"`
+}
+
 type Client struct {
 	API *ExternalAPIImplementation
+
+	// rng is not safe for concurrent use, rngMux guards it
+	rngMux *sync.Mutex
+	rng    *rand.Rand
+	// hosts is nil without configured hosts
+	hosts *hostPool
+	retry retry.Policy
 }
 
 func New() *Client {
+	return NewWithSeed(time.Now().UnixNano())
+}
+
+// NewWithSeed returns a client whose sequence of failures and latencies depends only on seed.
+func NewWithSeed(seed int64) *Client {
 	return &Client{
-		API: &ExternalAPIImplementation{},
+		API:    &ExternalAPIImplementation{},
+		rngMux: &sync.Mutex{},
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// NewFromConfig seeds the client from config, a nil config or zero seed is random.
+func NewFromConfig(conf *Config) *Client {
+	var c *Client
+	if conf == nil || conf.Seed == 0 {
+		c = New()
+	} else {
+		c = NewWithSeed(conf.Seed)
+	}
+	c.hosts = newHostPool(conf)
+	if conf != nil {
+		c.retry = conf.Retry
 	}
+	return c
 }
 
+// simulate picks the host and draws the latency and whether the call fails
+func (c *Client) simulate() (*host, time.Duration, bool) {
+	c.rngMux.Lock()
+	defer c.rngMux.Unlock()
+	var h *host
+	if c.hosts != nil {
+		h = c.hosts.pick(c.rng)
+	}
+	return h, time.Duration(1000+c.rng.Intn(10000)) * time.Millisecond, c.rng.Intn(10) == 0
+}
+
+// HostStats returns the per host call counters, nil without configured hosts.
+func (c *Client) HostStats() []HostStats {
+	if c.hosts == nil {
+		return nil
+	}
+	return c.hosts.stats()
+}
+
+// report counts the result against the host, cancelled calls say nothing about it
+func (c *Client) report(h *host, err error) {
+	if h != nil {
+		c.hosts.report(h, err)
+	}
+}
+
+func hostURL(h *host) string {
+	if h == nil {
+		return ""
+	}
+	return h.url
+}
+
+// Close releases the caller's resources, the simulated API holds none. The per host
+// counters are logged, as the client is closed when it is replaced or on shutdown.
+func (c *Client) Close(_ context.Context) error {
+	for _, stats := range c.HostStats() {
+		log.WithFields(log.Fields{"host": stats.URL, "successes": stats.Successes, "errors": stats.Errors, "healthy": stats.Healthy}).Info("External API host stats")
+	}
+	return nil
+}
+
+// GetSomething calls the API, failed calls are retried by the client's retry policy
 func (c *Client) GetSomething(ctx context.Context, taskID string, workerID int) error {
+	logger := taskLogger(ctx, taskID, workerID)
+	attempt := 0
+	return c.retry.Do(ctx, func() error {
+		attempt++
+		err := c.call(ctx, logger)
+		var customErr *CustomError
+		if errors.As(err, &customErr) && attempt < c.retry.Attempts() {
+			logger.WithFields(log.Fields{"attempt": attempt, "error": customErr.Msg}).Debug("External API call failed, retrying")
+		}
+		return err
+	})
+}
+
+func (c *Client) call(ctx context.Context, logger *log.Entry) error {
 	startedAt := time.Now()
-	sleepDuration := time.Duration(1000+rand.Intn(10000)) * time.Millisecond
-	if rand.Intn(10) == 0 {
-		return &CustomError{Msg: "External API simulated failure"}
+	h, sleepDuration, fail := c.simulate()
+	if fail {
+		err := &CustomError{Msg: "External API simulated failure"}
+		c.report(h, err)
+		return err
 	}
 	select {
 	case <-ctx.Done():
-		log.WithFields(log.Fields{"workerId": workerID, "taskId": taskID, "duration": time.Since(startedAt)}).Info("External API call cancelled by context")
+		logger.WithFields(log.Fields{"host": hostURL(h), "duration": time.Since(startedAt)}).Info("External API call cancelled by context")
 		return ctx.Err()
 	case <-time.After(sleepDuration):
-		log.WithFields(log.Fields{"workerId": workerID, "taskId": taskID, "duration": time.Since(startedAt)}).Info("External API call completed")
+		c.report(h, nil)
+		if !logSuccess(ctx) {
+			return nil
+		}
+		logger.WithFields(log.Fields{"host": hostURL(h), "duration": time.Since(startedAt)}).Info("External API call completed")
 		return nil
 	}
 }
@@ -0,0 +1,60 @@
+package extapi
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"process_service/internal/logctx"
+)
+
+func TestTaskLogger(t *testing.T) {
+	entry := log.WithFields(log.Fields{"workerId": 1, "taskId": "from-context"})
+	ctx := logctx.WithLogger(context.Background(), entry)
+	if got := taskLogger(ctx, "from-args", 2); got != entry {
+		t.Errorf("taskLogger with a task entry = %v, want the entry of the context", got.Data)
+	}
+
+	got := taskLogger(context.Background(), "from-args", 2)
+	if got.Data["taskId"] != "from-args" || got.Data["workerId"] != 2 {
+		t.Errorf("taskLogger without a task entry has fields %v, want the call arguments", got.Data)
+	}
+}
+
+func TestWithoutSuccessLog(t *testing.T) {
+	if !logSuccess(context.Background()) {
+		t.Error("logSuccess() = false, want success logged by default")
+	}
+	if logSuccess(WithoutSuccessLog(context.Background())) {
+		t.Error("logSuccess() = true after WithoutSuccessLog, want false")
+	}
+}
+
+// draws returns the next n simulated latencies and failures of the client
+func draws(c *Client, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		_, latency, fail := c.simulate()
+		out[i] = fmt.Sprintf("%s/%t", latency, fail)
+	}
+	return out
+}
+
+func TestSeededClientsAreReproducible(t *testing.T) {
+	const n = 100
+	a, b := NewWithSeed(42), NewFromConfig(&Config{Seed: 42})
+	gotA, gotB := draws(a, n), draws(b, n)
+	if !slices.Equal(gotA, gotB) {
+		t.Errorf("clients seeded alike differ:\n%v\n%v", gotA, gotB)
+	}
+	if !slices.ContainsFunc(gotA, func(s string) bool { return strings.HasSuffix(s, "/true") }) {
+		t.Errorf("no failure in %d calls, want some simulated failures", n)
+	}
+	if other := draws(NewWithSeed(7), n); slices.Equal(gotA, other) {
+		t.Error("clients seeded differently produce the same sequence")
+	}
+}
@@ -0,0 +1,127 @@
+package extapi
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	_defaultUnhealthyAfter = 3
+	_defaultRecoverAfter   = 30 * time.Second
+)
+
+// HostConfig is a downstream base URL with its share of the calls
+type HostConfig struct {
+	URL string `mapstructure:"url"`
+	// Weight is relative to the other hosts, 0 counts as 1
+	Weight int `mapstructure:"weight"`
+}
+
+// HostStats are the call counters of a host
+type HostStats struct {
+	URL       string `json:"url"`
+	Successes uint64 `json:"successes"`
+	Errors    uint64 `json:"errors"`
+	Healthy   bool   `json:"healthy"`
+}
+
+type host struct {
+	url    string
+	weight int
+
+	successes atomic.Uint64
+	errors    atomic.Uint64
+	// failures counts consecutive errors, downUntil is when an unhealthy host is tried again
+	failures  atomic.Int64
+	downUntil atomic.Int64
+}
+
+// hostPool picks hosts at random by weight, skipping unhealthy ones until they recover
+type hostPool struct {
+	hosts          []*host
+	unhealthyAfter int64
+	recoverAfter   time.Duration
+	now            func() time.Time
+}
+
+func newHostPool(conf *Config) *hostPool {
+	if conf == nil || len(conf.Hosts) == 0 {
+		return nil
+	}
+	p := &hostPool{
+		unhealthyAfter: _defaultUnhealthyAfter,
+		recoverAfter:   _defaultRecoverAfter,
+		now:            time.Now,
+	}
+	if conf.UnhealthyAfter > 0 {
+		p.unhealthyAfter = int64(conf.UnhealthyAfter)
+	}
+	if conf.RecoverAfter > 0 {
+		p.recoverAfter = conf.RecoverAfter
+	}
+	for _, hc := range conf.Hosts {
+		p.hosts = append(p.hosts, &host{url: hc.URL, weight: max(hc.Weight, 1)})
+	}
+	return p
+}
+
+func (h *host) healthy(now time.Time) bool {
+	return now.UnixNano() >= h.downUntil.Load()
+}
+
+// pick draws a healthy host by weight, when all hosts are down it draws among all of them.
+// The rng is guarded by the caller.
+func (p *hostPool) pick(rng *rand.Rand) *host {
+	now := p.now()
+	candidates := make([]*host, 0, len(p.hosts))
+	total := 0
+	for _, h := range p.hosts {
+		if h.healthy(now) {
+			candidates = append(candidates, h)
+			total += h.weight
+		}
+	}
+	if len(candidates) == 0 {
+		candidates, total = p.hosts, 0
+		for _, h := range p.hosts {
+			total += h.weight
+		}
+	}
+	n := rng.Intn(total)
+	for _, h := range candidates {
+		if n < h.weight {
+			return h
+		}
+		n -= h.weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+// report counts the call result, unhealthyAfter errors in a row take the host out for recoverAfter
+func (p *hostPool) report(h *host, err error) {
+	if err == nil {
+		h.successes.Add(1)
+		h.failures.Store(0)
+		return
+	}
+	h.errors.Add(1)
+	if h.failures.Add(1) >= p.unhealthyAfter {
+		h.failures.Store(0)
+		h.downUntil.Store(p.now().Add(p.recoverAfter).UnixNano())
+	}
+}
+
+func (p *hostPool) stats() []HostStats {
+	now := p.now()
+	stats := make([]HostStats, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		stats = append(stats, HostStats{
+			URL:       h.url,
+			Successes: h.successes.Load(),
+			Errors:    h.errors.Load(),
+			Healthy:   h.healthy(now),
+		})
+	}
+	return stats
+}
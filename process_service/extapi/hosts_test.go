@@ -0,0 +1,98 @@
+package extapi
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestPickByWeight(t *testing.T) {
+	const picks = 100000
+	p := newHostPool(&Config{Hosts: []HostConfig{
+		{URL: "http://a", Weight: 1},
+		{URL: "http://b", Weight: 3},
+		{URL: "http://c", Weight: 6},
+		// 0 counts as 1
+		{URL: "http://d"},
+	}})
+	rng := rand.New(rand.NewSource(1))
+
+	counts := make(map[string]int)
+	for range picks {
+		counts[p.pick(rng).url]++
+	}
+	want := map[string]float64{"http://a": 1.0 / 11, "http://b": 3.0 / 11, "http://c": 6.0 / 11, "http://d": 1.0 / 11}
+	for url, share := range want {
+		if got := float64(counts[url]) / picks; math.Abs(got-share) > 0.01 {
+			t.Errorf("%s got %.3f of the picks, want %.3f", url, got, share)
+		}
+	}
+}
+
+func TestUnhealthyHostIsSkippedUntilItRecovers(t *testing.T) {
+	now := time.Now()
+	p := newHostPool(&Config{
+		Hosts:          []HostConfig{{URL: "http://a"}, {URL: "http://b"}},
+		UnhealthyAfter: 2,
+		RecoverAfter:   time.Minute,
+	})
+	p.now = func() time.Time { return now }
+	a := p.hosts[0]
+	rng := rand.New(rand.NewSource(1))
+
+	// picked reports whether a is drawn in many picks
+	picked := func() bool {
+		for range 1000 {
+			if p.pick(rng) == a {
+				return true
+			}
+		}
+		return false
+	}
+
+	p.report(a, errors.New("unavailable"))
+	if !picked() {
+		t.Fatal("a host is skipped after a single error")
+	}
+	p.report(a, errors.New("unavailable"))
+	if picked() {
+		t.Fatal("an unhealthy host is picked")
+	}
+	if stats := p.stats(); stats[0].Healthy || stats[0].Errors != 2 {
+		t.Errorf("stats = %+v, want a unhealthy with 2 errors", stats[0])
+	}
+
+	now = now.Add(time.Minute - time.Second)
+	if picked() {
+		t.Fatal("an unhealthy host is picked before recover_after elapsed")
+	}
+	now = now.Add(time.Second)
+	if !picked() {
+		t.Fatal("a host is not picked again after recover_after elapsed")
+	}
+	if !p.stats()[0].Healthy {
+		t.Error("a recovered host is reported unhealthy")
+	}
+}
+
+func TestPickWhenAllHostsAreDown(t *testing.T) {
+	p := newHostPool(&Config{Hosts: []HostConfig{{URL: "http://a"}}, UnhealthyAfter: 1})
+	a := p.hosts[0]
+	p.report(a, errors.New("unavailable"))
+	if got := p.pick(rand.New(rand.NewSource(1))); got != a {
+		t.Errorf("pick() = %v, want the only host although it is down", got)
+	}
+}
+
+func TestSuccessResetsFailures(t *testing.T) {
+	p := newHostPool(&Config{Hosts: []HostConfig{{URL: "http://a"}}, UnhealthyAfter: 2})
+	a := p.hosts[0]
+	p.report(a, errors.New("unavailable"))
+	p.report(a, nil)
+	p.report(a, errors.New("unavailable"))
+	if stats := p.stats()[0]; !stats.Healthy || stats.Successes != 1 || stats.Errors != 2 {
+		t.Errorf("stats = %+v, want a healthy host with 1 success and 2 errors", stats)
+	}
+}
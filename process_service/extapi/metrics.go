@@ -0,0 +1,44 @@
+package extapi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HostCollector exports the host counters of the current client. They are read on
+// every scrape, so a client replaced on a hot reload is picked up and its counters
+// start over.
+type HostCollector struct {
+	stats   func() []HostStats
+	calls   *prometheus.Desc
+	healthy *prometheus.Desc
+}
+
+// NewHostCollector returns a collector of the hosts stats reports, stats may return nil.
+func NewHostCollector(stats func() []HostStats) *HostCollector {
+	return &HostCollector{
+		stats: stats,
+		calls: prometheus.NewDesc("extapi_host_calls_total",
+			"The total number of external API calls by host and result.", []string{"host", "result"}, nil),
+		healthy: prometheus.NewDesc("extapi_host_healthy",
+			"Whether the external API host takes calls, 0 while it is out after too many errors.", []string{"host"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *HostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.calls
+	ch <- c.healthy
+}
+
+// Collect implements prometheus.Collector.
+func (c *HostCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range c.stats() {
+		ch <- prometheus.MustNewConstMetric(c.calls, prometheus.CounterValue, float64(stats.Successes), stats.URL, "success")
+		ch <- prometheus.MustNewConstMetric(c.calls, prometheus.CounterValue, float64(stats.Errors), stats.URL, "error")
+		healthy := 0.0
+		if stats.Healthy {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.healthy, prometheus.GaugeValue, healthy, stats.URL)
+	}
+}
@@ -0,0 +1,39 @@
+package extapi
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHostCollector(t *testing.T) {
+	c := NewFromConfig(&Config{Hosts: []HostConfig{{URL: "http://a"}, {URL: "http://b"}}, UnhealthyAfter: 1})
+	a, b := c.hosts.hosts[0], c.hosts.hosts[1]
+	c.hosts.report(a, nil)
+	c.hosts.report(a, nil)
+	c.hosts.report(b, errors.New("api error"))
+
+	want := `
+# HELP extapi_host_calls_total The total number of external API calls by host and result.
+# TYPE extapi_host_calls_total counter
+extapi_host_calls_total{host="http://a",result="error"} 0
+extapi_host_calls_total{host="http://a",result="success"} 2
+extapi_host_calls_total{host="http://b",result="error"} 1
+extapi_host_calls_total{host="http://b",result="success"} 0
+# HELP extapi_host_healthy Whether the external API host takes calls, 0 while it is out after too many errors.
+# TYPE extapi_host_healthy gauge
+extapi_host_healthy{host="http://a"} 1
+extapi_host_healthy{host="http://b"} 0
+`
+	if err := testutil.CollectAndCompare(NewHostCollector(c.HostStats), strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHostCollectorWithoutHosts(t *testing.T) {
+	if n := testutil.CollectAndCount(NewHostCollector(New().HostStats)); n != 0 {
+		t.Errorf("collected %d metrics without hosts, want 0", n)
+	}
+}
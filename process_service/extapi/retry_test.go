@@ -0,0 +1,33 @@
+package extapi
+
+import (
+	"testing"
+	"time"
+
+	"process_service/internal/retry"
+)
+
+func TestNewFromConfigRetryPolicy(t *testing.T) {
+	own := retry.Policy{MaxAttempts: 4, BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.1}
+	tests := []struct {
+		name         string
+		conf         *Config
+		want         retry.Policy
+		wantAttempts int
+	}{
+		{"own policy", &Config{Seed: 1, Retry: own}, own, 4},
+		{"no retry section", &Config{Seed: 1}, retry.Policy{}, 1},
+		{"no config", nil, retry.Policy{}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewFromConfig(tt.conf)
+			if c.retry != tt.want {
+				t.Errorf("retry = %+v, want %+v", c.retry, tt.want)
+			}
+			if got := c.retry.Attempts(); got != tt.wantAttempts {
+				t.Errorf("Attempts() = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
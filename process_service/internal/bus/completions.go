@@ -0,0 +1,31 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// completedKey is a list of the latest completions, newest first, read by the submit service
+const completedKey = "tasks:completed"
+
+// Completion is when a task finished successfully
+type Completion struct {
+	TaskID      uuid.UUID `json:"task_id"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// RecordCompletion pushes the completion to the recent list and trims it to keep entries.
+func (s *TaskStates) RecordCompletion(ctx context.Context, c Completion, keep int) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	pipe := s.Client.TxPipeline()
+	pipe.LPush(ctx, completedKey, b)
+	pipe.LTrim(ctx, completedKey, 0, int64(keep)-1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,47 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRecordCompletion(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	rdb.Del(ctx, completedKey)
+	t.Cleanup(func() { rdb.Del(ctx, completedKey) })
+
+	states := NewTaskStates(rdb)
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	var recorded []Completion
+	for i := range 3 {
+		c := Completion{TaskID: uuid.New(), CompletedAt: start.Add(time.Duration(i) * time.Second)}
+		if err := states.RecordCompletion(ctx, c, 2); err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, c)
+	}
+
+	raw, err := rdb.LRange(ctx, completedKey, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newest first, trimmed to the 2 kept
+	want := []Completion{recorded[2], recorded[1]}
+	if len(raw) != len(want) {
+		t.Fatalf("%d completions kept, want %d", len(raw), len(want))
+	}
+	for i, entry := range raw {
+		var got Completion
+		if err := json.Unmarshal([]byte(entry), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.TaskID != want[i].TaskID || !got.CompletedAt.Equal(want[i].CompletedAt) {
+			t.Errorf("completion %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// DeadLetterFunc is called with the id of a task given up after too many deliveries
+type DeadLetterFunc func(ctx context.Context, taskID uuid.UUID, reason string)
+
+// SetMaxDeliveries makes the consumer give up on a reclaimed task delivered more than max times,
+// it goes to the DLQ and onDeadLetter is called. 0 retries a failing task forever.
+func (c *Consumer) SetMaxDeliveries(max int, onDeadLetter DeadLetterFunc) {
+	c.maxDeliveries = int64(max)
+	c.onDeadLetter = onDeadLetter
+}
+
+// exceedsDeliveries reports whether a task delivered that many times is given up
+func exceedsDeliveries(deliveries, max int64) bool {
+	return max > 0 && deliveries > max
+}
+
+// deadLetterExhausted sends reclaimed messages past the max deliveries to the DLQ, acks
+// them and returns the remaining ones
+func (c *Consumer) deadLetterExhausted(ctx context.Context, stream, consumerName string, messages []redis.XMessage) ([]redis.XMessage, error) {
+	if c.maxDeliveries <= 0 || len(messages) == 0 {
+		return messages, nil
+	}
+	kept := messages[:0]
+	for _, message := range messages {
+		pending, err := c.Client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   stream,
+			Group:    groupName,
+			Start:    message.ID,
+			End:      message.ID,
+			Count:    1,
+			Consumer: consumerName,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		if len(pending) == 0 || !exceedsDeliveries(pending[0].RetryCount, c.maxDeliveries) {
+			kept = append(kept, message)
+			continue
+		}
+
+		reason := fmt.Sprintf("delivered %d times, max deliveries is %d", pending[0].RetryCount, c.maxDeliveries)
+		// without a DLQ the task is still given up, only its payload is not kept
+		if c.dlqWriter != nil {
+			payload, _ := message.Values["payload"].(string)
+			if err := c.sendToDLQ(ctx, message.ID, []byte(payload), reason); err != nil {
+				return nil, err
+			}
+		}
+		if taskID, ok := extractTaskUUID(message); ok && c.onDeadLetter != nil {
+			c.onDeadLetter(ctx, taskID, reason)
+		}
+		if err := c.ackMessage(ctx, stream, message.ID); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
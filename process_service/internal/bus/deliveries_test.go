@@ -0,0 +1,114 @@
+package bus
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"process_service/internal/domain"
+)
+
+func TestExceedsDeliveries(t *testing.T) {
+	tests := []struct {
+		name       string
+		deliveries int64
+		max        int64
+		want       bool
+	}{
+		{"below the max", 3, 5, false},
+		{"at the max", 5, 5, false},
+		{"past the max", 6, 5, true},
+		{"unlimited", 1000, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exceedsDeliveries(tt.deliveries, tt.max); got != tt.want {
+				t.Errorf("exceedsDeliveries(%d, %d) = %v, want %v", tt.deliveries, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+// testRedis connects to the Redis in TEST_REDIS_ADDR, the test is skipped without it
+func testRedis(t testing.TB) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR is not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { rdb.Close() })
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("redis at %s is not reachable: %v", addr, err)
+	}
+	return rdb
+}
+
+func TestAtLeastOnceDeadLettersAfterMaxDeliveries(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	stream := StreamName(0, 1)
+	rdb.Del(ctx, stream)
+	t.Cleanup(func() { rdb.Del(ctx, stream) })
+
+	c := NewConsumer(rdb, nil, 1, 1, AtLeastOnce)
+	if err := c.EnsureGroups(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var deadLettered []uuid.UUID
+	c.SetMaxDeliveries(2, func(_ context.Context, taskID uuid.UUID, _ string) {
+		deadLettered = append(deadLettered, taskID)
+	})
+
+	taskID := uuid.New()
+	if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]any{"id": taskID.String(), "payload": "{}"}}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	failing := func(context.Context, ExternalAPICaller, int, *domain.Task) error {
+		calls++
+		return context.DeadlineExceeded
+	}
+	// the first read delivers it once, every reclaim once more
+	if err := c.ConsumeTasks(ctx, nil, 0, failing); err == nil {
+		t.Fatal("expected the handler error")
+	}
+	for i := 0; i < 2; i++ {
+		// make the pending message idle long enough to be reclaimed, JUSTID keeps its delivery count
+		for _, id := range pendingIDs(t, rdb, stream) {
+			idle := strconv.FormatInt(reclaimMinIdle.Milliseconds(), 10)
+			if err := rdb.Do(ctx, "XCLAIM", stream, groupName, "worker-0", "0", id, "IDLE", idle, "JUSTID").Err(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = c.ConsumeTasks(ctx, nil, 0, failing)
+	}
+
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+	if len(deadLettered) != 1 || deadLettered[0] != taskID {
+		t.Errorf("dead lettered %v, want [%s]", deadLettered, taskID)
+	}
+	if ids := pendingIDs(t, rdb, stream); len(ids) != 0 {
+		t.Errorf("%d messages still pending", len(ids))
+	}
+}
+
+func pendingIDs(t *testing.T, rdb *redis.Client, stream string) []string {
+	t.Helper()
+	pending, err := rdb.XPendingExt(context.Background(), &redis.XPendingExtArgs{Stream: stream, Group: groupName, Start: "-", End: "+", Count: 10}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(pending))
+	for _, p := range pending {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
@@ -0,0 +1,47 @@
+package bus
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+
+	"process_service/internal/domain"
+)
+
+// Mode returns when the consumer acknowledges tasks
+func (c *Consumer) Mode() DeliveryMode {
+	return c.mode
+}
+
+// Ack acknowledges the task's stream message, a task not read from a stream is skipped
+func (c *Consumer) Ack(ctx context.Context, task *domain.Task) error {
+	if task.MessageID == "" {
+		return nil
+	}
+	return c.ackMessage(ctx, task.Stream, task.MessageID)
+}
+
+// KeepPending resets the idle time of the held tasks' messages, so workers do not reclaim
+// them while they wait. Messages of a crashed or stopped instance are not refreshed and
+// are delivered again once they were idle long enough.
+func (c *Consumer) KeepPending(ctx context.Context, tasks []*domain.Task) error {
+	ids := make(map[string][]string)
+	for _, task := range tasks {
+		if task.MessageID != "" {
+			ids[task.Stream] = append(ids[task.Stream], task.MessageID)
+		}
+	}
+	for stream, messages := range ids {
+		// JUSTID leaves the delivery count alone, so held tasks do not reach max deliveries
+		err := c.Client.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    groupName,
+			Consumer: heldConsumer,
+			Messages: messages,
+		}).Err()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+	}
+	return nil
+}
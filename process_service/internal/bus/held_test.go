@@ -0,0 +1,64 @@
+package bus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"process_service/internal/domain"
+)
+
+func TestHeldTaskStaysPendingUntilAcked(t *testing.T) {
+	for _, mode := range []DeliveryMode{AtMostOnce, AtLeastOnce} {
+		t.Run(string(mode), func(t *testing.T) {
+			rdb := testRedis(t)
+			ctx := context.Background()
+			stream := StreamName(0, 1)
+			rdb.Del(ctx, stream)
+			t.Cleanup(func() { rdb.Del(ctx, stream) })
+
+			c := NewConsumer(rdb, nil, 1, 1, mode)
+			if err := c.EnsureGroups(ctx); err != nil {
+				t.Fatal(err)
+			}
+			values := map[string]any{"id": uuid.NewString(), "payload": "{}", "depends_on": uuid.NewString()}
+			if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: values}).Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			var held *domain.Task
+			hold := func(_ context.Context, _ ExternalAPICaller, _ int, task *domain.Task) error {
+				held = task
+				return ErrHeld
+			}
+			if err := c.ConsumeTasks(ctx, nil, 0, hold); err != nil {
+				t.Fatal(err)
+			}
+			if held == nil || held.MessageID == "" {
+				t.Fatalf("handler got %+v, want a task with its message id", held)
+			}
+			if ids := pendingIDs(t, rdb, stream); len(ids) != 1 || ids[0] != held.MessageID {
+				t.Fatalf("pending %v, want the held task", ids)
+			}
+
+			if err := c.KeepPending(ctx, []*domain.Task{held}); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Ack(ctx, held); err != nil {
+				t.Fatal(err)
+			}
+			if ids := pendingIDs(t, rdb, stream); len(ids) != 0 {
+				t.Errorf("pending %v after the ack, want none", ids)
+			}
+		})
+	}
+}
+
+func TestAckSkipsTaskWithoutMessage(t *testing.T) {
+	c := NewConsumer(nil, nil, 1, 1, AtMostOnce)
+	if err := c.Ack(context.Background(), &domain.Task{ID: uuid.New()}); err != nil {
+		t.Errorf("Ack() = %v, want nil", err)
+	}
+}
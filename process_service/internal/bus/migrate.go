@@ -0,0 +1,143 @@
+package bus
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// migrateConsumer owns the messages of other streams while they are moved
+	migrateConsumer = "migration"
+	// migrateBatch is how many messages are moved per read
+	migrateBatch = 100
+)
+
+// MigrateStreams moves tasks left in the streams of another shard count to the current
+// streams, e.g. the plain "tasks" stream after sharding was enabled. Tasks which were
+// never delivered are moved, and so are tasks pending longer than reclaimMinIdle, which
+// no worker reclaims anymore. It returns the number of moved tasks.
+func (c *Consumer) MigrateStreams(ctx context.Context) (int, error) {
+	streams, err := c.otherStreams(ctx)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, stream := range streams {
+		n, err := c.migrateStream(ctx, stream)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// otherStreams returns the existing task streams which do not belong to the current shard count
+func (c *Consumer) otherStreams(ctx context.Context) ([]string, error) {
+	current := make(map[string]bool, c.shards)
+	for i := 0; i < c.shards; i++ {
+		current[StreamName(i, c.shards)] = true
+	}
+
+	candidates := []string{StreamName(0, 1)}
+	iter := c.Client.ScanType(ctx, 0, shardStreamPrefix+"*", migrateBatch, "stream").Iterator()
+	for iter.Next(ctx) {
+		candidates = append(candidates, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	var streams []string
+	for _, stream := range candidates {
+		if current[stream] {
+			continue
+		}
+		n, err := c.Client.Exists(ctx, stream).Result()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			streams = append(streams, stream)
+		}
+	}
+	return streams, nil
+}
+
+// migrateStream moves the abandoned pending tasks of the stream first and then the undelivered ones
+func (c *Consumer) migrateStream(ctx context.Context, stream string) (int, error) {
+	// "0" makes a group created here deliver everything already in the stream
+	err := c.Client.XGroupCreate(ctx, stream, groupName, "0").Err()
+	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		return 0, err
+	}
+
+	moved := 0
+	start := "0-0"
+	for {
+		messages, next, err := c.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    groupName,
+			Consumer: migrateConsumer,
+			MinIdle:  reclaimMinIdle,
+			Start:    start,
+			Count:    migrateBatch,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return moved, err
+		}
+		if err := c.moveMessages(ctx, stream, messages); err != nil {
+			return moved, err
+		}
+		moved += len(messages)
+		if next == "0-0" || next == "" {
+			break
+		}
+		start = next
+	}
+
+	for {
+		res, err := c.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    groupName,
+			Consumer: migrateConsumer,
+			Streams:  []string{stream, ">"},
+			Count:    migrateBatch,
+			Block:    -1,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return moved, err
+		}
+		if len(res) == 0 || len(res[0].Messages) == 0 {
+			return moved, nil
+		}
+		if err := c.moveMessages(ctx, stream, res[0].Messages); err != nil {
+			return moved, err
+		}
+		moved += len(res[0].Messages)
+	}
+}
+
+// moveMessages adds the messages to the shard streams of their tasks and removes them
+// from the old stream in one transaction, so a task is neither lost nor duplicated
+func (c *Consumer) moveMessages(ctx context.Context, from string, messages []redis.XMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	_, err := c.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		ids := make([]string, 0, len(messages))
+		for _, message := range messages {
+			// a message without a valid task id goes to the first shard, workers dead letter it
+			taskID, _ := extractTaskUUID(message)
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: StreamName(ShardFor(taskID, c.shards), c.shards),
+				Values: message.Values,
+			})
+			ids = append(ids, message.ID)
+		}
+		pipe.XAck(ctx, from, groupName, ids...)
+		pipe.XDel(ctx, from, ids...)
+		return nil
+	})
+	return err
+}
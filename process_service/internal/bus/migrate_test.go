@@ -0,0 +1,95 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"process_service/internal/domain"
+)
+
+func TestMigrateStreams(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	const shards = 2
+	legacy := StreamName(0, 1)
+	cleanup := func() { rdb.Del(ctx, legacy, StreamName(0, shards), StreamName(1, shards)) }
+	cleanup()
+	t.Cleanup(cleanup)
+
+	addTask := func(id uuid.UUID) {
+		t.Helper()
+		if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: legacy, Values: map[string]any{"id": id.String(), "payload": "{}"}}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := NewConsumer(rdb, nil, 1, 1, AtLeastOnce)
+	if err := old.EnsureGroups(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// a processed task is acked and stays where it is
+	addTask(uuid.New())
+	if err := old.ConsumeTasks(ctx, nil, 0, func(context.Context, ExternalAPICaller, int, *domain.Task) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// a failed task of a stopped worker is pending and idle long enough to be moved
+	abandoned := uuid.New()
+	addTask(abandoned)
+	failing := func(context.Context, ExternalAPICaller, int, *domain.Task) error { return errors.New("failed") }
+	if err := old.ConsumeTasks(ctx, nil, 0, failing); err == nil {
+		t.Fatal("expected the handler error")
+	}
+	for _, id := range pendingIDs(t, rdb, legacy) {
+		idle := strconv.FormatInt(reclaimMinIdle.Milliseconds(), 10)
+		if err := rdb.Do(ctx, "XCLAIM", legacy, groupName, "worker-0", "0", id, "IDLE", idle, "JUSTID").Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []uuid.UUID{abandoned, uuid.New(), uuid.New()}
+	addTask(want[1])
+	addTask(want[2])
+
+	c := NewConsumer(rdb, nil, 1, shards, AtLeastOnce)
+	if err := c.EnsureGroups(ctx); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := c.MigrateStreams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != len(want) {
+		t.Errorf("moved %d tasks, want %d", moved, len(want))
+	}
+
+	for _, id := range want {
+		stream := StreamName(ShardFor(id, shards), shards)
+		messages, err := rdb.XRange(ctx, stream, "-", "+").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, m := range messages {
+			if got, ok := extractTaskUUID(m); ok && got == id {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("task %s is not in %s", id, stream)
+		}
+	}
+	if n, err := rdb.XLen(ctx, legacy).Result(); err != nil || n != 1 {
+		t.Errorf("old stream has %d messages (err %v), want only the processed one", n, err)
+	}
+	if ids := pendingIDs(t, rdb, legacy); len(ids) != 0 {
+		t.Errorf("%d messages still pending in the old stream", len(ids))
+	}
+
+	if moved, err := c.MigrateStreams(ctx); err != nil || moved != 0 {
+		t.Errorf("second migration moved %d tasks (err %v), want 0", moved, err)
+	}
+}
@@ -0,0 +1,51 @@
+package bus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestStreamIDTime(t *testing.T) {
+	if got := streamIDTime("1700000000000-3"); !got.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("streamIDTime() = %v", got)
+	}
+	if got := streamIDTime("garbage"); !got.IsZero() {
+		t.Errorf("streamIDTime(garbage) = %v, want zero", got)
+	}
+}
+
+func TestQueueStatsCountsUndeliveredAndPending(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	stream := StreamName(0, 1)
+	rdb.Del(ctx, stream)
+	t.Cleanup(func() { rdb.Del(ctx, stream) })
+
+	c := NewConsumer(rdb, nil, 1, 1, AtLeastOnce)
+	if err := c.EnsureGroups(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]any{"payload": "{}"}}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// one delivered and not acked yet, two not delivered
+	if _, err := c.readGroup(ctx, "worker-0", []string{stream}, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := c.QueueStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Depth != 3 {
+		t.Errorf("depth = %d, want 3", stats.Depth)
+	}
+	if stats.OldestEnqueuedAt.IsZero() || time.Since(stats.OldestEnqueuedAt) > time.Minute {
+		t.Errorf("oldest enqueued at %v", stats.OldestEnqueuedAt)
+	}
+}
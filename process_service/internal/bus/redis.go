@@ -3,9 +3,12 @@ package bus
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"process_service/internal/dlq"
 	"process_service/internal/domain"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,19 +16,45 @@ import (
 )
 
 const (
-	streamName = "tasks"
-	groupName  = "task_group"
+	groupName = "task_group"
+
+	// reclaimMinIdle is how long a failed task stays pending before it is retried in at-least-once mode
+	reclaimMinIdle = 30 * time.Second
+	// HeldRefreshInterval is how often held tasks are kept pending, well below reclaimMinIdle
+	HeldRefreshInterval = reclaimMinIdle / 3
+	// heldConsumer owns the messages of held tasks while they are kept pending
+	heldConsumer = "held"
+)
+
+// ErrRequeue is returned by a task handler to put the task back to the end of the stream
+var ErrRequeue = errors.New("task requeued")
+
+// ErrHeld is returned by a task handler to leave the task pending in the stream while it
+// waits, it is acked later with Ack
+var ErrHeld = errors.New("task held")
+
+// DeliveryMode defines when a task is acknowledged in the stream.
+type DeliveryMode string
+
+const (
+	// AtMostOnce acknowledges a task before processing, failed tasks are not retried.
+	AtMostOnce DeliveryMode = "at_most_once"
+	// AtLeastOnce acknowledges a task only after successful processing, failed or
+	// interrupted tasks are retried. A task may be processed more than once, e.g. when
+	// the ack fails after a success or a retried task was slow but not failed.
+	AtLeastOnce DeliveryMode = "at_least_once"
 )
 
 type Config struct {
 	RedisAddr string `mapstructure:"redis_addr"`
+	// Shards splits the task queue into that many streams to reduce contention, must match the submit service
+	Shards int `mapstructure:"shards"`
 }
 
 type Producer struct {
 	Client *redis.Client
 }
 
-
 type ExternalAPICaller interface {
 	GetSomething(ctx context.Context, taskID string, workerID int) error
 }
@@ -45,17 +74,34 @@ func (p *Producer) ProduceTask(ctx context.Context, task *domain.Task) error {
 }
 
 type Consumer struct {
-	Client      *redis.Client
-	dlqWriter   dlq.Writer
-	statusHook  InvalidTaskStatusUpdater
+	Client     *redis.Client
+	dlqWriter  dlq.Writer
+	statusHook InvalidTaskStatusUpdater
+	prefetch   int64
+	mode       DeliveryMode
+	shards     int
+
+	maxDeliveries int64
+	onDeadLetter  DeadLetterFunc
 }
 
 type InvalidTaskStatusUpdater interface {
 	MarkTaskInvalid(ctx context.Context, taskID uuid.UUID, failedPayload *string, reason string) error
 }
 
-func NewConsumer(redisClient *redis.Client, dlqWriter dlq.Writer, statusHook ...InvalidTaskStatusUpdater) *Consumer {
-	c := &Consumer{Client: redisClient, dlqWriter: dlqWriter}
+// NewConsumer creates a consumer which reads up to prefetch messages per stream read.
+// With several shards a worker prefers its own shard and steals from the others when it is empty.
+func NewConsumer(redisClient *redis.Client, dlqWriter dlq.Writer, prefetch int, shards int, mode DeliveryMode, statusHook ...InvalidTaskStatusUpdater) *Consumer {
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+	if shards <= 0 {
+		shards = 1
+	}
+	if mode != AtLeastOnce {
+		mode = AtMostOnce
+	}
+	c := &Consumer{Client: redisClient, dlqWriter: dlqWriter, prefetch: int64(prefetch), mode: mode, shards: shards}
 
 	if len(statusHook) > 0 {
 		c.statusHook = statusHook[0]
@@ -64,83 +110,216 @@ func NewConsumer(redisClient *redis.Client, dlqWriter dlq.Writer, statusHook ...
 	return c
 }
 
-func (c *Consumer) ConsumeTasks(ctx context.Context, apiCaller ExternalAPICaller, workerID int, 
+func (c *Consumer) ConsumeTasks(ctx context.Context, apiCaller ExternalAPICaller, workerID int,
 	handler func(ctx context.Context, apiCaller ExternalAPICaller, workerID int, task *domain.Task) error) error {
 	consumerName := fmt.Sprintf("worker-%d", workerID)
+	ownStreams := c.workerStreams(workerID)
+
+	// failed tasks stay pending in at-least-once mode and held tasks in both modes, tasks of
+	// crashed workers or stopped instances are retried before reading new ones
+	for _, stream := range ownStreams {
+		messages, _, err := c.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    groupName,
+			Consumer: consumerName,
+			MinIdle:  reclaimMinIdle,
+			Start:    "0-0",
+			Count:    c.prefetch,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		messages, err = c.deadLetterExhausted(ctx, stream, consumerName, messages)
+		if err != nil {
+			return err
+		}
+		if len(messages) > 0 {
+			return c.handleMessages(ctx, apiCaller, workerID, stream, messages, handler)
+		}
+	}
+
+	// the own shard goes first without blocking, other shards are only read when it is empty
+	if len(ownStreams) > 1 {
+		streams, err := c.readGroup(ctx, consumerName, ownStreams[:1], -1)
+		if err != nil {
+			return err
+		}
+		if len(streams) > 0 && len(streams[0].Messages) > 0 {
+			return c.handleMessages(ctx, apiCaller, workerID, streams[0].Stream, streams[0].Messages, handler)
+		}
+	}
+
+	streams, err := c.readGroup(ctx, consumerName, ownStreams, time.Second)
+	if err != nil {
+		return err
+	}
+	for _, stream := range streams {
+		if err := c.handleMessages(ctx, apiCaller, workerID, stream.Stream, stream.Messages, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	streams, err := c.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+// workerStreams returns the task streams ordered for the worker, its own shard first
+func (c *Consumer) workerStreams(workerID int) []string {
+	own := workerID % c.shards
+	streams := make([]string, 0, c.shards)
+	for i := 0; i < c.shards; i++ {
+		streams = append(streams, StreamName((own+i)%c.shards, c.shards))
+	}
+	return streams
+}
+
+// readGroup reads new messages of the streams, a negative block does not wait
+func (c *Consumer) readGroup(ctx context.Context, consumerName string, streams []string, block time.Duration) ([]redis.XStream, error) {
+	args := make([]string, 0, 2*len(streams))
+	args = append(args, streams...)
+	for range streams {
+		args = append(args, ">")
+	}
+	res, err := c.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
 		Group:    groupName,
 		Consumer: consumerName,
-		Streams:  []string{streamName, ">"},
-		Count:    1,
-		Block:    time.Second,
+		Streams:  args,
+		Count:    c.prefetch,
+		Block:    block,
 	}).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return nil
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return res, err
+}
+
+// EnsureGroups creates the consumer group on every task stream
+func (c *Consumer) EnsureGroups(ctx context.Context) error {
+	for i := 0; i < c.shards; i++ {
+		err := c.Client.XGroupCreateMkStream(ctx, StreamName(i, c.shards), groupName, "$").Err()
+		if err != nil && !errors.Is(err, redis.Nil) && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+			return err
 		}
-		return err
 	}
+	return nil
+}
 
-	for _, stream := range streams {
-		for _, message := range stream.Messages {
-			taskID, hasTaskID := extractTaskUUID(message)
-
-			rawPayload, ok := message.Values["payload"]
-			if !ok {
-				if err := c.handleInvalidPayload(ctx, message, hasTaskID, taskID, nil, "missing payload field"); err != nil {
-					return err
-				}
-				if err := c.ackMessage(ctx, message.ID); err != nil {
-					return err
-				}
-				continue
+func (c *Consumer) handleMessages(ctx context.Context, apiCaller ExternalAPICaller, workerID int, stream string, messages []redis.XMessage,
+	handler func(ctx context.Context, apiCaller ExternalAPICaller, workerID int, task *domain.Task) error) error {
+	// handler errors do not stop the batch, the rest of the prefetched tasks are still processed
+	var handlerErr error
+	for _, message := range messages {
+		taskID, hasTaskID := extractTaskUUID(message)
+
+		rawPayload, ok := message.Values["payload"]
+		if !ok {
+			if err := c.handleInvalidPayload(ctx, message, hasTaskID, taskID, nil, "missing payload field"); err != nil {
+				return err
+			}
+			if err := c.ackMessage(ctx, stream, message.ID); err != nil {
+				return err
 			}
+			continue
+		}
 
-			var payloadBytes []byte
-			switch v := rawPayload.(type) {
-			case string:
-				payloadBytes = []byte(v)
-			case []byte:
-				payloadBytes = v
-			default:
-				if err := c.handleInvalidPayload(ctx, message, hasTaskID, taskID, []byte(fmt.Sprintf("%v", v)), "unsupported payload type"); err != nil {
-					return err
-				}
-				if err := c.ackMessage(ctx, message.ID); err != nil {
-					return err
-				}
-				continue
+		var payloadBytes []byte
+		switch v := rawPayload.(type) {
+		case string:
+			payloadBytes = []byte(v)
+		case []byte:
+			payloadBytes = v
+		default:
+			if err := c.handleInvalidPayload(ctx, message, hasTaskID, taskID, []byte(fmt.Sprintf("%v", v)), "unsupported payload type"); err != nil {
+				return err
+			}
+			if err := c.ackMessage(ctx, stream, message.ID); err != nil {
+				return err
 			}
+			continue
+		}
 
-			task := &domain.Task{}
-			if err := json.Unmarshal(payloadBytes, task); err != nil {
-				if dlqErr := c.handleInvalidPayload(ctx, message, hasTaskID, taskID, payloadBytes, err.Error()); dlqErr != nil {
-					return dlqErr
-				}
-				if err := c.ackMessage(ctx, message.ID); err != nil {
-					return err
-				}
-				continue
+		task := &domain.Task{}
+		if err := json.Unmarshal(payloadBytes, task); err != nil {
+			if dlqErr := c.handleInvalidPayload(ctx, message, hasTaskID, taskID, payloadBytes, err.Error()); dlqErr != nil {
+				return dlqErr
 			}
+			if err := c.ackMessage(ctx, stream, message.ID); err != nil {
+				return err
+			}
+			continue
+		}
 
-			if hasTaskID {
-				task.ID = taskID
+		if hasTaskID {
+			task.ID = taskID
+		}
+		if tags, ok := extractTags(message); ok {
+			task.Tags = tags
+		}
+		if timeout, ok := extractTimeout(message); ok {
+			task.Timeout = timeout
+		}
+		if enqueuedAt, ok := extractEnqueuedAt(message); ok {
+			task.EnqueuedAt = enqueuedAt
+		}
+		task.StreamLogs = message.Values["stream_logs"] == "1"
+		task.Warmup = message.Values["warmup"] == "1"
+		if dependsOn, ok := extractDependsOn(message); ok {
+			task.DependsOn = dependsOn
+		}
+		if file, ok := message.Values["file"].(string); ok && file != "" {
+			task.File = []byte(file)
+		}
+		task.Status = domain.StatusProcessing
+		task.Stream, task.MessageID = stream, message.ID
+
+		// at-most-once acks before processing, so a crash or failure never delivers the task again.
+		// Tasks with dependencies stay pending while they wait, the handler acks them once they may run.
+		acked := false
+		if c.mode != AtLeastOnce && len(task.DependsOn) == 0 {
+			if err := c.ackMessage(ctx, stream, message.ID); err != nil {
+				return err
 			}
-			task.Status = domain.StatusProcessing
-			if err := handler(ctx, apiCaller, workerID, task); err != nil {
+			acked = true
+		}
+		err := handler(ctx, apiCaller, workerID, task)
+		if errors.Is(err, ErrHeld) {
+			continue
+		}
+		if errors.Is(err, ErrRequeue) {
+			if err := c.requeue(ctx, stream, message, !acked); err != nil {
 				return err
 			}
-			if err := c.ackMessage(ctx, message.ID); err != nil {
+			continue
+		}
+		if err != nil {
+			if handlerErr == nil {
+				handlerErr = err
+			}
+			continue
+		}
+		if !acked {
+			if err := c.ackMessage(ctx, stream, message.ID); err != nil {
 				return err
 			}
 		}
 	}
+	return handlerErr
+}
+
+// requeue adds the message again to the stream, ack acks the original one
+func (c *Consumer) requeue(ctx context.Context, stream string, message redis.XMessage, ack bool) error {
+	if err := c.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: message.Values,
+	}).Err(); err != nil {
+		return err
+	}
+	if ack {
+		return c.ackMessage(ctx, stream, message.ID)
+	}
 	return nil
 }
 
-func (c *Consumer) ackMessage(ctx context.Context, messageID string) error {
-	return c.Client.XAck(ctx, streamName, groupName, messageID).Err()
+func (c *Consumer) ackMessage(ctx context.Context, stream, messageID string) error {
+	return c.Client.XAck(ctx, stream, groupName, messageID).Err()
 }
 
 func (c *Consumer) handleInvalidPayload(ctx context.Context, message redis.XMessage, hasTaskID bool, taskID uuid.UUID, payload []byte, reason string) error {
@@ -166,6 +345,61 @@ func (c *Consumer) sendToDLQ(ctx context.Context, messageID string, payload []by
 	return c.dlqWriter.SendInvalidPayload(ctx, messageID, payload, reason)
 }
 
+// extractTags reads the optional JSON encoded tags field set by the submit service
+func extractTags(message redis.XMessage) (map[string]string, bool) {
+	raw, ok := message.Values["tags"].(string)
+	if !ok || raw == "" {
+		return nil, false
+	}
+	tags := make(map[string]string)
+	if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+		return nil, false
+	}
+	return tags, true
+}
+
+// extractTimeout reads the optional per task timeout set by the submit service
+func extractTimeout(message redis.XMessage) (time.Duration, bool) {
+	raw, ok := message.Values["timeout"].(string)
+	if !ok || raw == "" {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return 0, false
+	}
+	return timeout, true
+}
+
+// extractDependsOn reads the optional comma separated dependency ids set by the submit service,
+// malformed ids are skipped
+func extractDependsOn(message redis.XMessage) ([]uuid.UUID, bool) {
+	raw, ok := message.Values["depends_on"].(string)
+	if !ok || raw == "" {
+		return nil, false
+	}
+	var ids []uuid.UUID
+	for _, part := range strings.Split(raw, ",") {
+		if id, err := uuid.Parse(part); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, len(ids) > 0
+}
+
+// extractEnqueuedAt reads the unix nanoseconds enqueue time set by the submit service
+func extractEnqueuedAt(message redis.XMessage) (time.Time, bool) {
+	raw, ok := message.Values["enqueued_at"].(string)
+	if !ok || raw == "" {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
 func extractTaskUUID(message redis.XMessage) (uuid.UUID, bool) {
 	candidates := []string{"id", "ID", "task_id", "taskId"}
 	for _, key := range candidates {
@@ -190,6 +424,3 @@ func extractTaskUUID(message redis.XMessage) (uuid.UUID, bool) {
 
 	return uuid.Nil, false
 }
-
-
-
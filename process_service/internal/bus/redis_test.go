@@ -0,0 +1,146 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"process_service/internal/domain"
+)
+
+func TestConsumeTasksPrefetch(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	stream := StreamName(0, 1)
+	rdb.Del(ctx, stream)
+	t.Cleanup(func() { rdb.Del(ctx, stream) })
+
+	const prefetch = 5
+	c := NewConsumer(rdb, nil, prefetch, 1, AtMostOnce)
+	if err := c.EnsureGroups(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[uuid.UUID]bool, prefetch)
+	for range prefetch {
+		id := uuid.New()
+		want[id] = true
+		if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]any{"id": id.String(), "payload": "{}"}}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the handler is where the daemon counts metrics, so every task of the batch must reach it
+	handled := make(map[uuid.UUID]int)
+	errFailed := errors.New("api error")
+	handler := func(_ context.Context, _ ExternalAPICaller, _ int, task *domain.Task) error {
+		handled[task.ID]++
+		if len(handled) == 2 {
+			return errFailed
+		}
+		return nil
+	}
+	// a failing task does not stop the rest of the batch, its error is returned afterwards
+	if err := c.ConsumeTasks(ctx, nil, 1, handler); !errors.Is(err, errFailed) {
+		t.Fatalf("ConsumeTasks() = %v, want %v", err, errFailed)
+	}
+
+	if len(handled) != len(want) {
+		t.Fatalf("handled %d tasks in one read, want %d", len(handled), len(want))
+	}
+	for id, n := range handled {
+		if !want[id] || n != 1 {
+			t.Errorf("task %s handled %d times, want once", id, n)
+		}
+	}
+	if n, err := rdb.XPending(ctx, stream, groupName).Result(); err != nil {
+		t.Fatal(err)
+	} else if n.Count != 0 {
+		t.Errorf("%d tasks pending, want all acked", n.Count)
+	}
+}
+
+// BenchmarkPrefetch compares reading one task per stream read with batches, run it
+// without -race against the Redis in TEST_REDIS_ADDR, e.g.
+// TEST_REDIS_ADDR=127.0.0.1:6379 go test -run '^$' -bench Prefetch ./internal/bus
+func BenchmarkPrefetch(b *testing.B) {
+	for _, prefetch := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("prefetch=%d", prefetch), func(b *testing.B) {
+			rdb := testRedis(b)
+			ctx := context.Background()
+			stream := StreamName(0, 1)
+			rdb.Del(ctx, stream)
+			b.Cleanup(func() { rdb.Del(ctx, stream) })
+
+			c := NewConsumer(rdb, nil, prefetch, 1, AtMostOnce)
+			if err := c.EnsureGroups(ctx); err != nil {
+				b.Fatal(err)
+			}
+			produceBenchTasks(b, rdb, 1, b.N)
+
+			consumed := 0
+			handler := func(context.Context, ExternalAPICaller, int, *domain.Task) error {
+				consumed++
+				return nil
+			}
+
+			b.ResetTimer()
+			for consumed < b.N {
+				if err := c.ConsumeTasks(ctx, nil, 0, handler); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "tasks/s")
+		})
+	}
+}
+
+func TestExtractTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]any
+		want   map[string]string
+		wantOK bool
+	}{
+		{"tags of the submit service", map[string]any{"tags": `{"customer":"acme","job":"nightly"}`}, map[string]string{"customer": "acme", "job": "nightly"}, true},
+		{"no tags", map[string]any{}, nil, false},
+		{"empty tags", map[string]any{"tags": ""}, nil, false},
+		{"invalid tags", map[string]any{"tags": "customer=acme"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractTags(redis.XMessage{Values: tt.values})
+			if ok != tt.wantOK || !maps.Equal(got, tt.want) {
+				t.Errorf("extractTags() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestExtractTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]any
+		want   time.Duration
+		wantOK bool
+	}{
+		{"timeout of the submit service", map[string]any{"timeout": "1m30s"}, 90 * time.Second, true},
+		{"no timeout", map[string]any{}, 0, false},
+		{"invalid timeout", map[string]any{"timeout": "soon"}, 0, false},
+		{"not positive", map[string]any{"timeout": "-1s"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractTimeout(redis.XMessage{Values: tt.values})
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("extractTimeout() = %s, %v, want %s, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
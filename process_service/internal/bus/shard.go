@@ -0,0 +1,29 @@
+package bus
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// shardStreamPrefix names the task streams when the queue is sharded
+const shardStreamPrefix = "tasks:shard:"
+
+// StreamName returns the task stream of a shard, a single shard uses the plain "tasks" stream.
+func StreamName(shard, shards int) string {
+	if shards <= 1 {
+		return "tasks"
+	}
+	return shardStreamPrefix + strconv.Itoa(shard)
+}
+
+// ShardFor maps a task to a shard by its id.
+func ShardFor(id uuid.UUID, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write(id[:])
+	return int(h.Sum32() % uint32(shards))
+}
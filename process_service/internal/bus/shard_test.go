@@ -0,0 +1,124 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"process_service/internal/domain"
+)
+
+func TestShardFor(t *testing.T) {
+	for range 100 {
+		id := uuid.New()
+		if got := ShardFor(id, 1); got != 0 {
+			t.Fatalf("ShardFor(%s, 1) = %d, want 0", id, got)
+		}
+		got := ShardFor(id, 4)
+		if got < 0 || got >= 4 {
+			t.Fatalf("ShardFor(%s, 4) = %d, want [0, 4)", id, got)
+		}
+		if again := ShardFor(id, 4); again != got {
+			t.Fatalf("ShardFor(%s, 4) = %d then %d, want a stable shard", id, got, again)
+		}
+	}
+}
+
+func TestWorkerStreams(t *testing.T) {
+	tests := []struct {
+		name     string
+		shards   int
+		workerID int
+		want     []string
+	}{
+		{"single stream", 1, 3, []string{"tasks"}},
+		{"own shard first", 3, 4, []string{"tasks:shard:1", "tasks:shard:2", "tasks:shard:0"}},
+		{"first shard", 3, 3, []string{"tasks:shard:0", "tasks:shard:1", "tasks:shard:2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConsumer(nil, nil, 1, tt.shards, AtMostOnce)
+			if got := c.workerStreams(tt.workerID); !slices.Equal(got, tt.want) {
+				t.Errorf("workerStreams(%d) = %v, want %v", tt.workerID, got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkConsumeTasks compares the single task stream with sharded streams, run it
+// without -race against the Redis in TEST_REDIS_ADDR, e.g.
+// TEST_REDIS_ADDR=127.0.0.1:6379 go test -run '^$' -bench ConsumeTasks ./internal/bus
+func BenchmarkConsumeTasks(b *testing.B) {
+	const workers = 8
+	for _, shards := range []int{1, 4} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			rdb := testRedis(b)
+			streams := make([]string, 0, shards)
+			for i := 0; i < shards; i++ {
+				streams = append(streams, StreamName(i, shards))
+			}
+			rdb.Del(context.Background(), streams...)
+			b.Cleanup(func() { rdb.Del(context.Background(), streams...) })
+
+			c := NewConsumer(rdb, nil, 10, shards, AtMostOnce)
+			if err := c.EnsureGroups(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+			produceBenchTasks(b, rdb, shards, b.N)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var consumed atomic.Int64
+			handler := func(context.Context, ExternalAPICaller, int, *domain.Task) error {
+				if consumed.Add(1) == int64(b.N) {
+					cancel()
+				}
+				return nil
+			}
+
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for w := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for ctx.Err() == nil {
+						_ = c.ConsumeTasks(ctx, nil, w, handler)
+					}
+				}()
+			}
+			wg.Wait()
+			b.StopTimer()
+
+			if got := consumed.Load(); got != int64(b.N) {
+				b.Fatalf("consumed %d tasks, want %d", got, b.N)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "tasks/s")
+		})
+	}
+}
+
+// produceBenchTasks adds n tasks to their shard streams in pipelined batches
+func produceBenchTasks(b *testing.B, rdb *redis.Client, shards, n int) {
+	b.Helper()
+	ctx := context.Background()
+	pipe := rdb.Pipeline()
+	for i := range n {
+		id := uuid.New()
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: StreamName(ShardFor(id, shards), shards),
+			Values: map[string]any{"id": id.String(), "payload": "{}"},
+		})
+		if (i+1)%1000 == 0 || i == n-1 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
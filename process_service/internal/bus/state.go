@@ -0,0 +1,95 @@
+package bus
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// taskStateKeyPrefix keys hold the queued task state shared with the submit service
+	taskStateKeyPrefix = "tasks:state:"
+	taskStateActive    = "active"
+	taskStateCancelled = "cancelled"
+	taskStateDone      = "done"
+	taskStateFailed    = "failed"
+	taskStateTTL       = 24 * time.Hour
+	// workersAliveKey tells the submit service that workers are running
+	workersAliveKey = "tasks:workers:alive"
+)
+
+// TaskStates tracks whether a queued task was cancelled before a worker picked it up.
+type TaskStates struct {
+	Client *redis.Client
+}
+
+func NewTaskStates(redisClient *redis.Client) *TaskStates {
+	return &TaskStates{Client: redisClient}
+}
+
+// Claim marks the task active. It returns false when the task was cancelled while queued.
+// A task already claimed by a previous attempt is claimed again, so retries are processed.
+func (s *TaskStates) Claim(ctx context.Context, taskID uuid.UUID) (bool, error) {
+	key := taskStateKeyPrefix + taskID.String()
+	ok, err := s.Client.SetNX(ctx, key, taskStateActive, taskStateTTL).Result()
+	if err != nil || ok {
+		return ok, err
+	}
+	state, err := s.Client.Get(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return state != taskStateCancelled, nil
+}
+
+// Finish marks a claimed task done, so it can not be cancelled anymore.
+func (s *TaskStates) Finish(ctx context.Context, taskID uuid.UUID) error {
+	return s.Client.Set(ctx, taskStateKeyPrefix+taskID.String(), taskStateDone, taskStateTTL).Err()
+}
+
+// Fail marks a claimed task failed, tasks depending on it are not processed.
+func (s *TaskStates) Fail(ctx context.Context, taskID uuid.UUID) error {
+	return s.Client.Set(ctx, taskStateKeyPrefix+taskID.String(), taskStateFailed, taskStateTTL).Err()
+}
+
+// DependencyStatus sums up the states of the tasks a task depends on.
+type DependencyStatus int
+
+const (
+	// DependenciesPending means some dependencies are queued, running or unknown
+	DependenciesPending DependencyStatus = iota
+	// DependenciesDone means all dependencies succeeded
+	DependenciesDone
+	// DependenciesFailed means a dependency failed or was cancelled
+	DependenciesFailed
+)
+
+// Dependencies reads the states of the given tasks in one round trip.
+func (s *TaskStates) Dependencies(ctx context.Context, taskIDs []uuid.UUID) (DependencyStatus, error) {
+	keys := make([]string, len(taskIDs))
+	for i, id := range taskIDs {
+		keys[i] = taskStateKeyPrefix + id.String()
+	}
+	states, err := s.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return DependenciesPending, err
+	}
+	status := DependenciesDone
+	for _, state := range states {
+		switch state {
+		case taskStateFailed, taskStateCancelled:
+			return DependenciesFailed, nil
+		case taskStateDone:
+		default:
+			status = DependenciesPending
+		}
+	}
+	return status, nil
+}
+
+// WorkersAlive reports running workers for ttl, it is refreshed while they run.
+func (s *TaskStates) WorkersAlive(ctx context.Context, ttl time.Duration) error {
+	return s.Client.Set(ctx, workersAliveKey, "1", ttl).Err()
+}
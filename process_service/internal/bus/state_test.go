@@ -0,0 +1,58 @@
+package bus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// cancel marks the task cancelled the way the submit service does, only while it is queued
+func cancel(t *testing.T, s *TaskStates, taskID uuid.UUID) bool {
+	t.Helper()
+	ok, err := s.Client.SetNX(context.Background(), taskStateKeyPrefix+taskID.String(), taskStateCancelled, taskStateTTL).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestClaimCancelledTask(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	s := NewTaskStates(rdb)
+
+	t.Run("cancelled before dequeue", func(t *testing.T) {
+		taskID := uuid.New()
+		t.Cleanup(func() { rdb.Del(ctx, taskStateKeyPrefix+taskID.String()) })
+		if !cancel(t, s, taskID) {
+			t.Fatal("queued task was not cancelled")
+		}
+		claimed, err := s.Claim(ctx, taskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed {
+			t.Error("cancelled task was claimed")
+		}
+	})
+
+	t.Run("cancelled too late", func(t *testing.T) {
+		taskID := uuid.New()
+		t.Cleanup(func() { rdb.Del(ctx, taskStateKeyPrefix+taskID.String()) })
+		claimed, err := s.Claim(ctx, taskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !claimed {
+			t.Fatal("queued task was not claimed")
+		}
+		if cancel(t, s, taskID) {
+			t.Error("active task was cancelled")
+		}
+		// a retry of the active task is still processed
+		if claimed, err := s.Claim(ctx, taskID); err != nil || !claimed {
+			t.Errorf("Claim() again = %v, %v, want true", claimed, err)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// taskLogsChannelPrefix channels carry log lines of tasks submitted with streamed logs
+	taskLogsChannelPrefix = "tasks:logs:"
+	taskLogsPublishWait   = time.Second
+)
+
+// TaskLogsChannel returns the pub/sub channel of a task's log lines.
+func TaskLogsChannel(taskID uuid.UUID) string {
+	return taskLogsChannelPrefix + taskID.String()
+}
+
+// TaskLogHook publishes log entries of watched tasks, matched by their taskId field.
+type TaskLogHook struct {
+	rdb     *redis.Client
+	watched *sync.Map
+}
+
+func NewTaskLogHook(rdb *redis.Client) *TaskLogHook {
+	return &TaskLogHook{rdb: rdb, watched: &sync.Map{}}
+}
+
+// Watch starts publishing log entries of the task.
+func (h *TaskLogHook) Watch(taskID uuid.UUID) {
+	h.watched.Store(taskID.String(), struct{}{})
+}
+
+// Done publishes the final event of the task and stops watching it.
+func (h *TaskLogHook) Done(taskID uuid.UUID, taskErr error) {
+	h.watched.Delete(taskID.String())
+	event := map[string]any{"event": "done", "status": "processed", "time": time.Now().UTC()}
+	if taskErr != nil {
+		event["status"] = "failed"
+		event["error"] = taskErr.Error()
+	}
+	h.publish(taskID.String(), event)
+}
+
+// Abandon publishes the final event of a task which did not run to its end, e.g. a
+// cancelled or dead lettered one, so a client waiting on its logs stops waiting.
+// A nil hook publishes nothing.
+func (h *TaskLogHook) Abandon(taskID uuid.UUID, status, reason string) {
+	if h == nil {
+		return
+	}
+	h.watched.Delete(taskID.String())
+	event := map[string]any{"event": "done", "status": status, "time": time.Now().UTC()}
+	if reason != "" {
+		event["error"] = reason
+	}
+	h.publish(taskID.String(), event)
+}
+
+func (h *TaskLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *TaskLogHook) Fire(e *log.Entry) error {
+	taskID, ok := e.Data["taskId"].(string)
+	if !ok {
+		return nil
+	}
+	if _, watched := h.watched.Load(taskID); !watched {
+		return nil
+	}
+
+	event := make(map[string]any, len(e.Data)+4)
+	for k, v := range e.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		event[k] = v
+	}
+	event["event"] = "log"
+	event["time"] = e.Time.UTC()
+	event["level"] = e.Level.String()
+	event["message"] = e.Message
+	h.publish(taskID, event)
+	return nil
+}
+
+// publish is best effort, a lost line must not fail the task
+func (h *TaskLogHook) publish(taskID string, event map[string]any) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), taskLogsPublishWait)
+	defer cancel()
+	h.rdb.Publish(ctx, taskLogsChannelPrefix+taskID, b)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// writeConfig creates an empty config file in dir and returns its path
+func writeConfig(t *testing.T, dir string) string {
+	t.Helper()
+	file := filepath.Join(dir, DefaultConfigName+"."+DefaultConfigType)
+	if err := os.WriteFile(file, []byte("instance_id: test\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLogConfigFileUsed(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	t.Cleanup(viper.Reset)
+
+	file := writeConfig(t, t.TempDir())
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	logConfigFileUsed()
+
+	if want := "using config file '" + file + "'"; !strings.Contains(buf.String(), want) {
+		t.Errorf("log %q does not contain %q", buf.String(), want)
+	}
+}
+
+func TestConfigCandidates(t *testing.T) {
+	home, first, second, empty := t.TempDir(), t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	inHome := writeConfig(t, home)
+	inFirst := writeConfig(t, first)
+	inSecond := writeConfig(t, second)
+
+	got := configCandidates([]string{first, empty, "~", first, second})
+	want := []string{inFirst, inHome, inSecond}
+	if !slices.Equal(got, want) {
+		t.Errorf("configCandidates() = %q, want %q", got, want)
+	}
+}
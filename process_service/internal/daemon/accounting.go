@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const _accountingInterval = 30 * time.Second
+
+// Accounting is a snapshot of where every received task ended up, counted by task ID.
+// Each received task must be either active, processed or not processed.
+type Accounting struct {
+	Received     uint64 `json:"received"`
+	Active       uint64 `json:"active"`
+	Processed    uint64 `json:"processed"`
+	NotProcessed uint64 `json:"not_processed"`
+	// Missing is the number of tasks counted as active which no worker holds anymore.
+	// The active gauge is raised before a task is counted and lowered after its outcome,
+	// so Missing may be negative for a moment, a positive value means a leak.
+	Missing int64 `json:"missing"`
+}
+
+func (d *Daemon) Accounting() Accounting {
+	a := d.ledger.accounting()
+	a.Missing = int64(a.Active) - int64(d.Metrics.Recorder.GetActiveTasksTotal())
+	return a
+}
+
+// taskOutcome is the bucket of a received task
+type taskOutcome uint8
+
+const (
+	outcomeActive taskOutcome = iota + 1
+	outcomeProcessed
+	outcomeNotProcessed
+)
+
+// ledger keeps the latest outcome of every task taken in this run by its ID, so a task
+// retried, redelivered or given up more than once counts once. Not processed tasks
+// seeded from a previous run or swept after their TTL are not in it.
+type ledger struct {
+	mux   sync.Mutex
+	tasks map[uuid.UUID]taskOutcome
+}
+
+func newLedger() *ledger {
+	return &ledger{tasks: make(map[uuid.UUID]taskOutcome)}
+}
+
+func (l *ledger) set(taskID uuid.UUID, outcome taskOutcome) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.tasks[taskID] = outcome
+}
+
+func (l *ledger) accounting() Accounting {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	a := Accounting{Received: uint64(len(l.tasks))}
+	for _, outcome := range l.tasks {
+		switch outcome {
+		case outcomeActive:
+			a.Active++
+		case outcomeProcessed:
+			a.Processed++
+		case outcomeNotProcessed:
+			a.NotProcessed++
+		}
+	}
+	return a
+}
+
+// notProcessed records the task as given up on, in the ledger and in the persistent queue
+func (d *Daemon) notProcessed(taskID uuid.UUID, reason string, at time.Time) {
+	d.ledger.set(taskID, outcomeNotProcessed)
+	d.Q.AddNotProcessed(taskID.String(), reason, at)
+}
+
+// checkAccounting logs a warning when some received tasks are not accounted for.
+func (d *Daemon) checkAccounting() {
+	a := d.Accounting()
+	if a.Missing > 0 {
+		d.logger.WithFields(log.Fields{
+			"received":      a.Received,
+			"active":        a.Active,
+			"processed":     a.Processed,
+			"not_processed": a.NotProcessed,
+			"missing":       a.Missing,
+		}).Warn("task accounting does not balance")
+	}
+}
+
+func (d *Daemon) runAccountingCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.checkAccounting()
+		}
+	}
+}
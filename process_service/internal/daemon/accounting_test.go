@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"process_service/internal/domain"
+)
+
+// callerReturning returns a caller which answers err at once
+func callerReturning(err error) *blockingCaller {
+	c := &blockingCaller{release: make(chan struct{}), err: err}
+	close(c.release)
+	return c
+}
+
+func TestAccountingCountsTasksByID(t *testing.T) {
+	d, q := newTestDaemon(time.Now())
+	process := chain(d.processTask, d.metricsMiddleware)
+	ctx := context.Background()
+
+	retried := &domain.Task{ID: uuid.New()}
+	failed := &domain.Task{ID: uuid.New()}
+	deadLettered := uuid.New()
+
+	// a task failing once and succeeding on redelivery counts as one processed task
+	process(ctx, callerReturning(errors.New("api error")), 1, retried)
+	process(ctx, callerReturning(nil), 1, retried)
+	// a task failing twice counts as one not processed task
+	process(ctx, callerReturning(errors.New("api error")), 1, failed)
+	process(ctx, callerReturning(errors.New("api error")), 1, failed)
+	// a task given up without reaching a worker is received and not processed
+	d.notProcessed(deadLettered, reasonMaxDeliveries, time.Now())
+	// seeded and swept entries of the persistent queue are not counted
+	q.AddNotProcessed(uuid.NewString(), reasonError, time.Now())
+
+	want := Accounting{Received: 3, Processed: 1, NotProcessed: 2}
+	if got := d.Accounting(); got != want {
+		t.Errorf("Accounting() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAccountingReportsLeakedTask(t *testing.T) {
+	d, _ := newTestDaemon(time.Now())
+	release := make(chan struct{})
+	process := chain(d.processTask, d.metricsMiddleware)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		process(context.Background(), &blockingCaller{release: release}, 1, &domain.Task{ID: uuid.New()})
+	}()
+	for d.Metrics.Recorder.GetActiveTasksTotal() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if got := d.Accounting(); got.Active != 1 || got.Missing != 0 {
+		t.Errorf("Accounting() while running = %+v, want 1 active and none missing", got)
+	}
+
+	// the gauge is lowered without an outcome, e.g. by a panic, the task leaked
+	d.Metrics.Recorder.DecActiveTasks(1)
+	if got := d.Accounting(); got.Missing != 1 {
+		t.Errorf("Missing = %d, want 1", got.Missing)
+	}
+	d.Metrics.Recorder.AddActiveTasks(1)
+	close(release)
+	<-done
+}
@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// reasonOrphaned marks tasks the reaper removed from the active ones
+	reasonOrphaned = "orphaned"
+
+	_maxActiveReapInterval = 30 * time.Second
+)
+
+// activeTask is a task counted in the active gauge
+type activeTask struct {
+	taskID    uuid.UUID
+	workerID  int
+	startedAt time.Time
+}
+
+// ActiveTasks tracks the tasks counted in the active gauge with their start times,
+// so entries whose worker never finished them can be reclaimed. Reclaimed tasks are
+// remembered until their worker finishes them, so they are not counted twice.
+type ActiveTasks struct {
+	mux    sync.Mutex
+	next   uint64
+	tasks  map[uint64]activeTask
+	reaped map[uuid.UUID]struct{}
+}
+
+func NewActiveTasks() *ActiveTasks {
+	return &ActiveTasks{tasks: make(map[uint64]activeTask), reaped: make(map[uuid.UUID]struct{})}
+}
+
+// add starts tracking a task, the returned key removes it again
+func (a *ActiveTasks) add(taskID uuid.UUID, workerID int, startedAt time.Time) uint64 {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.next++
+	a.tasks[a.next] = activeTask{taskID: taskID, workerID: workerID, startedAt: startedAt}
+	return a.next
+}
+
+// remove reports whether the entry was still tracked, false means the reaper took it.
+// It forgets a reaped task, so it is called once the task is done.
+func (a *ActiveTasks) remove(key uint64, taskID uuid.UUID) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if _, ok := a.tasks[key]; !ok {
+		delete(a.reaped, taskID)
+		return false
+	}
+	delete(a.tasks, key)
+	return true
+}
+
+// isReaped reports whether the reaper took the task while it was still running
+func (a *ActiveTasks) isReaped(taskID uuid.UUID) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	_, ok := a.reaped[taskID]
+	return ok
+}
+
+// reap removes and returns the entries started before deadline
+func (a *ActiveTasks) reap(deadline time.Time) []activeTask {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	var reaped []activeTask
+	for key, task := range a.tasks {
+		if task.startedAt.Before(deadline) {
+			reaped = append(reaped, task)
+			delete(a.tasks, key)
+			a.reaped[task.taskID] = struct{}{}
+		}
+	}
+	return reaped
+}
+
+// runActiveReaper periodically reclaims active entries older than maxAge, which
+// a worker stuck or gone without cleaning up would otherwise leak forever
+func (d *Daemon) runActiveReaper(ctx context.Context, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(maxAge/2, _maxActiveReapInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.reapActive(maxAge)
+		}
+	}
+}
+
+// reapActive corrects the active gauge for reaped entries and records them as not
+// processed, so the accounting check still balances. A reaped task that finishes
+// later is not counted again, see metricsMiddleware and processTask.
+func (d *Daemon) reapActive(maxAge time.Duration) {
+	now := d.now()
+	for _, task := range d.active.reap(now.Add(-maxAge)) {
+		d.notProcessed(task.taskID, reasonOrphaned, now)
+		d.Metrics.Recorder.DecActiveTasks(1)
+		d.taskLogs.Abandon(task.taskID, reasonOrphaned, "worker did not finish the task")
+		d.logger.WithFields(log.Fields{
+			"taskId":   task.taskID.String(),
+			"workerId": task.workerID,
+			"age":      now.Sub(task.startedAt).String(),
+		}).Warn("reclaimed orphaned active task")
+	}
+}
@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+	"process_service/internal/metrics"
+)
+
+// fakeQueue is a PersistentQueue keeping not processed tasks in memory
+type fakeQueue struct {
+	mux     sync.Mutex
+	reasons map[string][]string
+}
+
+func (q *fakeQueue) AddNotProcessed(taskID, reason string, _ time.Time) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.reasons == nil {
+		q.reasons = make(map[string][]string)
+	}
+	q.reasons[taskID] = append(q.reasons[taskID], reason)
+}
+
+func (q *fakeQueue) GetAllNotProcessedTasks() []string { return q.GetLocalNotProcessedTasks() }
+
+func (q *fakeQueue) CountNotProcessedTasks() uint64 {
+	return uint64(len(q.GetLocalNotProcessedTasks()))
+}
+
+func (q *fakeQueue) GetLocalNotProcessedTasks() []string {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	ids := make([]string, 0, len(q.reasons))
+	for id := range q.reasons {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// blockingCaller returns err once release is closed
+type blockingCaller struct {
+	release chan struct{}
+	err     error
+}
+
+func (c *blockingCaller) GetSomething(context.Context, string, int) error {
+	<-c.release
+	return c.err
+}
+
+func newTestDaemon(now time.Time) (*Daemon, *fakeQueue) {
+	q := &fakeQueue{}
+	return &Daemon{
+		logger:    log.New(),
+		Metrics:   metrics.New(nil),
+		Q:         q,
+		active:    NewActiveTasks(),
+		ledger:    newLedger(),
+		DrainRate: NewDrainRate(0, 0),
+		now:       func() time.Time { return now },
+	}, q
+}
+
+func TestReapedTaskIsNotCountedAgain(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"succeeds after the reap", nil},
+		{"fails after the reap", errors.New("api error")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d, q := newTestDaemon(time.Now().Add(time.Hour))
+			caller := &blockingCaller{release: make(chan struct{}), err: tt.err}
+			process := chain(d.processTask, d.metricsMiddleware)
+
+			task := &domain.Task{ID: uuid.New()}
+			done := make(chan error)
+			go func() { done <- process(context.Background(), caller, 1, task) }()
+			for d.Metrics.Recorder.GetActiveTasksTotal() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			d.reapActive(time.Minute)
+			close(caller.release)
+			if err := <-done; !errors.Is(err, tt.err) {
+				t.Fatalf("process() = %v, want %v", err, tt.err)
+			}
+
+			rec := d.Metrics.Recorder
+			if got := rec.GetProcessedTasksTotal(); got != 0 {
+				t.Errorf("processed = %d, want 0 for a reaped task", got)
+			}
+			if got := rec.GetActiveTasksTotal(); got != 0 {
+				t.Errorf("active = %d, want 0", got)
+			}
+			if got := q.reasons[task.ID.String()]; len(got) != 1 || got[0] != reasonOrphaned {
+				t.Errorf("not processed reasons = %v, want [%s]", got, reasonOrphaned)
+			}
+			if d.active.isReaped(task.ID) {
+				t.Error("reaped mark is kept after the task finished")
+			}
+		})
+	}
+}
+
+func TestActiveTaskIsCountedOnce(t *testing.T) {
+	d, q := newTestDaemon(time.Now())
+	handler := func(context.Context, bus.ExternalAPICaller, int, *domain.Task) error { return nil }
+	process := chain(handler, d.metricsMiddleware)
+
+	if err := process(context.Background(), nil, 1, &domain.Task{ID: uuid.New()}); err != nil {
+		t.Fatal(err)
+	}
+	d.reapActive(time.Minute)
+
+	if got := d.Metrics.Recorder.GetProcessedTasksTotal(); got != 1 {
+		t.Errorf("processed = %d, want 1", got)
+	}
+	if len(q.reasons) != 0 {
+		t.Errorf("not processed = %v, want none", q.reasons)
+	}
+}
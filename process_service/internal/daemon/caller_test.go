@@ -0,0 +1,82 @@
+package daemon
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"process_service/internal/domain"
+)
+
+type closingCaller struct {
+	closed int
+}
+
+func (c *closingCaller) GetSomething(context.Context, string, int) error { return nil }
+
+func (c *closingCaller) Close(context.Context) error {
+	c.closed++
+	return nil
+}
+
+func TestSetAPICallerClosesReplacedCaller(t *testing.T) {
+	old, replacement := &closingCaller{}, &closingCaller{}
+	d := &Daemon{logger: log.New(), apiCaller: old, callerMux: &sync.RWMutex{}}
+
+	d.SetAPICaller(replacement)
+	if old.closed != 1 {
+		t.Errorf("replaced caller closed %d times, want 1", old.closed)
+	}
+	if d.APICaller() != replacement {
+		t.Error("APICaller() is not the new caller")
+	}
+
+	d.SetAPICaller(replacement)
+	if replacement.closed != 0 {
+		t.Error("caller closed when set again")
+	}
+}
+
+// recordingCaller records the ids of the tasks it was called for
+type recordingCaller struct {
+	mu    sync.Mutex
+	tasks []string
+}
+
+func (c *recordingCaller) GetSomething(_ context.Context, taskID string, _ int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tasks = append(c.tasks, taskID)
+	return nil
+}
+
+func TestWorkerUsesInjectedCaller(t *testing.T) {
+	injected, replacement := &recordingCaller{}, &recordingCaller{}
+	d, _ := newTestDaemon(time.Now())
+	d.apiCaller = injected
+	d.callerMux = &sync.RWMutex{}
+	process := chain(d.processTask, d.metricsMiddleware)
+
+	// workers look the caller up before every read, like here
+	first := &domain.Task{ID: uuid.New()}
+	if err := process(context.Background(), d.APICaller(), 1, first); err != nil {
+		t.Fatal(err)
+	}
+	d.SetAPICaller(replacement)
+	second := &domain.Task{ID: uuid.New()}
+	if err := process(context.Background(), d.APICaller(), 1, second); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(injected.tasks, []string{first.ID.String()}) {
+		t.Errorf("injected caller called for %v, want only %s", injected.tasks, first.ID)
+	}
+	if !slices.Equal(replacement.tasks, []string{second.ID.String()}) {
+		t.Errorf("replacement caller called for %v, want only %s", replacement.tasks, second.ID)
+	}
+}
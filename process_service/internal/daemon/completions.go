@@ -0,0 +1,39 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+)
+
+// completionRecordTimeout bounds storing a completion, the task ctx may be done already
+const completionRecordTimeout = 2 * time.Second
+
+// CompletionRecorder is optionally implemented by the task status updater to persist completions.
+type CompletionRecorder interface {
+	RecordCompletion(ctx context.Context, taskID uuid.UUID, at time.Time) error
+}
+
+// recordCompletion stores when a task finished successfully, warmup tasks are not recorded
+func (d *Daemon) recordCompletion(ctx context.Context, task *domain.Task) {
+	if d.recentCompletions <= 0 || task.Warmup {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), completionRecordTimeout)
+	defer cancel()
+
+	completedAt := d.now()
+	logger := d.loggerFromContext(ctx)
+	if err := d.states.RecordCompletion(ctx, bus.Completion{TaskID: task.ID, CompletedAt: completedAt}, d.recentCompletions); err != nil {
+		logger.WithError(err).Warn("failed to record recent completion")
+	}
+	if d.completions != nil {
+		if err := d.completions.RecordCompletion(ctx, task.ID, completedAt); err != nil {
+			logger.WithError(err).Warn("failed to record task completion")
+		}
+	}
+}
@@ -4,48 +4,173 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	log "github.com/sirupsen/logrus"
 
-	"process_service/extapi"
 	"process_service/internal/bus"
 	"process_service/internal/dlq"
 	"process_service/internal/domain"
+	"process_service/internal/logctx"
 	"process_service/internal/metrics"
 )
 
 const (
-	redisStreamName = "tasks"
-	redisGroupName  = "task_group"
+	defaultTaskTimeout = 3 * time.Second
+	// typeSlotWait is how long a task waits for a slot of its type before it is requeued
+	typeSlotWait = 100 * time.Millisecond
+	// callAbandonGrace is how long a cancelled external API call may take to return
+	callAbandonGrace = 100 * time.Millisecond
+	// _contextDoneStopTimeout bounds closing the external API caller when the daemon
+	// stops because its context is done
+	_contextDoneStopTimeout = 5 * time.Second
+	// _defaultMaxDeliveries is how often a task is delivered in at_least_once mode before it is given up
+	_defaultMaxDeliveries = 5
+
+	// reasons a task ends up not processed
+	reasonTimeout       = "timeout"
+	reasonError         = "error"
+	reasonShutdown      = "shutdown"
+	reasonMaxDeliveries = "max_deliveries"
 )
 
+type Config struct {
+	TaskTimeout  time.Duration            `mapstructure:"task_timeout"`
+	TypeTimeouts map[string]time.Duration `mapstructure:"type_timeouts"`
+	// TypeConcurrency caps concurrent tasks per type, other types are limited by workers only
+	TypeConcurrency map[string]int `mapstructure:"type_concurrency"`
+	// Prefetch is the max number of tasks a worker reads from the stream at once
+	Prefetch int `mapstructure:"prefetch"`
+	// DeliveryMode is "at_most_once" (default) or "at_least_once", see bus.DeliveryMode
+	DeliveryMode bus.DeliveryMode `mapstructure:"delivery_mode"`
+	// MaxDeliveries gives up on a task delivered more times in at_least_once mode, it goes
+	// to the DLQ and is recorded as not processed, 5 when unset, 0 retries forever
+	MaxDeliveries *int `mapstructure:"max_deliveries"`
+	// MaxWorkerLifetime replaces a worker with a fresh one after it ran that long, 0 disables it
+	MaxWorkerLifetime time.Duration `mapstructure:"max_worker_lifetime"`
+	// SuccessLogRate is the share of tasks whose per task info logs are written, 1 when unset.
+	// Errors and timeouts are always logged.
+	SuccessLogRate *float64 `mapstructure:"success_log_rate"`
+	// Middlewares lists built-in processing middlewares, outermost first: "logging", "metrics", "attempts".
+	// Without "metrics" tasks are not counted and the accounting check reports nothing useful.
+	Middlewares []string `mapstructure:"middlewares"`
+	// MaxTasks shuts the daemon down after that many tasks finished, 0 runs forever
+	MaxTasks int64 `mapstructure:"max_tasks"`
+	// DrainRateWindow and DrainRateAlpha tune the queue drain rate estimate, see DrainRate
+	DrainRateWindow time.Duration `mapstructure:"drain_rate_window"`
+	DrainRateAlpha  float64       `mapstructure:"drain_rate_alpha"`
+	// MaxHeldTasks bounds tasks waiting for their dependencies, more are requeued
+	MaxHeldTasks int `mapstructure:"max_held_tasks"`
+	// DependencyTimeout gives up on a task whose dependencies did not finish in time
+	DependencyTimeout time.Duration `mapstructure:"dependency_timeout"`
+	// FailOnDependencyFailure skips a task when a dependency failed, true when unset
+	FailOnDependencyFailure *bool `mapstructure:"fail_on_dependency_failure"`
+	// ActiveTaskMaxAge reclaims tasks counted as active for longer, it should exceed any
+	// task timeout, 0 disables the reaper
+	ActiveTaskMaxAge time.Duration `mapstructure:"active_task_max_age"`
+	// RecentCompletions keeps that many latest completions for /admin/recent of the submit
+	// service and appends every completion to task_completions, 0 disables both
+	RecentCompletions int `mapstructure:"recent_completions"`
+	// ResultTTL keeps results of finished tasks readable for that long, 0 keeps none
+	ResultTTL time.Duration `mapstructure:"result_ttl"`
+	// StopOnContextDone runs the Stop sequence when the context passed to Start is
+	// cancelled without Stop, true when unset
+	StopOnContextDone *bool `mapstructure:"stop_on_context_done"`
+}
+
 type ExternalAPICaller interface {
 	GetSomething(ctx context.Context, taskID string, workerID int) error
 }
 
+// CallerCloser is optionally implemented by an ExternalAPICaller holding resources,
+// it is closed once the workers have stopped.
+type CallerCloser interface {
+	Close(ctx context.Context) error
+}
+
 type PersistentQueue interface {
-	AddNotProcessedTask(taskID string)
+	AddNotProcessed(taskID, reason string, at time.Time)
 	GetAllNotProcessedTasks() []string
+	CountNotProcessedTasks() uint64
+	GetLocalNotProcessedTasks() []string
 }
 
 type Daemon struct {
-	baseCtx     context.Context
 	logger      *log.Logger
 	numWorkers  int
 	taskCounter uint64
-	consumer *bus.Consumer
+	consumer    *bus.Consumer
+	states      *bus.TaskStates
+	taskLogs    *bus.TaskLogHook
 	Metrics     *metrics.Service
 	Q           PersistentQueue
-	
-	Sem         chan struct{}
-	Wg          *sync.WaitGroup
+	Registry    *HandlerRegistry
+	taskTimeout time.Duration
+
+	Sem          chan struct{}
+	Wg           *sync.WaitGroup
 	workerCancel func()
+
+	callerMux *sync.RWMutex
+	apiCaller ExternalAPICaller
+
+	maxWorkerLifetime time.Duration
+	successLogRate    float64
+	now               func() time.Time
+
+	started  atomic.Bool
+	stopping atomic.Bool
+	stopOnce sync.Once
+	// stopped is closed once stop begins, stopOnContextDone stops watching then
+	stopped           chan struct{}
+	stopOnContextDone bool
+	// done is closed once stop finished
+	done chan struct{}
+	// liveWorkers counts running worker goroutines
+	liveWorkers atomic.Int64
+
+	// maxTasks limits the tasks to finish before shutting down, finished counts them and
+	// reserved the ones taken, finished or still running
+	maxTasks     int64
+	finished     atomic.Int64
+	reserved     atomic.Int64
+	limitOnce    sync.Once
+	limitReached chan struct{}
+
+	DrainRate *DrainRate
+
+	// middlewares wrap processTask, process is the chain built on Start
+	middlewares []Middleware
+	process     Processor
+	// attempts persists attempt history, nil when the status updater does not support it
+	attempts AttemptRecorder
+
+	// deps holds tasks until their dependencies finish, releaseSem bounds released tasks running at once
+	deps                    *DependencyTracker
+	releaseSem              chan struct{}
+	failOnDependencyFailure bool
+
+	// ledger counts received tasks by ID for the accounting check
+	ledger *ledger
+
+	// active tracks tasks in the active gauge, activeMaxAge is when the reaper reclaims them
+	active       *ActiveTasks
+	activeMaxAge time.Duration
+
+	// recentCompletions is the length of the recent completions list, 0 records no completions
+	recentCompletions int
+	completions       CompletionRecorder
+
+	// results caches outcomes of finished tasks, nil without a result TTL
+	results *ResultCache
 }
 
-func New(ctx context.Context, busConf *bus.Config, minioConf *dlq.Config, numWorkers int, queueSize int, m *metrics.Service, db PersistentQueue, statusHook bus.InvalidTaskStatusUpdater, logger *log.Logger) *Daemon {
+func New(ctx context.Context, conf *Config, busConf *bus.Config, minioConf *dlq.Config, numWorkers int, queueSize int, m *metrics.Service, db PersistentQueue, statusHook bus.InvalidTaskStatusUpdater, apiCaller ExternalAPICaller, logger *log.Logger) *Daemon {
 
 	rdb := redis.NewClient(&redis.Options{Addr: busConf.RedisAddr})
 
@@ -56,36 +181,206 @@ func New(ctx context.Context, busConf *bus.Config, minioConf *dlq.Config, numWor
 		dlqWriter = minioDLQ
 	}
 
-	if err := rdb.XGroupCreateMkStream(ctx, redisStreamName, redisGroupName, "$").Err(); err != nil && !errors.Is(err, redis.Nil) {
-		if err.Error() != "BUSYGROUP Consumer Group name already exists" {
-			logger.WithError(err).Warn("failed to ensure redis stream consumer group")
+	taskTimeout := defaultTaskTimeout
+	prefetch := 1
+	var maxWorkerLifetime time.Duration
+	successLogRate := 1.0
+	var maxTasks int64
+	var drainWindow time.Duration
+	var drainAlpha float64
+	deliveryMode := bus.AtMostOnce
+	maxDeliveries := _defaultMaxDeliveries
+	registry := NewHandlerRegistry()
+	middlewareNames := defaultMiddlewares
+	var maxHeldTasks int
+	var dependencyTimeout time.Duration
+	failOnDependencyFailure := true
+	var activeMaxAge time.Duration
+	var recentCompletions int
+	var resultTTL time.Duration
+	stopOnContextDone := true
+	if conf != nil {
+		if conf.StopOnContextDone != nil {
+			stopOnContextDone = *conf.StopOnContextDone
+		}
+		activeMaxAge, recentCompletions = conf.ActiveTaskMaxAge, conf.RecentCompletions
+		resultTTL = conf.ResultTTL
+		maxHeldTasks, dependencyTimeout = conf.MaxHeldTasks, conf.DependencyTimeout
+		if conf.FailOnDependencyFailure != nil {
+			failOnDependencyFailure = *conf.FailOnDependencyFailure
+		}
+		if conf.Middlewares != nil {
+			middlewareNames = conf.Middlewares
+		}
+		maxWorkerLifetime = conf.MaxWorkerLifetime
+		maxTasks = conf.MaxTasks
+		drainWindow, drainAlpha = conf.DrainRateWindow, conf.DrainRateAlpha
+		if conf.SuccessLogRate != nil {
+			successLogRate = *conf.SuccessLogRate
+		}
+		if conf.DeliveryMode != "" {
+			deliveryMode = conf.DeliveryMode
+		}
+		if conf.MaxDeliveries != nil {
+			maxDeliveries = max(*conf.MaxDeliveries, 0)
+		}
+		if conf.Prefetch > 0 {
+			prefetch = conf.Prefetch
+		}
+		if conf.TaskTimeout > 0 {
+			taskTimeout = conf.TaskTimeout
 		}
+		for taskType, timeout := range conf.TypeTimeouts {
+			registry.Register(taskType, timeout)
+		}
+		for taskType, limit := range conf.TypeConcurrency {
+			registry.SetConcurrency(taskType, limit)
+		}
+	}
+
+	taskLogs := bus.NewTaskLogHook(rdb)
+	logger.AddHook(taskLogs)
+
+	consumer := bus.NewConsumer(rdb, dlqWriter, prefetch, busConf.Shards, deliveryMode, statusHook)
+	if err := consumer.EnsureGroups(ctx); err != nil {
+		logger.WithError(err).Warn("failed to ensure redis stream consumer group")
+	}
+	if moved, err := consumer.MigrateStreams(ctx); err != nil {
+		logger.WithError(err).Warn("failed to migrate tasks of other task streams")
+	} else if moved > 0 {
+		logger.WithField("tasks", moved).Info("migrated tasks of other task streams")
 	}
 
-	return &Daemon{
+	d := &Daemon{
 		logger:      logger,
 		Metrics:     m,
-		consumer:    bus.NewConsumer(rdb, dlqWriter, statusHook),
+		consumer:    consumer,
+		states:      bus.NewTaskStates(rdb),
+		taskLogs:    taskLogs,
 		Sem:         make(chan struct{}, queueSize),
 		numWorkers:  numWorkers,
 		Wg:          &sync.WaitGroup{},
-		baseCtx:     ctx,
 		Q:           db,
+		Registry:    registry,
+		taskTimeout: taskTimeout,
+		callerMux:   &sync.RWMutex{},
+		apiCaller:   apiCaller,
+
+		maxWorkerLifetime: maxWorkerLifetime,
+		successLogRate:    successLogRate,
+		now:               time.Now,
+		maxTasks:          maxTasks,
+		limitReached:      make(chan struct{}),
+		DrainRate:         NewDrainRate(drainWindow, drainAlpha),
+
+		deps:                    NewDependencyTracker(maxHeldTasks, dependencyTimeout),
+		releaseSem:              make(chan struct{}, max(numWorkers, 1)),
+		failOnDependencyFailure: failOnDependencyFailure,
+
+		ledger:       newLedger(),
+		active:       NewActiveTasks(),
+		activeMaxAge: activeMaxAge,
+
+		recentCompletions: recentCompletions,
+
+		results: NewResultCache(resultTTL),
+
+		stopped:           make(chan struct{}),
+		stopOnContextDone: stopOnContextDone,
+		done:              make(chan struct{}),
+	}
+	consumer.SetMaxDeliveries(maxDeliveries, d.deadLettered)
+	if recorder, ok := statusHook.(AttemptRecorder); ok {
+		d.attempts = recorder
+	}
+	if recorder, ok := statusHook.(CompletionRecorder); ok {
+		d.completions = recorder
+	}
+	for _, name := range middlewareNames {
+		mw, ok := d.builtinMiddleware(name)
+		if !ok {
+			logger.WithField("middleware", name).Warn("unknown processing middleware, skipping")
+			continue
+		}
+		d.middlewares = append(d.middlewares, mw)
 	}
+	return d
 }
 
-func (d *Daemon) Start(ctx context.Context, apiCaller ExternalAPICaller) {
-	d.baseCtx = ctx
+// SetAPICaller replaces the external API caller, workers pick it up on their next read.
+// The replaced caller is closed, calls still running on it finish on the closed caller.
+func (d *Daemon) SetAPICaller(apiCaller ExternalAPICaller) {
+	d.callerMux.Lock()
+	old := d.apiCaller
+	d.apiCaller = apiCaller
+	d.callerMux.Unlock()
+	if closer, ok := old.(CallerCloser); ok && old != apiCaller {
+		if err := closer.Close(context.Background()); err != nil {
+			d.logger.WithError(err).Error("failed to close replaced external API caller")
+		}
+	}
+}
+
+// APICaller returns the current external API caller.
+func (d *Daemon) APICaller() ExternalAPICaller {
+	d.callerMux.RLock()
+	defer d.callerMux.RUnlock()
+	return d.apiCaller
+}
+
+// Start launches the workers, calls after the first one are no-ops.
+func (d *Daemon) Start(ctx context.Context) {
+	if !d.started.CompareAndSwap(false, true) {
+		d.logger.Warn("daemon is already started")
+		return
+	}
+	d.process = chain(d.processTask, d.middlewares...)
 	workerCtx, cancel := context.WithCancel(ctx)
 	d.workerCancel = cancel
 	for i := 0; i < d.numWorkers; i++ {
 		id := i + 1
-		go d.worker(workerCtx, apiCaller, id)
+		d.spawnWorker(workerCtx, id)
+	}
+	go d.runAccountingCheck(workerCtx, _accountingInterval)
+	go d.runDependencies(workerCtx)
+	go d.runWorkerMonitor(ctx, _workerMonitorInterval)
+	go d.runWorkersAlive(workerCtx, _workersAliveInterval)
+	go d.runActiveReaper(workerCtx, d.activeMaxAge)
+	go d.runResultSweeper(workerCtx)
+	go d.runQueueSampler(workerCtx, _queueSampleInterval)
+	go d.DrainRate.run(workerCtx)
+	if d.stopOnContextDone {
+		go d.stopOnDone(ctx)
 	}
 }
 
-func (d *Daemon) Stop(_ context.Context) error {
-	d.workerCancel()
+// stopOnDone runs Stop once ctx is cancelled, without it the workers exit but held tasks
+// are not recorded as not processed and the final metrics are not logged
+func (d *Daemon) stopOnDone(ctx context.Context) {
+	select {
+	case <-d.stopped:
+		return
+	case <-ctx.Done():
+	}
+	d.logger.WithError(ctx.Err()).Warn("daemon context is done, stopping")
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), _contextDoneStopTimeout)
+	defer cancel()
+	d.Stop(stopCtx)
+}
+
+// Stop waits for the workers to finish and closes the external API caller. It is safe
+// to call more than once and concurrently, later calls wait for the first one and return nil.
+func (d *Daemon) Stop(ctx context.Context) error {
+	d.stopOnce.Do(func() { d.stop(ctx) })
+	return nil
+}
+
+func (d *Daemon) stop(ctx context.Context) {
+	d.stopping.Store(true)
+	close(d.stopped)
+	if d.workerCancel != nil {
+		d.workerCancel()
+	}
 
 	doneCh := make(chan struct{})
 	go func() {
@@ -95,24 +390,47 @@ func (d *Daemon) Stop(_ context.Context) error {
 
 	<-doneCh
 
+	// held tasks stay pending in the stream, they are delivered again after a restart
+	if held := d.deps.take(); len(held) > 0 {
+		d.logger.WithField("held", len(held)).Info("leaving tasks waiting for dependencies pending")
+	}
 	d.logger.Info("submitted tasks:", d.Metrics.Recorder.GetSubmittedTasksTotal())
 	d.logger.Info("unavailable service:", d.Metrics.Recorder.GetUnavailableTotal())
 	d.logger.Info("errors:", d.Metrics.Recorder.GetTaskErrorsTotal())
 	d.logger.Info("timeouts:", d.Metrics.Recorder.GetTimeoutsTotal())
 	d.logger.Info("active tasks:", d.Metrics.Recorder.GetActiveTasksTotal())
 	d.logger.Info("All workers have stopped")
+	if closer, ok := d.APICaller().(CallerCloser); ok {
+		if err := closer.Close(ctx); err != nil {
+			d.logger.WithError(err).Error("failed to close external API caller")
+		}
+	}
+	d.checkAccounting()
 	d.logFinalMetrics()
-	return nil
+	close(d.done)
 }
 
-func (d *Daemon) worker(ctx context.Context, apiCaller ExternalAPICaller, workerID int) {
+// Done is closed once Stop finished, e.g. after the context passed to Start was cancelled,
+// so the caller can stop the rest of the service.
+func (d *Daemon) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *Daemon) worker(ctx context.Context, workerID int) {
+	startedAt := d.now()
 	for {
 		select {
 		case <-ctx.Done():
 			d.logger.WithFields(log.Fields{"workerId": workerID}).Info("stopped by context done")
 			return
 		default:
-			err := d.consumer.ConsumeTasks(ctx, apiCaller, workerID, d.handleTask)
+			// checked between reads, so tasks of the last read are already done
+			if d.maxWorkerLifetime > 0 && d.now().Sub(startedAt) >= d.maxWorkerLifetime {
+				d.logger.WithFields(log.Fields{"workerId": workerID}).Info("worker reached max lifetime, respawning")
+				d.spawnWorker(ctx, workerID)
+				return
+			}
+			err := d.consumer.ConsumeTasks(ctx, d.APICaller(), workerID, d.handleTask)
 			if err != nil {
 				d.logger.WithFields(log.Fields{"workerId": workerID, "error": err}).Error("error consuming tasks")
 			}
@@ -124,39 +442,216 @@ func (d *Daemon) handleTask(ctx context.Context, apiCaller bus.ExternalAPICaller
 	d.Wg.Add(1)
 	defer d.Wg.Done()
 
-	processingCtx, cancel := context.WithTimeout(ctx, time.Duration(3)*time.Second)
-	defer cancel()
+	logger := d.logger.WithFields(log.Fields{"workerId": workerID, "taskId": task.ID.String()})
+	ctx = logctx.WithLogger(ctx, logger)
 
-	d.logger.WithFields(log.Fields{"workerId": workerID, "taskId": task.ID.String()}).Info("start processing")
-	d.Metrics.Recorder.AddActiveTasks(1)
-	startedAt := time.Now()
+	if len(task.DependsOn) > 0 {
+		ready, err := d.checkDependencies(ctx, task)
+		if !ready {
+			return err
+		}
+	}
+
+	// do not hold the worker while the type is at its limit, other types may be waiting
+	release, ok := d.Registry.Acquire(task.Type, typeSlotWait)
+	if !ok {
+		logger.WithField("type", task.Type).Debug("task type is at its concurrency limit, requeueing")
+		return bus.ErrRequeue
+	}
+	defer release()
+
+	// past the limit tasks go back to the stream for other instances, the slot is reserved
+	// before the claim so workers running at once do not overshoot
+	if !d.reserveTask() {
+		return bus.ErrRequeue
+	}
+	claimed, err := d.states.Claim(ctx, task.ID)
+	if err != nil || !claimed {
+		d.unreserveTask()
+	}
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		logger.Info("task was cancelled, skipping")
+		d.Metrics.Recorder.IncCancelledTasks()
+		if task.StreamLogs {
+			d.taskLogs.Abandon(task.ID, "cancelled", "")
+		}
+		return nil
+	}
+	defer d.taskFinished()
+	if task.StreamLogs {
+		d.taskLogs.Watch(task.ID)
+		defer func() { d.taskLogs.Done(task.ID, err) }()
+	}
 	defer func() {
-		d.Metrics.Recorder.ObserveTaskDuration(time.Since(startedAt))
-		d.Metrics.Recorder.DecActiveTasks(1)
+		// a failed task fails the tasks depending on it, in at-least-once mode only once it
+		// is given up rather than retried, see deadLettered
+		var finish func(context.Context, uuid.UUID) error
+		switch {
+		case err == nil:
+			finish = d.states.Finish
+		case d.consumer.Mode() != bus.AtLeastOnce:
+			finish = d.states.Fail
+		}
+		if finish != nil {
+			if err := finish(ctx, task.ID); err != nil {
+				logger.WithField("error", err).Warn("failed to mark task done")
+			}
+		}
+		if err == nil {
+			d.recordCompletion(ctx, task)
+		}
+		d.recordResult(task, err)
+		d.deps.notify()
 	}()
 
-	err := apiCaller.GetSomething(processingCtx, task.ID.String(), workerID)
-	if err != nil {
-		var customErr *extapi.CustomError
-		if errors.As(err, &customErr) {
-			d.logger.WithFields(log.Fields{"workerId": workerID, "taskId": task.ID.String(), "error": customErr.Msg}).Error("External API error")
-			d.Metrics.Recorder.IncTaskError()
+	if !task.EnqueuedAt.IsZero() && !task.Warmup {
+		d.Metrics.Recorder.ObserveQueueWait(time.Since(task.EnqueuedAt))
+	}
+
+	processingCtx, cancel := d.processingWithTimeout(ctx, task)
+	defer cancel()
+
+	err = d.process(processingCtx, apiCaller, workerID, task)
+	return err
+}
+
+// processTask is the innermost processor, it calls the external API and records
+// the task as not processed on failure, failed warmup tasks are only logged and
+// reaped ones were already recorded
+func (d *Daemon) processTask(ctx context.Context, apiCaller bus.ExternalAPICaller, workerID int, task *domain.Task) error {
+	err := d.callWithContext(ctx, apiCaller, task, workerID)
+	if err != nil && !task.Warmup && !d.active.isReaped(task.ID) {
+		d.notProcessed(task.ID, notProcessedReason(err), time.Now())
+	}
+	return err
+}
+
+// deadLettered records a task given up after too many deliveries as not processed and fails
+// it, so tasks depending on it fail too
+func (d *Daemon) deadLettered(ctx context.Context, taskID uuid.UUID, reason string) {
+	d.logger.WithFields(log.Fields{"taskId": taskID.String(), "reason": reason}).Warn("task sent to the DLQ")
+	d.notProcessed(taskID, reasonMaxDeliveries, time.Now())
+	// whether its logs are streamed is in the message only, the final event is published anyway
+	d.taskLogs.Abandon(taskID, "dead_lettered", reason)
+	if err := d.states.Fail(ctx, taskID); err != nil {
+		d.logger.WithFields(log.Fields{"taskId": taskID.String(), "error": err}).Warn("failed to mark task failed")
+	}
+	d.deps.notify()
+}
+
+// LimitReached is closed once MaxTasks tasks finished, the daemon stops taking new ones then.
+func (d *Daemon) LimitReached() <-chan struct{} {
+	return d.limitReached
+}
+
+// RemainingTasks returns how many tasks may still be taken before the MaxTasks limit, -1 without a limit
+func (d *Daemon) RemainingTasks() int64 {
+	if d.maxTasks <= 0 {
+		return -1
+	}
+	return max(d.maxTasks-d.reserved.Load(), 0)
+}
+
+// reserveTask takes one of the MaxTasks slots, it returns false when none is left
+func (d *Daemon) reserveTask() bool {
+	if d.maxTasks <= 0 {
+		return true
+	}
+	for {
+		reserved := d.reserved.Load()
+		if reserved >= d.maxTasks {
+			return false
 		}
-		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-			d.Metrics.Recorder.IncTaskTimeout()
+		if d.reserved.CompareAndSwap(reserved, reserved+1) {
+			return true
 		}
-		d.Q.AddNotProcessedTask(task.ID.String())
+	}
+}
+
+// unreserveTask gives back the slot of a task which was not processed
+func (d *Daemon) unreserveTask() {
+	if d.maxTasks > 0 {
+		d.reserved.Add(-1)
+	}
+}
+
+func (d *Daemon) taskFinished() {
+	if d.maxTasks <= 0 || d.finished.Add(1) < d.maxTasks {
+		return
+	}
+	d.limitOnce.Do(func() {
+		d.logger.WithField("maxTasks", d.maxTasks).Info("max tasks reached, shutting down")
+		close(d.limitReached)
+	})
+}
+
+// sampleSuccessLog decides whether info logs of a task are written
+func (d *Daemon) sampleSuccessLog() bool {
+	return d.successLogRate >= 1 || (d.successLogRate > 0 && rand.Float64() < d.successLogRate)
+}
+
+func notProcessedReason(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return reasonTimeout
+	case errors.Is(err, context.Canceled):
+		return reasonShutdown
+	default:
+		return reasonError
+	}
+}
+
+// processingWithTimeout bounds the task by its own timeout when submitted with one,
+// then by its type timeout, falling back to the global one. ctx is the worker context
+// handed down by ConsumeTasks, so cancelling the workers in Stop cancels tasks in flight.
+func (d *Daemon) processingWithTimeout(ctx context.Context, task *domain.Task) (context.Context, context.CancelFunc) {
+	if task.Timeout > 0 {
+		return context.WithTimeout(ctx, task.Timeout)
+	}
+	return context.WithTimeout(ctx, d.Registry.Timeout(task.Type, d.taskTimeout))
+}
+
+// callWithContext returns as soon as ctx is done, so a caller which ignores
+// cancellation cannot hang the worker. Such a call is left to finish in background.
+func (d *Daemon) callWithContext(ctx context.Context, apiCaller bus.ExternalAPICaller, task *domain.Task, workerID int) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- apiCaller.GetSomething(ctx, task.ID.String(), workerID)
+	}()
+
+	select {
+	case err := <-errCh:
 		return err
+	case <-ctx.Done():
 	}
 
-	d.Metrics.Recorder.IncProcessedTasks(true)
-	return nil
+	// a caller honouring ctx returns right away, only report the ones that did not
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(callAbandonGrace):
+		d.loggerFromContext(ctx).WithField("error", ctx.Err()).Warn("external API call did not return on context done, abandoning it")
+		return ctx.Err()
+	}
+}
+
+// loggerFromContext returns the task's log entry set by handleTask, or the daemon logger
+func (d *Daemon) loggerFromContext(ctx context.Context) *log.Entry {
+	if logger, ok := logctx.LoggerFromContext(ctx); ok {
+		return logger
+	}
+	return log.NewEntry(d.logger)
 }
 
 func (d *Daemon) logFinalMetrics() {
 	metrics := d.Metrics.Recorder.GetMetrics()
-	metrics["not_processed_tasks_count"] = uint64(len(d.Q.GetAllNotProcessedTasks()))
+	metrics["not_processed_tasks_count"] = d.Q.CountNotProcessedTasks()
 	metrics["not_processed_tasks"] = d.Q.GetAllNotProcessedTasks()
+	metrics["accounting"] = d.Accounting()
+	metrics["remaining_tasks"] = d.RemainingTasks()
 	formattedMetrics, err := json.MarshalIndent(metrics, "", "  ")
 	if err != nil {
 		d.logger.WithError(err).Error("Failed to format metrics")
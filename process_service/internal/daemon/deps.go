@@ -0,0 +1,247 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+)
+
+const (
+	defaultMaxHeldTasks      = 1000
+	defaultDependencyTimeout = 10 * time.Minute
+	// dependencyPollInterval is how often held tasks are checked for dependencies finished by other instances
+	dependencyPollInterval = time.Second
+	// dependencyWorkerID marks log lines of held tasks released outside the workers
+	dependencyWorkerID = 0
+
+	reasonDependencyFailed  = "dependency_failed"
+	reasonDependencyTimeout = "dependency_timeout"
+)
+
+// heldTask stays pending in its stream while it waits, so a crash does not lose it
+type heldTask struct {
+	task   *domain.Task
+	heldAt time.Time
+}
+
+// DependencyTracker holds tasks until the tasks they depend on have finished.
+// Completions of this instance wake it up right away, completions of other
+// instances are noticed by polling the task states.
+type DependencyTracker struct {
+	mux     *sync.Mutex
+	held    map[uuid.UUID]heldTask
+	maxHeld int
+	maxWait time.Duration
+	wake    chan struct{}
+}
+
+func NewDependencyTracker(maxHeld int, maxWait time.Duration) *DependencyTracker {
+	if maxHeld <= 0 {
+		maxHeld = defaultMaxHeldTasks
+	}
+	if maxWait <= 0 {
+		maxWait = defaultDependencyTimeout
+	}
+	return &DependencyTracker{
+		mux:     &sync.Mutex{},
+		held:    make(map[uuid.UUID]heldTask),
+		maxHeld: maxHeld,
+		maxWait: maxWait,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Held returns the number of tasks waiting for their dependencies.
+func (t *DependencyTracker) Held() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return len(t.held)
+}
+
+// hold keeps the task until its dependencies finish, it returns false when the tracker is full
+func (t *DependencyTracker) hold(task *domain.Task, now time.Time) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.held[task.ID]; !ok && len(t.held) >= t.maxHeld {
+		return false
+	}
+	t.held[task.ID] = heldTask{task: task, heldAt: now}
+	return true
+}
+
+// notify wakes the tracker up after a task finished
+func (t *DependencyTracker) notify() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// snapshot returns the held tasks without removing them
+func (t *DependencyTracker) snapshot() []heldTask {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	res := make([]heldTask, 0, len(t.held))
+	for _, h := range t.held {
+		res = append(res, h)
+	}
+	return res
+}
+
+func (t *DependencyTracker) remove(id uuid.UUID) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.held, id)
+}
+
+// take removes and returns all held tasks
+func (t *DependencyTracker) take() []heldTask {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	res := make([]heldTask, 0, len(t.held))
+	for id, h := range t.held {
+		res = append(res, h)
+		delete(t.held, id)
+	}
+	return res
+}
+
+// checkDependencies reports whether the task may run now. Otherwise it is held
+// until its dependencies finish or given up on when one of them failed.
+func (d *Daemon) checkDependencies(ctx context.Context, task *domain.Task) (bool, error) {
+	status, err := d.states.Dependencies(ctx, task.DependsOn)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case bus.DependenciesDone:
+		return true, d.ackReady(ctx, task)
+	case bus.DependenciesFailed:
+		if !d.failOnDependencyFailure {
+			return true, d.ackReady(ctx, task)
+		}
+		d.dependencyFailed(ctx, task, reasonDependencyFailed)
+		return false, nil
+	}
+
+	if !d.deps.hold(task, d.now()) {
+		d.loggerFromContext(ctx).Debug("too many tasks wait for dependencies, requeueing")
+		return false, bus.ErrRequeue
+	}
+	d.loggerFromContext(ctx).WithField("dependsOn", task.DependsOn).Info("waiting for dependencies")
+	return false, bus.ErrHeld
+}
+
+// ackReady acks a task which may run now in at-most-once mode, it stayed pending while it
+// waited. In at-least-once mode it is acked once it succeeded.
+func (d *Daemon) ackReady(ctx context.Context, task *domain.Task) error {
+	if d.consumer.Mode() == bus.AtLeastOnce {
+		return nil
+	}
+	return d.consumer.Ack(ctx, task)
+}
+
+// dependencyFailed gives up on the task, acks and fails it, so tasks depending on it fail too
+func (d *Daemon) dependencyFailed(ctx context.Context, task *domain.Task, reason string) {
+	d.logger.WithFields(log.Fields{"taskId": task.ID.String(), "reason": reason}).Warn("task dependencies did not succeed, not processing it")
+	d.notProcessed(task.ID, reason, time.Now())
+	if task.StreamLogs {
+		d.taskLogs.Abandon(task.ID, "failed", reason)
+	}
+	if err := d.consumer.Ack(ctx, task); err != nil {
+		d.logger.WithFields(log.Fields{"taskId": task.ID.String(), "error": err}).Warn("failed to ack task")
+	}
+	if err := d.states.Fail(ctx, task.ID); err != nil {
+		d.logger.WithFields(log.Fields{"taskId": task.ID.String(), "error": err}).Warn("failed to mark task failed")
+	}
+	d.deps.notify()
+}
+
+// runDependencies releases held tasks whose dependencies finished, expires the ones
+// waiting longer than the dependency timeout and keeps the rest pending, until ctx is done
+func (d *Daemon) runDependencies(ctx context.Context) {
+	ticker := time.NewTicker(dependencyPollInterval)
+	defer ticker.Stop()
+	lastRefresh := d.now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.deps.wake:
+		}
+
+		held := d.deps.snapshot()
+		if d.now().Sub(lastRefresh) >= bus.HeldRefreshInterval {
+			lastRefresh = d.now()
+			d.keepPending(ctx, held)
+		}
+		for _, h := range held {
+			if d.now().Sub(h.heldAt) > d.deps.maxWait {
+				d.deps.remove(h.task.ID)
+				d.dependencyFailed(ctx, h.task, reasonDependencyTimeout)
+				continue
+			}
+			status, err := d.states.Dependencies(ctx, h.task.DependsOn)
+			if err != nil {
+				d.logger.WithFields(log.Fields{"taskId": h.task.ID.String(), "error": err}).Warn("failed to check task dependencies")
+				continue
+			}
+			if status == bus.DependenciesPending {
+				continue
+			}
+			d.deps.remove(h.task.ID)
+			go d.runReleased(ctx, h.task)
+		}
+	}
+}
+
+// keepPending refreshes the stream messages of held tasks, so workers do not reclaim them
+func (d *Daemon) keepPending(ctx context.Context, held []heldTask) {
+	if len(held) == 0 {
+		return
+	}
+	tasks := make([]*domain.Task, 0, len(held))
+	for _, h := range held {
+		tasks = append(tasks, h.task)
+	}
+	if err := d.consumer.KeepPending(ctx, tasks); err != nil {
+		d.logger.WithFields(log.Fields{"held": len(tasks), "error": err}).Warn("failed to keep held tasks pending")
+	}
+}
+
+// runReleased processes a task whose dependencies finished, bounded by the number of workers.
+// Its stream message is acked like the consumer would, a failed one stays pending for a retry
+// in at-least-once mode and one not run before shutdown is delivered again.
+func (d *Daemon) runReleased(ctx context.Context, task *domain.Task) {
+	select {
+	case d.releaseSem <- struct{}{}:
+		defer func() { <-d.releaseSem }()
+	case <-ctx.Done():
+		return
+	}
+
+	err := d.handleTask(ctx, d.APICaller(), dependencyWorkerID, task)
+	switch {
+	case errors.Is(err, bus.ErrHeld):
+	// a released task has no stream message to go back to, it waits in the tracker instead
+	case errors.Is(err, bus.ErrRequeue):
+		if d.deps.hold(task, d.now()) {
+			return
+		}
+		d.notProcessed(task.ID, reasonError, time.Now())
+		err = nil
+	}
+	if err == nil {
+		if err := d.consumer.Ack(ctx, task); err != nil {
+			d.logger.WithFields(log.Fields{"taskId": task.ID.String(), "error": err}).Warn("failed to ack released task")
+		}
+	}
+}
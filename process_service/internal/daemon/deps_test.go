@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+)
+
+// testRedis returns a client of the redis at TEST_REDIS_ADDR, the test is skipped without one
+func testRedis(t testing.TB) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR is not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { rdb.Close() })
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("redis at %s is not reachable: %v", addr, err)
+	}
+	return rdb
+}
+
+// newDependencyDaemon returns a daemon keeping task states in rdb which releases held
+// tasks until the test ends. Its caller records the tasks it ran.
+func newDependencyDaemon(t *testing.T, rdb *redis.Client, failOnDependencyFailure bool) (*Daemon, *fakeQueue, *recordingCaller) {
+	t.Helper()
+	d, q := newTestDaemon(time.Now())
+	caller := &recordingCaller{}
+	d.apiCaller = caller
+	d.callerMux = &sync.RWMutex{}
+	d.Wg = &sync.WaitGroup{}
+	d.Registry = NewHandlerRegistry()
+	d.taskTimeout = time.Second
+	d.process = chain(d.processTask)
+	d.states = bus.NewTaskStates(rdb)
+	d.consumer = bus.NewConsumer(rdb, nil, 1, 1, bus.AtMostOnce)
+	d.deps = NewDependencyTracker(10, time.Minute)
+	d.releaseSem = make(chan struct{}, 1)
+	d.failOnDependencyFailure = failOnDependencyFailure
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go d.runDependencies(ctx)
+	t.Cleanup(func() {
+		cancel()
+		d.Wg.Wait()
+	})
+	return d, q, caller
+}
+
+// dependencyChain returns tasks A, B and C where B depends on A and C on B
+func dependencyChain(t *testing.T, rdb *redis.Client) (a, b, c *domain.Task) {
+	t.Helper()
+	a = &domain.Task{ID: uuid.New()}
+	b = &domain.Task{ID: uuid.New(), DependsOn: []uuid.UUID{a.ID}}
+	c = &domain.Task{ID: uuid.New(), DependsOn: []uuid.UUID{b.ID}}
+	t.Cleanup(func() {
+		for _, task := range []*domain.Task{a, b, c} {
+			rdb.Del(context.Background(), "tasks:state:"+task.ID.String())
+		}
+	})
+	return a, b, c
+}
+
+// waitFor fails the test when cond does not hold within a few dependency poll intervals
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * dependencyPollInterval)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (c *recordingCaller) called() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.tasks)
+}
+
+func TestDependencyChainRunsInOrder(t *testing.T) {
+	rdb := testRedis(t)
+	d, _, caller := newDependencyDaemon(t, rdb, true)
+	a, b, c := dependencyChain(t, rdb)
+	ctx := context.Background()
+
+	// delivered in reverse, the dependents wait for the tasks before them
+	for _, task := range []*domain.Task{c, b} {
+		if err := d.handleTask(ctx, d.APICaller(), 1, task); !errors.Is(err, bus.ErrHeld) {
+			t.Fatalf("handleTask(%s) = %v, want %v", task.ID, err, bus.ErrHeld)
+		}
+	}
+	if got := d.deps.Held(); got != 2 {
+		t.Fatalf("held = %d, want 2", got)
+	}
+	if err := d.handleTask(ctx, d.APICaller(), 1, a); err != nil {
+		t.Fatalf("handleTask(A) = %v", err)
+	}
+
+	waitFor(t, "the chain to run", func() bool { return len(caller.called()) == 3 })
+	want := []string{a.ID.String(), b.ID.String(), c.ID.String()}
+	if got := caller.called(); !slices.Equal(got, want) {
+		t.Errorf("ran %v, want A, B, C in order %v", got, want)
+	}
+	if got := d.deps.Held(); got != 0 {
+		t.Errorf("held = %d after the chain ran, want 0", got)
+	}
+}
+
+func TestFailedDependencyFailsDependents(t *testing.T) {
+	rdb := testRedis(t)
+	d, q, caller := newDependencyDaemon(t, rdb, true)
+	a, b, c := dependencyChain(t, rdb)
+	ctx := context.Background()
+
+	for _, task := range []*domain.Task{b, c} {
+		if err := d.handleTask(ctx, d.APICaller(), 1, task); !errors.Is(err, bus.ErrHeld) {
+			t.Fatalf("handleTask(%s) = %v, want %v", task.ID, err, bus.ErrHeld)
+		}
+	}
+	if err := d.handleTask(ctx, failingCaller{err: errors.New("unavailable")}, 1, a); err == nil {
+		t.Fatal("handleTask(A) = nil, want the caller error")
+	}
+
+	notProcessed := func(task *domain.Task) []string {
+		q.mux.Lock()
+		defer q.mux.Unlock()
+		return slices.Clone(q.reasons[task.ID.String()])
+	}
+	waitFor(t, "the dependents to fail", func() bool { return len(notProcessed(c)) > 0 })
+	for name, task := range map[string]*domain.Task{"B": b, "C": c} {
+		if got := notProcessed(task); !slices.Equal(got, []string{reasonDependencyFailed}) {
+			t.Errorf("%s not processed for %q, want %q", name, got, reasonDependencyFailed)
+		}
+		status, err := d.states.Dependencies(ctx, []uuid.UUID{task.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != bus.DependenciesFailed {
+			t.Errorf("%s state = %v, want failed", name, status)
+		}
+	}
+	if got := caller.called(); len(got) != 0 {
+		t.Errorf("dependents of a failed task ran: %v", got)
+	}
+	if got := d.deps.Held(); got != 0 {
+		t.Errorf("held = %d, want 0", got)
+	}
+}
+
+func TestFailedDependencyRunsDependentsWhenConfigured(t *testing.T) {
+	rdb := testRedis(t)
+	d, q, caller := newDependencyDaemon(t, rdb, false)
+	a, b, _ := dependencyChain(t, rdb)
+	ctx := context.Background()
+
+	if err := d.handleTask(ctx, d.APICaller(), 1, b); !errors.Is(err, bus.ErrHeld) {
+		t.Fatalf("handleTask(B) = %v, want %v", err, bus.ErrHeld)
+	}
+	if err := d.handleTask(ctx, failingCaller{err: errors.New("unavailable")}, 1, a); err == nil {
+		t.Fatal("handleTask(A) = nil, want the caller error")
+	}
+
+	waitFor(t, "B to run", func() bool { return len(caller.called()) == 1 })
+	if got := caller.called(); got[0] != b.ID.String() {
+		t.Errorf("ran %v, want B", got)
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if got := q.reasons[b.ID.String()]; len(got) != 0 {
+		t.Errorf("B not processed for %q, want it processed", got)
+	}
+}
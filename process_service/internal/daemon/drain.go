@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDrainRateWindow = 5 * time.Second
+	defaultDrainRateAlpha  = 0.3
+)
+
+// DrainRate estimates how many tasks per second are done with and leave the queue.
+// Completions are counted per window and folded into an exponentially weighted
+// moving average, a higher alpha follows changes faster but is noisier.
+type DrainRate struct {
+	window  time.Duration
+	alpha   float64
+	count   atomic.Int64
+	mux     *sync.Mutex
+	rate    float64
+	started bool
+}
+
+func NewDrainRate(window time.Duration, alpha float64) *DrainRate {
+	if window <= 0 {
+		window = defaultDrainRateWindow
+	}
+	if alpha <= 0 || alpha > 1 {
+		alpha = defaultDrainRateAlpha
+	}
+	return &DrainRate{window: window, alpha: alpha, mux: &sync.Mutex{}}
+}
+
+// Observe counts a completed task.
+func (r *DrainRate) Observe() {
+	r.count.Add(1)
+}
+
+// Rate returns the estimated completions per second.
+func (r *DrainRate) Rate() float64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.rate
+}
+
+// fold closes the current window, the first window seeds the average
+func (r *DrainRate) fold(elapsed time.Duration) float64 {
+	sample := float64(r.count.Swap(0)) / elapsed.Seconds()
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !r.started {
+		r.rate, r.started = sample, true
+	} else {
+		r.rate = r.alpha*sample + (1-r.alpha)*r.rate
+	}
+	if math.IsNaN(r.rate) || math.IsInf(r.rate, 0) {
+		r.rate = 0
+	}
+	return r.rate
+}
+
+// run folds a window every tick until ctx is done, Rate serves the estimate to the
+// drain_rate_per_second gauge
+func (r *DrainRate) run(ctx context.Context) {
+	ticker := time.NewTicker(r.window)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.fold(now.Sub(last))
+			last = now
+		}
+	}
+}
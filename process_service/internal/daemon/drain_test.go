@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+)
+
+func TestDrainRateCountsCompletions(t *testing.T) {
+	d, _ := newTestDaemon(time.Now())
+	process := chain(d.processTask, d.metricsMiddleware)
+	ctx := context.Background()
+
+	process(ctx, callerReturning(nil), 1, &domain.Task{ID: uuid.New()})
+	process(ctx, callerReturning(errors.New("api error")), 1, &domain.Task{ID: uuid.New()})
+	process(ctx, callerReturning(nil), 1, &domain.Task{ID: uuid.New(), Warmup: true})
+
+	if got := d.DrainRate.count.Load(); got != 2 {
+		t.Errorf("observed %d completions, want 2", got)
+	}
+}
+
+func TestDrainRateSkipsRequeuedTasks(t *testing.T) {
+	d, _ := newTestDaemon(time.Now())
+	d.Wg = &sync.WaitGroup{}
+	d.Registry = NewHandlerRegistry()
+	d.maxTasks = 1
+	d.reserveTask()
+
+	err := d.handleTask(context.Background(), callerReturning(nil), 1, &domain.Task{ID: uuid.New()})
+	if !errors.Is(err, bus.ErrRequeue) {
+		t.Fatalf("handleTask() = %v, want ErrRequeue", err)
+	}
+	if got := d.DrainRate.count.Load(); got != 0 {
+		t.Errorf("observed %d completions for a requeued task, want 0", got)
+	}
+}
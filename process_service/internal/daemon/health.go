@@ -0,0 +1,28 @@
+package daemon
+
+import (
+	"context"
+
+	"process_service/internal/metrics"
+)
+
+// Health reports the workers and the tasks in the daemon for /health, with the tasks
+// left before MaxTasks when it is set. It is down when CheckWorkers fails.
+func (d *Daemon) Health(context.Context) metrics.ComponentHealth {
+	health := metrics.ComponentHealth{
+		Status: metrics.HealthOK,
+		Details: map[string]any{
+			"workers":      d.numWorkers,
+			"live_workers": d.LiveWorkers(),
+			"active_tasks": d.Metrics.Recorder.GetActiveTasksTotal(),
+			"held_tasks":   d.deps.Held(),
+		},
+	}
+	if remaining := d.RemainingTasks(); remaining >= 0 {
+		health.Details["remaining_tasks"] = remaining
+	}
+	if err := d.CheckWorkers(); err != nil {
+		health.Status, health.Error = metrics.HealthDown, err.Error()
+	}
+	return health
+}
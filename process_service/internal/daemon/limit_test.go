@@ -0,0 +1,66 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReserveTaskDoesNotOvershoot(t *testing.T) {
+	d := &Daemon{maxTasks: 5}
+
+	var reserved atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d.reserveTask() {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := reserved.Load(); got != 5 {
+		t.Errorf("reserved %d slots, want 5", got)
+	}
+	if got := d.RemainingTasks(); got != 0 {
+		t.Errorf("RemainingTasks() = %d, want 0", got)
+	}
+	d.unreserveTask()
+	if got := d.RemainingTasks(); got != 1 {
+		t.Errorf("RemainingTasks() after a release = %d, want 1", got)
+	}
+}
+
+func TestReserveTaskWithoutLimit(t *testing.T) {
+	d := &Daemon{}
+	for i := 0; i < 10; i++ {
+		if !d.reserveTask() {
+			t.Fatal("reserveTask() = false without a limit")
+		}
+	}
+	if got := d.RemainingTasks(); got != -1 {
+		t.Errorf("RemainingTasks() = %d, want -1", got)
+	}
+}
+
+func TestHealthReportsRemainingTasks(t *testing.T) {
+	d, _ := newTestDaemon(time.Now())
+	d.deps = NewDependencyTracker(0, 0)
+	d.maxTasks = 3
+	d.reserveTask()
+
+	health := d.Health(context.Background())
+	if got := health.Details["remaining_tasks"]; got != int64(2) {
+		t.Errorf("remaining_tasks = %v, want 2", got)
+	}
+
+	d.maxTasks = 0
+	if _, ok := d.Health(context.Background()).Details["remaining_tasks"]; ok {
+		t.Error("remaining_tasks reported without a limit")
+	}
+}
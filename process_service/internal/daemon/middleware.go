@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"process_service/extapi"
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+)
+
+const (
+	MiddlewareLogging  = "logging"
+	MiddlewareMetrics  = "metrics"
+	MiddlewareAttempts = "attempts"
+)
+
+// attemptRecordTimeout bounds persisting a single attempt
+const attemptRecordTimeout = 2 * time.Second
+
+// defaultMiddlewares are used when the config does not list any, outermost first.
+// Attempts are opt-in, they cost an insert per attempt on the worker path.
+var defaultMiddlewares = []string{MiddlewareLogging, MiddlewareMetrics}
+
+// Processor processes a claimed task within its timeout.
+type Processor func(ctx context.Context, apiCaller bus.ExternalAPICaller, workerID int, task *domain.Task) error
+
+// Middleware wraps a Processor with a cross-cutting concern, e.g. logging or retries.
+type Middleware func(next Processor) Processor
+
+// chain wraps p with middlewares, the first one ends up outermost
+func chain(p Processor, middlewares ...Middleware) Processor {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		p = middlewares[i](p)
+	}
+	return p
+}
+
+// builtinMiddleware returns the built-in middleware registered under name
+func (d *Daemon) builtinMiddleware(name string) (Middleware, bool) {
+	switch name {
+	case MiddlewareLogging:
+		return d.loggingMiddleware, true
+	case MiddlewareMetrics:
+		return d.metricsMiddleware, true
+	case MiddlewareAttempts:
+		return d.attemptsMiddleware, true
+	default:
+		return nil, false
+	}
+}
+
+// Use appends middlewares inside the configured ones, closest to the external call.
+// It must be called before Start.
+func (d *Daemon) Use(middlewares ...Middleware) {
+	d.middlewares = append(d.middlewares, middlewares...)
+}
+
+// loggingMiddleware writes the sampled start log and logs external API errors
+func (d *Daemon) loggingMiddleware(next Processor) Processor {
+	return func(ctx context.Context, apiCaller bus.ExternalAPICaller, workerID int, task *domain.Task) error {
+		if d.sampleSuccessLog() {
+			d.loggerFromContext(ctx).WithField("tags", task.Tags).Info("start processing")
+		} else {
+			ctx = extapi.WithoutSuccessLog(ctx)
+		}
+		err := next(ctx, apiCaller, workerID, task)
+		var customErr *extapi.CustomError
+		if errors.As(err, &customErr) {
+			d.loggerFromContext(ctx).WithField("error", customErr.Msg).Error("External API error")
+		}
+		return err
+	}
+}
+
+// metricsMiddleware records task counters and durations, the accounting check relies on them.
+// Warmup tasks are not counted.
+func (d *Daemon) metricsMiddleware(next Processor) Processor {
+	return func(ctx context.Context, apiCaller bus.ExternalAPICaller, workerID int, task *domain.Task) error {
+		if task.Warmup {
+			return next(ctx, apiCaller, workerID, task)
+		}
+		rec := d.Metrics.Recorder
+		// active goes first, so the accounting check never sees a received task in no bucket
+		rec.AddActiveTasks(1)
+		rec.IncReceivedTasks()
+		d.ledger.set(task.ID, outcomeActive)
+		startedAt := time.Now()
+		activeKey := d.active.add(task.ID, workerID, startedAt)
+		removed := false
+		defer func() {
+			// a panicking task still leaves the active gauge
+			if !removed && d.active.remove(activeKey, task.ID) {
+				rec.DecActiveTasks(1)
+			}
+		}()
+
+		err := next(ctx, apiCaller, workerID, task)
+		rec.ObserveTaskDuration(time.Since(startedAt))
+		removed = true
+		// the reaper already corrected the gauge and recorded a task it reclaimed as not processed
+		if !d.active.remove(activeKey, task.ID) {
+			return err
+		}
+		// only tasks done with count towards the drain rate, requeued ones return before
+		d.DrainRate.Observe()
+		if err == nil {
+			// the outcome goes before leaving the gauge, see Accounting
+			d.ledger.set(task.ID, outcomeProcessed)
+		}
+		rec.DecActiveTasks(1)
+		if err != nil {
+			var customErr *extapi.CustomError
+			if errors.As(err, &customErr) {
+				rec.IncTaskError()
+			}
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+				rec.IncTaskTimeout()
+			}
+			return err
+		}
+		rec.IncProcessedTasks(true)
+		rec.IncTaggedTask(task.Tags)
+		return nil
+	}
+}
+
+// AttemptRecorder is optionally implemented by the task status updater to persist attempt history.
+type AttemptRecorder interface {
+	RecordAttempt(ctx context.Context, taskID uuid.UUID, attempt domain.AttemptRecord) error
+}
+
+// attemptsMiddleware records every call of next in the task's attempt history,
+// so retries done by middlewares inside it are recorded one by one
+func (d *Daemon) attemptsMiddleware(next Processor) Processor {
+	return func(ctx context.Context, apiCaller bus.ExternalAPICaller, workerID int, task *domain.Task) error {
+		startedAt := time.Now()
+		err := next(ctx, apiCaller, workerID, task)
+		attempt := domain.AttemptRecord{At: startedAt, Duration: time.Since(startedAt)}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		task.Attempts = append(task.Attempts, attempt)
+
+		if d.attempts != nil && !task.Warmup {
+			// the processing ctx may be done already, the history is still worth keeping
+			recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), attemptRecordTimeout)
+			defer cancel()
+			if recErr := d.attempts.RecordAttempt(recordCtx, task.ID, attempt); recErr != nil {
+				d.loggerFromContext(ctx).WithField("error", recErr).Warn("failed to record task attempt")
+			}
+		}
+		return err
+	}
+}
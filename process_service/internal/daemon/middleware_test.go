@@ -0,0 +1,204 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"process_service/extapi"
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+)
+
+type fakeAttempts struct {
+	mux      sync.Mutex
+	attempts []domain.AttemptRecord
+}
+
+func (f *fakeAttempts) RecordAttempt(_ context.Context, _ uuid.UUID, attempt domain.AttemptRecord) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.attempts = append(f.attempts, attempt)
+	return nil
+}
+
+// retryUntilSuccess calls next until it succeeds, like a retry middleware inside attempts would
+func retryUntilSuccess(maxCalls int) Middleware {
+	return func(next Processor) Processor {
+		return func(ctx context.Context, apiCaller bus.ExternalAPICaller, workerID int, task *domain.Task) error {
+			var err error
+			for i := 0; i < maxCalls; i++ {
+				if err = next(ctx, apiCaller, workerID, task); err == nil {
+					return nil
+				}
+			}
+			return err
+		}
+	}
+}
+
+// tracing records when it is entered and left in calls
+func tracing(name string, calls *[]string) Middleware {
+	return func(next Processor) Processor {
+		return func(ctx context.Context, apiCaller bus.ExternalAPICaller, workerID int, task *domain.Task) error {
+			*calls = append(*calls, name+" before")
+			err := next(ctx, apiCaller, workerID, task)
+			*calls = append(*calls, name+" after")
+			return err
+		}
+	}
+}
+
+func TestChainComposesMiddlewares(t *testing.T) {
+	errFailed := errors.New("failed")
+	var calls []string
+	handler := func(context.Context, bus.ExternalAPICaller, int, *domain.Task) error {
+		calls = append(calls, "handler")
+		return errFailed
+	}
+	process := chain(handler, tracing("outer", &calls), tracing("inner", &calls))
+
+	if err := process(context.Background(), nil, 1, &domain.Task{ID: uuid.New()}); !errors.Is(err, errFailed) {
+		t.Errorf("process() = %v, want the handler error %v", err, errFailed)
+	}
+	want := []string{"outer before", "inner before", "handler", "inner after", "outer after"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestBuiltinMiddleware(t *testing.T) {
+	d := &Daemon{logger: log.New()}
+	for _, name := range []string{MiddlewareLogging, MiddlewareMetrics, MiddlewareAttempts} {
+		if mw, ok := d.builtinMiddleware(name); !ok || mw == nil {
+			t.Errorf("builtinMiddleware(%q) is missing", name)
+		}
+	}
+	if _, ok := d.builtinMiddleware("tracing"); ok {
+		t.Error("builtinMiddleware(\"tracing\") = true, want unknown")
+	}
+}
+
+func TestAttemptsMiddlewareRecordsEveryCall(t *testing.T) {
+	recorder := &fakeAttempts{}
+	d := &Daemon{logger: log.New(), attempts: recorder}
+
+	calls := 0
+	handler := func(context.Context, bus.ExternalAPICaller, int, *domain.Task) error {
+		calls++
+		if calls <= 2 {
+			return errors.New("flaky")
+		}
+		return nil
+	}
+	process := chain(handler, retryUntilSuccess(5), d.attemptsMiddleware)
+
+	task := &domain.Task{ID: uuid.New()}
+	if err := process(context.Background(), nil, 1, task); err != nil {
+		t.Fatalf("process() = %v, want nil", err)
+	}
+
+	if len(recorder.attempts) != 3 || len(task.Attempts) != 3 {
+		t.Fatalf("recorded %d attempts, task has %d, want 3", len(recorder.attempts), len(task.Attempts))
+	}
+	for i, want := range []string{"flaky", "flaky", ""} {
+		if got := recorder.attempts[i].Error; got != want {
+			t.Errorf("attempt %d error = %q, want %q", i+1, got, want)
+		}
+	}
+}
+
+func TestAttemptsAreOptIn(t *testing.T) {
+	for _, name := range defaultMiddlewares {
+		if name == MiddlewareAttempts {
+			t.Fatalf("attempts middleware is on by default")
+		}
+	}
+}
+
+func TestSuccessLogSampling(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      float64
+		err       error
+		wantStart bool
+		wantError bool
+	}{
+		{"all success logs", 1, nil, true, false},
+		{"success logs off", 0, nil, false, false},
+		{"errors logged with success logs off", 0, &extapi.CustomError{Msg: "boom"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := test.NewNullLogger()
+			d := &Daemon{logger: logger, successLogRate: tt.rate}
+			handler := func(context.Context, bus.ExternalAPICaller, int, *domain.Task) error { return tt.err }
+			process := chain(handler, d.loggingMiddleware)
+
+			if err := process(context.Background(), nil, 1, &domain.Task{ID: uuid.New()}); err != tt.err {
+				t.Fatalf("process() = %v, want %v", err, tt.err)
+			}
+			var gotStart, gotError bool
+			for _, entry := range hook.AllEntries() {
+				gotStart = gotStart || entry.Message == "start processing"
+				gotError = gotError || entry.Message == "External API error"
+			}
+			if gotStart != tt.wantStart {
+				t.Errorf("start log written = %t, want %t", gotStart, tt.wantStart)
+			}
+			if gotError != tt.wantError {
+				t.Errorf("error log written = %t, want %t", gotError, tt.wantError)
+			}
+		})
+	}
+}
+
+// failingCaller fails every call with err
+type failingCaller struct {
+	err error
+}
+
+func (c failingCaller) GetSomething(context.Context, string, int) error { return c.err }
+
+func TestWarmupTasksAreNotCounted(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller bus.ExternalAPICaller
+	}{
+		{"succeeded", &recordingCaller{}},
+		{"failed", failingCaller{err: errors.New("unavailable")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, q := newTestDaemon(time.Now())
+			process := chain(d.processTask, d.metricsMiddleware)
+
+			task := &domain.Task{ID: uuid.New(), Warmup: true}
+			_ = process(context.Background(), tt.caller, 1, task)
+
+			if caller, ok := tt.caller.(*recordingCaller); ok && !slices.Equal(caller.tasks, []string{task.ID.String()}) {
+				t.Errorf("caller called for %q, want the warmup task", caller.tasks)
+			}
+			rec := d.Metrics.Recorder
+			if got := rec.GetReceivedTasksTotal(); got != 0 {
+				t.Errorf("received = %d, want 0", got)
+			}
+			if got := rec.GetProcessedTasksTotal(); got != 0 {
+				t.Errorf("processed = %d, want 0", got)
+			}
+			if got := rec.GetTaskErrorsTotal(); got != 0 {
+				t.Errorf("task errors = %d, want 0", got)
+			}
+			if got := q.CountNotProcessedTasks(); got != 0 {
+				t.Errorf("%d tasks recorded as not processed, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"process_service/internal/bus"
+)
+
+// _queueSampleInterval matches the queue depth sampling of the submit service
+const _queueSampleInterval = 5 * time.Second
+
+// runQueueSampler periodically records the queue depth and the age of the oldest
+// pending task until ctx is done, so max_queue_depth and oldest_pending_task_age_seconds
+// are set on this service too
+func (d *Daemon) runQueueSampler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := d.consumer.QueueStats(ctx)
+		if err != nil {
+			d.logger.WithError(err).Debug("failed to sample queue depth")
+		} else {
+			d.recordQueueStats(stats)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordQueueStats sets the queue gauges from a sample, an empty queue has no pending age
+func (d *Daemon) recordQueueStats(stats bus.QueueStats) {
+	d.Metrics.Recorder.SetQueueDepth(stats.Depth)
+	var age time.Duration
+	if !stats.OldestEnqueuedAt.IsZero() {
+		age = d.now().Sub(stats.OldestEnqueuedAt)
+	}
+	d.Metrics.Recorder.SetOldestPendingAge(age)
+}
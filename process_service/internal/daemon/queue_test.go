@@ -0,0 +1,30 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"process_service/internal/bus"
+)
+
+func TestRecordQueueStats(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	d, _ := newTestDaemon(now)
+	rec := d.Metrics.Recorder
+
+	d.recordQueueStats(bus.QueueStats{Depth: 7, OldestEnqueuedAt: now.Add(-90 * time.Second)})
+	if got := rec.GetQueueDepth(); got != 7 {
+		t.Errorf("queue depth = %d, want 7", got)
+	}
+	if got := rec.GetOldestPendingAge(); got != 90 {
+		t.Errorf("oldest pending age = %v, want 90", got)
+	}
+
+	d.recordQueueStats(bus.QueueStats{})
+	if got := rec.GetOldestPendingAge(); got != 0 {
+		t.Errorf("oldest pending age of an empty queue = %v, want 0", got)
+	}
+	if got := rec.GetMaxQueueDepth(); got != 7 {
+		t.Errorf("max queue depth = %d, want 7", got)
+	}
+}
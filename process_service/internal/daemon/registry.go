@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+// HandlerRegistry keeps per task type processing settings.
+type HandlerRegistry struct {
+	mux      *sync.RWMutex
+	timeouts map[string]time.Duration
+	// slots limit concurrent tasks of a type, types without slots are not limited
+	slots map[string]chan struct{}
+}
+
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{
+		mux:      &sync.RWMutex{},
+		timeouts: make(map[string]time.Duration),
+		slots:    make(map[string]chan struct{}),
+	}
+}
+
+// SetConcurrency limits how many tasks of the type are processed at once.
+// A non-positive limit removes the limit.
+func (r *HandlerRegistry) SetConcurrency(taskType string, limit int) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if limit <= 0 {
+		delete(r.slots, taskType)
+		return
+	}
+	r.slots[taskType] = make(chan struct{}, limit)
+}
+
+// Acquire takes a processing slot for the task type waiting at most wait.
+// It returns a release func, or false when all slots stayed busy.
+func (r *HandlerRegistry) Acquire(taskType string, wait time.Duration) (func(), bool) {
+	r.mux.RLock()
+	slots, ok := r.slots[taskType]
+	r.mux.RUnlock()
+	if !ok {
+		return func() {}, true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
+// Register associates a processing timeout with the task type.
+// A non-positive timeout means the global task timeout is used.
+func (r *HandlerRegistry) Register(taskType string, timeout time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if timeout <= 0 {
+		delete(r.timeouts, taskType)
+		return
+	}
+	r.timeouts[taskType] = timeout
+}
+
+// Timeout returns the timeout registered for the task type or fallback when unset.
+func (r *HandlerRegistry) Timeout(taskType string, fallback time.Duration) time.Duration {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	if timeout, ok := r.timeouts[taskType]; ok {
+		return timeout
+	}
+	return fallback
+}
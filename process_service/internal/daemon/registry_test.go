@@ -0,0 +1,224 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+)
+
+// contextCaller returns when the call's context is done, like a well-behaved external API
+type contextCaller struct{}
+
+func (contextCaller) GetSomething(ctx context.Context, _ string, _ int) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTaskBoundedByTypeTimeout(t *testing.T) {
+	registry := NewHandlerRegistry()
+	registry.Register("lookup", 20*time.Millisecond)
+	registry.Register("report", 200*time.Millisecond)
+	d := &Daemon{Registry: registry, taskTimeout: time.Hour}
+
+	for _, tt := range []struct {
+		taskType string
+		want     time.Duration
+	}{
+		{"lookup", 20 * time.Millisecond},
+		{"report", 200 * time.Millisecond},
+	} {
+		t.Run(tt.taskType, func(t *testing.T) {
+			task := &domain.Task{ID: uuid.New(), Type: tt.taskType}
+			ctx, cancel := d.processingWithTimeout(context.Background(), task)
+			defer cancel()
+
+			startedAt := time.Now()
+			err := d.callWithContext(ctx, contextCaller{}, task, 1)
+			elapsed := time.Since(startedAt)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("call = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed < tt.want || elapsed > tt.want+150*time.Millisecond {
+				t.Errorf("task took %s, want about its type timeout %s", elapsed, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallerIgnoringCancellation(t *testing.T) {
+	d, q := newTestDaemon(time.Now())
+	d.Registry = NewHandlerRegistry()
+	d.taskTimeout = 20 * time.Millisecond
+	caller := &blockingCaller{release: make(chan struct{})}
+	defer close(caller.release)
+	process := chain(d.processTask, d.metricsMiddleware)
+
+	task := &domain.Task{ID: uuid.New()}
+	ctx, cancel := d.processingWithTimeout(context.Background(), task)
+	defer cancel()
+	startedAt := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- process(ctx, caller, 1, task) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("process = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("worker hangs on a caller ignoring cancellation")
+	}
+	if elapsed, want := time.Since(startedAt), d.taskTimeout+callAbandonGrace; elapsed > want+150*time.Millisecond {
+		t.Errorf("task took %s, want about %s", elapsed, want)
+	}
+	if got := d.Metrics.Recorder.GetTimeoutsTotal(); got != 1 {
+		t.Errorf("timeouts = %d, want 1", got)
+	}
+	if got := q.reasons[task.ID.String()]; !slices.Equal(got, []string{reasonTimeout}) {
+		t.Errorf("not processed reasons = %q, want %q", got, reasonTimeout)
+	}
+}
+
+func TestRegistryTimeout(t *testing.T) {
+	const global = 3 * time.Second
+	registry := NewHandlerRegistry()
+	registry.Register("lookup", time.Second)
+	registry.Register("removed", time.Minute)
+	registry.Register("removed", 0)
+
+	tests := []struct {
+		taskType string
+		want     time.Duration
+	}{
+		{"lookup", time.Second},
+		{"unregistered", global},
+		{"removed", global},
+		{"", global},
+	}
+	for _, tt := range tests {
+		if got := registry.Timeout(tt.taskType, global); got != tt.want {
+			t.Errorf("Timeout(%q) = %s, want %s", tt.taskType, got, tt.want)
+		}
+	}
+}
+
+func TestTaskTimeoutOverridesType(t *testing.T) {
+	registry := NewHandlerRegistry()
+	registry.Register("report", time.Hour)
+	d := &Daemon{Registry: registry, taskTimeout: time.Hour}
+
+	// the timeout requested at submit wins over the type and global ones
+	task := &domain.Task{ID: uuid.New(), Type: "report", Timeout: time.Minute}
+	ctx, cancel := d.processingWithTimeout(context.Background(), task)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("processing context has no deadline")
+	}
+	if left := time.Until(deadline); left > time.Minute || left < 59*time.Second {
+		t.Errorf("deadline in %s, want about %s", left, task.Timeout)
+	}
+}
+
+func TestAcquireCapsType(t *testing.T) {
+	registry := NewHandlerRegistry()
+	registry.SetConcurrency("report", 2)
+	registry.SetConcurrency("removed", 1)
+	registry.SetConcurrency("removed", 0)
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, ok := registry.Acquire("report", time.Millisecond)
+		if !ok {
+			t.Fatalf("Acquire() #%d = false, want a slot under the limit", i+1)
+		}
+		releases = append(releases, release)
+	}
+	if _, ok := registry.Acquire("report", 10*time.Millisecond); ok {
+		t.Error("Acquire() past the limit = true, want false")
+	}
+	for _, taskType := range []string{"lookup", "removed", ""} {
+		for i := 0; i < 10; i++ {
+			if _, ok := registry.Acquire(taskType, 0); !ok {
+				t.Fatalf("Acquire(%q) = false, want an unlimited type", taskType)
+			}
+		}
+	}
+
+	releases[0]()
+	if _, ok := registry.Acquire("report", time.Millisecond); !ok {
+		t.Error("Acquire() after a release = false, want the freed slot")
+	}
+}
+
+func TestAcquireConcurrentTypes(t *testing.T) {
+	const workers = 8
+	registry := NewHandlerRegistry()
+	registry.SetConcurrency("capped", 2)
+
+	for _, tt := range []struct {
+		taskType string
+		want     int64
+	}{
+		{"capped", 2},
+		{"unlimited", workers},
+	} {
+		t.Run(tt.taskType, func(t *testing.T) {
+			var running, peak atomic.Int64
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					release, ok := registry.Acquire(tt.taskType, 20*time.Millisecond)
+					if !ok {
+						return
+					}
+					defer release()
+					n := running.Add(1)
+					for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+					}
+					time.Sleep(50 * time.Millisecond)
+					running.Add(-1)
+				}()
+			}
+			close(start)
+			wg.Wait()
+			if got := peak.Load(); got != tt.want {
+				t.Errorf("peak concurrent tasks = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleTaskRequeuesWhenTypeIsFull(t *testing.T) {
+	d, _ := newTestDaemon(time.Now())
+	d.Wg = &sync.WaitGroup{}
+	d.Registry = NewHandlerRegistry()
+	d.Registry.SetConcurrency("report", 1)
+	release, ok := d.Registry.Acquire("report", time.Second)
+	if !ok {
+		t.Fatal("Acquire() = false, want the only slot")
+	}
+	defer release()
+
+	startedAt := time.Now()
+	err := d.handleTask(context.Background(), contextCaller{}, 1, &domain.Task{ID: uuid.New(), Type: "report"})
+	if !errors.Is(err, bus.ErrRequeue) {
+		t.Errorf("handleTask() = %v, want %v", err, bus.ErrRequeue)
+	}
+	if elapsed := time.Since(startedAt); elapsed > typeSlotWait+time.Second {
+		t.Errorf("handleTask() held the worker for %s, want about %s", elapsed, typeSlotWait)
+	}
+}
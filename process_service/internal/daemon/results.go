@@ -0,0 +1,152 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"process_service/internal/domain"
+)
+
+const (
+	_maxResultSweepInterval = 30 * time.Second
+
+	// ResultsPath serves the cached results on the metrics server
+	ResultsPath = "GET /results/{id}"
+)
+
+// TaskResult is the outcome of a finished task.
+type TaskResult struct {
+	TaskID     uuid.UUID         `json:"task_id"`
+	Status     domain.TaskStatus `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	FinishedAt time.Time         `json:"finished_at"`
+}
+
+type cachedResult struct {
+	result    TaskResult
+	expiresAt time.Time
+}
+
+// ResultCache keeps results of finished tasks for ttl, so a poller a little late
+// for the completion still reads it. A nil cache keeps nothing.
+type ResultCache struct {
+	mux     sync.RWMutex
+	ttl     time.Duration
+	results map[uuid.UUID]cachedResult
+}
+
+// NewResultCache returns nil for a non-positive ttl.
+func NewResultCache(ttl time.Duration) *ResultCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &ResultCache{ttl: ttl, results: make(map[uuid.UUID]cachedResult)}
+}
+
+// Put stores the result until ttl after it finished.
+func (c *ResultCache) Put(result TaskResult) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.results[result.TaskID] = cachedResult{result: result, expiresAt: result.FinishedAt.Add(c.ttl)}
+}
+
+// Get returns the task's result unless it is unknown or expired at now.
+func (c *ResultCache) Get(taskID uuid.UUID, now time.Time) (TaskResult, bool) {
+	if c == nil {
+		return TaskResult{}, false
+	}
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	cached, ok := c.results[taskID]
+	if !ok || !now.Before(cached.expiresAt) {
+		return TaskResult{}, false
+	}
+	return cached.result, true
+}
+
+// Len returns the number of cached results, expired ones not swept yet included.
+func (c *ResultCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return len(c.results)
+}
+
+// sweep drops the results expired at now and returns how many
+func (c *ResultCache) sweep(now time.Time) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	swept := 0
+	for id, cached := range c.results {
+		if !now.Before(cached.expiresAt) {
+			delete(c.results, id)
+			swept++
+		}
+	}
+	return swept
+}
+
+// Result returns the result of a task finished within the configured result TTL.
+func (d *Daemon) Result(taskID uuid.UUID) (TaskResult, bool) {
+	return d.results.Get(taskID, d.now())
+}
+
+// ResultHandler answers ResultsPath with the result of the task, 404 when it is
+// unknown or expired and 400 for an invalid task id.
+func (d *Daemon) ResultHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid task id", http.StatusBadRequest)
+			return
+		}
+		result, ok := d.Result(taskID)
+		if !ok {
+			http.Error(w, "result not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// recordResult caches the outcome of a claimed task, warmup tasks are not kept
+func (d *Daemon) recordResult(task *domain.Task, err error) {
+	if d.results == nil || task.Warmup {
+		return
+	}
+	result := TaskResult{TaskID: task.ID, Status: domain.StatusProcessed, FinishedAt: d.now()}
+	if err != nil {
+		result.Status, result.Error = domain.StatusFailed, err.Error()
+	}
+	d.results.Put(result)
+}
+
+// runResultSweeper bounds the cache memory by dropping expired results
+func (d *Daemon) runResultSweeper(ctx context.Context) {
+	if d.results == nil {
+		return
+	}
+	ticker := time.NewTicker(min(d.results.ttl/2, _maxResultSweepInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if swept := d.results.sweep(d.now()); swept > 0 {
+				d.logger.WithField("swept", swept).Debug("dropped expired task results")
+			}
+		}
+	}
+}
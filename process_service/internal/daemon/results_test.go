@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"process_service/internal/domain"
+)
+
+func TestResultWithinTTL(t *testing.T) {
+	finished := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := finished
+	d := &Daemon{results: NewResultCache(time.Minute), now: func() time.Time { return now }}
+
+	ok := &domain.Task{ID: uuid.New()}
+	failed := &domain.Task{ID: uuid.New()}
+	d.recordResult(ok, nil)
+	d.recordResult(failed, errors.New("api error"))
+
+	for _, tt := range []struct {
+		name   string
+		at     time.Time
+		task   *domain.Task
+		found  bool
+		status domain.TaskStatus
+	}{
+		{"processed right away", finished, ok, true, domain.StatusProcessed},
+		{"failed within the ttl", finished.Add(59 * time.Second), failed, true, domain.StatusFailed},
+		{"gone at the ttl", finished.Add(time.Minute), ok, false, ""},
+		{"gone after the ttl", finished.Add(time.Hour), failed, false, ""},
+		{"unknown task", finished, &domain.Task{ID: uuid.New()}, false, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			now = tt.at
+			result, found := d.Result(tt.task.ID)
+			if found != tt.found {
+				t.Fatalf("Result() found = %v, want %v", found, tt.found)
+			}
+			if result.Status != tt.status {
+				t.Errorf("Result() status = %q, want %q", result.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestResultSweepDropsExpired(t *testing.T) {
+	finished := time.Now()
+	c := NewResultCache(time.Minute)
+	c.Put(TaskResult{TaskID: uuid.New(), FinishedAt: finished})
+	c.Put(TaskResult{TaskID: uuid.New(), FinishedAt: finished.Add(time.Minute)})
+
+	if swept := c.sweep(finished.Add(time.Minute)); swept != 1 {
+		t.Errorf("sweep() = %d, want 1", swept)
+	}
+	if got := c.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+}
+
+func TestWarmupResultsAreNotKept(t *testing.T) {
+	d := &Daemon{results: NewResultCache(time.Minute), now: time.Now}
+	task := &domain.Task{ID: uuid.New(), Warmup: true}
+	d.recordResult(task, nil)
+	if _, found := d.Result(task.ID); found {
+		t.Error("warmup result is cached")
+	}
+}
+
+func TestResultHandler(t *testing.T) {
+	finished := time.Now()
+	now := finished
+	d := &Daemon{results: NewResultCache(time.Minute), now: func() time.Time { return now }}
+	task := &domain.Task{ID: uuid.New()}
+	d.recordResult(task, nil)
+
+	mux := http.NewServeMux()
+	mux.Handle(ResultsPath, d.ResultHandler())
+	get := func(id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/results/"+id, nil))
+		return rec
+	}
+
+	rec := get(task.ID.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var result TaskResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.TaskID != task.ID || result.Status != domain.StatusProcessed {
+		t.Errorf("result = %+v", result)
+	}
+
+	if rec := get("not-a-uuid"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid id status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	now = finished.Add(time.Minute)
+	if rec := get(task.ID.String()); rec.Code != http.StatusNotFound {
+		t.Errorf("expired status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestResultWithoutCache(t *testing.T) {
+	d := &Daemon{results: NewResultCache(0), now: time.Now}
+	task := &domain.Task{ID: uuid.New()}
+	d.recordResult(task, nil)
+	if _, found := d.Result(task.ID); found {
+		t.Error("result found without a result ttl")
+	}
+}
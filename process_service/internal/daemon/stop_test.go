@@ -0,0 +1,192 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"process_service/internal/bus"
+	"process_service/internal/domain"
+)
+
+// newStoppableDaemon is a test daemon with what stop needs, its caller records Close
+func newStoppableDaemon() (*Daemon, *closingCaller) {
+	d, _ := newTestDaemon(time.Now())
+	caller := &closingCaller{}
+	d.apiCaller = caller
+	d.callerMux = &sync.RWMutex{}
+	d.Wg = &sync.WaitGroup{}
+	d.deps = NewDependencyTracker(1, time.Minute)
+	d.stopped = make(chan struct{})
+	d.done = make(chan struct{})
+	return d, caller
+}
+
+func TestStopOnContextDone(t *testing.T) {
+	d, caller := newStoppableDaemon()
+	ctx, cancel := context.WithCancel(context.Background())
+	go d.stopOnDone(ctx)
+
+	select {
+	case <-d.Done():
+		t.Fatal("Done is closed before the context was cancelled")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-d.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done was not closed after the context was cancelled")
+	}
+	if !d.stopping.Load() {
+		t.Error("daemon is not stopping")
+	}
+	if caller.closed != 1 {
+		t.Errorf("caller closed %d times, want 1", caller.closed)
+	}
+
+	// the stop sequence of main runs it again, which is a no-op
+	if err := d.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if caller.closed != 1 {
+		t.Errorf("caller closed %d times after a second Stop, want 1", caller.closed)
+	}
+}
+
+func TestStopEndsContextWatch(t *testing.T) {
+	d, _ := newStoppableDaemon()
+	watching := make(chan struct{})
+	go func() {
+		d.stopOnDone(context.Background())
+		close(watching)
+	}()
+
+	if err := d.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-watching:
+	case <-time.After(time.Second):
+		t.Fatal("stopOnDone still watches the context after Stop")
+	}
+	select {
+	case <-d.Done():
+	default:
+		t.Error("Done is not closed after Stop")
+	}
+}
+
+func TestConcurrentStop(t *testing.T) {
+	d, caller := newStoppableDaemon()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- d.Stop(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Stop() = %v, want nil", err)
+		}
+	}
+	if caller.closed != 1 {
+		t.Errorf("caller closed %d times, want 1", caller.closed)
+	}
+	select {
+	case <-d.Done():
+	default:
+		t.Error("Done is not closed after Stop")
+	}
+}
+
+// failingCloser is a caller whose Close fails
+type failingCloser struct{ contextCaller }
+
+func (failingCloser) Close(context.Context) error { return errors.New("close failed") }
+
+func TestStopClosesCaller(t *testing.T) {
+	d, caller := newStoppableDaemon()
+	if err := d.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if caller.closed != 1 {
+		t.Errorf("caller closed %d times, want 1", caller.closed)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		caller    bus.ExternalAPICaller
+		wantError bool
+	}{
+		{"caller without Close", contextCaller{}, false},
+		{"failing Close", failingCloser{}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newStoppableDaemon()
+			logger, hook := test.NewNullLogger()
+			d.logger = logger
+			d.apiCaller = tt.caller
+			if err := d.Stop(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			logged := false
+			for _, entry := range hook.AllEntries() {
+				logged = logged || entry.Message == "failed to close external API caller"
+			}
+			if logged != tt.wantError {
+				t.Errorf("close failure logged = %t, want %t", logged, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestStopCancelsTasksInFlight(t *testing.T) {
+	d, _ := newStoppableDaemon()
+	d.Registry = NewHandlerRegistry()
+	d.taskTimeout = time.Hour
+	d.process = chain(d.processTask, d.metricsMiddleware)
+	// the worker context as Start hands it to the workers
+	workerCtx, cancel := context.WithCancel(context.Background())
+	d.workerCancel = cancel
+
+	task := &domain.Task{ID: uuid.New()}
+	processed := make(chan error, 1)
+	d.Wg.Add(1)
+	go func() {
+		defer d.Wg.Done()
+		ctx, cancel := d.processingWithTimeout(workerCtx, task)
+		defer cancel()
+		processed <- d.process(ctx, contextCaller{}, 1, task)
+	}()
+	for d.Metrics.Recorder.GetActiveTasksTotal() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop(context.Background())
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop waits for a task which should have been cancelled")
+	}
+	if err := <-processed; !errors.Is(err, context.Canceled) {
+		t.Errorf("task ended with %v, want %v", err, context.Canceled)
+	}
+}
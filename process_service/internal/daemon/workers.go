@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	_workerMonitorInterval = time.Second
+	// _workersAliveInterval refreshes the workers alive key, it expires after a few missed refreshes
+	_workersAliveInterval = 2 * time.Second
+)
+
+// ErrNoWorkers is reported by CheckWorkers when every worker exited while the daemon runs.
+var ErrNoWorkers = errors.New("all workers have exited")
+
+// LiveWorkers returns the number of running workers.
+func (d *Daemon) LiveWorkers() int64 {
+	return d.liveWorkers.Load()
+}
+
+// CheckWorkers fails when the daemon was started and is not stopping but has no live workers,
+// it is meant for liveness probes so the orchestrator restarts the process.
+func (d *Daemon) CheckWorkers() error {
+	if d.started.Load() && !d.stopping.Load() && d.liveWorkers.Load() <= 0 {
+		return ErrNoWorkers
+	}
+	return nil
+}
+
+// spawnWorker counts the worker as live before it starts, so a respawn never reads as zero
+func (d *Daemon) spawnWorker(ctx context.Context, workerID int) {
+	d.liveWorkers.Add(1)
+	go func() {
+		defer d.liveWorkers.Add(-1)
+		d.worker(ctx, workerID)
+	}()
+}
+
+// runWorkerMonitor logs once every time the live worker count drops to zero
+func (d *Daemon) runWorkerMonitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	reported := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := d.CheckWorkers()
+			if err != nil && !reported {
+				d.logger.WithFields(log.Fields{"workers": d.numWorkers}).WithError(err).Error("CRITICAL: no live workers, tasks are not consumed")
+			}
+			reported = err != nil
+		}
+	}
+}
+
+// runWorkersAlive reports running workers to the submit service, which may hold
+// back submits on startup until some instance has them
+func (d *Daemon) runWorkersAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if d.CheckWorkers() == nil && !d.stopping.Load() {
+			if err := d.states.WorkersAlive(ctx, 3*interval); err != nil {
+				d.logger.WithError(err).Debug("failed to report live workers")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
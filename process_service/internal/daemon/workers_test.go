@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestWorkerRespawnsAfterLifetime(t *testing.T) {
+	const lifetime = time.Hour
+	d, _ := newTestDaemon(time.Now())
+	logger, hook := test.NewNullLogger()
+	d.logger = logger
+	d.maxWorkerLifetime = lifetime
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the first worker starts at t0 and sees its lifetime over at its first check, the
+	// clock stops with the context cancelled, so the replacement stops before reading tasks
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var mu sync.Mutex
+	calls := 0
+	d.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			return t0
+		}
+		cancel()
+		return t0.Add(lifetime)
+	}
+
+	d.spawnWorker(ctx, 7)
+	deadline := time.Now().Add(time.Second)
+	for d.LiveWorkers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d workers still live after the context was cancelled", d.LiveWorkers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["workerId"] != 7 {
+			t.Errorf("entry %q of worker %v, want worker 7", entry.Message, entry.Data["workerId"])
+		}
+		messages = append(messages, entry.Message)
+	}
+	want := []string{"worker reached max lifetime, respawning", "stopped by context done"}
+	if len(messages) != len(want) || messages[0] != want[0] || messages[1] != want[1] {
+		t.Errorf("logged %q, want %q", messages, want)
+	}
+}
+
+func TestAllWorkersExited(t *testing.T) {
+	d, _ := newTestDaemon(time.Now())
+	logger, hook := test.NewNullLogger()
+	d.logger = logger
+	d.numWorkers = 3
+
+	d.started.Store(true)
+	// the workers are cancelled before they read any task, there is no consumer
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < d.numWorkers; i++ {
+		d.spawnWorker(ctx, i)
+	}
+	deadline := time.Now().Add(time.Second)
+	for d.LiveWorkers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d workers still live after the context was cancelled", d.LiveWorkers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := d.CheckWorkers(); !errors.Is(err, ErrNoWorkers) {
+		t.Errorf("CheckWorkers() = %v, want %v", err, ErrNoWorkers)
+	}
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.runWorkerMonitor(monitorCtx, time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	stopMonitor()
+	<-done
+	critical := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.ErrorLevel {
+			critical++
+		}
+	}
+	if critical != 1 {
+		t.Errorf("monitor logged %d errors, want 1 however often it checks", critical)
+	}
+
+	// a daemon shutting down has no workers on purpose
+	d.stopping.Store(true)
+	if err := d.CheckWorkers(); err != nil {
+		t.Errorf("CheckWorkers() while stopping = %v, want nil", err)
+	}
+}
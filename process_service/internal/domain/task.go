@@ -1,12 +1,43 @@
 package domain
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Task struct {
-	ID      uuid.UUID
-	Status  TaskStatus
-	Payload *string
+	ID            uuid.UUID
+	Type          string `json:"type,omitempty"`
+	Status        TaskStatus
+	Payload       *string
 	FailedPayload *string
+	Tags          map[string]string `json:"tags,omitempty"`
+	// EnqueuedAt is when the submit service added the task to the queue
+	EnqueuedAt time.Time `json:"-"`
+	// StreamLogs publishes the task's log lines for a client waiting on them
+	StreamLogs bool `json:"-"`
+	// File is an upload attached on submit
+	File []byte `json:"-"`
+	// Timeout overrides the processing timeout when set
+	Timeout time.Duration `json:"-"`
+	// Attempts of this delivery, earlier deliveries are only kept in the tasks table
+	Attempts []AttemptRecord `json:"-"`
+	// Warmup marks a synthetic startup task of the submit service, it is left out of business metrics
+	Warmup bool `json:"-"`
+	// DependsOn lists tasks which must succeed before this one is processed
+	DependsOn []uuid.UUID `json:"-"`
+	// Stream and MessageID locate the task's stream message, a held task is acked through them
+	Stream    string `json:"-"`
+	MessageID string `json:"-"`
+}
+
+// AttemptRecord describes one processing attempt of a task.
+type AttemptRecord struct {
+	At       time.Time
+	Duration time.Duration
+	// Error is empty for a successful attempt
+	Error string
 }
 
 type TaskStatus string
@@ -16,4 +47,5 @@ const (
 	StatusProcessing TaskStatus = "processing"
 	StatusPending    TaskStatus = "pending"
 	StatusProcessed  TaskStatus = "done"
+	StatusCancelled  TaskStatus = "cancelled"
 )
@@ -0,0 +1,22 @@
+// Package logctx carries the log entry of a task in its context.
+package logctx
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type loggerKey struct{}
+
+// WithLogger carries the log entry of a task, tagged with its ids once, to everything
+// logging for the task, so the fields of its log lines do not drift.
+func WithLogger(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the entry set by WithLogger.
+func LoggerFromContext(ctx context.Context) (*log.Entry, bool) {
+	logger, ok := ctx.Value(loggerKey{}).(*log.Entry)
+	return logger, ok
+}
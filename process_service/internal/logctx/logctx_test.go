@@ -0,0 +1,36 @@
+package logctx
+
+import (
+	"context"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLoggerFromContext(t *testing.T) {
+	if _, ok := LoggerFromContext(context.Background()); ok {
+		t.Fatal("LoggerFromContext found an entry in an empty context")
+	}
+
+	logger, hook := test.NewNullLogger()
+	entry := logger.WithFields(log.Fields{"workerId": 3, "taskId": "abc"})
+	ctx, cancel := context.WithCancel(WithLogger(context.Background(), entry))
+	defer cancel()
+
+	got, ok := LoggerFromContext(ctx)
+	if !ok {
+		t.Fatal("LoggerFromContext did not find the entry in a derived context")
+	}
+	got.WithField("error", "boom").Error("External API error")
+
+	last := hook.LastEntry()
+	if last == nil {
+		t.Fatal("nothing was logged")
+	}
+	for key, want := range map[string]any{"workerId": 3, "taskId": "abc", "error": "boom"} {
+		if last.Data[key] != want {
+			t.Errorf("field %s = %v, want %v", key, last.Data[key], want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import "fmt"
+
+// MaxBuckets caps the configured buckets of a histogram, each bucket is a series of its own
+const MaxBuckets = 50
+
+// Validate checks the configured histogram buckets, which must be strictly increasing
+// and at most MaxBuckets long.
+func (c *Config) Validate() error {
+	for _, b := range []struct {
+		name    string
+		buckets []float64
+	}{
+		{"duration_buckets", c.DurationBuckets},
+		{"task_duration_buckets", c.TaskDurationBuckets},
+		{"http_duration_buckets", c.HTTPDurationBuckets},
+		{"queue_wait_buckets", c.QueueWaitBuckets},
+	} {
+		if err := validateBuckets(b.name, b.buckets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateBuckets(name string, buckets []float64) error {
+	if len(buckets) > MaxBuckets {
+		return fmt.Errorf("%s has %d buckets, at most %d are allowed", name, len(buckets), MaxBuckets)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("%s must be strictly increasing, %g follows %g", name, buckets[i], buckets[i-1])
+		}
+	}
+	return nil
+}
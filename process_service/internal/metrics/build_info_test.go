@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBuildInfo(t *testing.T) {
+	r := NewRecorder(nil)
+
+	version, commit, goVersion := buildInfo()
+	if goVersion != runtime.Version() {
+		t.Errorf("goversion = %q, want %q", goVersion, runtime.Version())
+	}
+	want := fmt.Sprintf(`
+		# HELP build_info Build information of the running binary, the value is always 1.
+		# TYPE build_info gauge
+		build_info{commit=%q,goversion=%q,version=%q} 1
+	`, commit, goVersion, version)
+	if err := testutil.CollectAndCompare(r.buildInfo, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	if got, want := testutil.ToFloat64(r.startTime), float64(r.startedAt.Unix()); got != want {
+		t.Errorf("start_time_seconds = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	_healthPath = "/health"
+	// _healthCheckTimeout bounds every component check of /health
+	_healthCheckTimeout = 2 * time.Second
+)
+
+// HealthStatus is the state of a component or of the whole service.
+type HealthStatus string
+
+const (
+	HealthOK       HealthStatus = "ok"
+	HealthDown     HealthStatus = "down"
+	HealthDegraded HealthStatus = "degraded"
+)
+
+// ComponentHealth is what a component check reports to /health.
+type ComponentHealth struct {
+	Status  HealthStatus   `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// HealthCheck reports the health of a component, ctx is bounded by the check timeout.
+type HealthCheck func(ctx context.Context) ComponentHealth
+
+// HealthReport is the /health response.
+type HealthReport struct {
+	Status     HealthStatus               `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// CheckHealth wraps an error returning check, a nil error is ok
+func CheckHealth(check func(ctx context.Context) error) HealthCheck {
+	return func(ctx context.Context) ComponentHealth {
+		if err := check(ctx); err != nil {
+			return ComponentHealth{Status: HealthDown, Error: err.Error()}
+		}
+		return ComponentHealth{Status: HealthOK}
+	}
+}
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// AddHealthCheck adds a component to /health, the metrics server reports itself.
+func (a *API) AddHealthCheck(name string, check HealthCheck) {
+	a.healthMux.Lock()
+	defer a.healthMux.Unlock()
+	a.healthChecks = append(a.healthChecks, namedCheck{name: name, check: check})
+}
+
+// Health runs the component checks concurrently. The service is degraded when
+// any component is not ok.
+func (a *API) Health(ctx context.Context) HealthReport {
+	a.healthMux.Lock()
+	checks := append([]namedCheck{{name: "metrics", check: a.selfHealth}}, a.healthChecks...)
+	a.healthMux.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, _healthCheckTimeout)
+	defer cancel()
+
+	results := make([]ComponentHealth, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: HealthOK, Components: make(map[string]ComponentHealth, len(checks))}
+	for i, c := range checks {
+		report.Components[c.name] = results[i]
+		if results[i].Status != HealthOK {
+			report.Status = HealthDegraded
+		}
+	}
+	return report
+}
+
+// selfHealth reports the metrics server, which is ok once started
+func (a *API) selfHealth(context.Context) ComponentHealth {
+	if !a.started.Load() {
+		return ComponentHealth{Status: HealthDown, Error: "metrics server is not started"}
+	}
+	return ComponentHealth{Status: HealthOK, Details: map[string]any{"addr": a.conf.Addr}}
+}
+
+// handleHealth answers 200 when every component is ok and 503 otherwise
+func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
+	report := a.Health(r.Context())
+	status := http.StatusOK
+	if report.Status != HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthDegradedWhenClickHouseUnreachable(t *testing.T) {
+	a := newAPI(withDefaults(nil))
+	a.started.Store(true)
+	a.AddHealthCheck("redis", CheckHealth(func(context.Context) error { return nil }))
+	a.AddHealthCheck("clickhouse", CheckHealth(func(context.Context) error {
+		return errors.New("dial tcp 127.0.0.1:9000: connect: connection refused")
+	}))
+
+	rec := httptest.NewRecorder()
+	a.handleHealth(rec, httptest.NewRequest(http.MethodGet, _healthPath, nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	var report HealthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != HealthDegraded {
+		t.Errorf("report status = %q, want %q", report.Status, HealthDegraded)
+	}
+	for name, want := range map[string]HealthStatus{"metrics": HealthOK, "redis": HealthOK, "clickhouse": HealthDown} {
+		if got := report.Components[name].Status; got != want {
+			t.Errorf("%s status = %q, want %q", name, got, want)
+		}
+	}
+	if report.Components["clickhouse"].Error == "" {
+		t.Error("clickhouse error is not reported")
+	}
+}
+
+func TestHealthOK(t *testing.T) {
+	a := newAPI(withDefaults(nil))
+	a.started.Store(true)
+	a.AddHealthCheck("clickhouse", CheckHealth(func(context.Context) error { return nil }))
+
+	rec := httptest.NewRecorder()
+	a.handleHealth(rec, httptest.NewRequest(http.MethodGet, _healthPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	tests := []struct {
+		name       string
+		check      func() error
+		wantStatus int
+	}{
+		{"no check", nil, http.StatusOK},
+		{"passing check", func() error { return nil }, http.StatusOK},
+		{"failing check", func() error { return errors.New("all workers have exited") }, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAPI(withDefaults(nil))
+			if tt.check != nil {
+				a.SetLivenessCheck(tt.check)
+			}
+			rec := httptest.NewRecorder()
+			a.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, _livezPath, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
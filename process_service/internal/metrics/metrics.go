@@ -3,7 +3,10 @@ package metrics
 import (
 	"context"
 	"errors"
+	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -18,6 +21,11 @@ const (
 	statusCodeLabel    = "code"
 	methodLabel        = "method"
 	errorLabel         = "error"
+	tagLabel           = "tag"
+	tagValueLabel      = "value"
+	instanceLabel      = "instance_id"
+
+	_sampleInterval = 5 * time.Second
 )
 
 // Service struct
@@ -25,39 +33,87 @@ type Service struct {
 	API      *API
 	Recorder *Recorder
 	logger   *log.Logger
+	conf     *Config
+
+	cancel   context.CancelFunc
+	started  atomic.Bool
+	stopOnce sync.Once
+	sinks    sinkSet
 }
 
 type RecorderConfig struct {
-	Prefix          string    `mapstructure:"prefix"`
-	DurationBuckets []float64 `mapstructure:"duration_buckets"`
-	SizeBuckets     []float64 `mapstructure:"size_buckets"`
+	Prefix              string    `mapstructure:"prefix"`
+	InstanceID          string    `mapstructure:"-"`
+	DurationBuckets     []float64 `mapstructure:"duration_buckets"`
+	TaskDurationBuckets []float64 `mapstructure:"task_duration_buckets"`
+	HTTPDurationBuckets []float64 `mapstructure:"http_duration_buckets"`
+	QueueWaitBuckets    []float64 `mapstructure:"queue_wait_buckets"`
+	SizeBuckets         []float64 `mapstructure:"size_buckets"`
 }
 
 // Recorder contains prometheus metrics used in app
 type Recorder struct {
-	conf *RecorderConfig
+	conf      *RecorderConfig
+	startedAt time.Time
 
 	taskCounter   *prometheus.CounterVec // 200, 503
 	statusCounter *prometheus.CounterVec // 200, 503
 	errorCounter  *prometheus.CounterVec //timeouts, common errors
 
+	cancelledTasks    prometheus.Counter
+	receivedTasks     prometheus.Counter
+	chWritesRejected  prometheus.Counter
+	chLogsDropped     prometheus.Counter
+	droppedErrors     prometheus.Counter
+	rateLimited       prometheus.Counter
+	notProcessedSwept prometheus.Counter
+	taggedTasks       *prometheus.CounterVec // allow-listed tag keys only
+	tagLabels         map[string]struct{}
+
 	taskDuration prometheus.Histogram
+	httpDuration prometheus.Histogram
+	queueWait    prometheus.Histogram
 
 	memUsed              prometheus.Gauge
+	buildInfo            *prometheus.GaugeVec
+	startTime            prometheus.Gauge
 	activeTasks          prometheus.Gauge
 	httpRequestsInflight prometheus.Gauge
+	queueDepth           prometheus.Gauge
+	maxQueueDepth        prometheus.Gauge
+	oldestPendingAge     prometheus.Gauge
+	// maxDepth backs maxQueueDepth, so raising the high-water mark is race free
+	maxDepth atomic.Int64
+
+	// queueWaitExported is set by ExportQueueWait
+	queueWaitExported atomic.Bool
+	// gaugeFuncs are the gauges added by AddGaugeFunc
+	gaugeFuncsMux sync.Mutex
+	gaugeFuncs    []namedGaugeFunc
 }
 
-// New constructor
+type namedGaugeFunc struct {
+	name string
+	read func() float64
+}
+
+// New constructor, a nil conf serves the defaults DefaultAddr and DefaultEndpoint
 func New(conf *Config) *Service {
+	conf = withDefaults(conf)
 	return &Service{
 		API:      newAPI(conf),
-		Recorder: NewRecorder(),
+		Recorder: NewRecorder(conf),
+		conf:     conf,
 	}
 }
 
-// Start registers metrics and starts the metrics HTTP API.
+// Start registers metrics and starts the samplers, the sinks and the metrics HTTP API.
+// Calls after the first one are no-ops, so metrics are never registered twice.
 func (s *Service) Start(errCh chan error) error {
+	if !s.started.CompareAndSwap(false, true) {
+		log.Warn("metrics service is already started")
+		return nil
+	}
 	if err := s.Recorder.RegisterMetrics(); err != nil {
 		return err
 	}
@@ -65,29 +121,58 @@ func (s *Service) Start(errCh chan error) error {
 		log.Error("metrics API not initialized")
 		return errors.New("metrics API not initialized")
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.Recorder.sampleMemory(ctx, _sampleInterval)
+
+	s.startSinks()
+	go s.runSinks(ctx, s.conf.flushInterval())
+
 	log.WithField("addr", s.API.conf.Addr).Info("Starting metrics API")
 	s.API.Start(errCh)
 	return nil
 }
 
-// Stop stops the metrics HTTP API.
+// Stop stops the samplers and the metrics HTTP API. It is safe to call more than once.
 func (s *Service) Stop(ctx context.Context) error {
-	if s.API != nil {
-		return s.API.Stop(ctx)
-	}
-	return nil
+	var err error
+	s.stopOnce.Do(func() {
+		if s.cancel != nil {
+			s.cancel()
+		}
+		if s.API != nil {
+			err = s.API.Stop(ctx)
+		}
+	})
+	return err
 }
 
 // NewRecorder returns a new metrics recorder that implements the recorder
 // using Prometheus as the backend.
-func NewRecorder() *Recorder {
+func NewRecorder(apiConf *Config) *Recorder {
 	conf := &RecorderConfig{
 		DurationBuckets: prometheus.DefBuckets,
 		SizeBuckets:     prometheus.ExponentialBuckets(100, 10, 8),
 	}
 
+	tagLabels := make(map[string]struct{})
+	if apiConf != nil {
+		for _, key := range apiConf.TagLabels {
+			tagLabels[key] = struct{}{}
+		}
+		if len(apiConf.DurationBuckets) > 0 {
+			conf.DurationBuckets = apiConf.DurationBuckets
+		}
+		conf.TaskDurationBuckets = apiConf.TaskDurationBuckets
+		conf.HTTPDurationBuckets = apiConf.HTTPDurationBuckets
+		conf.QueueWaitBuckets = apiConf.QueueWaitBuckets
+		conf.InstanceID = apiConf.InstanceID
+	}
+
 	r := &Recorder{
-		conf: conf,
+		conf:      conf,
+		startedAt: time.Now(),
+		tagLabels: tagLabels,
 
 		taskCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: conf.Prefix,
@@ -110,12 +195,94 @@ func NewRecorder() *Recorder {
 			Help:      "The total number of task errors.",
 		}, []string{errorLabel}),
 
+		cancelledTasks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "task",
+			Name:      "cancelled_tasks_total",
+			Help:      "The total number of tasks skipped because they were cancelled while queued.",
+		}),
+
+		receivedTasks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "task",
+			Name:      "received_tasks_total",
+			Help:      "The total number of tasks taken by workers for processing.",
+		}),
+
+		chWritesRejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "clickhouse",
+			Name:      "writes_rejected_total",
+			Help:      "The total number of ClickHouse writes rejected because too many were in flight.",
+		}),
+
+		chLogsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "clickhouse",
+			Name:      "logs_dropped_total",
+			Help:      "The total number of log entries not shipped to ClickHouse because writes were overloaded.",
+		}),
+
+		droppedErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: conf.Prefix,
+			Name:      "dropped_errors_total",
+			Help:      "The total number of background errors dropped because nobody was reading them.",
+		}),
+
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "http",
+			Name:      "rate_limited_total",
+			Help:      "The total number of submits rejected by the submit rate limit.",
+		}),
+
+		notProcessedSwept: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "task",
+			Name:      "not_processed_swept_total",
+			Help:      "The total number of in-memory not processed entries dropped after their TTL.",
+		}),
+
+		taggedTasks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "task",
+			Name:      "tagged_tasks_total",
+			Help:      "The total number of tasks by allow-listed tag.",
+		}, []string{tagLabel, tagValueLabel}),
+
 		taskDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
 			Namespace: conf.Prefix,
 			Subsystem: "task",
 			Name:      "duration_seconds",
 			Help:      "The duration of task processing in seconds.",
-			Buckets:   conf.DurationBuckets,
+			Buckets:   bucketsOrDefault(conf.TaskDurationBuckets, conf.DurationBuckets),
+		}),
+
+		httpDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "The duration of HTTP request handling in seconds.",
+			Buckets:   bucketsOrDefault(conf.HTTPDurationBuckets, conf.DurationBuckets),
+		}),
+
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "task",
+			Name:      "queue_wait_seconds",
+			Help:      "The time tasks waited in the queue between submit and pick up by a worker in seconds.",
+			Buckets:   bucketsOrDefault(conf.QueueWaitBuckets, conf.DurationBuckets),
+		}),
+
+		buildInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: conf.Prefix,
+			Name:      "build_info",
+			Help:      "Build information of the running binary, the value is always 1.",
+		}, []string{"version", "commit", "goversion"}),
+		startTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: conf.Prefix,
+			Name:      "start_time_seconds",
+			Help:      "Start time of the process since unix epoch in seconds.",
 		}),
 
 		memUsed: prometheus.NewGauge(prometheus.GaugeOpts{
@@ -136,23 +303,78 @@ func NewRecorder() *Recorder {
 			Name:      "requests_inflight",
 			Help:      "The number of inflight requests being handled at the same time.",
 		}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "task",
+			Name:      "queue_depth",
+			Help:      "The number of tasks waiting in the queue or taken but not acknowledged.",
+		}),
+		maxQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: conf.Prefix,
+			Subsystem: "task",
+			Name:      "max_queue_depth",
+			Help:      "The highest queue depth sampled since start or the last reset.",
+		}),
+		oldestPendingAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: conf.Prefix,
+			Name:      "oldest_pending_task_age_seconds",
+			Help:      "The time the oldest task not acknowledged by the workers has been in the queue in seconds.",
+		}),
 	}
 
+	r.buildInfo.WithLabelValues(buildInfo()).Set(1)
+	r.startTime.Set(float64(r.startedAt.Unix()))
+
 	return r
 }
 
+// bucketsOrDefault returns per metric buckets when configured, the shared ones otherwise
+func bucketsOrDefault(buckets, fallback []float64) []float64 {
+	if len(buckets) > 0 {
+		return buckets
+	}
+	return fallback
+}
+
 func (r *Recorder) GetMetrics() map[string]any {
 	metrics := make(map[string]any)
 	metrics["mem_used_bytes"] = r.GetMemUsed()
 	metrics["active_tasks"] = r.GetActiveTasksTotal()
 	metrics["unavailable_total"] = r.GetUnavailableTotal()
+	metrics["too_many_requests_total"] = r.GetTooManyRequestsTotal()
+	metrics["rate_limited_total"] = r.GetRateLimitedTotal()
 	metrics["submitted_tasks_total"] = r.GetSubmittedTasksTotal()
 	metrics["task_errors_total"] = r.GetTaskErrorsTotal()
 	metrics["timeouts_total"] = r.GetTimeoutsTotal()
 	metrics["processed_tasks_total"] = r.GetProcessedTasksTotal()
+	metrics["cancelled_tasks_total"] = r.GetCancelledTasksTotal()
+	metrics["received_tasks_total"] = r.GetReceivedTasksTotal()
+	metrics["clickhouse_writes_rejected_total"] = r.GetClickHouseWritesRejectedTotal()
+	metrics["clickhouse_logs_dropped_total"] = r.GetClickHouseLogsDroppedTotal()
+	metrics["dropped_errors_total"] = r.GetDroppedErrorsTotal()
+	metrics["not_processed_swept_total"] = r.GetNotProcessedSweptTotal()
+	metrics["started_at"] = r.startedAt.UTC().Format(time.RFC3339)
+	metrics["uptime_seconds"] = r.GetUptime().Seconds()
+	if r.queueWaitExported.Load() {
+		metrics["queue_wait_seconds_p50"] = r.GetQueueWaitQuantile(0.5)
+		metrics["queue_wait_seconds_p99"] = r.GetQueueWaitQuantile(0.99)
+	}
+	metrics["queue_depth"] = r.GetQueueDepth()
+	metrics["max_queue_depth"] = r.GetMaxQueueDepth()
+	metrics["oldest_pending_task_age_seconds"] = r.GetOldestPendingAge()
+	r.gaugeFuncsMux.Lock()
+	for _, g := range r.gaugeFuncs {
+		metrics[g.name] = g.read()
+	}
+	r.gaugeFuncsMux.Unlock()
 	return metrics
 }
 
+// GetUptime returns the time passed since the recorder was created on startup
+func (r *Recorder) GetUptime() time.Duration {
+	return time.Since(r.startedAt)
+}
+
 func (r *Recorder) IncProcessedTasks(processed bool) {
 	r.taskCounter.WithLabelValues(strconv.FormatBool(processed)).Inc()
 }
@@ -165,6 +387,77 @@ func (r *Recorder) GetProcessedTasksTotal() uint64 {
 	return uint64(metric.GetCounter().GetValue())
 }
 
+func (r *Recorder) IncCancelledTasks() {
+	r.cancelledTasks.Inc()
+}
+
+func (r *Recorder) GetCancelledTasksTotal() uint64 {
+	metric := &dto.Metric{}
+	if err := r.cancelledTasks.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
+func (r *Recorder) IncReceivedTasks() {
+	r.receivedTasks.Inc()
+}
+
+func (r *Recorder) GetReceivedTasksTotal() uint64 {
+	metric := &dto.Metric{}
+	if err := r.receivedTasks.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
+func (r *Recorder) IncClickHouseWritesRejected() {
+	r.chWritesRejected.Inc()
+}
+
+func (r *Recorder) GetClickHouseWritesRejectedTotal() uint64 {
+	metric := &dto.Metric{}
+	if err := r.chWritesRejected.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
+// IncClickHouseLogsDropped counts a log entry the ClickHouse hook gave up on
+func (r *Recorder) IncClickHouseLogsDropped() {
+	r.chLogsDropped.Inc()
+}
+
+func (r *Recorder) GetClickHouseLogsDroppedTotal() uint64 {
+	metric := &dto.Metric{}
+	if err := r.chLogsDropped.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
+// IncDroppedErrors counts a background error nobody was there to receive
+func (r *Recorder) IncDroppedErrors() {
+	r.droppedErrors.Inc()
+}
+
+func (r *Recorder) GetDroppedErrorsTotal() uint64 {
+	metric := &dto.Metric{}
+	if err := r.droppedErrors.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
+// IncTaggedTask counts the task once per allow-listed tag it carries
+func (r *Recorder) IncTaggedTask(tags map[string]string) {
+	for key, value := range tags {
+		if _, ok := r.tagLabels[key]; ok {
+			r.taggedTasks.WithLabelValues(key, value).Inc()
+		}
+	}
+}
+
 func (r *Recorder) GetMemUsed() float64 {
 	metric := &dto.Metric{}
 	if err := r.memUsed.Write(metric); err != nil {
@@ -189,6 +482,41 @@ func (r *Recorder) GetUnavailableTotal() uint64 {
 	return uint64(metric.GetCounter().GetValue())
 }
 
+// AddNotProcessedSwept counts not processed entries dropped from memory after their TTL
+func (r *Recorder) AddNotProcessedSwept(n int) {
+	r.notProcessedSwept.Add(float64(n))
+}
+
+func (r *Recorder) GetNotProcessedSweptTotal() uint64 {
+	metric := &dto.Metric{}
+	if err := r.notProcessedSwept.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
+// IncRateLimited counts a submit rejected by the submit rate limit
+func (r *Recorder) IncRateLimited() {
+	r.rateLimited.Inc()
+}
+
+func (r *Recorder) GetRateLimitedTotal() uint64 {
+	metric := &dto.Metric{}
+	if err := r.rateLimited.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
+// GetTooManyRequestsTotal counts 429 responses, e.g. submits rejected by a full queue
+func (r *Recorder) GetTooManyRequestsTotal() uint64 {
+	metric := &dto.Metric{}
+	if err := r.statusCounter.WithLabelValues("429").Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
 func (r *Recorder) GetSubmittedTasksTotal() uint64 {
 	metric := &dto.Metric{}
 	if err := r.statusCounter.WithLabelValues("202").Write(metric); err != nil {
@@ -233,12 +561,146 @@ func (r *Recorder) DecActiveTasks(count float64) {
 	r.activeTasks.Sub(count)
 }
 
+// sampleMemory periodically updates memUsed metric until ctx is done
+func (r *Recorder) sampleMemory(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var ms runtime.MemStats
+	for {
+		runtime.ReadMemStats(&ms)
+		r.memUsed.Set(float64(ms.Alloc))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // ObserveTaskDuration updates httpRequestDurHistogram metric with passed request
 func (r *Recorder) ObserveTaskDuration(duration time.Duration) {
 	r.taskDuration.
 		Observe(duration.Seconds())
 }
 
+// ObserveHTTPDuration updates httpDuration metric with passed request duration
+func (r *Recorder) ObserveHTTPDuration(duration time.Duration) {
+	r.httpDuration.Observe(duration.Seconds())
+}
+
+// ObserveQueueWait records how long a task waited in the queue
+func (r *Recorder) ObserveQueueWait(wait time.Duration) {
+	r.queueWait.Observe(max(wait, 0).Seconds())
+}
+
+// GetQueueWaitQuantile estimates the q-quantile of the queue wait in seconds from the histogram buckets
+func (r *Recorder) GetQueueWaitQuantile(q float64) float64 {
+	metric := &dto.Metric{}
+	if err := r.queueWait.Write(metric); err != nil {
+		return 0
+	}
+	return histogramQuantile(metric.GetHistogram(), q)
+}
+
+// histogramQuantile interpolates linearly inside the bucket holding the quantile,
+// like histogram_quantile in PromQL. Values above the last bucket report its bound.
+func histogramQuantile(h *dto.Histogram, q float64) float64 {
+	total := h.GetSampleCount()
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var lowerBound, lowerCount float64
+	for _, b := range h.GetBucket() {
+		count := float64(b.GetCumulativeCount())
+		if count >= rank {
+			if count == lowerCount {
+				return b.GetUpperBound()
+			}
+			return lowerBound + (b.GetUpperBound()-lowerBound)*(rank-lowerCount)/(count-lowerCount)
+		}
+		lowerBound, lowerCount = b.GetUpperBound(), count
+	}
+	return lowerBound
+}
+
+// ExportQueueWait registers the queue wait histogram, only the service taking tasks
+// from the queue observes it. GetMetrics reports its quantiles afterwards.
+func (r *Recorder) ExportQueueWait() error {
+	if err := r.Register(r.queueWait); err != nil {
+		return err
+	}
+	r.queueWaitExported.Store(true)
+	return nil
+}
+
+// AddGaugeFunc exports a gauge only one service feeds, e.g. the drain rate of the process
+// service. It is read on every scrape and reported by GetMetrics under name.
+func (r *Recorder) AddGaugeFunc(subsystem, name, help string, read func() float64) error {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: r.conf.Prefix,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	}, read)
+	if err := r.Register(gauge); err != nil {
+		return err
+	}
+	r.gaugeFuncsMux.Lock()
+	defer r.gaugeFuncsMux.Unlock()
+	r.gaugeFuncs = append(r.gaugeFuncs, namedGaugeFunc{name: name, read: read})
+	return nil
+}
+
+// SetQueueDepth records a queue depth sample and raises the high-water mark when it is exceeded
+func (r *Recorder) SetQueueDepth(depth int64) {
+	r.queueDepth.Set(float64(depth))
+	for {
+		peak := r.maxDepth.Load()
+		if depth <= peak {
+			return
+		}
+		if r.maxDepth.CompareAndSwap(peak, depth) {
+			r.maxQueueDepth.Set(float64(depth))
+			return
+		}
+	}
+}
+
+func (r *Recorder) GetQueueDepth() uint64 {
+	metric := &dto.Metric{}
+	if err := r.queueDepth.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetGauge().GetValue())
+}
+
+func (r *Recorder) GetMaxQueueDepth() uint64 {
+	return uint64(r.maxDepth.Load())
+}
+
+// ResetMaxQueueDepth restarts the high-water mark from the last sampled depth
+func (r *Recorder) ResetMaxQueueDepth() {
+	depth := int64(r.GetQueueDepth())
+	r.maxDepth.Store(depth)
+	r.maxQueueDepth.Set(float64(depth))
+}
+
+// SetOldestPendingAge records how long the oldest pending task has waited, 0 for an empty queue
+func (r *Recorder) SetOldestPendingAge(age time.Duration) {
+	r.oldestPendingAge.Set(max(age, 0).Seconds())
+}
+
+func (r *Recorder) GetOldestPendingAge() float64 {
+	metric := &dto.Metric{}
+	if err := r.oldestPendingAge.Write(metric); err != nil {
+		return 0
+	}
+	return metric.GetGauge().GetValue()
+}
+
 // AddInflightRequests updates httpRequestsInflight metric with passed request
 func (r *Recorder) AddInflightRequests(quantity int) {
 	r.httpRequestsInflight.Add(float64(quantity))
@@ -247,14 +709,25 @@ func (r *Recorder) AddInflightRequests(quantity int) {
 // RegisterMetrics registers needed metrics with default prometheus registerer
 func (r *Recorder) RegisterMetrics() error {
 	metricsToRegister := []prometheus.Collector{
-		r.activeTasks, r.errorCounter, r.taskDuration, r.memUsed, r.httpRequestsInflight, r.statusCounter, r.taskCounter,
+		r.activeTasks, r.errorCounter, r.taskDuration, r.httpDuration, r.queueDepth, r.maxQueueDepth, r.oldestPendingAge, r.memUsed, r.httpRequestsInflight, r.statusCounter, r.taskCounter,
+		r.cancelledTasks, r.receivedTasks, r.chWritesRejected, r.chLogsDropped, r.droppedErrors, r.rateLimited, r.notProcessedSwept, r.taggedTasks, r.buildInfo, r.startTime,
 	}
 
-	for _, metric := range metricsToRegister {
-		if err := prometheus.DefaultRegisterer.Register(metric); err != nil {
+	return r.Register(metricsToRegister...)
+}
+
+// Register adds collectors of a single service, e.g. the external API hosts of the
+// process service, with the labels of the other metrics.
+func (r *Recorder) Register(collectors ...prometheus.Collector) error {
+	// instance_id rather than instance, which prometheus sets to the scrape target
+	registerer := prometheus.DefaultRegisterer
+	if r.conf.InstanceID != "" {
+		registerer = prometheus.WrapRegistererWith(prometheus.Labels{instanceLabel: r.conf.InstanceID}, registerer)
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
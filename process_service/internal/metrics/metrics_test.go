@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAddGaugeFunc(t *testing.T) {
+	r := NewRecorder(nil)
+	value := 1.5
+	if err := r.AddGaugeFunc("test", "gauge_func_value", "A gauge of the test.", func() float64 { return value }); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		prometheus.Unregister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Subsystem: "test", Name: "gauge_func_value", Help: "A gauge of the test."}, nil))
+	})
+
+	value = 2.5
+	if got := r.GetMetrics()["gauge_func_value"]; got != 2.5 {
+		t.Errorf("GetMetrics()[gauge_func_value] = %v, want 2.5", got)
+	}
+	if n, err := testutil.GatherAndCount(prometheus.DefaultGatherer, "test_gauge_func_value"); err != nil || n != 1 {
+		t.Errorf("gathered %d test_gauge_func_value series, err %v, want 1", n, err)
+	}
+
+	if err := r.AddGaugeFunc("test", "gauge_func_value", "A gauge of the test.", func() float64 { return 0 }); err == nil {
+		t.Error("AddGaugeFunc() registered the same gauge twice")
+	}
+}
+
+func TestGetMetricsWithoutGaugeFuncs(t *testing.T) {
+	if _, ok := NewRecorder(nil).GetMetrics()["drain_rate_per_second"]; ok {
+		t.Error("drain_rate_per_second is reported without being added")
+	}
+}
+
+func TestQueueWaitIsExportedOnRequest(t *testing.T) {
+	r := NewRecorder(nil)
+	if _, ok := r.GetMetrics()["queue_wait_seconds_p50"]; ok {
+		t.Error("queue wait is reported before ExportQueueWait")
+	}
+	if err := r.ExportQueueWait(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { prometheus.Unregister(r.queueWait) })
+
+	r.ObserveQueueWait(time.Second)
+	if got := r.GetMetrics()["queue_wait_seconds_p50"]; got == 0.0 {
+		t.Error("queue_wait_seconds_p50 is 0 after an observation")
+	}
+	if n, err := testutil.GatherAndCount(prometheus.DefaultGatherer, "task_queue_wait_seconds"); err != nil || n != 1 {
+		t.Errorf("gathered %d task_queue_wait_seconds series, err %v, want 1", n, err)
+	}
+}
+
+func TestIncTaggedTask(t *testing.T) {
+	r := NewRecorder(&Config{TagLabels: []string{"customer"}})
+	r.IncTaggedTask(map[string]string{"customer": "acme", "job": "nightly"})
+	r.IncTaggedTask(map[string]string{"customer": "acme"})
+	r.IncTaggedTask(nil)
+
+	// only the allow-listed key becomes a label, job would grow the series without bound
+	want := `
+		# HELP task_tagged_tasks_total The total number of tasks by allow-listed tag.
+		# TYPE task_tagged_tasks_total counter
+		task_tagged_tasks_total{tag="customer",value="acme"} 2
+	`
+	if err := testutil.CollectAndCompare(r.taggedTasks, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+// upperBounds returns the bucket bounds of the histogram
+func upperBounds(t *testing.T, h prometheus.Histogram) []float64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := h.Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	var bounds []float64
+	for _, b := range metric.GetHistogram().GetBucket() {
+		bounds = append(bounds, b.GetUpperBound())
+	}
+	return bounds
+}
+
+func TestDurationBuckets(t *testing.T) {
+	shared := []float64{1, 2}
+	task := []float64{1, 10, 100}
+	http := []float64{0.01, 0.1}
+	wait := []float64{5, 50}
+	tests := []struct {
+		name               string
+		conf               *Config
+		wantTask, wantHTTP []float64
+		wantQueueWait      []float64
+	}{
+		{"defaults", nil, prometheus.DefBuckets, prometheus.DefBuckets, prometheus.DefBuckets},
+		{"shared", &Config{DurationBuckets: shared}, shared, shared, shared},
+		{"per histogram", &Config{DurationBuckets: shared, TaskDurationBuckets: task, HTTPDurationBuckets: http, QueueWaitBuckets: wait}, task, http, wait},
+		{"some overridden", &Config{DurationBuckets: shared, HTTPDurationBuckets: http}, shared, http, shared},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRecorder(tt.conf)
+			for _, h := range []struct {
+				name string
+				got  []float64
+				want []float64
+			}{
+				{"task duration", upperBounds(t, r.taskDuration), tt.wantTask},
+				{"http duration", upperBounds(t, r.httpDuration), tt.wantHTTP},
+				{"queue wait", upperBounds(t, r.queueWait), tt.wantQueueWait},
+			} {
+				if !slices.Equal(h.got, h.want) {
+					t.Errorf("%s buckets = %v, want %v", h.name, h.got, h.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUptime(t *testing.T) {
+	r := NewRecorder(nil)
+	first := r.GetMetrics()
+	time.Sleep(10 * time.Millisecond)
+	second := r.GetMetrics()
+
+	if first["uptime_seconds"].(float64) >= second["uptime_seconds"].(float64) {
+		t.Errorf("uptime_seconds went from %v to %v, want it to increase", first["uptime_seconds"], second["uptime_seconds"])
+	}
+	startedAt, err := time.Parse(time.RFC3339, second["started_at"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first["started_at"] != second["started_at"] || time.Since(startedAt) > time.Minute {
+		t.Errorf("started_at = %v then %v, want the same recent time", first["started_at"], second["started_at"])
+	}
+}
+
+func TestInstanceLabel(t *testing.T) {
+	// a registry of its own, the default one may hold the unlabelled metrics already
+	reg := prometheus.NewRegistry()
+	registerer := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = reg
+	t.Cleanup(func() { prometheus.DefaultRegisterer = registerer })
+
+	r := NewRecorder(&Config{InstanceID: "pod-1"})
+	if err := r.RegisterMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	r.IncCancelledTasks()
+
+	want := `
+		# HELP task_cancelled_tasks_total The total number of tasks skipped because they were cancelled while queued.
+		# TYPE task_cancelled_tasks_total counter
+		task_cancelled_tasks_total{instance_id="pod-1"} 1
+	`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "task_cancelled_tasks_total"); err != nil {
+		t.Error(err)
+	}
+}
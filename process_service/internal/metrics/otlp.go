@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_defaultOTLPServiceName = "shortcut"
+	_otlpTimeout            = 5 * time.Second
+	// _otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE, totals are sent as they are
+	_otlpCumulative = 2
+)
+
+// OTLPSink posts metrics to an OpenTelemetry collector over OTLP/HTTP with the JSON encoding,
+// totals as cumulative monotonic sums and the rest as gauges
+type OTLPSink struct {
+	endpoint    string
+	serviceName string
+	instanceID  string
+	client      *http.Client
+	startedAt   time.Time
+}
+
+func NewOTLPSink(endpoint, serviceName, instanceID string) (*OTLPSink, error) {
+	if endpoint == "" {
+		return nil, errors.New("otlp_endpoint is empty")
+	}
+	if serviceName == "" {
+		serviceName = _defaultOTLPServiceName
+	}
+	return &OTLPSink{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		instanceID:  instanceID,
+		client:      &http.Client{Timeout: _otlpTimeout},
+		startedAt:   time.Now(),
+	}, nil
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpDataPoint struct {
+	StartTimeUnixNano string  `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string  `json:"timeUnixNano"`
+	AsDouble          float64 `json:"asDouble"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   map[string]string `json:"scope"`
+	Metrics []otlpMetric      `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     map[string][]otlpAttribute `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics         `json:"scopeMetrics"`
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func (s *OTLPSink) Flush(metrics map[string]any) error {
+	keys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	start := strconv.FormatInt(s.startedAt.UnixNano(), 10)
+	out := make([]otlpMetric, 0, len(keys))
+	for _, key := range keys {
+		value, ok := toFloat(metrics[key])
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(key, "_total") {
+			out = append(out, otlpMetric{Name: key, Sum: &otlpSum{
+				DataPoints:             []otlpDataPoint{{StartTimeUnixNano: start, TimeUnixNano: now, AsDouble: value}},
+				AggregationTemporality: _otlpCumulative,
+				IsMonotonic:            true,
+			}})
+		} else {
+			out = append(out, otlpMetric{Name: key, Gauge: &otlpGauge{
+				DataPoints: []otlpDataPoint{{TimeUnixNano: now, AsDouble: value}},
+			}})
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+
+	attributes := []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: s.serviceName}}}
+	if s.instanceID != "" {
+		attributes = append(attributes, otlpAttribute{Key: "service.instance.id", Value: otlpValue{StringValue: s.instanceID}})
+	}
+	body, err := json.Marshal(otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: map[string][]otlpAttribute{"attributes": attributes},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   map[string]string{"name": _defaultOTLPServiceName},
+			Metrics: out,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("otlp collector answered %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (s *OTLPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
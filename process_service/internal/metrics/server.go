@@ -3,45 +3,123 @@ package metrics
 import (
 	"context"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	_livezPath = "/livez"
+
+	DefaultAddr     = ":9090"
+	DefaultEndpoint = "/metrics"
 )
 
 type Config struct {
 	Addr     string `mapstructure:"addr"`
 	Endpoint string `mapstructure:"endpoint"`
+	// TagLabels is the allow-list of task tag keys promoted to prometheus labels
+	TagLabels []string `mapstructure:"tag_labels"`
+	// DurationBuckets are shared by all duration histograms without own buckets
+	DurationBuckets     []float64 `mapstructure:"duration_buckets"`
+	TaskDurationBuckets []float64 `mapstructure:"task_duration_buckets"`
+	HTTPDurationBuckets []float64 `mapstructure:"http_duration_buckets"`
+	QueueWaitBuckets    []float64 `mapstructure:"queue_wait_buckets"`
+	// StatsDAddr enables sending metrics to StatsD over UDP, e.g. "127.0.0.1:8125"
+	StatsDAddr     string        `mapstructure:"statsd_addr"`
+	StatsDPrefix   string        `mapstructure:"statsd_prefix"`
+	StatsDInterval time.Duration `mapstructure:"statsd_interval"`
+	// Sinks lists the enabled metrics exports: clickhouse, statsd, otlp
+	Sinks []string `mapstructure:"sinks"`
+	// FlushInterval is shared by all sinks, it falls back to StatsDInterval
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// OTLPEndpoint is the OTLP/HTTP metrics url, e.g. "http://127.0.0.1:4318/v1/metrics"
+	OTLPEndpoint    string `mapstructure:"otlp_endpoint"`
+	OTLPServiceName string `mapstructure:"otlp_service_name"`
+	// InstanceID is the instance_id label of every metric, it is set from the top level instance_id
+	InstanceID string `mapstructure:"-"`
 }
 
 // API contains settings for the metrics api
 type API struct {
-	conf   *Config
-	server *http.Server
+	conf    *Config
+	server  *http.Server
+	mux     *http.ServeMux
+	started atomic.Bool
+	// liveness is checked by /livez, nil always reports alive
+	liveness atomic.Pointer[func() error]
+	// healthChecks are the components reported by /health besides the metrics server
+	healthMux    sync.Mutex
+	healthChecks []namedCheck
 }
 
-func newRoutes(endpoint string) http.Handler {
+func (a *API) newRoutes(endpoint string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.Handle(endpoint, promhttp.Handler())
+	mux.HandleFunc(_livezPath, a.handleLiveness)
+	mux.HandleFunc(_healthPath, a.handleHealth)
 	return mux
 }
 
-func newAPI(conf *Config) *API {
-	routes := newRoutes(conf.Endpoint)
+// Handle serves an extra route of the service on the metrics server, like the health
+// checks it may be added after Start.
+func (a *API) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// withDefaults returns a copy of conf with the server address and endpoint set,
+// a nil conf, e.g. without a metrics section in the config file, gets all defaults
+func withDefaults(conf *Config) *Config {
+	c := &Config{}
+	if conf != nil {
+		*c = *conf
+	}
+	if c.Addr == "" {
+		c.Addr = DefaultAddr
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = DefaultEndpoint
+	}
+	return c
+}
 
-	server := &http.Server{
+func newAPI(conf *Config) *API {
+	a := &API{conf: conf}
+	a.mux = a.newRoutes(conf.Endpoint)
+	a.server = &http.Server{
 		Addr:              conf.Addr,
-		Handler:           routes,
+		Handler:           a.mux,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+	return a
+}
 
-	return &API{
-		conf:   conf,
-		server: server,
+// SetLivenessCheck sets the check behind /livez, a failing check answers 503.
+func (a *API) SetLivenessCheck(check func() error) {
+	a.liveness.Store(&check)
+}
+
+func (a *API) handleLiveness(w http.ResponseWriter, _ *http.Request) {
+	if check := a.liveness.Load(); check != nil {
+		if err := (*check)(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
 	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
 }
 
-// Start launches the metrics HTTP server in a goroutine.
+// Start launches the metrics HTTP server in a goroutine, calls after the first one are no-ops.
 func (a *API) Start(errCh chan error) {
+	if !a.started.CompareAndSwap(false, true) {
+		log.WithField("addr", a.conf.Addr).Warn("metrics API is already started")
+		return
+	}
 	go func() {
 		if err := a.server.ListenAndServe(); err != nil {
 			errCh <- err
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testInstanceID labels the collectors of a started service, so they do not collide with
+// the ones of other tests in the default registry
+func testInstanceID() string {
+	return "test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+func TestStartStopDoesNotLeakGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	s := New(&Config{Addr: "127.0.0.1:0", InstanceID: testInstanceID()})
+	errCh := make(chan error, 1)
+	if err := s.Start(errCh); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Errorf("second Stop = %v, want nil", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines after Stop, %d before Start:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	s := New(&Config{Addr: "127.0.0.1:0", InstanceID: testInstanceID()})
+	errCh := make(chan error, 2)
+	if err := s.Start(errCh); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	started := runtime.NumGoroutine()
+
+	// a second start would register the collectors again and fail
+	if err := s.Start(errCh); err != nil {
+		t.Errorf("second Start = %v, want nil", err)
+	}
+	if got := runtime.NumGoroutine(); got > started {
+		t.Errorf("%d goroutines after the second Start, want %d", got, started)
+	}
+	select {
+	case err := <-errCh:
+		t.Errorf("metrics API error = %v", err)
+	default:
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	tests := []struct {
+		name         string
+		conf         *Config
+		wantAddr     string
+		wantEndpoint string
+	}{
+		{"nil config", nil, DefaultAddr, DefaultEndpoint},
+		{"empty config", &Config{}, DefaultAddr, DefaultEndpoint},
+		{"address only", &Config{Addr: "127.0.0.1:9191"}, "127.0.0.1:9191", DefaultEndpoint},
+		{"both set", &Config{Addr: "127.0.0.1:9191", Endpoint: "/prom"}, "127.0.0.1:9191", "/prom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before Config
+			if tt.conf != nil {
+				before = *tt.conf
+			}
+			s := New(tt.conf)
+			if s.API.server.Addr != tt.wantAddr {
+				t.Errorf("addr = %q, want %q", s.API.server.Addr, tt.wantAddr)
+			}
+			rec := httptest.NewRecorder()
+			s.API.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.wantEndpoint, nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("GET %s status = %d, want %d", tt.wantEndpoint, rec.Code, http.StatusOK)
+			}
+			if tt.conf != nil && !reflect.DeepEqual(*tt.conf, before) {
+				t.Errorf("New() changed the given config to %+v, want %+v", *tt.conf, before)
+			}
+		})
+	}
+}
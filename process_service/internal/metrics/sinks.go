@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SinkClickHouse = "clickhouse"
+	SinkStatsD     = "statsd"
+	SinkOTLP       = "otlp"
+
+	_defaultFlushInterval = 10 * time.Second
+)
+
+// Sink receives a snapshot of Recorder.GetMetrics() periodically
+type Sink interface {
+	Flush(metrics map[string]any) error
+	Close() error
+}
+
+// namedSink is a registered sink, busy skips flushes while the previous one is still running
+type namedSink struct {
+	name string
+	sink Sink
+	busy atomic.Bool
+}
+
+// sinkSet holds the sinks every flush fans out to, flushing tracks the flushes in flight
+type sinkSet struct {
+	mux      sync.Mutex
+	sinks    []*namedSink
+	flushing sync.WaitGroup
+}
+
+// SinkEnabled reports whether the sink is listed in sinks. Without the list ClickHouse
+// is enabled and StatsD is enabled by statsd_addr, as before sinks were configurable.
+func (c *Config) SinkEnabled(name string) bool {
+	if c == nil {
+		return name == SinkClickHouse
+	}
+	if len(c.Sinks) == 0 {
+		return name == SinkClickHouse || (name == SinkStatsD && c.StatsDAddr != "")
+	}
+	return slices.Contains(c.Sinks, name)
+}
+
+func (c *Config) flushInterval() time.Duration {
+	if c == nil {
+		return _defaultFlushInterval
+	}
+	if c.FlushInterval > 0 {
+		return c.FlushInterval
+	}
+	if c.StatsDInterval > 0 {
+		return c.StatsDInterval
+	}
+	return _defaultFlushInterval
+}
+
+// SinkEnabled reports whether the named sink is enabled in the config
+func (s *Service) SinkEnabled(name string) bool {
+	return s.conf.SinkEnabled(name)
+}
+
+// AddSink registers a sink for the following flushes, it may be called after Start.
+func (s *Service) AddSink(name string, sink Sink) {
+	s.sinks.mux.Lock()
+	defer s.sinks.mux.Unlock()
+	s.sinks.sinks = append(s.sinks.sinks, &namedSink{name: name, sink: sink})
+	log.WithField("sink", name).Info("metrics sink added")
+}
+
+// startSinks creates the configured built-in sinks, the ClickHouse one is added by the repository
+func (s *Service) startSinks() {
+	if s.conf.SinkEnabled(SinkStatsD) {
+		if sink, err := NewStatsDSink(s.conf.StatsDAddr, s.conf.StatsDPrefix); err != nil {
+			log.WithError(err).Error("failed to create statsd sink")
+		} else {
+			s.AddSink(SinkStatsD, sink)
+		}
+	}
+	if s.conf.SinkEnabled(SinkOTLP) {
+		if sink, err := NewOTLPSink(s.conf.OTLPEndpoint, s.conf.OTLPServiceName, s.conf.InstanceID); err != nil {
+			log.WithError(err).Error("failed to create otlp sink")
+		} else {
+			s.AddSink(SinkOTLP, sink)
+		}
+	}
+}
+
+// runSinks fans out a metrics snapshot to all sinks every interval until ctx is done.
+// Sinks flush concurrently, so a failing or slow sink does not hold back the others.
+func (s *Service) runSinks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer s.closeSinks()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flushSinks()
+		}
+	}
+}
+
+func (s *Service) flushSinks() {
+	s.sinks.mux.Lock()
+	sinks := slices.Clone(s.sinks.sinks)
+	s.sinks.mux.Unlock()
+	if len(sinks) == 0 {
+		return
+	}
+
+	snapshot := s.Recorder.GetMetrics()
+	for _, ns := range sinks {
+		if !ns.busy.CompareAndSwap(false, true) {
+			log.WithField("sink", ns.name).Warn("metrics sink is still flushing, skipping")
+			continue
+		}
+		s.sinks.flushing.Add(1)
+		go func(ns *namedSink) {
+			defer s.sinks.flushing.Done()
+			defer ns.busy.Store(false)
+			if err := ns.sink.Flush(snapshot); err != nil {
+				log.WithError(err).WithField("sink", ns.name).Warn("failed to flush metrics sink")
+			}
+		}(ns)
+	}
+}
+
+// closeSinks waits for the flushes in flight, so their snapshots are not cut off, then closes the sinks
+func (s *Service) closeSinks() {
+	s.sinks.flushing.Wait()
+	s.sinks.mux.Lock()
+	defer s.sinks.mux.Unlock()
+	for _, ns := range s.sinks.sinks {
+		if err := ns.sink.Close(); err != nil {
+			log.WithError(err).WithField("sink", ns.name).Warn("failed to close metrics sink")
+		}
+	}
+	s.sinks.sinks = nil
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowSink records flushes and whether it was closed while a flush was running
+type slowSink struct {
+	mux           sync.Mutex
+	delay         time.Duration
+	flushing      bool
+	flushes       int
+	closed        bool
+	closedInFlush bool
+}
+
+func (s *slowSink) Flush(map[string]any) error {
+	s.mux.Lock()
+	s.flushing = true
+	s.mux.Unlock()
+	time.Sleep(s.delay)
+	s.mux.Lock()
+	s.flushing = false
+	s.flushes++
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *slowSink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed = true
+	s.closedInFlush = s.flushing
+	return nil
+}
+
+func TestCloseSinksWaitsForFlushesInFlight(t *testing.T) {
+	s := New(nil)
+	sink := &slowSink{delay: 50 * time.Millisecond}
+	s.AddSink("slow", sink)
+
+	s.flushSinks()
+	s.closeSinks()
+
+	sink.mux.Lock()
+	defer sink.mux.Unlock()
+	if !sink.closed {
+		t.Fatal("sink was not closed")
+	}
+	if sink.closedInFlush || sink.flushes != 1 {
+		t.Errorf("sink closed during its flush (flushes %d)", sink.flushes)
+	}
+}
+
+func TestFlushSinksSkipsBusySink(t *testing.T) {
+	s := New(nil)
+	sink := &slowSink{delay: 50 * time.Millisecond}
+	s.AddSink("slow", sink)
+
+	s.flushSinks()
+	s.flushSinks()
+	s.closeSinks()
+
+	if sink.flushes != 1 {
+		t.Errorf("flushes = %d, want the second one skipped while the first ran", sink.flushes)
+	}
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+const _maxStatsDPacket = 1432
+
+// StatsDSink sends metrics over UDP in StatsD format, totals as counters and the rest as gauges
+type StatsDSink struct {
+	conn   net.Conn
+	prefix string
+	// last keeps previously sent totals, StatsD counters are deltas
+	last map[string]float64
+}
+
+func NewStatsDSink(addr, prefix string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsDSink{conn: conn, prefix: prefix, last: make(map[string]float64)}, nil
+}
+
+func (s *StatsDSink) Flush(metrics map[string]any) error {
+	keys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var packet bytes.Buffer
+	for _, key := range keys {
+		value, ok := toFloat(metrics[key])
+		if !ok {
+			continue
+		}
+		var line string
+		if strings.HasSuffix(key, "_total") {
+			delta := value - s.last[key]
+			s.last[key] = value
+			if delta <= 0 {
+				continue
+			}
+			line = fmt.Sprintf("%s%s:%g|c", s.prefix, key, delta)
+		} else {
+			line = fmt.Sprintf("%s%s:%g|g", s.prefix, key, value)
+		}
+
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > _maxStatsDPacket {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(packet.Bytes())
+	return err
+}
+
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case uint64:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readStatsD returns the lines of the next packet the listener receives
+func readStatsD(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	buf := make([]byte, _maxStatsDPacket)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestStatsDSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewStatsDSink(conn.LocalAddr().String(), "shortcut")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	metrics := map[string]any{
+		"active_tasks":          uint64(3),
+		"processed_tasks_total": uint64(10),
+		"uptime_seconds":        1.5,
+		"started_at":            "2026-01-02T03:04:05Z",
+	}
+	if err := sink.Flush(metrics); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"shortcut.active_tasks:3|g", "shortcut.processed_tasks_total:10|c", "shortcut.uptime_seconds:1.5|g"}
+	if got := readStatsD(t, conn); !slices.Equal(got, want) {
+		t.Errorf("first flush sent %q, want %q", got, want)
+	}
+
+	// totals are sent as the increase since the last flush, unchanged ones not at all
+	metrics["processed_tasks_total"] = uint64(14)
+	metrics["active_tasks"] = uint64(1)
+	if err := sink.Flush(metrics); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"shortcut.active_tasks:1|g", "shortcut.processed_tasks_total:4|c", "shortcut.uptime_seconds:1.5|g"}
+	if got := readStatsD(t, conn); !slices.Equal(got, want) {
+		t.Errorf("second flush sent %q, want %q", got, want)
+	}
+}
+
+func TestStatsDSinkSplitsPackets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewStatsDSink(conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	metrics := make(map[string]any)
+	for i := range 200 {
+		metrics[fmt.Sprintf("gauge_with_a_rather_long_name_%03d", i)] = float64(i)
+	}
+	if err := sink.Flush(metrics); err != nil {
+		t.Fatal(err)
+	}
+	received := 0
+	for received < len(metrics) {
+		received += len(readStatsD(t, conn))
+	}
+	if received != len(metrics) {
+		t.Errorf("received %d lines, want %d", received, len(metrics))
+	}
+}
@@ -3,13 +3,20 @@ package repository
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ch "github.com/ClickHouse/clickhouse-go/v2"
 	log "github.com/sirupsen/logrus"
 
 	"process_service/internal/metrics"
+	"process_service/internal/retry"
 )
 
 type Config struct {
@@ -19,15 +26,97 @@ type Config struct {
 	NumRetries int           `mapstructure:"num_retries"`
 	Timeout    time.Duration `mapstructure:"timeout"`
 	UseTLS     bool          `mapstructure:"use_tls"`
+	// Retry is the policy of log and metric inserts, without it NumRetries attempts are made 500ms apart
+	Retry *retry.Policy `mapstructure:"retry"`
+	// LogHookEnabled turns log shipping to ClickHouse on or off, it is on when unset
+	LogHookEnabled *bool `mapstructure:"log_hook_enabled"`
+	// MaxConcurrentWrites bounds log and metric inserts in flight, excess writes are rejected
+	MaxConcurrentWrites int `mapstructure:"max_concurrent_writes"`
+	// NotProcessedSeedFile is a JSONL logs file of a previous run to load not processed tasks from
+	NotProcessedSeedFile string `mapstructure:"not_processed_seed_file"`
+	// NotProcessedTTL drops not processed tasks from memory that long after they were added,
+	// they stay in the not_processed table. 0 keeps them for the life of the process.
+	NotProcessedTTL time.Duration `mapstructure:"not_processed_ttl"`
+	// LogsDSN and MetricsDSN route log and metric rows to other clusters, both fall back to DSN
+	LogsDSN    string `mapstructure:"logs_dsn"`
+	MetricsDSN string `mapstructure:"metrics_dsn"`
+	// AsyncInsert lets ClickHouse batch log and metric inserts server side. Without
+	// WaitForAsyncInsert an insert is acknowledged before it is flushed, so rows
+	// buffered by the server are lost if it crashes.
+	AsyncInsert        bool `mapstructure:"async_insert"`
+	WaitForAsyncInsert bool `mapstructure:"wait_for_async_insert"`
+	// DebugInserts logs the target, the row and the full ClickHouse error of failed log and
+	// metric inserts, e.g. to find schema mismatches. Rows may hold sensitive data.
+	DebugInserts bool `mapstructure:"debug_inserts"`
+	// MetricsFormat is "wide" (default), one JSON row per flush in metrics, or "long",
+	// one row per metric with name and value in metrics_long for time series queries
+	MetricsFormat string `mapstructure:"metrics_format"`
+	// InstanceID is added to every log and metric row, it is set from the top level instance_id
+	InstanceID string `mapstructure:"-"`
+}
+
+// _legacyRetryDelay is the wait between the NumRetries attempts without a retry policy
+const _legacyRetryDelay = 500 * time.Millisecond
+
+// RetryPolicy returns the insert retry policy
+func (c *Config) RetryPolicy() retry.Policy {
+	if c.Retry != nil {
+		return *c.Retry
+	}
+	return retry.Policy{MaxAttempts: c.NumRetries, BaseDelay: _legacyRetryDelay, MaxDelay: _legacyRetryDelay}
+}
+
+func (c *Config) ShipLogs() bool {
+	return c.LogHookEnabled == nil || *c.LogHookEnabled
 }
 
 type Client struct {
 	conf *Config
 	conn ch.Conn
-	ctx  context.Context
+	// logsConn and metricsConn are conn unless separate DSNs are configured
+	logsConn    ch.Conn
+	metricsConn ch.Conn
+	ctx         context.Context
+	// cancel aborts writes still running when the drain on Close times out
+	cancel context.CancelFunc
+	// inflight tracks log, metric and not processed writes, closed rejects new ones once Close began
+	inflight sync.WaitGroup
+	closeMux sync.RWMutex
+	closed   bool
+	// writeSem bounds concurrent log and metric writes
+	writeSem   chan struct{}
+	metricsSrv *metrics.Service
+	// retry is the policy of log and metric inserts
+	retry retry.Policy
 }
 
 func NewClient(ctx context.Context, conf *Config) (*Client, error) {
+	c, err := openConn(conf, conf.DSN)
+	if err != nil {
+		return nil, err
+	}
+	logsConn, err := openConnOr(conf, conf.LogsDSN, c)
+	if err != nil {
+		return nil, err
+	}
+	metricsConn, err := openConnOr(conf, conf.MetricsDSN, c)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Client{
+		conf:        conf,
+		conn:        c,
+		logsConn:    logsConn,
+		metricsConn: metricsConn,
+		ctx:         ctx,
+		cancel:      cancel,
+		writeSem:    make(chan struct{}, maxConcurrentWrites(conf)),
+		retry:       conf.RetryPolicy(),
+	}, nil
+}
+
+func openConn(conf *Config, dsn string) (ch.Conn, error) {
 	var tlsConfig *tls.Config
 	if conf.UseTLS {
 		tlsConfig = &tls.Config{InsecureSkipVerify: true}
@@ -38,25 +127,123 @@ func NewClient(ctx context.Context, conf *Config) (*Client, error) {
 		user = "default"
 	}
 
-	c, err := ch.Open(&ch.Options{
+	return ch.Open(&ch.Options{
 		Protocol: ch.HTTP,
 		TLS:      tlsConfig,
-		Addr:     []string{conf.DSN},
+		Addr:     []string{dsn},
 		Auth: ch.Auth{
 			Database: "default",
 			Username: user,
 			Password: conf.Password,
 		},
 		DialTimeout: conf.Timeout,
+		TransportFunc: func(t *http.Transport) (http.RoundTripper, error) {
+			return &bodyCheckTransport{next: t}, nil
+		},
 	})
-	if err != nil {
-		return nil, err
+}
+
+// Ping checks that ClickHouse is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.conn.Ping(ctx)
+}
+
+// openConnOr opens a connection to dsn, or returns fallback when dsn is empty or the same as the main one
+func openConnOr(conf *Config, dsn string, fallback ch.Conn) (ch.Conn, error) {
+	if dsn == "" || dsn == conf.DSN {
+		return fallback, nil
 	}
-	return &Client{
-		conf: conf,
-		conn: c,
-		ctx:  ctx,
-	}, nil
+	return openConn(conf, dsn)
+}
+
+// connFor returns the connection serving the table
+func (c *Client) connFor(table string) ch.Conn {
+	switch table {
+	case "logs":
+		return c.logsConn
+	case "metrics", metricsLongTable:
+		return c.metricsConn
+	default:
+		return c.conn
+	}
+}
+
+// insertContext applies the async insert settings to log and metric inserts
+func (c *Client) insertContext(ctx context.Context) context.Context {
+	if !c.conf.AsyncInsert {
+		return ctx
+	}
+	wait := 0
+	if c.conf.WaitForAsyncInsert {
+		wait = 1
+	}
+	return ch.Context(ctx, ch.WithSettings(ch.Settings{
+		"async_insert":          1,
+		"wait_for_async_insert": wait,
+	}))
+}
+
+// _debugRowLimit truncates rows logged by DebugInserts
+const _debugRowLimit = 2048
+
+// debugInsert logs a failed insert when DebugInserts is on, to the standard logger
+// for the same reason as LogHook.drop.
+func (c *Client) debugInsert(table, query, row string, err error) {
+	if !c.conf.DebugInserts {
+		return
+	}
+	if len(row) > _debugRowLimit {
+		row = row[:_debugRowLimit] + "...(truncated)"
+	}
+	log.WithFields(log.Fields{
+		"dsn":   redactDSN(c.dsnFor(table)),
+		"table": table,
+		"query": query,
+		"row":   row,
+		// the error carries the exception ClickHouse answered with
+		"response": err.Error(),
+	}).Warn("clickhouse insert failed")
+}
+
+// dsnFor returns the DSN of the connection serving the table
+func (c *Client) dsnFor(table string) string {
+	switch {
+	case table == "logs" && c.conf.LogsDSN != "":
+		return c.conf.LogsDSN
+	case (table == "metrics" || table == metricsLongTable) && c.conf.MetricsDSN != "":
+		return c.conf.MetricsDSN
+	default:
+		return c.conf.DSN
+	}
+}
+
+// _redactedDSN replaces a DSN which can not be parsed, as it may hold credentials anywhere
+const _redactedDSN = "[redacted]"
+
+// redactDSN hides credentials and secret looking query parameters of a DSN
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Host == "" {
+		return _redactedDSN
+	}
+	q := u.Query()
+	for key := range q {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "password") || strings.Contains(lower, "secret") || strings.Contains(lower, "token") {
+			q.Set(key, "xxxxx")
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.Redacted()
+}
+
+const defaultMaxConcurrentWrites = 16
+
+func maxConcurrentWrites(conf *Config) int {
+	if conf.MaxConcurrentWrites > 0 {
+		return conf.MaxConcurrentWrites
+	}
+	return defaultMaxConcurrentWrites
 }
 
 type Service struct {
@@ -65,7 +252,25 @@ type Service struct {
 	ErrCh      chan error
 	logger     log.Logger
 	mux        *sync.Mutex
-	storage    map[string]struct{}
+	storage    map[string]NotProcessedTask
+	// notProcessedCount caches CountNotProcessedTasks
+	notProcessedCount *countCache
+	started           atomic.Bool
+	stopOnce          sync.Once
+	stopErr           error
+
+	// notProcessedTTL bounds how long storage keeps an entry, now is its clock
+	notProcessedTTL time.Duration
+	now             func() time.Time
+}
+
+// NotProcessedTask describes why and when a task was given up on.
+type NotProcessedTask struct {
+	TaskID string    `json:"task_id"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+	// addedAt is when the entry was stored, the TTL counts from it rather than from At
+	addedAt time.Time
 }
 
 func NewService(ctx context.Context, conf *Config, m *metrics.Service, errCh chan error) (*Service, error) {
@@ -73,26 +278,89 @@ func NewService(ctx context.Context, conf *Config, m *metrics.Service, errCh cha
 	if err != nil {
 		return nil, err
 	}
+	c.metricsSrv = m
 	// ensure ClickHouse tables exist when running against HTTP ClickHouse
 	if err := c.ensureTables(); err != nil {
 		return nil, err
 	}
-	return &Service{
-		Client:     c,
-		metricsSrv: m,
-		ErrCh:      errCh,
-		mux:        &sync.Mutex{},
-		storage:    make(map[string]struct{}),
-	}, nil
+	s := &Service{
+		Client:            c,
+		metricsSrv:        m,
+		ErrCh:             errCh,
+		mux:               &sync.Mutex{},
+		storage:           make(map[string]NotProcessedTask),
+		notProcessedCount: newCountCache(notProcessedCountTTL),
+
+		notProcessedTTL: conf.NotProcessedTTL,
+		now:             time.Now,
+	}
+	if conf.NotProcessedSeedFile != "" {
+		s.seedNotProcessed(conf.NotProcessedSeedFile)
+	}
+	return s, nil
 }
 
-func (s *Service) AddNotProcessedTask(taskID string) {
+// AddNotProcessed keeps the task in memory and persists it to ClickHouse in the background.
+func (s *Service) AddNotProcessed(taskID, reason string, at time.Time) {
+	s.mux.Lock()
+	s.storage[taskID] = NotProcessedTask{TaskID: taskID, Reason: reason, At: at, addedAt: s.now()}
+	s.mux.Unlock()
+
+	s.Client.WriteNotProcessed(taskID, reason, at, func(err error) {
+		log.WithError(err).WithField("taskId", taskID).Warn("failed to persist not processed task")
+	})
+}
+
+// GetNotProcessedTasks returns not processed tasks with their reasons, oldest first.
+func (s *Service) GetNotProcessedTasks() []NotProcessedTask {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.storage[taskID] = struct{}{}
+
+	res := make([]NotProcessedTask, 0, len(s.storage))
+	for _, t := range s.storage {
+		res = append(res, t)
+	}
+	slices.SortFunc(res, func(a, b NotProcessedTask) int {
+		return a.At.Compare(b.At)
+	})
+
+	return res
 }
 
+// GetAllNotProcessedTasks returns ids of not processed tasks of all instances, at most
+// notProcessedIDsLimit of them. Use CountNotProcessedTasks for the number.
 func (s *Service) GetAllNotProcessedTasks() []string {
+	return s.GetAllNotProcessedTasksContext(s.Client.ctx)
+}
+
+// GetAllNotProcessedTasksContext reads not processed task ids from ClickHouse,
+// falling back to the ones added by this instance when the query fails.
+func (s *Service) GetAllNotProcessedTasksContext(ctx context.Context) []string {
+	ids, err := s.Client.ReadNotProcessedIDs(ctx)
+	if err == nil {
+		return ids
+	}
+	log.WithError(err).Warn("failed to query not processed tasks, using in-memory set")
+	return s.GetLocalNotProcessedTasks()
+}
+
+// CountNotProcessedTasks returns the number of not processed tasks of all instances,
+// cached for notProcessedCountTTL. A failed query falls back to this instance's tasks.
+func (s *Service) CountNotProcessedTasks() uint64 {
+	count, err := s.notProcessedCount.get(func() (uint64, error) {
+		return s.Client.CountNotProcessed(s.Client.ctx)
+	})
+	if err == nil {
+		return count
+	}
+	log.WithError(err).Warn("failed to count not processed tasks, using in-memory set")
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return uint64(len(s.storage))
+}
+
+// GetLocalNotProcessedTasks returns ids of tasks this instance failed to process.
+func (s *Service) GetLocalNotProcessedTasks() []string {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
@@ -108,9 +376,25 @@ func (c *Client) ensureTables() error {
 	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
 	defer cancel()
 
+	if err := c.logsConn.Exec(ctx, `CREATE TABLE IF NOT EXISTS logs (ts DateTime64(9), val String) ENGINE = MergeTree() ORDER BY ts`); err != nil {
+		return err
+	}
+	if err := c.metricsConn.Exec(ctx, `CREATE TABLE IF NOT EXISTS metrics (ts DateTime64(9), val String) ENGINE = MergeTree() ORDER BY ts`); err != nil {
+		return err
+	}
+	if c.conf.MetricsFormat == MetricsFormatLong {
+		if err := c.metricsConn.Exec(ctx, `CREATE TABLE IF NOT EXISTS metrics_long (
+			ts DateTime64(9),
+			name LowCardinality(String),
+			value Float64,
+			instance_id LowCardinality(String)
+		) ENGINE = MergeTree() ORDER BY (name, ts)`); err != nil {
+			return err
+		}
+	}
+
 	ddls := []string{
-		`CREATE TABLE IF NOT EXISTS logs (ts DateTime64(9), val String) ENGINE = MergeTree() ORDER BY ts`,
-		`CREATE TABLE IF NOT EXISTS metrics (ts DateTime64(9), val String) ENGINE = MergeTree() ORDER BY ts`,
+		`CREATE TABLE IF NOT EXISTS not_processed (task_id String, reason String, ts DateTime64(9)) ENGINE = MergeTree() ORDER BY ts`,
 		`CREATE TABLE IF NOT EXISTS tasks (
 			id UUID,
 			status String,
@@ -128,27 +412,112 @@ func (c *Client) ensureTables() error {
 	if err := c.conn.Exec(ctx, `ALTER TABLE tasks ADD COLUMN IF NOT EXISTS failed_payload Nullable(String)`); err != nil {
 		return err
 	}
+	if err := c.conn.Exec(ctx, `ALTER TABLE tasks ADD COLUMN IF NOT EXISTS tags Map(String, String)`); err != nil {
+		return err
+	}
+	// attempts are appended as rows, updating the tasks row per attempt would be a mutation each
+	if err := c.conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS task_attempts (
+		task_id UUID,
+		ts DateTime64(9),
+		duration_ms UInt64,
+		error String
+	) ENGINE = MergeTree() ORDER BY (task_id, ts)`); err != nil {
+		return err
+	}
+	if err := c.conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS task_completions (
+		task_id UUID,
+		completed_at DateTime64(9)
+	) ENGINE = MergeTree() ORDER BY (completed_at, task_id)`); err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// Start registers ClickHouse as a metrics sink unless it is disabled in metrics sinks,
+// calls after the first one are no-ops.
 func (s *Service) Start() {
+	if !s.started.CompareAndSwap(false, true) {
+		log.Warn("repository service is already started")
+		return
+	}
+	if s.metricsSrv != nil && s.metricsSrv.SinkEnabled(metrics.SinkClickHouse) {
+		s.metricsSrv.AddSink(metrics.SinkClickHouse, metricsSink{s})
+	}
+	go s.runNotProcessedSweeper(s.Client.ctx)
+}
+
+// metricsSink writes metric snapshots into the metrics table
+type metricsSink struct {
+	s *Service
+}
+
+// Flush hands write failures to ErrCh like other background errors rather than to the
+// metrics service, which would only log them
+func (m metricsSink) Flush(snapshot map[string]any) error {
+	if err := m.s.Client.WriteMetrics(snapshot); err != nil && !errors.Is(err, ErrClientClosed) {
+		m.s.reportErr(err)
+	}
+	return nil
+}
+
+// Close is a no-op, the connections are closed by Stop
+func (m metricsSink) Close() error {
+	return nil
+}
+
+// reportErr hands err to the ErrCh reader without blocking, when the channel is
+// full the error is logged and counted as dropped
+func (s *Service) reportErr(err error) {
+	select {
+	case s.ErrCh <- err:
+		return
+	case <-s.Client.ctx.Done():
+	default:
+	}
+	log.WithError(err).Warn("error channel is full, dropping error")
+	if s.metricsSrv != nil {
+		s.metricsSrv.Recorder.IncDroppedErrors()
+	}
+}
+
+// Stop waits for log and metric writes in flight until ctx is done, so the last
+// ones are not lost, then closes the connections. It is safe to call more than once.
+func (s *Service) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		s.stopErr = s.Client.Close(ctx)
+	})
+	return s.stopErr
+}
+
+// Close rejects new log and metric writes with ErrClientClosed, waits for the ones in
+// flight until ctx is done, cancels what is left and closes the connections.
+func (c *Client) Close(ctx context.Context) error {
+	c.closeMux.Lock()
+	c.closed = true
+	c.closeMux.Unlock()
+
+	drained := make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-s.Client.ctx.Done():
-				return
-			case <-ticker.C:
-				if s.metricsSrv != nil {
-					m := s.metricsSrv.Recorder.GetMetrics()
-					err := s.Client.WriteMetrics(m)
-					if err != nil {
-						s.ErrCh <- err
-					}
-				}
-			}
-		}
+		c.inflight.Wait()
+		close(drained)
 	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Warn("clickhouse writes did not finish in time, cancelling them")
+	}
+	c.cancel()
+
+	// logs and metrics share the main connection unless they have own DSNs
+	var errs []error
+	closed := make(map[ch.Conn]struct{}, 3)
+	for _, conn := range []ch.Conn{c.conn, c.logsConn, c.metricsConn} {
+		if _, ok := closed[conn]; ok {
+			continue
+		}
+		closed[conn] = struct{}{}
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// _maxExceptionBody bounds how much of a 200 insert response is read, ClickHouse
+// limits an exception block to 16KiB
+const _maxExceptionBody = 32 * 1024
+
+// exceptionPattern matches ClickHouse's text exception format, e.g.
+// "Code: 27. DB::Exception: Cannot parse input: ..."
+var exceptionPattern = regexp.MustCompile(`Code: (\d+)\. DB::Exception: ([^\r\n]*)`)
+
+// ClickHouseException is an error ClickHouse reported in the body of a 200 response
+type ClickHouseException struct {
+	Code    int
+	Message string
+}
+
+func (e *ClickHouseException) Error() string {
+	return fmt.Sprintf("clickhouse exception %d: %s", e.Code, e.Message)
+}
+
+type checkBodyKey struct{}
+
+// withBodyCheck marks inserts whose 200 responses are inspected for exceptions,
+// query results are streamed and never buffered
+func withBodyCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, checkBodyKey{}, true)
+}
+
+// bodyCheckTransport turns a 200 response carrying a ClickHouse exception into an error,
+// the driver only looks at the status code of statements without results
+type bodyCheckTransport struct {
+	next http.RoundTripper
+}
+
+func (t *bodyCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || req.Context().Value(checkBodyKey{}) == nil {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, _maxExceptionBody))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if exc := parseException(body); exc != nil {
+		return nil, exc
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// parseException returns the first exception in body, or nil when there is none
+func parseException(body []byte) *ClickHouseException {
+	m := exceptionPattern.FindSubmatch(body)
+	if m == nil {
+		return nil
+	}
+	code, _ := strconv.Atoi(string(m[1]))
+	return &ClickHouseException{Code: code, Message: string(bytes.TrimSpace(m[2]))}
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseException(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *ClickHouseException
+	}{
+		{"no exception", "", nil},
+		{"plain output", "Ok.\n", nil},
+		{"exception", "Code: 27. DB::Exception: Cannot parse input: expected '\"' before: 'x': (at row 1)\n", &ClickHouseException{Code: 27, Message: "Cannot parse input: expected '\"' before: 'x': (at row 1)"}},
+		{"exception after output", "1\n2\nCode: 241. DB::Exception: Memory limit exceeded. (MEMORY_LIMIT_EXCEEDED)\r\n", &ClickHouseException{Code: 241, Message: "Memory limit exceeded. (MEMORY_LIMIT_EXCEEDED)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseException([]byte(tt.body))
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("parseException(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFailsOnExceptionBody(t *testing.T) {
+	c := testClickHouse(t, &Config{NumRetries: 1}, func(w http.ResponseWriter, _ *http.Request, query string) {
+		if strings.HasPrefix(query, "INSERT") {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Code: 27. DB::Exception: Cannot parse input: expected ',' before: 'x'\n"))
+		}
+	})
+
+	for name, write := range map[string]func() error{
+		"WriteLog":     func() error { return c.WriteLog(map[string]any{"msg": "x"}) },
+		"WriteMetrics": func() error { return c.WriteMetrics(map[string]any{"submitted_tasks_total": 1}) },
+	} {
+		var exc *ClickHouseException
+		if err := write(); !errors.As(err, &exc) || exc.Code != 27 {
+			t.Errorf("%s() = %v, want the ClickHouse exception 27", name, err)
+		}
+	}
+}
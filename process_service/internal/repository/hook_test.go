@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"process_service/internal/metrics"
+)
+
+func TestLogHookCountsDrops(t *testing.T) {
+	const overflow = 5
+	hooks := log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(hooks) })
+	warnings := test.NewGlobal()
+
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	c := testClickHouse(t, &Config{MaxConcurrentWrites: 1}, func(_ http.ResponseWriter, _ *http.Request, query string) {
+		if strings.HasPrefix(query, "INSERT") {
+			received <- struct{}{}
+			<-release
+		}
+	})
+	c.metricsSrv = metrics.New(nil)
+	h := NewLogHook(c)
+	fire := func(msg string) error {
+		return h.Fire(&log.Entry{Time: time.Now(), Level: log.InfoLevel, Message: msg, Data: log.Fields{}})
+	}
+
+	// the first entry holds the only write slot, the next ones find the buffer full
+	slow := make(chan error, 1)
+	go func() { slow <- fire("slow") }()
+	<-received
+	for range overflow {
+		if err := fire("dropped"); err != nil {
+			t.Errorf("Fire() of a dropped entry = %v, want nil", err)
+		}
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Errorf("Fire() within the gate = %v", err)
+	}
+
+	if got := c.metricsSrv.Recorder.GetClickHouseLogsDroppedTotal(); got != overflow {
+		t.Errorf("clickhouse_logs_dropped_total = %d, want %d", got, overflow)
+	}
+	if got := c.metricsSrv.Recorder.GetMetrics()["clickhouse_logs_dropped_total"]; got != uint64(overflow) {
+		t.Errorf("stats clickhouse_logs_dropped_total = %v, want %d", got, overflow)
+	}
+	// the warning is throttled, only the first drop within the interval logs it
+	if got := len(warnings.AllEntries()); got != 1 {
+		t.Fatalf("%d warnings logged, want 1", got)
+	}
+	if entry := warnings.LastEntry(); entry.Message != "clickhouse log hook is dropping entries" || entry.Data["dropped"] != int64(1) {
+		t.Errorf("warning = %q with %v, want the drop warning for 1 entry", entry.Message, entry.Data)
+	}
+}
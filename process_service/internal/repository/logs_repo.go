@@ -4,9 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"maps"
 	"time"
 )
 
+var (
+	// ErrWritesOverloaded is returned when too many writes are already in flight
+	ErrWritesOverloaded = errors.New("clickhouse writes overloaded")
+	// ErrClientClosed is returned for writes started after Close
+	ErrClientClosed = errors.New("clickhouse client is closed")
+)
+
 func (c *Client) WriteLog(entry map[string]any) error {
 	return c.postLogsOrMetricsWithRetries(c.ctx, "logs", entry)
 }
@@ -17,24 +26,67 @@ type LogRequest struct {
 }
 
 func (c *Client) postLogsOrMetricsWithRetries(ctx context.Context, table string, data map[string]any) error {
-	query := "INSERT INTO " + table + " (ts, val) VALUES (?, ?)"
-	b, err := json.Marshal(data)
+	c.closeMux.RLock()
+	if c.closed {
+		c.closeMux.RUnlock()
+		return ErrClientClosed
+	}
+	c.inflight.Add(1)
+	c.closeMux.RUnlock()
+	defer c.inflight.Done()
+
+	select {
+	case c.writeSem <- struct{}{}:
+		defer func() { <-c.writeSem }()
+	default:
+		if c.metricsSrv != nil {
+			c.metricsSrv.Recorder.IncClickHouseWritesRejected()
+		}
+		return ErrWritesOverloaded
+	}
+
+	query, row, args, err := c.insertQuery(table, time.Now(), data)
 	if err != nil {
 		return err
 	}
+	ctx = withBodyCheck(c.insertContext(ctx))
+	return c.retry.Do(ctx, func() error {
+		err := c.connFor(table).Exec(ctx, query, args...)
+		if err != nil {
+			c.debugInsert(table, query, row, err)
+		}
+		return err
+	})
+}
+
+// insertQuery builds the insert of data into table, row is the inserted data for debug logs
+func (c *Client) insertQuery(table string, ts time.Time, data map[string]any) (query, row string, args []any, err error) {
+	if table == metricsLongTable {
+		row, err = c.longMetricRows(ts, data)
+		return "INSERT INTO " + table + " FORMAT JSONEachRow\n" + row, row, nil, err
+	}
+	b, err := json.Marshal(c.withInstanceID(data))
+	if err != nil {
+		return "", "", nil, err
+	}
 	req := &LogRequest{
-		TS:  time.Now(),
+		TS:  ts,
 		Val: bytes.NewBuffer(b).String(),
 	}
-	for i := 0; i < c.conf.NumRetries; i++ {
-		if err := c.conn.Exec(ctx, query, req.TS, req.Val); err != nil {
-			if i == c.conf.NumRetries-1 {
-				return err
-			}
-			time.Sleep(500 * time.Millisecond)
-			continue
-		}
-		return nil
+	return "INSERT INTO " + table + " (ts, val) VALUES (?, ?)", req.Val, []any{req.TS, req.Val}, nil
+}
+
+// withInstanceID returns data with the instance_id field, rows which already have one,
+// e.g. replayed ones, keep it. The snapshot is shared with other metrics sinks, so it is copied.
+func (c *Client) withInstanceID(data map[string]any) map[string]any {
+	if c.conf.InstanceID == "" {
+		return data
+	}
+	if _, ok := data["instance_id"]; ok {
+		return data
 	}
-	return nil
+	row := make(map[string]any, len(data)+1)
+	maps.Copy(row, data)
+	row["instance_id"] = c.conf.InstanceID
+	return row
 }
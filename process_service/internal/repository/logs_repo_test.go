@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"process_service/internal/metrics"
+)
+
+func TestWriteLogRejectedPastGate(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	c := testClickHouse(t, &Config{MaxConcurrentWrites: 1}, func(w http.ResponseWriter, _ *http.Request, query string) {
+		if strings.HasPrefix(query, "INSERT") {
+			received <- struct{}{}
+			<-release
+		}
+	})
+	c.metricsSrv = metrics.New(nil)
+	rejected := c.metricsSrv.Recorder.GetClickHouseWritesRejectedTotal()
+
+	slow := make(chan error, 1)
+	go func() { slow <- c.WriteLog(map[string]any{"msg": "slow"}) }()
+	<-received
+
+	start := time.Now()
+	if err := c.WriteLog(map[string]any{"msg": "excess"}); !errors.Is(err, ErrWritesOverloaded) {
+		t.Errorf("WriteLog() past the gate = %v, want %v", err, ErrWritesOverloaded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WriteLog() past the gate took %s, want it rejected without waiting", elapsed)
+	}
+	if got := c.metricsSrv.Recorder.GetClickHouseWritesRejectedTotal() - rejected; got != 1 {
+		t.Errorf("clickhouse_writes_rejected_total grew by %d, want 1", got)
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Errorf("WriteLog() within the gate = %v", err)
+	}
+}
+
+func TestAsyncInsertSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		conf      Config
+		wantAsync string
+		wantWait  string
+	}{
+		{"disabled", Config{}, "", ""},
+		{"without waiting", Config{AsyncInsert: true}, "1", "0"},
+		{"waiting for the flush", Config{AsyncInsert: true, WaitForAsyncInsert: true}, "1", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := make(chan url.Values, 1)
+			conf := tt.conf
+			c := testClickHouse(t, &conf, func(_ http.ResponseWriter, r *http.Request, query string) {
+				if strings.HasPrefix(query, "INSERT") {
+					params <- r.URL.Query()
+				}
+			})
+
+			if err := c.WriteLog(map[string]any{"msg": "x"}); err != nil {
+				t.Fatalf("WriteLog() = %v", err)
+			}
+			got := <-params
+			if got.Get("async_insert") != tt.wantAsync || got.Get("wait_for_async_insert") != tt.wantWait {
+				t.Errorf("insert URL has async_insert=%q wait_for_async_insert=%q, want %q and %q",
+					got.Get("async_insert"), got.Get("wait_for_async_insert"), tt.wantAsync, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestWithInstanceID(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance string
+		data     map[string]any
+		want     any
+	}{
+		{"unset", "", map[string]any{"msg": "x"}, nil},
+		{"added", "pod-1", map[string]any{"msg": "x"}, "pod-1"},
+		{"kept when the row has one", "pod-1", map[string]any{"msg": "x", "instance_id": "other"}, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{conf: &Config{InstanceID: tt.instance}}
+			row := c.withInstanceID(tt.data)
+			if got := row["instance_id"]; got != tt.want {
+				t.Errorf("instance_id = %v, want %v", got, tt.want)
+			}
+			if row["msg"] != "x" {
+				t.Errorf("row = %v, want the original fields", row)
+			}
+		})
+	}
+}
@@ -1,5 +1,72 @@
 package repository
 
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// MetricsFormatWide writes a snapshot as one JSON row into metrics, it is the default
+	MetricsFormatWide = "wide"
+	// MetricsFormatLong writes one row per numeric metric into metrics_long
+	MetricsFormatLong = "long"
+
+	metricsLongTable = "metrics_long"
+	// _chDateTime64 is accepted by DateTime64(9) columns in JSONEachRow
+	_chDateTime64 = "2006-01-02 15:04:05.000000000"
+)
+
+// metricRow is a row of metrics_long
+type metricRow struct {
+	TS         string  `json:"ts"`
+	Name       string  `json:"name"`
+	Value      float64 `json:"value"`
+	InstanceID string  `json:"instance_id"`
+}
+
 func (c *Client) WriteMetrics(metrics map[string]any) error {
+	if c.conf.MetricsFormat == MetricsFormatLong {
+		return c.postLogsOrMetricsWithRetries(c.ctx, metricsLongTable, metrics)
+	}
 	return c.postLogsOrMetricsWithRetries(c.ctx, "metrics", metrics)
 }
+
+// longMetricRows renders the numeric metrics as JSONEachRow lines, the rest is skipped
+func (c *Client) longMetricRows(ts time.Time, metrics map[string]any) (string, error) {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows strings.Builder
+	enc := json.NewEncoder(&rows)
+	at := ts.UTC().Format(_chDateTime64)
+	for _, name := range names {
+		value, ok := metricValue(metrics[name])
+		if !ok {
+			continue
+		}
+		if err := enc.Encode(metricRow{TS: at, Name: name, Value: value, InstanceID: c.conf.InstanceID}); err != nil {
+			return "", err
+		}
+	}
+	return rows.String(), nil
+}
+
+func metricValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case uint64:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsFormats(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	snapshot := map[string]any{
+		"submitted_tasks_total": uint64(3),
+		"mem_used_bytes":        1.5,
+		"started_at":            "2026-01-02T00:00:00Z",
+	}
+	tests := []struct {
+		name      string
+		format    string
+		table     string
+		wantQuery string
+		wantRow   string
+	}{
+		{
+			name:      "wide",
+			format:    MetricsFormatWide,
+			table:     "metrics",
+			wantQuery: "INSERT INTO metrics (ts, val) VALUES (?, ?)",
+			wantRow:   `{"instance_id":"pod-1","mem_used_bytes":1.5,"started_at":"2026-01-02T00:00:00Z","submitted_tasks_total":3}`,
+		},
+		{
+			name:   "long",
+			format: MetricsFormatLong,
+			table:  metricsLongTable,
+			// one row per numeric metric, started_at is not a number and is skipped
+			wantRow: `{"ts":"2026-01-02 03:04:05.000000006","name":"mem_used_bytes","value":1.5,"instance_id":"pod-1"}` + "\n" +
+				`{"ts":"2026-01-02 03:04:05.000000006","name":"submitted_tasks_total","value":3,"instance_id":"pod-1"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{conf: &Config{MetricsFormat: tt.format, InstanceID: "pod-1"}}
+			query, row, _, err := c.insertQuery(tt.table, ts, snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if row != tt.wantRow {
+				t.Errorf("row = %s, want %s", row, tt.wantRow)
+			}
+			if tt.wantQuery == "" {
+				tt.wantQuery = "INSERT INTO " + tt.table + " FORMAT JSONEachRow\n" + tt.wantRow
+			}
+			if query != tt.wantQuery {
+				t.Errorf("query = %q, want %q", query, tt.wantQuery)
+			}
+		})
+	}
+}
+
+func TestWriteMetricsFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		wantTable string
+	}{
+		{"default", "", "metrics"},
+		{"wide", MetricsFormatWide, "metrics"},
+		{"long", MetricsFormatLong, metricsLongTable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mux sync.Mutex
+			var inserts []string
+			c := testClickHouse(t, &Config{MetricsFormat: tt.format}, func(_ http.ResponseWriter, _ *http.Request, query string) {
+				if strings.HasPrefix(query, "INSERT") {
+					mux.Lock()
+					defer mux.Unlock()
+					inserts = append(inserts, query)
+				}
+			})
+			if err := c.WriteMetrics(map[string]any{"submitted_tasks_total": uint64(1)}); err != nil {
+				t.Fatalf("WriteMetrics() = %v", err)
+			}
+			if len(inserts) != 1 || !strings.HasPrefix(inserts[0], "INSERT INTO "+tt.wantTable+" ") {
+				t.Errorf("inserts = %q, want one into %s", inserts, tt.wantTable)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const _maxNotProcessedSweepInterval = time.Minute
+
+// runNotProcessedSweeper drops in-memory not processed tasks older than the TTL until
+// ctx is done, it does nothing without a TTL
+func (s *Service) runNotProcessedSweeper(ctx context.Context) {
+	if s.notProcessedTTL <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(s.notProcessedTTL/2, _maxNotProcessedSweepInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			swept := s.sweepNotProcessed(s.now().Add(-s.notProcessedTTL))
+			if swept == 0 {
+				continue
+			}
+			if s.metricsSrv != nil {
+				s.metricsSrv.Recorder.AddNotProcessedSwept(swept)
+			}
+			log.WithField("swept", swept).Debug("dropped expired not processed tasks from memory")
+		}
+	}
+}
+
+// sweepNotProcessed removes the entries added before deadline and returns how many
+func (s *Service) sweepNotProcessed(deadline time.Time) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	swept := 0
+	for id, t := range s.storage {
+		if t.addedAt.Before(deadline) {
+			delete(s.storage, id)
+			swept++
+		}
+	}
+	return swept
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSweepNotProcessed(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	const ttl = time.Minute
+	s := &Service{
+		// a closed client drops the ClickHouse writes, only the in-memory entries are tested
+		Client:          &Client{closed: true},
+		mux:             &sync.Mutex{},
+		storage:         make(map[string]NotProcessedTask),
+		notProcessedTTL: ttl,
+		now:             func() time.Time { return now },
+	}
+	sweep := func() int { return s.sweepNotProcessed(s.now().Add(-s.notProcessedTTL)) }
+
+	s.AddNotProcessed("old", "timeout", now)
+	now = now.Add(40 * time.Second)
+	// the TTL counts from when the entry was added, not from when the task failed
+	s.AddNotProcessed("new", "timeout", now.Add(-time.Hour))
+	if swept := sweep(); swept != 0 {
+		t.Fatalf("swept %d entries before the TTL, want 0", swept)
+	}
+
+	now = now.Add(30 * time.Second)
+	if swept := sweep(); swept != 1 {
+		t.Fatalf("swept %d entries after the TTL of one, want 1", swept)
+	}
+	if got := s.GetLocalNotProcessedTasks(); !slices.Equal(got, []string{"new"}) {
+		t.Fatalf("kept %v, want [new]", got)
+	}
+
+	now = now.Add(31 * time.Second)
+	if swept := sweep(); swept != 1 {
+		t.Fatalf("swept %d entries after the TTL of the last one, want 1", swept)
+	}
+	if got := s.GetLocalNotProcessedTasks(); len(got) != 0 {
+		t.Errorf("kept %v, want none", got)
+	}
+}
+
+func TestNotProcessedSweeperWithoutTTL(t *testing.T) {
+	s := &Service{mux: &sync.Mutex{}, storage: make(map[string]NotProcessedTask), now: time.Now}
+	done := make(chan struct{})
+	go func() {
+		s.runNotProcessedSweeper(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the sweeper runs without a TTL")
+	}
+}
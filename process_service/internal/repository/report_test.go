@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"process_service/internal/metrics"
+)
+
+func TestReportErrCountsDroppedErrors(t *testing.T) {
+	m := metrics.New(nil)
+	s := &Service{Client: &Client{ctx: context.Background()}, metricsSrv: m, ErrCh: make(chan error, 1)}
+
+	first := errors.New("metrics write failed")
+	s.reportErr(first)
+	s.reportErr(errors.New("metrics write failed again"))
+
+	if got := <-s.ErrCh; got != first {
+		t.Errorf("ErrCh got %v, want the first error", got)
+	}
+	if got := m.Recorder.GetDroppedErrorsTotal(); got != 1 {
+		t.Errorf("dropped errors = %d, want 1", got)
+	}
+}
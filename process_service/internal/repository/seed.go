@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// seedEntry is a JSONL line of a previous run's logs, only lines with the logger's taskId field are used
+type seedEntry struct {
+	TaskID string    `json:"taskId"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+	Time   time.Time `json:"time"`
+}
+
+// seedNotProcessed loads not processed tasks of a previous run from a JSONL file,
+// so counts reflect prior state when ClickHouse was not the sink. A missing file
+// is not an error, garbled lines and lines without a task id are skipped.
+func (s *Service) seedNotProcessed(path string) {
+	logger := log.WithField("file", path)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Info("no not processed tasks to seed, file does not exist")
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Warn("failed to open not processed seed file")
+		return
+	}
+	defer f.Close()
+
+	var loaded, skipped int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	s.mux.Lock()
+	for scanner.Scan() {
+		var entry seedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			skipped++
+			continue
+		}
+		if entry.TaskID == "" {
+			skipped++
+			continue
+		}
+		at := entry.At
+		if at.IsZero() {
+			at = entry.Time
+		}
+		s.storage[entry.TaskID] = NotProcessedTask{TaskID: entry.TaskID, Reason: entry.Reason, At: at, addedAt: s.now()}
+		loaded++
+	}
+	s.mux.Unlock()
+
+	if err := scanner.Err(); err != nil {
+		logger.WithError(err).Warn("failed to read not processed seed file to the end")
+	}
+	logger.WithFields(log.Fields{"loaded": loaded, "skipped": skipped}).Info("seeded not processed tasks")
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSeedNotProcessed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not_processed.jsonl")
+	lines := `{"level":"warning","msg":"task failed","taskId":"a","reason":"timeout","time":"2026-01-02T03:04:05Z"}
+not json
+{"level":"info","msg":"worker started","workerId":1}
+{"taskId":"b","reason":"error","at":"2026-01-02T03:04:06Z","time":"2026-01-02T03:04:07Z"}
+`
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	s := &Service{mux: &sync.Mutex{}, storage: make(map[string]NotProcessedTask), now: func() time.Time { return now }}
+
+	s.seedNotProcessed(path)
+
+	if len(s.storage) != 2 {
+		t.Fatalf("seeded %d tasks, want 2: %v", len(s.storage), s.storage)
+	}
+	if got := s.storage["a"]; got.Reason != "timeout" || !got.At.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("task a = %+v, want reason timeout at the log time", got)
+	}
+	if got := s.storage["b"]; !got.At.Equal(time.Date(2026, 1, 2, 3, 4, 6, 0, time.UTC)) {
+		t.Errorf("task b at %v, want the at field over the log time", got.At)
+	}
+}
+
+func TestSeedNotProcessedMissingFile(t *testing.T) {
+	s := &Service{mux: &sync.Mutex{}, storage: make(map[string]NotProcessedTask), now: time.Now}
+	s.seedNotProcessed(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if len(s.storage) != 0 {
+		t.Errorf("seeded %d tasks from a missing file", len(s.storage))
+	}
+}
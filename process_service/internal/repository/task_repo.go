@@ -22,11 +22,11 @@ func NewTaskRepository(s *Service) *TaskRepository {
 }
 
 type TaskDTO struct {
-	ID      string            `json:"id"`
-	Status  domain.TaskStatus `json:"status"`
-	Payload *string           `json:"payload,omitempty"`
-	FailedPayload *string     `json:"failed_payload,omitempty"`
-	Ts      time.Time         `json:"ts"`
+	ID            string            `json:"id"`
+	Status        domain.TaskStatus `json:"status"`
+	Payload       *string           `json:"payload,omitempty"`
+	FailedPayload *string           `json:"failed_payload,omitempty"`
+	Ts            time.Time         `json:"ts"`
 }
 
 func (r *TaskRepository) GetAllNotProcessedTasks() ([]TaskDTO, error) {
@@ -83,15 +83,51 @@ func (r *TaskRepository) InsertTask(task *domain.Task) error {
 	if r.s == nil {
 		return nil
 	}
-	query := "INSERT INTO tasks (id, status, payload, failed_payload, ts) VALUES ($1, $2, $3, $4, $5)"
+	query := "INSERT INTO tasks (id, status, payload, failed_payload, tags, ts) VALUES ($1, $2, $3, $4, $5, $6)"
 	now := time.Now().Format(timeFormat)
-	if err := r.s.Client.conn.Exec(r.s.Client.ctx, query, task.ID, task.Status, task.Payload, task.FailedPayload, now); err != nil {
+	if err := r.s.Client.conn.Exec(r.s.Client.ctx, query, task.ID, task.Status, task.Payload, task.FailedPayload, tagsOrEmpty(task.Tags), now); err != nil {
 		r.s.logger.WithError(err).Errorf("Failed to insert task %s with status %s", task.ID, task.Status)
 		return err
 	}
 	return nil
 }
 
+// RecordAttempt appends the attempt to task_attempts, ordered by task and start time.
+func (r *TaskRepository) RecordAttempt(ctx context.Context, taskID uuid.UUID, attempt domain.AttemptRecord) error {
+	if r.s == nil {
+		return nil
+	}
+	query := "INSERT INTO task_attempts (task_id, ts, duration_ms, error) VALUES ($1, $2, $3, $4)"
+	ctx = r.s.Client.insertContext(ctx)
+	if err := r.s.Client.conn.Exec(ctx, query, taskID, attempt.At, uint64(attempt.Duration.Milliseconds()), attempt.Error); err != nil {
+		r.s.logger.WithError(err).Errorf("Failed to record attempt of task %s", taskID)
+		return err
+	}
+	return nil
+}
+
+// RecordCompletion appends when the task finished successfully to task_completions.
+func (r *TaskRepository) RecordCompletion(ctx context.Context, taskID uuid.UUID, at time.Time) error {
+	if r.s == nil {
+		return nil
+	}
+	query := "INSERT INTO task_completions (task_id, completed_at) VALUES ($1, $2)"
+	ctx = r.s.Client.insertContext(ctx)
+	if err := r.s.Client.conn.Exec(ctx, query, taskID, at); err != nil {
+		r.s.logger.WithError(err).Errorf("Failed to record completion of task %s", taskID)
+		return err
+	}
+	return nil
+}
+
+// tagsOrEmpty avoids inserting NULL into the non nullable tags column
+func tagsOrEmpty(tags map[string]string) map[string]string {
+	if tags == nil {
+		return map[string]string{}
+	}
+	return tags
+}
+
 func (r *TaskRepository) GetTaskByID(taskID uuid.UUID) *TaskDTO {
 	// Здесь будет логика получения задачи по ID из базы данных
 	return nil
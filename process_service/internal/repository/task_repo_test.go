@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"testing"
+
+	ch "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/google/uuid"
+
+	"process_service/internal/domain"
+)
+
+// recordingConn records the args of every Exec, the other methods are not used by the tests
+type recordingConn struct {
+	ch.Conn
+	mu    sync.Mutex
+	execs [][]any
+}
+
+func (c *recordingConn) Exec(_ context.Context, _ string, args ...any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.execs = append(c.execs, args)
+	return nil
+}
+
+// args returns the args of the last Exec
+func (c *recordingConn) args() []any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.execs[len(c.execs)-1]
+}
+
+func TestInsertTaskTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want map[string]string
+	}{
+		{"tags are written", map[string]string{"customer": "acme", "job": "nightly"}, map[string]string{"customer": "acme", "job": "nightly"}},
+		// the column is not nullable
+		{"no tags", nil, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordingConn{}
+			repo := NewTaskRepository(&Service{Client: &Client{ctx: context.Background(), conn: conn}})
+			payload := "x"
+			if err := repo.InsertTask(&domain.Task{ID: uuid.New(), Status: domain.StatusProcessing, Payload: &payload, Tags: tt.tags}); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := conn.args()[4].(map[string]string)
+			if !ok || got == nil || !maps.Equal(got, tt.want) {
+				t.Errorf("tags written = %#v, want %#v", conn.args()[4], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Package retry holds the retry policy shared by the subsystems which retry failed calls.
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy is how often and how fast a failed call is retried. Every subsystem has its own.
+type Policy struct {
+	// MaxAttempts counts the first call, below 1 means a single call without retries
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// BaseDelay is the wait after the first failure, it doubles after every further one up to MaxDelay
+	BaseDelay time.Duration `mapstructure:"base_delay"`
+	MaxDelay  time.Duration `mapstructure:"max_delay"`
+	// Jitter spreads every wait by up to that fraction of it, e.g. 0.2 for +-20%
+	Jitter float64 `mapstructure:"jitter"`
+}
+
+// Attempts returns the number of calls the policy allows, at least one.
+func (p Policy) Attempts() int {
+	return max(p.MaxAttempts, 1)
+}
+
+// Delay returns the wait after the failed attempt, counting from 1, before jitter is applied.
+func (p Policy) Delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d > 0; i++ {
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+		if d > time.Duration(1<<62) {
+			break // doubling again overflows
+		}
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// jittered spreads d by up to Jitter of it in both directions
+func (p Policy) jittered(d time.Duration) time.Duration {
+	if p.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+// Do calls fn until it succeeds or the attempts are used up and returns the last error.
+// It gives up with ctx.Err() when ctx is done while waiting for the next attempt.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= p.Attempts(); attempt++ {
+		if err = fn(); err == nil || attempt == p.Attempts() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.jittered(p.Delay(attempt))):
+		}
+	}
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 	"time"
 
@@ -34,32 +35,64 @@ func main() {
 	container.Provide(ProvideRepository)
 	container.Provide(PovideTaskService)
 	container.Provide(ProvideMetrics)
+	container.Provide(ProvideExternalAPI)
 	container.Provide(ProvideDaemon)
 
 	// the dependencies will stop in the order they were registered in the stoppables group
 	// should stop them in this order to ensure no data loss:
 	// daemon.Daemon: Finish processing the tasks already in the internal queue.
+	// repository.Service: Let the last ClickHouse writes finish.
 	// metrics.Service: Stop the metrics server only after everything else is done.
 	container.Provide(func(d *daemon.Daemon) Stoppable { return d }, dig.Group("stoppables"))
+	container.Provide(func(repo *repository.Service) Stoppable { return repo }, dig.Group("stoppables"))
 	container.Provide(func(m *metrics.Service) Stoppable { return m }, dig.Group("stoppables"))
 
 	if err := container.Invoke(func(ctx context.Context, args RunArgs) {
-		defer stop(ctx, args.Stop)
+		// ctx may be cancelled already, which must not cut the stop sequence short
+		defer stop(context.WithoutCancel(ctx), args.Stop)
 
 		args.Repo.Start()
-		args.D.Start(ctx, extapi.New())
+		args.M.API.SetLivenessCheck(args.D.CheckWorkers)
+		args.M.API.AddHealthCheck("daemon", args.D.Health)
+		args.M.API.AddHealthCheck("clickhouse", metrics.CheckHealth(args.Repo.Client.Ping))
+		args.M.API.Handle(daemon.ResultsPath, args.D.ResultHandler())
+		if err := args.M.Recorder.Register(extapi.NewHostCollector(hostStats(args.D))); err != nil {
+			log.WithError(err).Error("failed to register external API host metrics")
+		}
+		if err := args.M.Recorder.AddGaugeFunc("task", "drain_rate_per_second", "The estimated number of tasks completed per second.", args.D.DrainRate.Rate); err != nil {
+			log.WithError(err).Error("failed to register the drain rate metric")
+		}
+		if err := args.M.Recorder.ExportQueueWait(); err != nil {
+			log.WithError(err).Error("failed to register the queue wait metric")
+		}
+		args.D.Start(ctx)
+		if config.RemoteConfigured() {
+			go config.WatchRemote(ctx, config.DefaultRemoteWatchInterval, hotReload(args.Conf, args.D))
+		}
 
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		dumpCh := make(chan os.Signal, 1)
+		signal.Notify(dumpCh, syscall.SIGUSR1)
 
 		for {
 			select {
 			case <-sigCh:
 				log.Info("Received shutdown signal, exiting...")
 				return
+			case <-dumpCh:
+				if err := dumpState(args.Conf.DumpDir, args.Repo, args.D, time.Now()); err != nil {
+					log.WithError(err).Error("failed to dump not processed tasks")
+				}
 			case err := <-args.Repo.ErrCh:
 				log.Errorf("repository error: %v", err)
 				return
+			case <-args.D.LimitReached():
+				log.Info("Processed max tasks, exiting...")
+				return
+			case <-args.D.Done():
+				log.Info("Daemon stopped, exiting...")
+				return
 			}
 		}
 	}); err != nil {
@@ -70,9 +103,10 @@ func main() {
 
 type RunArgs struct {
 	dig.In
-	Repo   *repository.Service
+	Repo *repository.Service
 	D    *daemon.Daemon
 	M    *metrics.Service
+	Conf *config.AppConfig
 	Stop StopArgs
 }
 
@@ -100,6 +134,31 @@ func stop(ctx context.Context, args StopArgs) {
 	}
 }
 
+// hotReload applies the fields of a re-read config which can change at runtime,
+// currently the external API settings. The applied settings belong to the watcher,
+// the config shared with the rest of the service is not written.
+func hotReload(current *config.AppConfig, d *daemon.Daemon) func(*config.AppConfig) {
+	applied := current.ExtAPI
+	return func(conf *config.AppConfig) {
+		if reflect.DeepEqual(conf.ExtAPI, applied) {
+			return
+		}
+		log.WithField("extapi", conf.ExtAPI).Info("external API config changed, reloading")
+		d.SetAPICaller(extapi.NewFromConfig(conf.ExtAPI))
+		applied = conf.ExtAPI
+	}
+}
+
+// hostStats reads the host counters of the daemon's current external API client
+func hostStats(d *daemon.Daemon) func() []extapi.HostStats {
+	return func() []extapi.HostStats {
+		if client, ok := d.APICaller().(*extapi.Client); ok {
+			return client.HostStats()
+		}
+		return nil
+	}
+}
+
 func ProvideBaseContext() context.Context {
 	return context.Background()
 }
@@ -109,6 +168,7 @@ func ProvideConfig() *config.AppConfig {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	log.WithFields(conf.Summary()).Info("Config loaded")
 	return conf
 }
 
@@ -120,11 +180,13 @@ func ProvideRepository(ctx context.Context, conf *config.AppConfig, m *metrics.S
 	return repository.NewService(ctx, conf.RepoConf, m, errCh)
 }
 
-func ProvideLogger(ch *repository.Service) *log.Logger {
+func ProvideLogger(conf *config.AppConfig, ch *repository.Service) *log.Logger {
 	logger := log.New()
 	logger.SetLevel(log.DebugLevel)
-	hook := repository.NewLogHook(ch.Client)
-	logger.AddHook(hook)
+	if conf.RepoConf.ShipLogs() {
+		hook := repository.NewLogHook(ch.Client)
+		logger.AddHook(hook)
+	}
 	return logger
 }
 
@@ -139,6 +201,10 @@ func PovideTaskService(repo *repository.Service) *repository.TaskRepository {
 	return repository.NewTaskRepository(repo)
 }
 
-func ProvideDaemon(ctx context.Context, conf *config.AppConfig, m *metrics.Service, repo *repository.Service, taskRepo *repository.TaskRepository, logger *log.Logger) *daemon.Daemon {
-	return daemon.New(ctx, conf.RedisConf, conf.MinIOConf, numWorkers, queueSize, m, repo, taskRepo, logger)
+func ProvideExternalAPI(conf *config.AppConfig) daemon.ExternalAPICaller {
+	return extapi.NewFromConfig(conf.ExtAPI)
+}
+
+func ProvideDaemon(ctx context.Context, conf *config.AppConfig, m *metrics.Service, repo *repository.Service, taskRepo *repository.TaskRepository, apiCaller daemon.ExternalAPICaller, logger *log.Logger) *daemon.Daemon {
+	return daemon.New(ctx, conf.Daemon, conf.RedisConf, conf.MinIOConf, numWorkers, queueSize, m, repo, taskRepo, apiCaller, logger)
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"process_service/internal/config"
+	"process_service/internal/repository"
+)
+
+func TestProvideLoggerLogHook(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name      string
+		enabled   *bool
+		wantHooks int
+	}{
+		{"unset", nil, 1},
+		{"enabled", &enabled, 1},
+		// no hook, so nothing is written to ClickHouse
+		{"disabled", &disabled, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &config.AppConfig{RepoConf: &repository.Config{LogHookEnabled: tt.enabled}}
+			logger := ProvideLogger(conf, &repository.Service{})
+			if got := len(logger.Hooks[log.InfoLevel]); got != tt.wantHooks {
+				t.Errorf("%d hooks, want %d", got, tt.wantHooks)
+			}
+		})
+	}
+}
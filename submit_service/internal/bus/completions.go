@@ -0,0 +1,36 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// completedKey is the list of the latest completions the process service keeps, newest first
+const completedKey = "tasks:completed"
+
+// Completion is when a task finished successfully
+type Completion struct {
+	TaskID      uuid.UUID `json:"task_id"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// RecentCompletions returns up to n latest completions, newest first. The list is
+// empty unless the process service runs with recent_completions.
+func (p *Producer) RecentCompletions(ctx context.Context, n int) ([]Completion, error) {
+	raw, err := p.redisClient.LRange(ctx, completedKey, 0, int64(n)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	completions := make([]Completion, 0, len(raw))
+	for _, entry := range raw {
+		var c Completion
+		if err := json.Unmarshal([]byte(entry), &c); err != nil {
+			continue
+		}
+		completions = append(completions, c)
+	}
+	return completions, nil
+}
@@ -0,0 +1,46 @@
+package bus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRecentCompletions(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	rdb.Del(ctx, completedKey)
+	t.Cleanup(func() { rdb.Del(ctx, completedKey) })
+
+	older := Completion{TaskID: uuid.New(), CompletedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	newer := Completion{TaskID: uuid.New(), CompletedAt: older.CompletedAt.Add(time.Second)}
+	// the process service pushes to the head, so the newest comes first
+	for _, entry := range []string{
+		`{"task_id":"` + older.TaskID.String() + `","completed_at":"2026-01-02T03:04:05Z"}`,
+		`not json`,
+		`{"task_id":"` + newer.TaskID.String() + `","completed_at":"2026-01-02T03:04:06Z"}`,
+	} {
+		rdb.LPush(ctx, completedKey, entry)
+	}
+
+	p := NewProducer(rdb, nil, 1)
+	got, err := p.RecentCompletions(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Completion{newer, older}
+	if len(got) != len(want) {
+		t.Fatalf("RecentCompletions() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].TaskID != want[i].TaskID || !got[i].CompletedAt.Equal(want[i].CompletedAt) {
+			t.Errorf("completion %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if got, err := p.RecentCompletions(ctx, 1); err != nil || len(got) != 1 || got[0].TaskID != newer.TaskID {
+		t.Errorf("RecentCompletions(1) = %+v, %v, want the newest only", got, err)
+	}
+}
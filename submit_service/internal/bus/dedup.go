@@ -0,0 +1,49 @@
+package bus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// payloadDedupKeyPrefix keys map a payload hash to the first task submitted with it
+const payloadDedupKeyPrefix = "tasks:dedup:"
+
+// PayloadHash identifies a submission by its payload and attached file.
+func PayloadHash(payload string, file []byte) string {
+	h := sha256.New()
+	h.Write([]byte(payload))
+	h.Write([]byte{0})
+	h.Write(file)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ClaimPayload records taskID as the owner of the payload hash for window. It
+// returns the id of the task which already claimed the hash and false for duplicates.
+func (p *Producer) ClaimPayload(ctx context.Context, hash string, taskID uuid.UUID, window time.Duration) (uuid.UUID, bool, error) {
+	key := payloadDedupKeyPrefix + hash
+	ok, err := p.redisClient.SetNX(ctx, key, taskID.String(), window).Result()
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	if ok {
+		return taskID, true, nil
+	}
+	owner, err := p.redisClient.Get(ctx, key).Result()
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	ownerID, err := uuid.Parse(owner)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	return ownerID, false, nil
+}
+
+// ReleasePayload forgets the hash, so a submit which failed can be retried right away.
+func (p *Producer) ReleasePayload(ctx context.Context, hash string) error {
+	return p.redisClient.Del(ctx, payloadDedupKeyPrefix+hash).Err()
+}
@@ -0,0 +1,91 @@
+package bus
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Peek returns the ids of the next n tasks the workers will read, oldest first,
+// without delivering them to the consumer group.
+func (p *Producer) Peek(ctx context.Context, n int) ([]uuid.UUID, error) {
+	var entries []redis.XMessage
+	for shard := 0; shard < max(p.shards, 1); shard++ {
+		stream := StreamName(shard, p.shards)
+		start, err := p.undeliveredStart(ctx, stream)
+		if err != nil {
+			return nil, err
+		}
+		shardEntries, err := p.redisClient.XRangeN(ctx, stream, start, "+", int64(n)).Result()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, shardEntries...)
+	}
+
+	// shards are merged by entry id, which orders them by the time they were added
+	slices.SortStableFunc(entries, func(a, b redis.XMessage) int {
+		return compareStreamIDs(a.ID, b.ID)
+	})
+	ids := make([]uuid.UUID, 0, min(n, len(entries)))
+	for _, entry := range entries {
+		if len(ids) == n {
+			break
+		}
+		raw, _ := entry.Values["id"].(string)
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// undeliveredStart is the XRANGE start after the last entry delivered to the task group,
+// a stream without the group has not been read at all
+func (p *Producer) undeliveredStart(ctx context.Context, stream string) (string, error) {
+	groups, err := p.redisClient.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return "-", nil
+		}
+		return "", err
+	}
+	for _, g := range groups {
+		if g.Name == taskGroupName && g.LastDeliveredID != "" && g.LastDeliveredID != "0-0" {
+			return "(" + g.LastDeliveredID, nil
+		}
+	}
+	return "-", nil
+}
+
+// compareStreamIDs orders entry ids like "1700000000000-1" numerically
+func compareStreamIDs(a, b string) int {
+	aMs, aSeq := splitStreamID(a)
+	bMs, bSeq := splitStreamID(b)
+	if aMs != bMs {
+		if aMs < bMs {
+			return -1
+		}
+		return 1
+	}
+	if aSeq != bSeq {
+		if aSeq < bSeq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func splitStreamID(id string) (ms, seq uint64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(msPart, 10, 64)
+	seq, _ = strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq
+}
@@ -0,0 +1,69 @@
+package bus
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"submit_service/internal/domain"
+)
+
+func TestCompareStreamIDs(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1700000000000-0", "1700000000000-0", 0},
+		{"1700000000000-0", "1700000000001-0", -1},
+		{"1700000000000-2", "1700000000000-10", -1},
+		{"1700000000000-10", "1700000000000-2", 1},
+		{"999-5", "1000-0", -1},
+	}
+	for _, tt := range tests {
+		if got := compareStreamIDs(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareStreamIDs(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPeekDoesNotConsume(t *testing.T) {
+	ctx := context.Background()
+	rdb := testRedis(t)
+	p := NewProducer(rdb, nil, 1)
+	var produced []uuid.UUID
+	for range 3 {
+		task := &domain.Task{ID: uuid.New(), Status: domain.StatusPending}
+		if err := p.ProduceTask(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+		produced = append(produced, task.ID)
+	}
+	t.Cleanup(func() {
+		entries, _ := rdb.XRange(ctx, StreamName(0, 1), "-", "+").Result()
+		for _, entry := range entries {
+			raw, _ := entry.Values["id"].(string)
+			if id, err := uuid.Parse(raw); err == nil && slices.Contains(produced, id) {
+				rdb.XDel(ctx, StreamName(0, 1), entry.ID)
+			}
+		}
+	})
+
+	peeked := func() []uuid.UUID {
+		t.Helper()
+		ids, err := p.Peek(ctx, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// other tasks may be queued in a shared redis, keep the ones produced here
+		return slices.DeleteFunc(ids, func(id uuid.UUID) bool { return !slices.Contains(produced, id) })
+	}
+	first := peeked()
+	if !slices.Equal(first, produced) {
+		t.Fatalf("Peek() = %v, want %v in the order produced", first, produced)
+	}
+	if second := peeked(); !slices.Equal(second, first) {
+		t.Errorf("second Peek() = %v, want %v", second, first)
+	}
+}
@@ -0,0 +1,87 @@
+package bus
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// taskGroupName is the consumer group of the process service workers
+const taskGroupName = "task_group"
+
+// QueueStats describes the tasks the workers have not acknowledged yet.
+type QueueStats struct {
+	// Depth counts the tasks not delivered to the consumer group plus the ones being processed
+	Depth int64
+	// OldestEnqueuedAt is when the oldest of them was added, zero for an empty queue
+	OldestEnqueuedAt time.Time
+}
+
+// QueueStats reads depth and age of the queue. Streams the process service has
+// not created yet count as empty.
+func (p *Producer) QueueStats(ctx context.Context) (QueueStats, error) {
+	var stats QueueStats
+	for shard := 0; shard < max(p.shards, 1); shard++ {
+		stream := StreamName(shard, p.shards)
+		groups, err := p.redisClient.XInfoGroups(ctx, stream).Result()
+		if err != nil {
+			if strings.Contains(err.Error(), "no such key") {
+				continue
+			}
+			return QueueStats{}, err
+		}
+		for _, g := range groups {
+			if g.Name != taskGroupName {
+				continue
+			}
+			stats.Depth += g.Pending
+			// lag is unknown after deletions from the stream, pending still gives a lower bound
+			if g.Lag > 0 {
+				stats.Depth += g.Lag
+			}
+			oldest, err := p.oldestUnacked(ctx, stream, g)
+			if err != nil {
+				return QueueStats{}, err
+			}
+			if !oldest.IsZero() && (stats.OldestEnqueuedAt.IsZero() || oldest.Before(stats.OldestEnqueuedAt)) {
+				stats.OldestEnqueuedAt = oldest
+			}
+		}
+	}
+	return stats, nil
+}
+
+// oldestUnacked returns the enqueue time of the oldest pending or undelivered entry,
+// it is read from the entry id, which XADD generates from the current time
+func (p *Producer) oldestUnacked(ctx context.Context, stream string, group redis.XInfoGroup) (time.Time, error) {
+	if group.Pending > 0 {
+		pending, err := p.redisClient.XPending(ctx, stream, group.Name).Result()
+		if err != nil {
+			return time.Time{}, err
+		}
+		// pending entries were delivered before any undelivered one was added
+		return streamIDTime(pending.Lower), nil
+	}
+	start := "-"
+	if group.LastDeliveredID != "" && group.LastDeliveredID != "0-0" {
+		start = "(" + group.LastDeliveredID
+	}
+	entries, err := p.redisClient.XRangeN(ctx, stream, start, "+", 1).Result()
+	if err != nil || len(entries) == 0 {
+		return time.Time{}, err
+	}
+	return streamIDTime(entries[0].ID), nil
+}
+
+// streamIDTime parses the milliseconds part of an entry id like "1700000000000-0"
+func streamIDTime(id string) time.Time {
+	ms, _, _ := strings.Cut(id, "-")
+	v, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(v)
+}
@@ -2,43 +2,205 @@ package bus
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"submit_service/internal/chaos"
 	"submit_service/internal/domain"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	// taskStateKeyPrefix keys hold the queued task state shared with the process service workers
+	taskStateKeyPrefix = "tasks:state:"
+	taskStateCancelled = "cancelled"
+	taskStateDone      = "done"
+	taskStateFailed    = "failed"
+	taskStateTTL       = 24 * time.Hour
+	// workersAliveKey is refreshed by the process service while it has running workers
+	workersAliveKey = "tasks:workers:alive"
+)
+
+var (
+	// ErrNoWorkers is returned by WorkersAlive when no process service reported running workers
+	ErrNoWorkers = errors.New("no workers are running")
+	// ErrQueueClosed is returned by ProduceTask after Stop, the service is stopping
+	ErrQueueClosed = errors.New("task queue is closed")
+	// ErrQueueFull is returned by ProduceTask when redis rejects the task for lack of memory
+	ErrQueueFull = errors.New("task queue is full")
+)
+
 type Config struct {
 	RedisAddr string `mapstructure:"redis_addr"`
+	// Shards splits the task queue into that many streams to reduce contention, must match the process service
+	Shards int `mapstructure:"shards"`
+	// ScheduleInterval is how often delayed tasks which are due get queued, 0 means DefaultScheduleInterval
+	ScheduleInterval time.Duration `mapstructure:"schedule_interval"`
 }
 
 type Producer struct {
 	redisClient *redis.Client
+	chaos       *chaos.State
+	shards      int
+	closed      atomic.Bool
+	// stopCh and scheduler let Stop wait for the scheduler before closing the client
+	stopCh    chan struct{}
+	scheduler sync.WaitGroup
 }
 
-func NewProducer(redisClient *redis.Client) *Producer {
-	return &Producer{redisClient: redisClient}
+func NewProducer(redisClient *redis.Client, chaosState *chaos.State, shards int) *Producer {
+	return &Producer{redisClient: redisClient, chaos: chaosState, shards: shards, stopCh: make(chan struct{})}
 }
 
 func (p *Producer) ProduceTask(ctx context.Context, task *domain.Task) error {
+	if p.closed.Load() {
+		return ErrQueueClosed
+	}
+	if delay := p.chaos.QueueDelay(); delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if p.chaos.ShouldFail() {
+		return chaos.ErrInjected
+	}
+
 	payload := ""
 	if task.Payload != nil {
 		payload = *task.Payload
 	}
 
+	task.EnqueuedAt = time.Now()
+	values := map[string]interface{}{
+		"id":          task.ID.String(),
+		"status":      string(task.Status),
+		"payload":     payload,
+		"enqueued_at": task.EnqueuedAt.UnixNano(),
+	}
+	if task.Timeout > 0 {
+		values["timeout"] = task.Timeout.String()
+	}
+	if task.StreamLogs {
+		values["stream_logs"] = "1"
+	}
+	if task.Warmup {
+		values["warmup"] = "1"
+	}
+	if len(task.DependsOn) > 0 {
+		ids := make([]string, len(task.DependsOn))
+		for i, id := range task.DependsOn {
+			ids[i] = id.String()
+		}
+		values["depends_on"] = strings.Join(ids, ",")
+	}
+	if len(task.File) > 0 {
+		values["file"] = task.File
+	}
+	if len(task.Tags) > 0 {
+		tags, err := json.Marshal(task.Tags)
+		if err != nil {
+			return err
+		}
+		values["tags"] = string(tags)
+	}
+
+	stream := StreamName(ShardFor(task.ID, p.shards), p.shards)
+	if task.RunAt.After(task.EnqueuedAt) {
+		return p.schedule(ctx, stream, values, task.RunAt)
+	}
 	if err := p.redisClient.XAdd(ctx, &redis.XAddArgs{
-		Stream: "tasks",
-		Values: map[string]interface{}{
-			"id":      task.ID.String(),
-			"status":  string(task.Status),
-			"payload": payload,
-		},
+		Stream: stream,
+		Values: values,
 	}).Err(); err != nil {
+		return produceErr(err)
+	}
+	return nil
+}
+
+// produceErr maps redis errors meaning stopping or full to ErrQueueClosed and ErrQueueFull
+func produceErr(err error) error {
+	switch {
+	case errors.Is(err, redis.ErrClosed):
+		return ErrQueueClosed
+	case strings.HasPrefix(err.Error(), "OOM "):
+		return fmt.Errorf("%w: %v", ErrQueueFull, err)
+	default:
 		return err
 	}
+}
+
+// Stop rejects further tasks with ErrQueueClosed and closes the redis client,
+// it is stopped after the web API so submits in flight still get through.
+// Delayed tasks not due yet stay scheduled in redis for the next start.
+func (p *Producer) Stop(_ context.Context) error {
+	if !p.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(p.stopCh)
+	p.scheduler.Wait()
+	return p.redisClient.Close()
+}
+
+// Ping checks that Redis answers.
+func (p *Producer) Ping(ctx context.Context) error {
+	return p.redisClient.Ping(ctx).Err()
+}
+
+// WorkersAlive checks that some process service instance has running workers.
+func (p *Producer) WorkersAlive(ctx context.Context) error {
+	n, err := p.redisClient.Exists(ctx, workersAliveKey).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoWorkers
+	}
 	return nil
 }
 
+// CancelTask marks a queued task as cancelled so workers skip it.
+// It returns false when a worker has already picked the task up.
+func (p *Producer) CancelTask(ctx context.Context, taskID uuid.UUID) (bool, error) {
+	key := taskStateKeyPrefix + taskID.String()
+	ok, err := p.redisClient.SetNX(ctx, key, taskStateCancelled, taskStateTTL).Result()
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return true, nil
+	}
+	state, err := p.redisClient.Get(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return state == taskStateCancelled, nil
+}
+
+// TaskFinalState returns "done", "failed" or "cancelled" for a task which reached one of
+// them and "" for a queued, running or unknown one.
+func (p *Producer) TaskFinalState(ctx context.Context, taskID uuid.UUID) (string, error) {
+	state, err := p.redisClient.Get(ctx, taskStateKeyPrefix+taskID.String()).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	switch state {
+	case taskStateDone, taskStateFailed, taskStateCancelled:
+		return state, nil
+	}
+	return "", nil
+}
+
 type Consumer struct {
 	redisClient *redis.Client
 }
@@ -91,4 +253,4 @@ func NewService(producer *Producer, consumer *Consumer) *Service {
 		Producer: producer,
 		Consumer: consumer,
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,163 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"submit_service/internal/chaos"
+	"submit_service/internal/domain"
+)
+
+func TestProduceTaskChaos(t *testing.T) {
+	task := &domain.Task{ID: uuid.New()}
+
+	t.Run("injected failure", func(t *testing.T) {
+		state := chaos.New()
+		state.FailNext(1)
+		// the failure is returned before redis is used, so no client is needed
+		p := NewProducer(nil, state, 1)
+		if err := p.ProduceTask(context.Background(), task); !errors.Is(err, chaos.ErrInjected) {
+			t.Errorf("ProduceTask() = %v, want %v", err, chaos.ErrInjected)
+		}
+	})
+
+	t.Run("queue delay", func(t *testing.T) {
+		state := chaos.New()
+		state.SetQueueDelay(time.Hour)
+		p := NewProducer(nil, state, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := p.ProduceTask(ctx, task); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ProduceTask() = %v, want the delay to hold it until %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestProduceAfterStop(t *testing.T) {
+	// nothing listens there, a closed producer must not try to reach it
+	p := NewProducer(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}), nil, 1)
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+	if err := p.Stop(context.Background()); err != nil {
+		t.Errorf("second Stop() = %v, want nil", err)
+	}
+	if err := p.ProduceTask(context.Background(), &domain.Task{ID: uuid.New()}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("ProduceTask() after Stop = %v, want %v", err, ErrQueueClosed)
+	}
+}
+
+func TestProduceErr(t *testing.T) {
+	other := errors.New("READONLY You can't write against a read only replica.")
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"client closed", redis.ErrClosed, ErrQueueClosed},
+		{"out of memory", errors.New("OOM command not allowed when used memory > 'maxmemory'."), ErrQueueFull},
+		{"other", other, other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := produceErr(tt.err); !errors.Is(got, tt.want) {
+				t.Errorf("produceErr(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// testRedis returns a client of the redis at TEST_REDIS_ADDR, the test is skipped without one
+func testRedis(t testing.TB) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR is not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { rdb.Close() })
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("redis at %s is not reachable: %v", addr, err)
+	}
+	return rdb
+}
+
+func TestClaimPayload(t *testing.T) {
+	const window = 100 * time.Millisecond
+	ctx := context.Background()
+	p := NewProducer(testRedis(t), nil, 1)
+	hash := PayloadHash("payload "+uuid.NewString(), nil)
+	t.Cleanup(func() { p.ReleasePayload(ctx, hash) })
+
+	claim := func(taskID uuid.UUID) (uuid.UUID, bool) {
+		t.Helper()
+		owner, ok, err := p.ClaimPayload(ctx, hash, taskID, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return owner, ok
+	}
+
+	first := uuid.New()
+	if _, ok := claim(first); !ok {
+		t.Fatal("first claim = false, want true")
+	}
+	if owner, ok := claim(uuid.New()); ok || owner != first {
+		t.Errorf("claim within the window = %s, %t, want %s, false", owner, ok, first)
+	}
+	time.Sleep(window + 50*time.Millisecond)
+	if _, ok := claim(uuid.New()); !ok {
+		t.Error("claim after the window = false, want true")
+	}
+	if err := p.ReleasePayload(ctx, hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := claim(uuid.New()); !ok {
+		t.Error("claim after a release = false, want true")
+	}
+}
+
+func TestProduceWarmupTask(t *testing.T) {
+	ctx := context.Background()
+	rdb := testRedis(t)
+	p := NewProducer(rdb, nil, 1)
+	payload := "warmup"
+	task := &domain.Task{ID: uuid.New(), Status: domain.StatusPending, Payload: &payload, Warmup: true}
+	if err := p.ProduceTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := rdb.XRevRangeN(ctx, StreamName(0, 1), "+", "-", 100).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		if message.Values["id"] != task.ID.String() {
+			continue
+		}
+		t.Cleanup(func() { rdb.XDel(ctx, StreamName(0, 1), message.ID) })
+		if got := message.Values["warmup"]; got != "1" {
+			t.Errorf("warmup field = %v, want 1", got)
+		}
+		return
+	}
+	t.Fatalf("task %s is not in the stream", task.ID)
+}
+
+func TestPayloadHash(t *testing.T) {
+	if PayloadHash("x", nil) != PayloadHash("x", []byte{}) {
+		t.Error("a missing file hashes differently from an empty one")
+	}
+	if PayloadHash("x", []byte("f")) == PayloadHash("x", nil) {
+		t.Error("the attached file does not change the hash")
+	}
+	if PayloadHash("ab", nil) == PayloadHash("a", []byte("b")) {
+		t.Error("payload and file are not separated in the hash")
+	}
+}
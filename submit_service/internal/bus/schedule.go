@@ -0,0 +1,123 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// scheduledKey is a sorted set of delayed tasks scored by their run time in unix milliseconds,
+	// it lives in redis so scheduled tasks outlast restarts of the submit service
+	scheduledKey = "tasks:scheduled"
+	// DefaultScheduleInterval is how often due tasks are moved into the queue
+	DefaultScheduleInterval = time.Second
+	// scheduleBatch bounds the due tasks moved per tick
+	scheduleBatch = 100
+)
+
+// scheduledTask is a delayed task waiting in scheduledKey with the fields of its stream entry
+type scheduledTask struct {
+	Stream string            `json:"stream"`
+	Values map[string]string `json:"values"`
+	File   []byte            `json:"file,omitempty"`
+}
+
+// schedule holds the entry in scheduledKey until runAt
+func (p *Producer) schedule(ctx context.Context, stream string, values map[string]interface{}, runAt time.Time) error {
+	entry := scheduledTask{Stream: stream, Values: make(map[string]string, len(values))}
+	for k, v := range values {
+		switch v := v.(type) {
+		case []byte:
+			entry.File = v
+		case string:
+			entry.Values[k] = v
+		default:
+			entry.Values[k] = fmt.Sprint(v)
+		}
+	}
+	member, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := p.redisClient.ZAdd(ctx, scheduledKey, redis.Z{
+		Score:  float64(runAt.UnixMilli()),
+		Member: member,
+	}).Err(); err != nil {
+		return produceErr(err)
+	}
+	return nil
+}
+
+// StartScheduler moves due delayed tasks into the queue every interval until Stop.
+// Every submit service instance may run it, a task is moved by whichever removes it first.
+func (p *Producer) StartScheduler(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultScheduleInterval
+	}
+	p.scheduler.Add(1)
+	go func() {
+		defer p.scheduler.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+				if err := p.releaseDue(context.Background()); err != nil {
+					log.WithError(err).Warn("failed to move scheduled tasks into the queue")
+				}
+			}
+		}
+	}()
+}
+
+// releaseDue adds the due scheduled tasks to their streams, a task failing to be added is
+// put back with its run time so the next tick retries it
+func (p *Producer) releaseDue(ctx context.Context) error {
+	members, err := p.redisClient.ZRangeByScoreWithScores(ctx, scheduledKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: scheduleBatch,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, z := range members {
+		member, _ := z.Member.(string)
+		removed, err := p.redisClient.ZRem(ctx, scheduledKey, member).Result()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			continue // another instance moved it
+		}
+		var entry scheduledTask
+		if err := json.Unmarshal([]byte(member), &entry); err != nil {
+			log.WithError(err).Error("dropping malformed scheduled task")
+			continue
+		}
+		values := make(map[string]interface{}, len(entry.Values)+1)
+		for k, v := range entry.Values {
+			values[k] = v
+		}
+		if len(entry.File) > 0 {
+			values["file"] = entry.File
+		}
+		// queue wait starts when the task is due, not when it was submitted
+		values["enqueued_at"] = time.Now().UnixNano()
+		if err := p.redisClient.XAdd(ctx, &redis.XAddArgs{Stream: entry.Stream, Values: values}).Err(); err != nil {
+			if err := p.redisClient.ZAdd(ctx, scheduledKey, z).Err(); err != nil {
+				log.WithError(err).WithField("task_id", entry.Values["id"]).Error("lost scheduled task")
+			}
+			return err
+		}
+	}
+	return nil
+}
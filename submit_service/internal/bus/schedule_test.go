@@ -0,0 +1,67 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"submit_service/internal/domain"
+)
+
+func TestDelayedTaskWaitsForRunAt(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	ctx := context.Background()
+	rdb := testRedis(t)
+	p := NewProducer(rdb, nil, 1)
+	payload := "delayed"
+	task := &domain.Task{ID: uuid.New(), Status: domain.StatusPending, Payload: &payload, RunAt: time.Now().Add(delay)}
+	t.Cleanup(func() {
+		members, _ := rdb.ZRange(ctx, scheduledKey, 0, -1).Result()
+		for _, member := range members {
+			var entry scheduledTask
+			if json.Unmarshal([]byte(member), &entry) == nil && entry.Values["id"] == task.ID.String() {
+				rdb.ZRem(ctx, scheduledKey, member)
+			}
+		}
+	})
+	if err := p.ProduceTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+
+	// queued returns the stream entry of the task, or "" while it is not in the stream
+	queued := func() string {
+		t.Helper()
+		messages, err := rdb.XRevRangeN(ctx, StreamName(0, 1), "+", "-", 100).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, message := range messages {
+			if message.Values["id"] == task.ID.String() {
+				t.Cleanup(func() { rdb.XDel(ctx, StreamName(0, 1), message.ID) })
+				return message.ID
+			}
+		}
+		return ""
+	}
+
+	if id := queued(); id != "" {
+		t.Fatalf("task %s is in the stream before its run time", task.ID)
+	}
+	if err := p.releaseDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if id := queued(); id != "" {
+		t.Fatalf("task %s was released before its run time", task.ID)
+	}
+
+	time.Sleep(time.Until(task.RunAt) + 50*time.Millisecond)
+	if err := p.releaseDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if id := queued(); id == "" {
+		t.Fatalf("task %s is not in the stream after its run time", task.ID)
+	}
+}
@@ -0,0 +1,34 @@
+package bus
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// taskLogsChannelPrefix channels carry log lines published by the process service workers
+const taskLogsChannelPrefix = "tasks:logs:"
+
+// SubscribeTaskLogs subscribes to the log lines of a task, it returns once the
+// subscription is active, so no line of a task produced afterwards is missed.
+// The channel is closed by the returned func.
+func (p *Producer) SubscribeTaskLogs(ctx context.Context, taskID uuid.UUID) (<-chan string, func() error, error) {
+	pubsub := p.redisClient.Subscribe(ctx, taskLogsChannelPrefix+taskID.String())
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, nil, err
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for msg := range pubsub.Channel() {
+			select {
+			case lines <- msg.Payload:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines, pubsub.Close, nil
+}
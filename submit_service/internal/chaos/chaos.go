@@ -0,0 +1,80 @@
+// Package chaos holds failure injection switches used for resilience testing.
+package chaos
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInjected = errors.New("chaos: injected failure")
+
+// State is checked by the bus producer and the repository before doing real work.
+// The zero value injects nothing.
+type State struct {
+	failNext   atomic.Int64
+	queueDelay atomic.Int64
+	failWrites atomic.Bool
+}
+
+func New() *State {
+	return &State{}
+}
+
+// FailNext forces the next n bus produce calls to fail.
+func (s *State) FailNext(n int64) {
+	s.failNext.Store(n)
+}
+
+// ShouldFail reports whether the current call must fail and consumes one forced failure.
+func (s *State) ShouldFail() bool {
+	if s == nil {
+		return false
+	}
+	for {
+		left := s.failNext.Load()
+		if left <= 0 {
+			return false
+		}
+		if s.failNext.CompareAndSwap(left, left-1) {
+			return true
+		}
+	}
+}
+
+func (s *State) SetQueueDelay(d time.Duration) {
+	s.queueDelay.Store(int64(d))
+}
+
+func (s *State) QueueDelay() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return time.Duration(s.queueDelay.Load())
+}
+
+func (s *State) SetFailWrites(fail bool) {
+	s.failWrites.Store(fail)
+}
+
+// WriteErr returns ErrInjected while ClickHouse writes are set to fail.
+func (s *State) WriteErr() error {
+	if s != nil && s.failWrites.Load() {
+		return ErrInjected
+	}
+	return nil
+}
+
+func (s *State) Snapshot() map[string]any {
+	return map[string]any{
+		"fail_next":              s.failNext.Load(),
+		"queue_delay":            s.QueueDelay().String(),
+		"fail_clickhouse_writes": s.failWrites.Load(),
+	}
+}
+
+func (s *State) Reset() {
+	s.failNext.Store(0)
+	s.queueDelay.Store(0)
+	s.failWrites.Store(false)
+}
@@ -0,0 +1,47 @@
+package chaos
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailNext(t *testing.T) {
+	s := New()
+	s.FailNext(2)
+	for i, want := range []bool{true, true, false, false} {
+		if got := s.ShouldFail(); got != want {
+			t.Errorf("call %d: ShouldFail() = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestWriteErr(t *testing.T) {
+	s := New()
+	if err := s.WriteErr(); err != nil {
+		t.Fatalf("WriteErr() = %v before failing writes", err)
+	}
+	s.SetFailWrites(true)
+	if err := s.WriteErr(); !errors.Is(err, ErrInjected) {
+		t.Errorf("WriteErr() = %v, want %v", err, ErrInjected)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := New()
+	s.FailNext(3)
+	s.SetQueueDelay(time.Second)
+	s.SetFailWrites(true)
+	s.Reset()
+
+	if s.ShouldFail() || s.QueueDelay() != 0 || s.WriteErr() != nil {
+		t.Errorf("state after Reset = %v, want nothing injected", s.Snapshot())
+	}
+}
+
+func TestNilStateInjectsNothing(t *testing.T) {
+	var s *State
+	if s.ShouldFail() || s.QueueDelay() != 0 || s.WriteErr() != nil {
+		t.Error("a nil state injects failures")
+	}
+}
@@ -2,7 +2,10 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -22,10 +25,12 @@ const (
 
 // AppConfig is an example for app's config container
 type AppConfig struct {
-	RepoConf  *repository.Config `mapstructure:"repository"`
-	RedisConf *bus.Config `mapstructure:"bus"`
-	Metrics *metrics.Config    `mapstructure:"metrics"`
-	WebAPI  *webapi.Config     `mapstructure:"web_api"`
+	// InstanceID tells replicas apart in metrics and logs, it defaults to the hostname
+	InstanceID string             `mapstructure:"instance_id"`
+	RepoConf   *repository.Config `mapstructure:"repository"`
+	RedisConf  *bus.Config        `mapstructure:"bus"`
+	Metrics    *metrics.Config    `mapstructure:"metrics"`
+	WebAPI     *webapi.Config     `mapstructure:"web_api"`
 }
 
 func defaultSearchParths() []string {
@@ -41,14 +46,22 @@ func GetConf() (*AppConfig, error) {
 
 	viper.SetConfigName(DefaultConfigName)
 	viper.SetConfigType(DefaultConfigType)
+	viper.SetDefault("instance_id", defaultInstanceID())
 
 	config := new(AppConfig)
 
-	err := viper.ReadInConfig()
+	remote, err := readRemoteConfig()
 	if err != nil {
-		log.Printf("read config failed: '%s'", err)
+		log.Printf("read remote config failed: '%s'", err)
 		return nil, err
 	}
+	if !remote {
+		if err := viper.ReadInConfig(); err != nil {
+			log.Printf("read config failed: '%s'", err)
+			return nil, err
+		}
+		logConfigFileUsed()
+	}
 
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -58,6 +71,97 @@ func GetConf() (*AppConfig, error) {
 		log.Printf("unmarshal failed: '%s'", err)
 		return nil, err
 	}
+	config.applyInstanceID()
+	if err := config.validate(); err != nil {
+		log.Printf("invalid config: '%s'", err)
+		return nil, err
+	}
 
 	return config, nil
 }
+
+// defaultInstanceID is the hostname, which is the pod name in kubernetes
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// applyInstanceID hands instance_id to the sections labelling metrics and log rows with it
+func (c *AppConfig) applyInstanceID() {
+	if c.Metrics != nil {
+		c.Metrics.InstanceID = c.InstanceID
+	}
+	if c.RepoConf != nil {
+		c.RepoConf.InstanceID = c.InstanceID
+	}
+}
+
+// validate rejects settings which would break the service at runtime
+func (c *AppConfig) validate() error {
+	if c.Metrics != nil {
+		if err := c.Metrics.Validate(); err != nil {
+			return fmt.Errorf("metrics: %w", err)
+		}
+	}
+	return nil
+}
+
+// logConfigFileUsed logs the config file viper picked and warns when the search
+// paths hold more than one, only the first of them is in effect
+func logConfigFileUsed() {
+	used := viper.ConfigFileUsed()
+	if abs, err := filepath.Abs(used); err == nil {
+		used = abs
+	}
+	log.Printf("using config file '%s'", used)
+
+	candidates := configCandidates(defaultSearchParths())
+	if len(candidates) > 1 {
+		log.Printf("WARN: found %d config files %v, only '%s' is used", len(candidates), candidates, used)
+	}
+}
+
+// configCandidates returns the absolute paths of existing config files in the search paths
+func configCandidates(paths []string) []string {
+	home, _ := os.UserHomeDir()
+	seen := make(map[string]struct{})
+	var res []string
+	for _, dir := range paths {
+		if rest, ok := strings.CutPrefix(dir, "~"); ok && home != "" {
+			dir = home + rest
+		}
+		file, err := filepath.Abs(filepath.Join(dir, DefaultConfigName+"."+DefaultConfigType))
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[file]; ok {
+			continue
+		}
+		seen[file] = struct{}{}
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			res = append(res, file)
+		}
+	}
+	return res
+}
+
+// readRemoteConfig reads the config from the remote source when one is given, it
+// returns false without one to read the config file. A remote source which can not be
+// read is an error rather than a silent fall back to a possibly stale file.
+func readRemoteConfig() (bool, error) {
+	rawURL := remoteURL(os.Args[1:])
+	if rawURL == "" {
+		return false, nil
+	}
+	if err := addRemoteProvider(rawURL); err != nil {
+		return false, err
+	}
+	if err := viper.ReadRemoteConfig(); err != nil {
+		return false, fmt.Errorf("remote config '%s': %w", rawURL, err)
+	}
+	log.Printf("using remote config '%s'", rawURL)
+	return true, nil
+}
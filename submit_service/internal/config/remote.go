@@ -0,0 +1,235 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// RemoteFlag selects a remote config source, e.g. --config-remote=consul://127.0.0.1:8500/shortcut/config.yml
+	RemoteFlag = "--config-remote"
+	// RemoteEnv is read when the flag is not given
+	RemoteEnv = "CONFIG_REMOTE"
+	// SourceFlag selects the config source: file (default), consul or etcd. A remote source
+	// needs the endpoint and key path flags, e.g. --config-source=etcd
+	// --config-remote-endpoint=127.0.0.1:2379 --config-remote-path=/shortcut/config.yml
+	SourceFlag         = "--config-source"
+	RemoteEndpointFlag = "--config-remote-endpoint"
+	RemotePathFlag     = "--config-remote-path"
+	// SourceEnv, RemoteEndpointEnv and RemotePathEnv are read when the flags are not given
+	SourceEnv         = "CONFIG_SOURCE"
+	RemoteEndpointEnv = "CONFIG_REMOTE_ENDPOINT"
+	RemotePathEnv     = "CONFIG_REMOTE_PATH"
+
+	SourceFile   = "file"
+	SourceConsul = "consul"
+	SourceEtcd   = "etcd"
+	// DefaultRemoteWatchInterval is how often WatchRemote re-reads the remote config
+	DefaultRemoteWatchInterval = 30 * time.Second
+
+	_remoteTimeout = 5 * time.Second
+)
+
+// argOrEnv returns the value of flag from the command line, or the env variable when the flag is not given
+func argOrEnv(args []string, flag, env string) string {
+	for i, arg := range args {
+		if v, ok := strings.CutPrefix(arg, flag+"="); ok {
+			return v
+		}
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(env)
+}
+
+// remoteURL returns the remote config URL from the command line or the environment, empty when not set.
+// The source selector wins over the URL form.
+func remoteURL(args []string) string {
+	switch source := argOrEnv(args, SourceFlag, SourceEnv); source {
+	case "":
+		return argOrEnv(args, RemoteFlag, RemoteEnv)
+	case SourceFile:
+		return ""
+	default:
+		endpoint := argOrEnv(args, RemoteEndpointFlag, RemoteEndpointEnv)
+		path := argOrEnv(args, RemotePathFlag, RemotePathEnv)
+		return source + "://" + endpoint + "/" + strings.TrimPrefix(path, "/")
+	}
+}
+
+// RemoteConfigured reports whether the config is read from a remote source.
+func RemoteConfigured() bool {
+	return remoteURL(os.Args[1:]) != ""
+}
+
+// remoteProvider maps a config source to the viper provider name, etcd is served by the v3 API
+func remoteProvider(source string) (string, error) {
+	switch source {
+	case SourceConsul:
+		return "consul", nil
+	case SourceEtcd, "etcd3":
+		return "etcd3", nil
+	default:
+		return "", fmt.Errorf("remote config source '%s' is not supported, use consul or etcd", source)
+	}
+}
+
+// addRemoteProvider registers the remote source given as provider://endpoint/path.
+// Consul and etcd are served by a built-in KV client unless the viper/remote package is linked in.
+func addRemoteProvider(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("parse remote config url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" || u.Path == "" || u.Path == "/" {
+		return fmt.Errorf("remote config url must look like provider://host:port/path, got '%s'", rawURL)
+	}
+	provider, err := remoteProvider(u.Scheme)
+	if err != nil {
+		return err
+	}
+	if viper.RemoteConfig == nil {
+		viper.RemoteConfig = &remoteKV{client: &http.Client{Timeout: _remoteTimeout}}
+	}
+	return viper.AddRemoteProvider(provider, u.Host, u.Path)
+}
+
+// WatchRemote re-reads the remote config every interval until ctx is done and passes
+// the new config to onChange when the read succeeds. Only fields the receiver applies
+// at runtime take effect, the rest needs a restart.
+func WatchRemote(ctx context.Context, interval time.Duration, onChange func(*AppConfig)) {
+	if interval <= 0 {
+		interval = DefaultRemoteWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := viper.WatchRemoteConfig(); err != nil {
+			log.Printf("re-read remote config failed: '%s'", err)
+			continue
+		}
+		conf := new(AppConfig)
+		if err := viper.Unmarshal(&conf); err != nil {
+			log.Printf("unmarshal remote config failed: '%s'", err)
+			continue
+		}
+		conf.applyInstanceID()
+		if err := conf.validate(); err != nil {
+			log.Printf("invalid remote config: '%s'", err)
+			continue
+		}
+		onChange(conf)
+	}
+}
+
+// remoteKV reads the config from the Consul KV HTTP API or the etcd v3 JSON gateway
+type remoteKV struct {
+	client *http.Client
+}
+
+func (c *remoteKV) Get(rp viper.RemoteProvider) (io.Reader, error) {
+	if rp.Provider() == "etcd3" {
+		return c.getEtcd(rp)
+	}
+	return c.getConsul(rp)
+}
+
+func (c *remoteKV) getConsul(rp viper.RemoteProvider) (io.Reader, error) {
+	u := url.URL{Scheme: "http", Host: rp.Endpoint(), Path: "/v1/kv/" + strings.TrimPrefix(rp.Path(), "/"), RawQuery: "raw"}
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul kv %s: unexpected status %d", rp.Path(), resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(body), nil
+}
+
+// getEtcd reads the key with a range request, keys and values are base64 in the JSON API
+func (c *remoteKV) getEtcd(rp viper.RemoteProvider) (io.Reader, error) {
+	req, err := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(rp.Path()))})
+	if err != nil {
+		return nil, err
+	}
+	u := url.URL{Scheme: "http", Host: rp.Endpoint(), Path: "/v3/kv/range"}
+	resp, err := c.client.Post(u.String(), "application/json", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("etcd kv %s: unexpected status %d", rp.Path(), resp.StatusCode)
+	}
+	var out struct {
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Kvs) == 0 {
+		return nil, fmt.Errorf("etcd kv %s: key not found", rp.Path())
+	}
+	value, err := base64.StdEncoding.DecodeString(out.Kvs[0].Value)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(value), nil
+}
+
+func (c *remoteKV) Watch(rp viper.RemoteProvider) (io.Reader, error) {
+	return c.Get(rp)
+}
+
+// WatchChannel polls the key, the stop channel ends the polling
+func (c *remoteKV) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool) {
+	resp := make(chan *viper.RemoteResponse)
+	quit := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(DefaultRemoteWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+			r, err := c.Get(rp)
+			res := &viper.RemoteResponse{Error: err}
+			if err == nil {
+				res.Value, res.Error = io.ReadAll(r)
+			}
+			select {
+			case resp <- res:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return resp, quit
+}
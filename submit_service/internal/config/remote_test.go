@@ -0,0 +1,109 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRemoteURL(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"no remote source", nil, nil, ""},
+		{"file source", []string{"--config-source=file", "--config-remote-endpoint=127.0.0.1:8500"}, nil, ""},
+		{"consul source", []string{"--config-source=consul", "--config-remote-endpoint=127.0.0.1:8500", "--config-remote-path=/shortcut/config.yml"}, nil, "consul://127.0.0.1:8500/shortcut/config.yml"},
+		{"etcd source without a leading slash", []string{"--config-source", "etcd", "--config-remote-endpoint", "127.0.0.1:2379", "--config-remote-path", "shortcut/config.yml"}, nil, "etcd://127.0.0.1:2379/shortcut/config.yml"},
+		{"url flag", []string{"--config-remote=consul://127.0.0.1:8500/shortcut/config.yml"}, nil, "consul://127.0.0.1:8500/shortcut/config.yml"},
+		{"source from the environment", nil, map[string]string{SourceEnv: "etcd", RemoteEndpointEnv: "etcd:2379", RemotePathEnv: "/shortcut"}, "etcd://etcd:2379/shortcut"},
+		{"flag wins over the environment", []string{"--config-source=file"}, map[string]string{SourceEnv: "consul"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{SourceEnv, RemoteEndpointEnv, RemotePathEnv, RemoteEnv} {
+				t.Setenv(env, tt.env[env])
+			}
+			if got := remoteURL(tt.args); got != tt.want {
+				t.Errorf("remoteURL(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteProvider(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{SourceConsul, "consul", false},
+		{SourceEtcd, "etcd3", false},
+		{"etcd3", "etcd3", false},
+		{"firestore", "", true},
+	}
+	for _, tt := range tests {
+		got, err := remoteProvider(tt.source)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("remoteProvider(%q) = %q, %v, want %q, error %t", tt.source, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReadRemoteConfig(t *testing.T) {
+	const doc = "instance_id: remote\n"
+	tests := []struct {
+		source   string
+		wantPath string
+		serve    func(w http.ResponseWriter, r *http.Request)
+	}{
+		{SourceConsul, "/v1/kv/shortcut/config.yml", func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte(doc))
+		}},
+		{SourceEtcd, "/v3/kv/range", func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Key string `json:"key"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if key, _ := base64.StdEncoding.DecodeString(req.Key); string(key) != "/shortcut/config.yml" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"kvs": []map[string]string{{"value": base64.StdEncoding.EncodeToString([]byte(doc))}}})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			var paths []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				tt.serve(w, r)
+			}))
+			t.Cleanup(srv.Close)
+			t.Cleanup(viper.Reset)
+			args := os.Args
+			os.Args = []string{"test", SourceFlag + "=" + tt.source, RemoteEndpointFlag + "=" + strings.TrimPrefix(srv.URL, "http://"), RemotePathFlag + "=/shortcut/config.yml"}
+			t.Cleanup(func() { os.Args = args })
+
+			viper.SetConfigType(DefaultConfigType)
+			remote, err := readRemoteConfig()
+			if err != nil || !remote {
+				t.Fatalf("readRemoteConfig() = %t, %v, want true, nil", remote, err)
+			}
+			if len(paths) != 1 || paths[0] != tt.wantPath {
+				t.Errorf("remote requests = %q, want one to %q", paths, tt.wantPath)
+			}
+			if got := viper.GetString("instance_id"); got != "remote" {
+				t.Errorf("instance_id = %q, want the remote value", got)
+			}
+		})
+	}
+}
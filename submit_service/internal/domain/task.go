@@ -1,11 +1,30 @@
 package domain
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Task struct {
 	ID      uuid.UUID
 	Status  TaskStatus
 	Payload *string
+	Tags    map[string]string
+	// EnqueuedAt is set when the task is added to the queue
+	EnqueuedAt time.Time
+	// StreamLogs asks the workers to publish the task's log lines
+	StreamLogs bool
+	// File holds an upload attached to the submit, it is size-limited by the web API
+	File []byte
+	// Timeout overrides the processing timeout when set
+	Timeout time.Duration
+	// Warmup marks a synthetic startup task, it is left out of business metrics
+	Warmup bool
+	// DependsOn lists tasks which must succeed before this one is processed
+	DependsOn []uuid.UUID
+	// RunAt delays queueing the task until then, zero queues it right away
+	RunAt time.Time
 }
 
 type TaskStatus string
@@ -15,4 +34,5 @@ const (
 	StatusProcessing TaskStatus = "processing"
 	StatusPending    TaskStatus = "pending"
 	StatusProcessed  TaskStatus = "done"
+	StatusCancelled  TaskStatus = "cancelled"
 )
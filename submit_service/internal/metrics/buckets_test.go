@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tooMany := make([]float64, MaxBuckets+1)
+	for i := range tooMany {
+		tooMany[i] = float64(i + 1)
+	}
+	atMax := tooMany[:MaxBuckets]
+
+	tests := []struct {
+		name    string
+		conf    Config
+		wantErr string
+	}{
+		{"defaults", Config{}, ""},
+		{"increasing", Config{DurationBuckets: []float64{0.1, 0.5, 1}}, ""},
+		{"single bucket", Config{HTTPDurationBuckets: []float64{1}}, ""},
+		{"at the max", Config{TaskDurationBuckets: atMax}, ""},
+		{"too many", Config{TaskDurationBuckets: tooMany}, "task_duration_buckets has 51 buckets"},
+		{"equal", Config{HTTPDurationBuckets: []float64{0.1, 0.5, 0.5}}, "http_duration_buckets must be strictly increasing"},
+		{"decreasing", Config{QueueWaitBuckets: []float64{1, 0.5}}, "queue_wait_buckets must be strictly increasing"},
+		{"first invalid wins", Config{DurationBuckets: []float64{2, 1}, QueueWaitBuckets: tooMany}, "duration_buckets must be strictly increasing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error starting with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+const unknownBuildValue = "unknown"
+
+// buildInfo returns version, commit and go version of the running binary
+func buildInfo() (version, commit, goVersion string) {
+	version, commit, goVersion = unknownBuildValue, unknownBuildValue, runtime.Version()
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version, commit, goVersion
+	}
+	if bi.Main.Version != "" {
+		version = bi.Main.Version
+	}
+	for _, setting := range bi.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			commit = setting.Value
+		}
+	}
+	return version, commit, goVersion
+}
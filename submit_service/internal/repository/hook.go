@@ -1,17 +1,27 @@
 package repository
 
 import (
+	"errors"
 	"maps"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// _dropWarnInterval throttles the warning about dropped log entries
+const _dropWarnInterval = 10 * time.Second
+
 type LogHook struct {
 	client *Client
+	ring   *LogRing
+	// dropped counts entries dropped since the last warning at lastWarn, unix nanoseconds
+	dropped  atomic.Int64
+	lastWarn atomic.Int64
 }
 
-func NewLogHook(c *Client) *LogHook {
-	return &LogHook{client: c}
+func NewLogHook(c *Client, ring *LogRing) *LogHook {
+	return &LogHook{client: c, ring: ring}
 }
 
 func (h *LogHook) Levels() []log.Level {
@@ -25,5 +35,35 @@ func (h *LogHook) Fire(e *log.Entry) error {
 	data["level"] = e.Level.String()
 	data["message"] = e.Message
 
-	return h.client.WriteLog(data)
+	// keep the entry locally first, so it is not lost if ClickHouse is down
+	if h.ring != nil {
+		h.ring.Add(data)
+	}
+
+	if h.client == nil {
+		return nil
+	}
+	// dropping a log line is better than piling up writers while ClickHouse is slow
+	err := h.client.WriteLog(data)
+	if errors.Is(err, ErrWritesOverloaded) || errors.Is(err, ErrClientClosed) {
+		h.drop()
+		return nil
+	}
+	return err
+}
+
+// drop counts a dropped entry and warns at most once per _dropWarnInterval.
+// The warning goes to the standard logger, which does not ship to ClickHouse.
+func (h *LogHook) drop() {
+	if h.client.metricsSrv != nil {
+		h.client.metricsSrv.Recorder.IncClickHouseLogsDropped()
+	}
+	h.dropped.Add(1)
+
+	now := time.Now().UnixNano()
+	last := h.lastWarn.Load()
+	if now-last < int64(_dropWarnInterval) || !h.lastWarn.CompareAndSwap(last, now) {
+		return
+	}
+	log.WithField("dropped", h.dropped.Swap(0)).Warn("clickhouse log hook is dropping entries")
 }
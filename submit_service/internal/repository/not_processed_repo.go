@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	notProcessedQueryTimeout = 5 * time.Second
+	// notProcessedIDsLimit bounds the ids read for reports, CountNotProcessed is exact
+	notProcessedIDsLimit = 1000
+	// notProcessedCountTTL is how long a count is served before ClickHouse is asked again
+	notProcessedCountTTL = 30 * time.Second
+)
+
+// WriteNotProcessed inserts the task in the background, so the caller does not wait
+// for ClickHouse. Close waits for it like for log writes, a failure is passed to onErr.
+func (c *Client) WriteNotProcessed(taskID, reason string, at time.Time, onErr func(error)) {
+	c.closeMux.RLock()
+	if c.closed {
+		c.closeMux.RUnlock()
+		onErr(ErrClientClosed)
+		return
+	}
+	c.inflight.Add(1)
+	c.closeMux.RUnlock()
+
+	go func() {
+		defer c.inflight.Done()
+		query := "INSERT INTO not_processed (task_id, reason, ts) VALUES (?, ?, ?)"
+		if err := c.conn.Exec(c.ctx, query, taskID, reason, at); err != nil {
+			onErr(err)
+		}
+	}()
+}
+
+// ReadNotProcessedIDs returns up to notProcessedIDsLimit ids, the most recent first
+func (c *Client) ReadNotProcessedIDs(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, notProcessedQueryTimeout)
+	defer cancel()
+
+	rows, err := c.conn.Query(ctx, "SELECT task_id FROM not_processed GROUP BY task_id ORDER BY max(ts) DESC LIMIT ?", notProcessedIDsLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CountNotProcessed returns the number of distinct not processed task ids
+func (c *Client) CountNotProcessed(ctx context.Context) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, notProcessedQueryTimeout)
+	defer cancel()
+
+	var count uint64
+	err := c.conn.QueryRow(ctx, "SELECT uniqExact(task_id) FROM not_processed").Scan(&count)
+	return count, err
+}
+
+// countCache serves a loaded count for ttl, so frequent readers like /metrics do not
+// query ClickHouse on every call. Failed loads are not cached.
+type countCache struct {
+	mux    sync.Mutex
+	ttl    time.Duration
+	now    func() time.Time
+	value  uint64
+	loaded time.Time
+}
+
+func newCountCache(ttl time.Duration) *countCache {
+	return &countCache{ttl: ttl, now: time.Now}
+}
+
+func (c *countCache) get(load func() (uint64, error)) (uint64, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	now := c.now()
+	if !c.loaded.IsZero() && now.Sub(c.loaded) < c.ttl {
+		return c.value, nil
+	}
+	value, err := load()
+	if err != nil {
+		return 0, err
+	}
+	c.value, c.loaded = value, now
+	return value, nil
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCountCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newCountCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	loads := 0
+	var loadErr error
+	load := func() (uint64, error) {
+		loads++
+		return uint64(loads * 10), loadErr
+	}
+
+	for _, tt := range []struct {
+		name      string
+		advance   time.Duration
+		err       error
+		want      uint64
+		wantErr   bool
+		wantLoads int
+	}{
+		{"first read loads", 0, nil, 10, false, 1},
+		{"served from the cache within the ttl", 59 * time.Second, nil, 10, false, 1},
+		{"reloaded after the ttl", time.Second, nil, 20, false, 2},
+		{"failed load after the ttl", time.Minute, errors.New("clickhouse down"), 0, true, 3},
+		{"failed load is not cached", 0, nil, 40, false, 4},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			loadErr = tt.err
+			got, err := c.get(load)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("get() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("get() = %d, want %d", got, tt.want)
+			}
+			if loads != tt.wantLoads {
+				t.Errorf("loads = %d, want %d", loads, tt.wantLoads)
+			}
+		})
+	}
+}
+
+func TestAddNotProcessedStoresReason(t *testing.T) {
+	conn := &recordingConn{}
+	s := &Service{
+		Client:  &Client{ctx: context.Background(), conn: conn},
+		mux:     &sync.Mutex{},
+		storage: make(map[string]NotProcessedTask),
+	}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.AddNotProcessed("timed-out", "timeout", at)
+	s.AddNotProcessed("failed", "error", at.Add(time.Second))
+	s.Client.inflight.Wait()
+
+	want := []NotProcessedTask{
+		{TaskID: "timed-out", Reason: "timeout", At: at},
+		{TaskID: "failed", Reason: "error", At: at.Add(time.Second)},
+	}
+	sameTask := func(a, b NotProcessedTask) bool {
+		return a.TaskID == b.TaskID && a.Reason == b.Reason && a.At.Equal(b.At)
+	}
+	if got := s.GetNotProcessedTasks(); !slices.EqualFunc(got, want, sameTask) {
+		t.Errorf("GetNotProcessedTasks() = %v, want %v", got, want)
+	}
+	// the id only view of the count and list endpoints is kept
+	ids := s.GetLocalNotProcessedTasks()
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"failed", "timed-out"}) {
+		t.Errorf("GetLocalNotProcessedTasks() = %v, want both ids", ids)
+	}
+
+	rows := make(map[string][]any)
+	for _, args := range conn.execs {
+		rows[args[0].(string)] = args
+	}
+	for _, task := range want {
+		row, ok := rows[task.TaskID]
+		if !ok {
+			t.Errorf("task %s was not written", task.TaskID)
+			continue
+		}
+		if row[1] != task.Reason || row[2] != task.At {
+			t.Errorf("row of %s = %v, want reason %s at %s", task.TaskID, row, task.Reason, task.At)
+		}
+	}
+}
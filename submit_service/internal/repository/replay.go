@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// replayMaxLine is the longest JSONL line accepted by Replay
+const replayMaxLine = 1 << 20
+
+// ReplayStats counts lines handled by Replay.
+type ReplayStats struct {
+	Lines    int
+	Inserted int
+	Skipped  int
+	Failed   int
+}
+
+// Replay inserts JSONL entries from r into the logs or metrics table, keeping
+// the original "time" of an entry when present. Malformed lines are skipped,
+// failed inserts are counted and replay goes on. progress, if set, is called
+// every progressEvery lines.
+func (c *Client) Replay(ctx context.Context, table string, r io.Reader, progressEvery int, progress func(ReplayStats)) (ReplayStats, error) {
+	var stats ReplayStats
+	if table != "logs" && table != "metrics" {
+		return stats, fmt.Errorf("replay: unsupported table %q", table)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), replayMaxLine)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		stats.Lines++
+
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry == nil {
+			stats.Skipped++
+		} else if err := c.postWithRetries(ctx, table, entryTime(entry), entry); err != nil {
+			stats.Failed++
+		} else {
+			stats.Inserted++
+		}
+
+		if progress != nil && progressEvery > 0 && stats.Lines%progressEvery == 0 {
+			progress(stats)
+		}
+	}
+
+	return stats, scanner.Err()
+}
+
+func entryTime(entry map[string]any) time.Time {
+	if s, ok := entry["time"].(string); ok {
+		if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return ts
+		}
+	}
+	return time.Now()
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	var mux sync.Mutex
+	var inserts []string
+	c := testClickHouse(t, &Config{}, func(w http.ResponseWriter, _ *http.Request, query string) {
+		if !strings.HasPrefix(query, "INSERT") {
+			return
+		}
+		if strings.Contains(query, "rejected") {
+			http.Error(w, "Code: 27. DB::Exception: Cannot parse input", http.StatusInternalServerError)
+			return
+		}
+		mux.Lock()
+		inserts = append(inserts, query)
+		mux.Unlock()
+	})
+
+	file := filepath.Join(t.TempDir(), "logs.jsonl")
+	lines := []string{
+		`{"msg":"first","time":"2024-05-01T10:00:00Z"}`,
+		`not json`,
+		`{"msg":"rejected"}`,
+		`null`,
+		`{"msg":"second","time":"2024-05-01T10:00:01Z"}`,
+	}
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var progress []ReplayStats
+	stats, err := c.Replay(context.Background(), "logs", f, 2, func(s ReplayStats) { progress = append(progress, s) })
+	if err != nil {
+		t.Fatalf("Replay() = %v", err)
+	}
+	if want := (ReplayStats{Lines: 5, Inserted: 2, Skipped: 2, Failed: 1}); stats != want {
+		t.Errorf("Replay() stats = %+v, want %+v", stats, want)
+	}
+	if len(progress) != 2 || progress[1].Lines != 4 {
+		t.Errorf("progress reports = %+v, want one every 2 lines", progress)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(inserts) != 2 {
+		t.Fatalf("inserted %d rows, want 2: %q", len(inserts), inserts)
+	}
+	for i, want := range []string{"2024-05-01 10:00:00", "2024-05-01 10:00:01"} {
+		if !strings.Contains(inserts[i], want) {
+			t.Errorf("insert %q does not keep the entry time %s", inserts[i], want)
+		}
+	}
+}
+
+func TestReplayUnsupportedTable(t *testing.T) {
+	c := &Client{ctx: context.Background()}
+	if _, err := c.Replay(context.Background(), "tasks", strings.NewReader("{}\n"), 0, nil); err == nil {
+		t.Error("Replay() into tasks = nil, want an error")
+	}
+}
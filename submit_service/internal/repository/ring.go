@@ -0,0 +1,60 @@
+package repository
+
+import "sync"
+
+const defaultRecentLogs = 1000
+
+// LogRing keeps the last N log entries in memory so they stay available
+// even when shipping to ClickHouse is broken.
+type LogRing struct {
+	mux     *sync.Mutex
+	entries []map[string]any
+	next    int
+	full    bool
+}
+
+func NewLogRing(size int) *LogRing {
+	if size <= 0 {
+		size = defaultRecentLogs
+	}
+	return &LogRing{
+		mux:     &sync.Mutex{},
+		entries: make([]map[string]any, size),
+	}
+}
+
+func (r *LogRing) Add(entry map[string]any) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns up to n most recent entries, oldest first.
+func (r *LogRing) Recent(n int) []map[string]any {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	size := r.next
+	if r.full {
+		size = len(r.entries)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	res := make([]map[string]any, 0, n)
+	start := r.next - n
+	if start < 0 {
+		start += len(r.entries)
+	}
+	for i := 0; i < n; i++ {
+		res = append(res, r.entries[(start+i)%len(r.entries)])
+	}
+
+	return res
+}
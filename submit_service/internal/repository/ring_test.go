@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+)
+
+// ringMessages returns the msg fields of the entries
+func ringMessages(entries []map[string]any) []int {
+	res := make([]int, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e["msg"].(int))
+	}
+	return res
+}
+
+func TestLogRingRecent(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		added int
+		n     int
+		want  []int
+	}{
+		{"empty", 3, 0, 2, []int{}},
+		{"partly filled", 3, 2, 2, []int{0, 1}},
+		{"partly filled clamped", 3, 2, 5, []int{0, 1}},
+		{"exactly full", 3, 3, 3, []int{0, 1, 2}},
+		{"wrapped newest", 3, 7, 2, []int{5, 6}},
+		{"wrapped all", 3, 7, 3, []int{4, 5, 6}},
+		{"wrapped clamped", 3, 7, 10, []int{4, 5, 6}},
+		{"wrapped non-positive n", 3, 8, 0, []int{5, 6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewLogRing(tt.size)
+			for i := range tt.added {
+				r.Add(map[string]any{"msg": i})
+			}
+			if got := ringMessages(r.Recent(tt.n)); !slices.Equal(got, tt.want) {
+				t.Errorf("Recent(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLogRingDefaultSize(t *testing.T) {
+	if got := len(NewLogRing(0).entries); got != defaultRecentLogs {
+		t.Errorf("size = %d, want %d", got, defaultRecentLogs)
+	}
+}
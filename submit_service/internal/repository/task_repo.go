@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"database/sql"
+	"errors"
 	"submit_service/internal/domain"
 	"time"
 
@@ -54,6 +56,9 @@ func (r *TaskRepository) UpdateTaskStatus(taskID uuid.UUID, status domain.TaskSt
 	if r.s == nil {
 		return nil
 	}
+	if err := r.s.Client.chaos.WriteErr(); err != nil {
+		return err
+	}
 	query := "ALTER TABLE tasks UPDATE status = $1 WHERE id = $2 SETTINGS mutations_sync = 1"
 	if err := r.s.Client.conn.Exec(r.s.Client.ctx, query, status, taskID); err != nil {
 		r.s.logger.WithError(err).Errorf("Failed to update task %s status to %s", taskID, status)
@@ -66,16 +71,41 @@ func (r *TaskRepository) InsertTask(task *domain.Task) error {
 	if r.s == nil {
 		return nil
 	}
-	query := "INSERT INTO tasks (id, status, payload, ts) VALUES ($1, $2, $3, $4)"
+	if err := r.s.Client.chaos.WriteErr(); err != nil {
+		return err
+	}
+	query := "INSERT INTO tasks (id, status, payload, tags, ts) VALUES ($1, $2, $3, $4, $5)"
 	now := time.Now().Format(timeFormat)
-	if err := r.s.Client.conn.Exec(r.s.Client.ctx, query, task.ID, task.Status, task.Payload, now); err != nil {
+	if err := r.s.Client.conn.Exec(r.s.Client.ctx, query, task.ID, task.Status, task.Payload, tagsOrEmpty(task.Tags), now); err != nil {
 		r.s.logger.WithError(err).Errorf("Failed to insert task %s with status %s", task.ID, task.Status)
 		return err
 	}
 	return nil
 }
 
+// tagsOrEmpty avoids inserting NULL into the non nullable tags column
+func tagsOrEmpty(tags map[string]string) map[string]string {
+	if tags == nil {
+		return map[string]string{}
+	}
+	return tags
+}
+
+// GetTaskByID returns the task with the id, or nil when there is no such task
 func (r *TaskRepository) GetTaskByID(taskID uuid.UUID) (*TaskDTO, error) {
-	// Здесь будет логика получения задачи по ID из базы данных
-	return nil, nil
+	if r.s == nil {
+		return nil, nil
+	}
+	query := "SELECT toString(id), status, payload, ts FROM tasks WHERE id = $1 LIMIT 1"
+	var id, status, payload string
+	var ts time.Time
+	err := r.s.Client.conn.QueryRow(r.s.Client.ctx, query, taskID).Scan(&id, &status, &payload, &ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		r.s.logger.WithError(err).Errorf("Failed to get task %s", taskID)
+		return nil, err
+	}
+	return &TaskDTO{ID: id, Status: domain.TaskStatus(status), Payload: &payload, Ts: ts}, nil
 }
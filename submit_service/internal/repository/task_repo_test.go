@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	chproto "github.com/ClickHouse/ch-go/proto"
+	ch "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/column"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/proto"
+	"github.com/google/uuid"
+
+	"submit_service/internal/domain"
+)
+
+// recordingConn records the args of every Exec, the other methods are not used by the tests
+type recordingConn struct {
+	ch.Conn
+	mu    sync.Mutex
+	execs [][]any
+}
+
+func (c *recordingConn) Exec(_ context.Context, _ string, args ...any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.execs = append(c.execs, args)
+	return nil
+}
+
+// args returns the args of the last Exec
+func (c *recordingConn) args() []any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.execs[len(c.execs)-1]
+}
+
+func TestInsertTaskTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want map[string]string
+	}{
+		{"tags are written", map[string]string{"customer": "acme", "job": "nightly"}, map[string]string{"customer": "acme", "job": "nightly"}},
+		// the column is not nullable
+		{"no tags", nil, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordingConn{}
+			repo := NewTaskRepository(&Service{Client: &Client{ctx: context.Background(), conn: conn}})
+			payload := "x"
+			if err := repo.InsertTask(&domain.Task{ID: uuid.New(), Status: domain.StatusProcessing, Payload: &payload, Tags: tt.tags}); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := conn.args()[3].(map[string]string)
+			if !ok || got == nil || !maps.Equal(got, tt.want) {
+				t.Errorf("tags written = %#v, want %#v", conn.args()[3], tt.want)
+			}
+		})
+	}
+}
+
+// writeTaskRow answers a task query of a test ClickHouse with the task
+func writeTaskRow(t testing.TB, w http.ResponseWriter, task TaskDTO) {
+	block := proto.NewBlock()
+	for _, col := range []struct{ name, typ string }{
+		{"toString(id)", "String"},
+		{"status", "String"},
+		{"payload", "String"},
+		{"ts", "DateTime64(9)"},
+	} {
+		if err := block.AddColumn(col.name, column.Type(col.typ)); err != nil {
+			t.Fatalf("AddColumn() = %v", err)
+		}
+	}
+	if err := block.Append(task.ID, string(task.Status), *task.Payload, task.Ts); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+	buf := new(chproto.Buffer)
+	if err := block.Encode(buf, 54460); err != nil {
+		t.Fatalf("Encode() = %v", err)
+	}
+	w.Write(buf.Buf)
+}
+
+func TestGetTaskByID(t *testing.T) {
+	payload := "x"
+	stored := TaskDTO{ID: uuid.NewString(), Status: domain.StatusFailed, Payload: &payload, Ts: time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)}
+	broken := uuid.New()
+	c := testClickHouse(t, &Config{}, func(w http.ResponseWriter, _ *http.Request, query string) {
+		switch {
+		case !strings.HasPrefix(query, "SELECT"):
+		case strings.Contains(query, stored.ID):
+			writeTaskRow(t, w, stored)
+		case strings.Contains(query, broken.String()):
+			http.Error(w, "Code: 241. DB::Exception: Memory limit exceeded", http.StatusInternalServerError)
+		}
+	})
+	repo := NewTaskRepository(&Service{Client: c})
+
+	got, err := repo.GetTaskByID(uuid.MustParse(stored.ID))
+	if err != nil {
+		t.Fatalf("GetTaskByID() of a stored task = %v", err)
+	}
+	if got == nil || got.ID != stored.ID || got.Status != stored.Status || *got.Payload != payload || !got.Ts.Equal(stored.Ts) {
+		t.Errorf("GetTaskByID() = %+v, want %+v", got, stored)
+	}
+
+	if got, err := repo.GetTaskByID(uuid.New()); got != nil || err != nil {
+		t.Errorf("GetTaskByID() of an unknown task = %+v, %v, want nil, nil", got, err)
+	}
+	if _, err := repo.GetTaskByID(broken); err == nil {
+		t.Error("GetTaskByID() = nil error, want the ClickHouse error")
+	}
+	if got, err := NewTaskRepository(nil).GetTaskByID(uuid.New()); got != nil || err != nil {
+		t.Errorf("GetTaskByID() without a service = %+v, %v, want nil, nil", got, err)
+	}
+}
@@ -0,0 +1,130 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAttempts(t *testing.T) {
+	tests := []struct {
+		maxAttempts int
+		want        int
+	}{
+		{-1, 1},
+		{0, 1},
+		{1, 1},
+		{5, 5},
+	}
+	for _, tt := range tests {
+		if got := (Policy{MaxAttempts: tt.maxAttempts}).Attempts(); got != tt.want {
+			t.Errorf("Attempts() with MaxAttempts %d = %d, want %d", tt.maxAttempts, got, tt.want)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		attempt int
+		want    time.Duration
+	}{
+		{"first failure waits the base delay", Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 1, 100 * time.Millisecond},
+		{"doubles after the second failure", Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 2, 200 * time.Millisecond},
+		{"doubles after every failure", Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 4, 800 * time.Millisecond},
+		{"capped at the max delay", Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 5, time.Second},
+		{"stays at the max delay", Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 50, time.Second},
+		{"base above the max delay", Policy{BaseDelay: 2 * time.Second, MaxDelay: time.Second}, 1, time.Second},
+		{"no max delay", Policy{BaseDelay: time.Millisecond}, 11, 1024 * time.Millisecond},
+		{"no max delay stops doubling before it overflows", Policy{BaseDelay: time.Millisecond}, 200, time.Millisecond << 43},
+		{"no base delay", Policy{MaxDelay: time.Second}, 3, 0},
+		{"constant delay", Policy{BaseDelay: 500 * time.Millisecond, MaxDelay: 500 * time.Millisecond}, 3, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Delay(tt.attempt); got != tt.want {
+				t.Errorf("Delay(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJittered(t *testing.T) {
+	const d = time.Second
+	tests := []struct {
+		name   string
+		jitter float64
+	}{
+		{"no jitter", 0},
+		{"20 percent", 0.2},
+		{"full jitter", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Policy{Jitter: tt.jitter}
+			low := time.Duration(float64(d) * (1 - tt.jitter))
+			high := time.Duration(float64(d) * (1 + tt.jitter))
+			for range 1000 {
+				if got := p.jittered(d); got < low || got > high {
+					t.Fatalf("jittered(%s) = %s, want within [%s, %s]", d, got, low, high)
+				}
+			}
+		})
+	}
+	if got := (Policy{Jitter: 0.5}).jittered(0); got != 0 {
+		t.Errorf("jittered(0) = %s, want 0", got)
+	}
+}
+
+func TestDo(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name      string
+		policy    Policy
+		failures  int
+		wantCalls int
+		wantErr   error
+	}{
+		{"success needs no retry", Policy{MaxAttempts: 3}, 0, 1, nil},
+		{"retried until success", Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}, 2, 3, nil},
+		{"gives up after max attempts", Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}, 5, 3, errFailed},
+		{"zero policy calls once", Policy{}, 5, 1, errFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := tt.policy.Do(context.Background(), func() error {
+				calls++
+				if calls <= tt.failures {
+					return errFailed
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Do() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{MaxAttempts: 3, BaseDelay: time.Hour}
+	calls := 0
+	err := p.Do(ctx, func() error {
+		calls++
+		cancel()
+		return errors.New("failed")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() = %v, want %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
@@ -0,0 +1,56 @@
+package webapi
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+const _stackBufSize = 1 << 20
+
+type AdminHandler struct {
+	token string
+}
+
+func NewAdminHandler(token string) *AdminHandler {
+	return &AdminHandler{token: token}
+}
+
+// RequireToken allows the request only with a matching "Authorization: Bearer <token>" header.
+// Admin endpoints are disabled when no token is configured.
+func (ah *AdminHandler) RequireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ah.token == "" {
+			writeError(w, http.StatusForbidden, codeForbidden, "admin endpoints are disabled")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(ah.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (ah *AdminHandler) Stacks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	buf := make([]byte, _stackBufSize)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
@@ -0,0 +1,59 @@
+package webapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRecentLogsRequireAdminToken(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		token      string
+		auth       string
+		wantStatus int
+	}{
+		{"admin endpoints disabled", "", "", http.StatusForbidden},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer nope", http.StatusUnauthorized},
+		{"matching token", "secret", "Bearer secret", http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			api := New(context.Background(), &Config{AdminToken: tt.token}, nil, nil, nil, nil, nil, log.New())
+			r := httptest.NewRequest(http.MethodGet, _recentLogsPath, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			api.server.Handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestStacks(t *testing.T) {
+	api := New(context.Background(), &Config{AdminToken: "secret"}, nil, nil, nil, nil, nil, log.New())
+	r := httptest.NewRequest(http.MethodGet, _adminStacksPath, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+	body := rec.Body.String()
+	for _, marker := range []string{"goroutine ", "[running]", "TestStacks"} {
+		if !strings.Contains(body, marker) {
+			t.Errorf("stack dump does not contain %q", marker)
+		}
+	}
+}
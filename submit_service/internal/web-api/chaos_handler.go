@@ -0,0 +1,64 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"submit_service/internal/chaos"
+)
+
+type ChaosHandler struct {
+	state *chaos.State
+}
+
+func NewChaosHandler(state *chaos.State) *ChaosHandler {
+	return &ChaosHandler{state: state}
+}
+
+type chaosRequest struct {
+	// FailNext forces the next N task produce calls to fail.
+	FailNext int64 `json:"fail_next"`
+	// QueueDelay slows down every task produce call, e.g. "500ms".
+	QueueDelay string `json:"queue_delay"`
+	// FailClickHouseWrites makes all ClickHouse writes return an error.
+	FailClickHouseWrites bool `json:"fail_clickhouse_writes"`
+}
+
+// HandleChaos injects failures on POST, clears them on DELETE and reports them on GET.
+func (ch *ChaosHandler) HandleChaos(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req chaosRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "invalid chaos request body")
+			return
+		}
+		if req.FailNext < 0 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "fail_next must not be negative")
+			return
+		}
+		var delay time.Duration
+		if req.QueueDelay != "" {
+			d, err := time.ParseDuration(req.QueueDelay)
+			if err != nil || d < 0 {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "queue_delay must be a non-negative duration")
+				return
+			}
+			delay = d
+		}
+		ch.state.FailNext(req.FailNext)
+		ch.state.SetQueueDelay(delay)
+		ch.state.SetFailWrites(req.FailClickHouseWrites)
+	case http.MethodDelete:
+		ch.state.Reset()
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ch.state.Snapshot())
+}
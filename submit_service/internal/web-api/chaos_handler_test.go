@@ -0,0 +1,75 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"submit_service/internal/chaos"
+)
+
+func serveChaos(ch *ChaosHandler, method, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, _chaosPath, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	ch.HandleChaos(rec, r)
+	return rec
+}
+
+func TestHandleChaos(t *testing.T) {
+	state := chaos.New()
+	ch := NewChaosHandler(state)
+
+	rec := serveChaos(ch, http.MethodPost, `{"fail_next":1,"queue_delay":"5ms","fail_clickhouse_writes":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !state.ShouldFail() || state.ShouldFail() {
+		t.Error("fail_next 1 did not fail exactly the next call")
+	}
+	if state.QueueDelay() != 5*time.Millisecond {
+		t.Errorf("queue delay = %s, want 5ms", state.QueueDelay())
+	}
+	if state.WriteErr() == nil {
+		t.Error("ClickHouse writes do not fail")
+	}
+
+	rec = serveChaos(ch, http.MethodGet, "")
+	var snapshot map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot["fail_clickhouse_writes"] != true || snapshot["queue_delay"] != "5ms" {
+		t.Errorf("GET = %v, want the injected failures", snapshot)
+	}
+
+	if rec := serveChaos(ch, http.MethodDelete, ""); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if state.QueueDelay() != 0 || state.WriteErr() != nil {
+		t.Error("DELETE did not clear the injected failures")
+	}
+}
+
+func TestHandleChaosInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"invalid body", http.MethodPost, "{", http.StatusBadRequest},
+		{"negative fail_next", http.MethodPost, `{"fail_next":-1}`, http.StatusBadRequest},
+		{"invalid queue_delay", http.MethodPost, `{"queue_delay":"soon"}`, http.StatusBadRequest},
+		{"negative queue_delay", http.MethodPost, `{"queue_delay":"-1s"}`, http.StatusBadRequest},
+		{"unsupported method", http.MethodPut, "", http.StatusMethodNotAllowed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveChaos(NewChaosHandler(chaos.New()), tt.method, tt.body); rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
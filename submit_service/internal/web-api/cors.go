@@ -0,0 +1,69 @@
+package webapi
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	_defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+	_defaultCORSHeaders = []string{"Content-Type", "Authorization"}
+)
+
+// CORSConfig allows browser clients on other origins, no origins keeps the API same-origin only
+type CORSConfig struct {
+	// AllowedOrigins are full origins like "https://app.example.com", "*" allows any
+	AllowedOrigins []string      `mapstructure:"allowed_origins"`
+	AllowedMethods []string      `mapstructure:"allowed_methods"`
+	AllowedHeaders []string      `mapstructure:"allowed_headers"`
+	ExposedHeaders []string      `mapstructure:"exposed_headers"`
+	MaxAge         time.Duration `mapstructure:"max_age"`
+}
+
+// withCORS adds CORS headers for allowed origins and answers their preflight
+// requests with 204 before they reach the routes
+func withCORS(next http.Handler, conf CORSConfig) http.Handler {
+	if len(conf.AllowedOrigins) == 0 {
+		return next
+	}
+	methods := strings.Join(orDefault(conf.AllowedMethods, _defaultCORSMethods), ", ")
+	headers := strings.Join(orDefault(conf.AllowedHeaders, _defaultCORSHeaders), ", ")
+	exposed := strings.Join(conf.ExposedHeaders, ", ")
+	anyOrigin := slices.Contains(conf.AllowedOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || (!anyOrigin && !slices.Contains(conf.AllowedOrigins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		if exposed != "" {
+			h.Set("Access-Control-Expose-Headers", exposed)
+		}
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Methods", methods)
+		h.Set("Access-Control-Allow-Headers", headers)
+		if conf.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(conf.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func orDefault(values, fallback []string) []string {
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
@@ -0,0 +1,102 @@
+package webapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusAccepted) })
+	configured := CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{http.MethodPost},
+		AllowedHeaders: []string{"Content-Type", "X-Task-Tags"},
+		ExposedHeaders: []string{"Retry-After"},
+		MaxAge:         10 * time.Minute,
+	}
+	tests := []struct {
+		name        string
+		conf        CORSConfig
+		method      string
+		origin      string
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "preflight",
+			conf:       configured,
+			method:     http.MethodOptions,
+			origin:     "https://app.example.com",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example.com",
+				"Access-Control-Allow-Methods":  "POST",
+				"Access-Control-Allow-Headers":  "Content-Type, X-Task-Tags",
+				"Access-Control-Expose-Headers": "Retry-After",
+				"Access-Control-Max-Age":        "600",
+			},
+		},
+		{
+			name:       "preflight with the defaults",
+			conf:       CORSConfig{AllowedOrigins: []string{"*"}},
+			method:     http.MethodOptions,
+			origin:     "https://other.example.com",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://other.example.com",
+				"Access-Control-Allow-Methods": "GET, POST, DELETE",
+				"Access-Control-Allow-Headers": "Content-Type, Authorization",
+				"Access-Control-Max-Age":       "",
+			},
+		},
+		{
+			name:       "request from an allowed origin",
+			conf:       configured,
+			method:     http.MethodPost,
+			origin:     "https://app.example.com",
+			wantStatus: http.StatusAccepted,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+		{
+			name:        "origin not allowed",
+			conf:        configured,
+			method:      http.MethodOptions,
+			origin:      "https://evil.example.com",
+			wantStatus:  http.StatusAccepted,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:        "disabled by default",
+			conf:        CORSConfig{},
+			method:      http.MethodOptions,
+			origin:      "https://app.example.com",
+			wantStatus:  http.StatusAccepted,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/submit", nil)
+			r.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rec := httptest.NewRecorder()
+			withCORS(next, tt.conf).ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package webapi
 
 import (
+	"context"
 	"encoding/json"
 	"math"
 	"net/http"
@@ -10,25 +11,25 @@ import (
 )
 
 type CPULoadHandler struct {
+	// ctx is cancelled on API shutdown to stop running load
+	ctx context.Context
 }
 
-func NewCPULoadHandler() *CPULoadHandler {
-	return &CPULoadHandler{}
+func NewCPULoadHandler(ctx context.Context) *CPULoadHandler {
+	return &CPULoadHandler{ctx: ctx}
 }
 
-func (h *CPULoadHandler) CPULoadHandler(w http.ResponseWriter, r *http.Request) {
-	workers, err := parsePositiveInt(r.URL.Query().Get("workers"), runtime.NumCPU())
+func (h *CPULoadHandler) CPULoadHandler(w http.ResponseWriter, r *http.Request) error {
+	workers, err := queryPositiveInt(r, "workers", runtime.NumCPU(), 0)
 	if err != nil {
-		http.Error(w, "workers must be a positive integer", http.StatusBadRequest)
-		return
+		return err
 	}
-	seconds, err := parsePositiveInt(r.URL.Query().Get("seconds"), 30)
+	seconds, err := queryPositiveInt(r, "seconds", 30, 0)
 	if err != nil {
-		http.Error(w, "seconds must be a positive integer", http.StatusBadRequest)
-		return
+		return err
 	}
 
-	go runCPULoad(workers, time.Duration(seconds)*time.Second)
+	go runCPULoad(h.ctx, workers, time.Duration(seconds)*time.Second)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusAccepted)
@@ -37,10 +38,14 @@ func (h *CPULoadHandler) CPULoadHandler(w http.ResponseWriter, r *http.Request)
 		"workers": workers,
 		"seconds": seconds,
 	})
+	return nil
 }
 
-func runCPULoad(workers int, duration time.Duration) {
-	deadline := time.Now().Add(duration)
+func runCPULoad(ctx context.Context, workers int, duration time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	done := ctx.Done()
+
 	var wg sync.WaitGroup
 	wg.Add(workers)
 
@@ -48,7 +53,12 @@ func runCPULoad(workers int, duration time.Duration) {
 		go func(offset int) {
 			defer wg.Done()
 			value := float64(offset + 1)
-			for time.Now().Before(deadline) {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
 				value = math.Sqrt(value*1.000001 + 123.456)
 				if value > 100000 {
 					value = 1
@@ -0,0 +1,18 @@
+package webapi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCPULoadStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	startedAt := time.Now()
+	runCPULoad(ctx, 2, time.Hour)
+	if elapsed := time.Since(startedAt); elapsed > time.Second {
+		t.Errorf("cpu load cancelled after 50ms ran %s, want it to stop right away", elapsed)
+	}
+}
@@ -0,0 +1,153 @@
+package webapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	codeBadRequest       = "bad_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeInternal         = "internal_error"
+	codeQueueFull        = "queue_full"
+	codeTooManyRequests  = "too_many_requests"
+	codeShuttingDown     = "shutting_down"
+	codeStartingUp       = "starting_up"
+	codeTooLarge         = "payload_too_large"
+	// codeUnsupportedMediaType is returned with 415
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeDuplicate            = "duplicate"
+	codeTimeout              = "timeout"
+)
+
+type errorBody struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+// writeError writes {"error": {"code": ..., "message": ...}} with the given status.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeErrorDetails(w, status, code, msg, nil)
+}
+
+// writeErrorDetails writes the error envelope with extra details for clients, e.g. a related task id
+func writeErrorDetails(w http.ResponseWriter, status int, code, msg string, details map[string]any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorEnvelope{Error: errorBody{Code: code, Message: msg, Details: details}})
+}
+
+// APIError is an error carrying its HTTP status and error code, handlers
+// wrapped with withErrors return it instead of writing the response.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	// Details are sent along, e.g. the offending param of a bad request
+	Details map[string]any
+	// RetryAfter is sent as the Retry-After header when set
+	RetryAfter string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Is matches errors of the same kind regardless of the message
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Status == e.Status && t.Code == e.Code
+}
+
+func (e *APIError) withMessage(msg string) *APIError {
+	return &APIError{Status: e.Status, Code: e.Code, Message: msg}
+}
+
+var (
+	ErrQueueFull        = &APIError{Status: http.StatusServiceUnavailable, Code: codeQueueFull, Message: "Task queue is full, try again later"}
+	ErrShuttingDown     = &APIError{Status: http.StatusServiceUnavailable, Code: codeShuttingDown, Message: "shutting down"}
+	ErrStartingUp       = &APIError{Status: http.StatusServiceUnavailable, Code: codeStartingUp, Message: "starting up, try again later"}
+	ErrRateLimited      = &APIError{Status: http.StatusTooManyRequests, Code: codeTooManyRequests, Message: "Submit rate limit exceeded, try again later"}
+	ErrInvalidParam     = &APIError{Status: http.StatusBadRequest, Code: codeBadRequest, Message: "invalid parameter"}
+	ErrNotFound         = &APIError{Status: http.StatusNotFound, Code: codeNotFound, Message: "not found"}
+	ErrConflict         = &APIError{Status: http.StatusConflict, Code: codeConflict, Message: "conflict"}
+	ErrMethodNotAllowed = &APIError{Status: http.StatusMethodNotAllowed, Code: codeMethodNotAllowed, Message: "method not allowed"}
+)
+
+// InvalidParam returns an ErrInvalidParam with a specific message.
+func InvalidParam(format string, args ...any) error {
+	return ErrInvalidParam.withMessage(fmt.Sprintf(format, args...))
+}
+
+// internalError hides the cause from clients, it is logged instead
+type internalError struct {
+	msg   string
+	cause error
+}
+
+func (e *internalError) Error() string {
+	if e.cause == nil {
+		return e.msg
+	}
+	return e.msg + ": " + e.cause.Error()
+}
+
+func (e *internalError) Unwrap() error {
+	return e.cause
+}
+
+// Internal returns an error answered with 500 and msg, cause is only logged.
+func Internal(msg string, cause error) error {
+	return &internalError{msg: msg, cause: cause}
+}
+
+// StatusOf returns the HTTP status an error is answered with.
+func StatusOf(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// withErrors adapts a handler returning an error, the error is written as the JSON envelope
+func withErrors(h func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			writeAPIError(w, r, err)
+		}
+	}
+}
+
+// writeAPIError writes err as the JSON envelope, errors other than APIError are logged and answered with 500
+func writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter != "" {
+			w.Header().Set("Retry-After", apiErr.RetryAfter)
+		}
+		writeErrorDetails(w, apiErr.Status, apiErr.Code, apiErr.Message, apiErr.Details)
+		return
+	}
+	msg := "internal error"
+	var intErr *internalError
+	if errors.As(err, &intErr) {
+		msg = intErr.msg
+	}
+	log.WithError(err).WithField("path", r.URL.Path).Error("request failed")
+	writeError(w, http.StatusInternalServerError, codeInternal, msg)
+}
@@ -0,0 +1,105 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"submit_service/internal/services"
+)
+
+func TestInternalErrorWithoutCause(t *testing.T) {
+	if got := Internal("Metrics are disabled", nil).Error(); got != "Metrics are disabled" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := Internal("Failed to read", errors.New("eof")).Error(); got != "Failed to read: eof" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestWithErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantMsg    string
+	}{
+		{"api error", InvalidParam("limit must be positive"), http.StatusBadRequest, codeBadRequest, "limit must be positive"},
+		{"internal error hides the cause", Internal("Failed to read", errors.New("secret dsn")), http.StatusInternalServerError, codeInternal, "Failed to read"},
+		{"internal error without cause", Internal("Failed to read", nil), http.StatusInternalServerError, codeInternal, "Failed to read"},
+		{"plain error", errors.New("boom"), http.StatusInternalServerError, codeInternal, "internal error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withErrors(func(http.ResponseWriter, *http.Request) error { return tt.err })
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body errorEnvelope
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != tt.wantCode || body.Error.Message != tt.wantMsg {
+				t.Errorf("error = %+v, want %s %q", body.Error, tt.wantCode, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestResetMetricsWhenDisabled(t *testing.T) {
+	mh := NewMetricsHandler(nil, nil)
+	rec := httptest.NewRecorder()
+	withErrors(mh.ResetMetrics)(rec, httptest.NewRequest(http.MethodPost, "/admin/metrics/reset", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	fullQueue := NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, 0, nil, 0, 0, nil)
+	fullQueue.sem = make(chan struct{})
+	cpuLoad := NewCPULoadHandler(context.Background())
+
+	tests := []struct {
+		name       string
+		serve      func() *httptest.ResponseRecorder
+		wantStatus int
+		wantCode   string
+	}{
+		{"submit queue full", func() *httptest.ResponseRecorder { return submit(fullQueue) }, http.StatusServiceUnavailable, codeQueueFull},
+		{"invalid load param", func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			withErrors(cpuLoad.CPULoadHandler)(rec, httptest.NewRequest(http.MethodPost, "/load/cpu?workers=many", nil))
+			return rec
+		}, http.StatusBadRequest, codeBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := tt.serve()
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Content-Type = %q, want JSON", ct)
+			}
+			var body errorEnvelope
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != tt.wantCode || body.Error.Message == "" {
+				t.Errorf("error = %+v, want code %s with a message", body.Error, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package webapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipResponses(t *testing.T) {
+	large := make(map[string]int)
+	for i := range 200 {
+		large[fmt.Sprintf("metric_%d", i)] = i
+	}
+	small := map[string]int{"queue_depth": 3}
+
+	tests := []struct {
+		name           string
+		body           map[string]int
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"large body compressed", large, "gzip", true},
+		{"gzip among other encodings", large, "br;q=1.0, gzip;q=0.8", true},
+		{"small body sent as is", small, "gzip", false},
+		{"gzip not accepted", large, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := gzipResponses(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(tt.body)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip %v", rec.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+			var body io.Reader = rec.Body
+			if gotGzip {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			var got map[string]int
+			if err := json.NewDecoder(body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			want, _ := json.Marshal(tt.body)
+			if gotJSON, _ := json.Marshal(got); !bytes.Equal(gotJSON, want) {
+				t.Errorf("body = %s, want %s", gotJSON, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type RecentLogsReader interface {
+	Recent(n int) []map[string]any
+}
+
+type LogsHandler struct {
+	logs RecentLogsReader
+}
+
+func NewLogsHandler(logs RecentLogsReader) *LogsHandler {
+	return &LogsHandler{logs: logs}
+}
+
+func (lh *LogsHandler) RecentLogs(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return ErrMethodNotAllowed
+	}
+	n, err := queryPositiveInt(r, "n", 100, 0)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]map[string]any, 0)
+	if lh.logs != nil {
+		entries = lh.logs.Recent(n)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+	return nil
+}
@@ -1,34 +1,58 @@
 package webapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"runtime"
-	"strconv"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	_defaultMaxLoadMB      = 1024
+	_defaultMaxTotalLoadMB = 2048
+	_megabyte              = 1024 * 1024
+)
+
 type MemoryLoadHandler struct {
+	// ctx is cancelled on API shutdown to release allocated memory
+	ctx context.Context
+	// maxMB caps a single request, maxTotalMB caps all running loads together
+	maxMB      int
+	maxTotalMB int64
+	inUseMB    atomic.Int64
 }
 
-func NewMemoryLoadHandler() *MemoryLoadHandler {
-	return &MemoryLoadHandler{}
+func NewMemoryLoadHandler(ctx context.Context, maxMB, maxTotalMB int) *MemoryLoadHandler {
+	if maxMB <= 0 {
+		maxMB = _defaultMaxLoadMB
+	}
+	if maxTotalMB <= 0 {
+		maxTotalMB = _defaultMaxTotalLoadMB
+	}
+	return &MemoryLoadHandler{ctx: ctx, maxMB: maxMB, maxTotalMB: int64(maxTotalMB)}
 }
 
-func (h *MemoryLoadHandler) MemoryLoadHandler(w http.ResponseWriter, r *http.Request) {
-	megabytes, err := parsePositiveInt(r.URL.Query().Get("mb"), 128)
+func (h *MemoryLoadHandler) MemoryLoadHandler(w http.ResponseWriter, r *http.Request) error {
+	megabytes, err := queryPositiveInt(r, "mb", 128, h.maxMB)
 	if err != nil {
-		http.Error(w, "mb must be a positive integer", http.StatusBadRequest)
-		return
+		return err
 	}
-	seconds, err := parsePositiveInt(r.URL.Query().Get("seconds"), 30)
+	seconds, err := queryPositiveInt(r, "seconds", 30, 0)
 	if err != nil {
-		http.Error(w, "seconds must be a positive integer", http.StatusBadRequest)
-		return
+		return err
+	}
+
+	if !h.reserve(megabytes) {
+		return &APIError{Status: http.StatusTooManyRequests, Code: codeTooManyRequests, Message: fmt.Sprintf("total memory load must not exceed %d mb", h.maxTotalMB)}
 	}
 
-	go runMemoryLoad(megabytes, time.Duration(seconds)*time.Second)
+	go func() {
+		defer h.inUseMB.Add(-int64(megabytes))
+		runMemoryLoad(h.ctx, megabytes, time.Duration(seconds)*time.Second)
+	}()
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusAccepted)
@@ -37,38 +61,56 @@ func (h *MemoryLoadHandler) MemoryLoadHandler(w http.ResponseWriter, r *http.Req
 		"mb":      megabytes,
 		"seconds": seconds,
 	})
+	return nil
+}
+
+// reserve accounts megabytes against the total cap, it fails when the cap would be exceeded
+func (h *MemoryLoadHandler) reserve(megabytes int) bool {
+	for {
+		inUse := h.inUseMB.Load()
+		if inUse+int64(megabytes) > h.maxTotalMB {
+			return false
+		}
+		if h.inUseMB.CompareAndSwap(inUse, inUse+int64(megabytes)) {
+			return true
+		}
+	}
 }
 
-func runMemoryLoad(megabytes int, duration time.Duration) {
-	chunk := make([]byte, megabytes*1024*1024)
-	for i := 0; i < len(chunk); i += 4096 {
-		chunk[i] = byte(i)
+func runMemoryLoad(ctx context.Context, megabytes int, duration time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	// allocate in 1mb chunks instead of one huge contiguous slice,
+	// checking for cancellation between chunks so a big load stops promptly
+	chunks := make([][]byte, megabytes)
+	for i := range chunks {
+		if ctx.Err() != nil {
+			return
+		}
+		chunks[i] = make([]byte, _megabyte)
+		for j := 0; j < _megabyte; j += 4096 {
+			chunks[i][j] = byte(j)
+		}
 	}
 
 	ticker := time.NewTicker(300 * time.Millisecond)
 	defer ticker.Stop()
 
-	timeout := time.After(duration)
 	for {
 		select {
-		case <-timeout:
-			runtime.KeepAlive(chunk)
+		case <-ctx.Done():
+			runtime.KeepAlive(chunks)
 			return
 		case <-ticker.C:
-			for i := 0; i < len(chunk); i += 4096 {
-				chunk[i]++
+			for _, chunk := range chunks {
+				if ctx.Err() != nil {
+					break
+				}
+				for i := 0; i < len(chunk); i += 4096 {
+					chunk[i]++
+				}
 			}
 		}
 	}
 }
-
-func parsePositiveInt(raw string, fallback int) (int, error) {
-	if raw == "" {
-		return fallback, nil
-	}
-	v, err := strconv.Atoi(raw)
-	if err != nil || v <= 0 {
-		return 0, fmt.Errorf("value must be a positive integer")
-	}
-	return v, nil
-}
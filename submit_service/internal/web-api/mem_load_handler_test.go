@@ -0,0 +1,70 @@
+package webapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serveMemoryLoad(h *MemoryLoadHandler, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	withErrors(h.MemoryLoadHandler)(rec, httptest.NewRequest(http.MethodPost, _memoryLoadPath+query, nil))
+	return rec
+}
+
+func TestMemoryLoadPerRequestCap(t *testing.T) {
+	h := NewMemoryLoadHandler(context.Background(), 2, 10)
+	rec := serveMemoryLoad(h, "?mb=3&seconds=1")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if got := errorCode(t, rec); got != codeBadRequest {
+		t.Errorf("code = %q, want %q", got, codeBadRequest)
+	}
+	if got := h.inUseMB.Load(); got != 0 {
+		t.Errorf("in use = %d mb after a rejected load, want 0", got)
+	}
+}
+
+func TestMemoryLoadTotalCap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewMemoryLoadHandler(ctx, 2, 3)
+
+	if rec := serveMemoryLoad(h, "?mb=2&seconds=3600"); rec.Code != http.StatusAccepted {
+		t.Fatalf("first load status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	rec := serveMemoryLoad(h, "?mb=2&seconds=3600")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("load past the total status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := errorCode(t, rec); got != codeTooManyRequests {
+		t.Errorf("code = %q, want %q", got, codeTooManyRequests)
+	}
+	if rec := serveMemoryLoad(h, "?mb=1&seconds=3600"); rec.Code != http.StatusAccepted {
+		t.Errorf("load within the total status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+
+	// ended loads give their memory back
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for h.inUseMB.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("in use = %d mb after the loads ended, want 0", h.inUseMB.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMemoryLoadStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	startedAt := time.Now()
+	runMemoryLoad(ctx, 8, time.Hour)
+	if elapsed := time.Since(startedAt); elapsed > time.Second {
+		t.Errorf("memory load cancelled after 50ms ran %s, want it to stop right away", elapsed)
+	}
+}
@@ -1,15 +1,22 @@
 package webapi
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"submit_service/internal/metrics"
 	"submit_service/internal/services"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type MetricsHandler struct {
 	taskService *services.TaskService
 	metrics     *metrics.Service
+	// nilWarnOnce keeps a missing dependency from flooding the logs on every scrape
+	nilWarnOnce sync.Once
 }
 
 func NewMetricsHandler(taskSrv *services.TaskService, m *metrics.Service) *MetricsHandler {
@@ -19,22 +26,78 @@ func NewMetricsHandler(taskSrv *services.TaskService, m *metrics.Service) *Metri
 	}
 }
 
-func (mh *MetricsHandler) LogMetrics(w http.ResponseWriter, r *http.Request) {
-	resp := mh.metrics.Recorder.GetMetrics()
-	tasks, err := mh.taskService.GetAllNotProcessedTasks()
+func (mh *MetricsHandler) LogMetrics(w http.ResponseWriter, r *http.Request) error {
+	resp := make(map[string]any)
+	if mh.metrics != nil {
+		resp = mh.metrics.Recorder.GetMetrics()
+	}
+	if mh.taskService != nil {
+		tasks, err := mh.taskService.GetAllNotProcessedTasks()
+		if err != nil {
+			return Internal("Failed to get not processed tasks", err)
+		}
+		resp["not_processed_tasks_count"] = uint64(len(tasks))
+	} else {
+		resp["not_processed_tasks_count"] = uint64(0)
+	}
+	if mh.metrics == nil || mh.taskService == nil {
+		mh.nilWarnOnce.Do(func() {
+			log.Warn("metrics handler: metrics or task service is nil, /metrics reports partial data")
+		})
+	}
+
+	// marshal before touching the response, so a failure can still be reported as a clean 500
+	formattedResponse, err := marshalMetrics(resp, r.URL.Query().Get("pretty") != "false")
 	if err != nil {
-		http.Error(w, "Failed to get not processed tasks", http.StatusInternalServerError)
-		return
+		return Internal("Failed to format response", err)
 	}
-	resp["not_processed_tasks_count"] = uint64(len(tasks))
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	formattedResponse, err := json.MarshalIndent(resp, "", "  ")
-	status := http.StatusAccepted
-	if err != nil {
-		status = http.StatusInternalServerError
-		http.Error(w, "Failed to format response", status)
-		return
-	}
 	w.Write(formattedResponse)
+	return nil
+}
+
+// ResetMetrics restarts the high-water marks, e.g. max_queue_depth, from the current values
+func (mh *MetricsHandler) ResetMetrics(w http.ResponseWriter, r *http.Request) error {
+	if mh.metrics == nil {
+		return ErrNotFound.withMessage("Metrics are disabled")
+	}
+	mh.metrics.Recorder.ResetMaxQueueDepth()
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// sampleQueueDepth periodically records the queue depth and the age of the oldest
+// pending task until ctx is done
+func sampleQueueDepth(ctx context.Context, taskBus TaskBus, recorder *metrics.Recorder, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := taskBus.QueueStats(ctx)
+		if err != nil {
+			log.WithError(err).Debug("failed to sample queue depth")
+		} else {
+			recorder.SetQueueDepth(stats.Depth)
+			var age time.Duration
+			if !stats.OldestEnqueuedAt.IsZero() {
+				age = time.Since(stats.OldestEnqueuedAt)
+			}
+			recorder.SetOldestPendingAge(age)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// marshalMetrics indents the response for humans, machine consumers can ask for compact JSON with ?pretty=false
+func marshalMetrics(resp map[string]any, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(resp, "", "  ")
+	}
+	return json.Marshal(resp)
 }
@@ -0,0 +1,118 @@
+package webapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"submit_service/internal/metrics"
+)
+
+func TestLogMetricsPretty(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantIndent bool
+	}{
+		{"pretty by default", "", true},
+		{"pretty on request", "?pretty=true", true},
+		{"compact", "?pretty=false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mh := NewMetricsHandler(nil, nil)
+			rec := httptest.NewRecorder()
+			withErrors(mh.LogMetrics)(rec, httptest.NewRequest(http.MethodGet, "/metrics"+tt.query, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			body := rec.Body.Bytes()
+			if got := bytes.Contains(body, []byte("\n  ")); got != tt.wantIndent {
+				t.Errorf("indented = %v, want %v: %s", got, tt.wantIndent, body)
+			}
+			var resp map[string]any
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := resp["not_processed_tasks_count"]; !ok {
+				t.Errorf("response %s misses not_processed_tasks_count", body)
+			}
+		})
+	}
+}
+
+func TestLogMetricsMarshalError(t *testing.T) {
+	m := metrics.New(nil)
+	// NaN has no JSON encoding, so the response can not be marshaled
+	if err := m.Recorder.AddGaugeFunc("test", "nan_value", "A gauge JSON can not encode.", math.NaN); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		prometheus.Unregister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Subsystem: "test", Name: "nan_value", Help: "A gauge JSON can not encode."}, nil))
+	})
+
+	mh := NewMetricsHandler(nil, m)
+	rec := httptest.NewRecorder()
+	withErrors(mh.LogMetrics)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	// nothing of the metrics was written before the error envelope
+	var body errorEnvelope
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("body is not a single error envelope: %v", err)
+	}
+	if body.Error.Code != codeInternal {
+		t.Errorf("code = %q, want %q", body.Error.Code, codeInternal)
+	}
+}
+
+func TestLogMetricsWithNilDependencies(t *testing.T) {
+	hooks := log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(hooks) })
+	hook := logtest.NewGlobal()
+
+	tests := []struct {
+		name        string
+		m           *metrics.Service
+		wantMetrics bool
+	}{
+		{"no task service", metrics.New(nil), true},
+		{"no metrics and no task service", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook.Reset()
+			mh := NewMetricsHandler(nil, tt.m)
+			for i := 0; i < 3; i++ {
+				rec := httptest.NewRecorder()
+				withErrors(mh.LogMetrics)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+				}
+				var resp map[string]any
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if got := resp["not_processed_tasks_count"]; got != float64(0) {
+					t.Errorf("not_processed_tasks_count = %v, want 0", got)
+				}
+				if _, ok := resp["submitted_tasks_total"]; ok != tt.wantMetrics {
+					t.Errorf("submitted_tasks_total reported = %t, want %t", ok, tt.wantMetrics)
+				}
+			}
+			if got := len(hook.AllEntries()); got != 1 {
+				t.Errorf("%d warnings logged over 3 scrapes, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package webapi
+
+import (
+	"net/http"
+	"time"
+
+	"submit_service/internal/metrics"
+)
+
+// instrument tracks inflight requests and request durations
+func instrument(next http.Handler, m *metrics.Service) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedAt := time.Now()
+		m.Recorder.AddInflightRequests(1)
+		defer func() {
+			m.Recorder.AddInflightRequests(-1)
+			m.Recorder.ObserveHTTPDuration(time.Since(startedAt))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,163 @@
+package webapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_overflowTimeout          = 2 * time.Second
+	_overflowBreakerThreshold = 5
+	_overflowBreakerCooldown  = 30 * time.Second
+	// _overflowForwardedHeader marks a forwarded submit, the receiver does not forward
+	// it again so two instances overflowing into each other do not loop
+	_overflowForwardedHeader = "X-Overflow-Forwarded"
+)
+
+var errBreakerOpen = errors.New("overflow circuit breaker is open")
+
+// OverflowForwarder sends tasks which do not fit into the local queue to another endpoint
+type OverflowForwarder struct {
+	url     string
+	client  *http.Client
+	breaker *circuitBreaker
+}
+
+func NewOverflowForwarder(forwardURL string) *OverflowForwarder {
+	return &OverflowForwarder{
+		url:     forwardURL,
+		client:  &http.Client{Timeout: _overflowTimeout},
+		breaker: newCircuitBreaker(_overflowBreakerThreshold, _overflowBreakerCooldown),
+	}
+}
+
+// Forward posts the whole submission, the payload with the attached file, and the task
+// options of r to the overflow endpoint
+func (f *OverflowForwarder) Forward(ctx context.Context, r *http.Request, sub *submission) error {
+	body, contentType, err := encodeSubmission(r, sub)
+	if err != nil {
+		return err
+	}
+	if !f.breaker.Allow() {
+		return errBreakerOpen
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, body)
+	if err != nil {
+		f.breaker.Failure()
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(_overflowForwardedHeader, "1")
+	for _, h := range []string{_taskTagsHeader, _taskTimeoutHeader, _taskDependsOnHeader, _taskRunAtHeader, _taskDelayHeader} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		f.breaker.Failure()
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		f.breaker.Failure()
+		return fmt.Errorf("overflow endpoint responded with %d", resp.StatusCode)
+	}
+	f.breaker.Success()
+	return nil
+}
+
+// encodeSubmission encodes sub with the option fields of r, as multipart when a file is
+// attached and form-encoded otherwise
+func encodeSubmission(r *http.Request, sub *submission) (io.Reader, string, error) {
+	fields := url.Values{"payload": {sub.Payload}}
+	for _, field := range []string{"depends_on", "run_at"} {
+		if v := r.FormValue(field); v != "" {
+			fields.Set(field, v)
+		}
+	}
+	if sub.File == nil {
+		return strings.NewReader(fields.Encode()), "application/x-www-form-urlencoded", nil
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for field := range fields {
+		if err := mw.WriteField(field, fields.Get(field)); err != nil {
+			return nil, "", err
+		}
+	}
+	fw, err := mw.CreateFormFile(_uploadField, _uploadField)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := fw.Write(sub.File); err != nil {
+		return nil, "", err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, mw.FormDataContentType(), nil
+}
+
+// forwarded reports whether the submit was forwarded by another instance's overflow
+func forwarded(r *http.Request) bool {
+	return r.Header.Get(_overflowForwardedHeader) != ""
+}
+
+// circuitBreaker opens after threshold consecutive failures. After cooldown it is
+// half-open and lets a single probe through, the probe's result closes or reopens it.
+type circuitBreaker struct {
+	mux       *sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	// probing is set while the half-open probe is in flight
+	probing bool
+	now     func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{mux: &sync.Mutex{}, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func (b *circuitBreaker) Allow() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) Success() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+}
+
+func (b *circuitBreaker) Failure() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+		b.failures, b.probing = 0, false
+	}
+}
@@ -0,0 +1,144 @@
+package webapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"submit_service/internal/services"
+)
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	if !b.Allow() {
+		t.Fatal("breaker open below the threshold")
+	}
+	b.Failure()
+	if b.Allow() {
+		t.Fatal("breaker closed after threshold failures")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.Allow() {
+		t.Fatal("no probe after the cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("second call let through while the probe is in flight")
+	}
+
+	// a failed probe reopens at once
+	b.Failure()
+	if b.Allow() {
+		t.Fatal("breaker closed after a failed probe")
+	}
+	now = now.Add(time.Minute)
+	if !b.Allow() {
+		t.Fatal("no probe after the second cooldown")
+	}
+
+	// a successful probe closes
+	b.Success()
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("call %d rejected after a successful probe", i)
+		}
+	}
+}
+
+func TestForwardMarksRequest(t *testing.T) {
+	var marked atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		marked.Store(forwarded(r))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	f := NewOverflowForwarder(srv.URL)
+	r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+	if err := f.Forward(context.Background(), r, &submission{Payload: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if !marked.Load() {
+		t.Error("forwarded submit is not marked")
+	}
+}
+
+func TestForwardedSubmitIsNotForwardedAgain(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	th := NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, time.Second, NewOverflowForwarder(srv.URL), 0, 0, nil)
+	for i := 0; i < cap(th.sem); i++ {
+		th.sem <- struct{}{}
+	}
+
+	rec := submitWith(th, func(r *http.Request) { r.Header.Set(_overflowForwardedHeader, "1") })
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("forwarded %d times, want 0", n)
+	}
+
+	if rec := submit(th); rec.Code != http.StatusAccepted {
+		t.Errorf("unmarked submit status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("forwarded %d times, want 1", n)
+	}
+}
+
+func TestForwardSendsWholeSubmission(t *testing.T) {
+	type received struct {
+		payload, dependsOn, tags string
+		file                     []byte
+	}
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub, err := readSubmission(w, r)
+		if err != nil {
+			t.Errorf("read forwarded submission: %v", err)
+		}
+		got <- received{payload: sub.Payload, file: sub.File, dependsOn: r.FormValue("depends_on"), tags: r.Header.Get(_taskTagsHeader)}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	f := NewOverflowForwarder(srv.URL)
+	for _, tt := range []struct {
+		name string
+		file []byte
+	}{
+		{"payload only", nil},
+		{"with a file", []byte("file content")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit?depends_on=abc", nil)
+			r.Header.Set(_taskTagsHeader, "team=a")
+			if err := f.Forward(context.Background(), r, &submission{Payload: "x", File: tt.file}); err != nil {
+				t.Fatal(err)
+			}
+			rcv := <-got
+			if rcv.payload != "x" || string(rcv.file) != string(tt.file) {
+				t.Errorf("forwarded payload %q file %q, want %q %q", rcv.payload, rcv.file, "x", tt.file)
+			}
+			if rcv.dependsOn != "abc" || rcv.tags != "team=a" {
+				t.Errorf("forwarded depends_on %q tags %q", rcv.dependsOn, rcv.tags)
+			}
+		})
+	}
+}
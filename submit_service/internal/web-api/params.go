@@ -0,0 +1,34 @@
+package webapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// invalidQueryParam is answered with 400, the details name the param and echo the given value
+func invalidQueryParam(name, value, format string, args ...any) *APIError {
+	return &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    codeBadRequest,
+		Message: name + " " + fmt.Sprintf(format, args...),
+		Details: map[string]any{"param": name, "value": value},
+	}
+}
+
+// queryPositiveInt reads the positive integer query param name, fallback when it is absent.
+// A maxValue > 0 is the inclusive upper bound.
+func queryPositiveInt(r *http.Request, name string, fallback, maxValue int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		return 0, invalidQueryParam(name, raw, "must be a positive integer")
+	}
+	if maxValue > 0 && v > maxValue {
+		return 0, invalidQueryParam(name, raw, "must not exceed %d", maxValue)
+	}
+	return v, nil
+}
@@ -0,0 +1,72 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInvalidQueryParams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cpu := withErrors(NewCPULoadHandler(ctx).CPULoadHandler)
+	memory := withErrors(NewMemoryLoadHandler(ctx, 64, 0).MemoryLoadHandler)
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		query       string
+		wantParam   string
+		wantValue   string
+		wantMessage string
+	}{
+		{"cpu workers not a number", cpu, "?workers=many", "workers", "many", "workers must be a positive integer"},
+		{"cpu workers zero", cpu, "?workers=0", "workers", "0", "workers must be a positive integer"},
+		{"cpu seconds negative", cpu, "?workers=1&seconds=-5", "seconds", "-5", "seconds must be a positive integer"},
+		{"memory mb fraction", memory, "?mb=1.5", "mb", "1.5", "mb must be a positive integer"},
+		{"memory mb above the max", memory, "?mb=65", "mb", "65", "mb must not exceed 64"},
+		{"memory seconds not a number", memory, "?mb=1&seconds=1m", "seconds", "1m", "seconds must be a positive integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodPost, "/load"+tt.query, nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			var body errorEnvelope
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != codeBadRequest || body.Error.Message != tt.wantMessage {
+				t.Errorf("error = %s %q, want %s %q", body.Error.Code, body.Error.Message, codeBadRequest, tt.wantMessage)
+			}
+			if body.Error.Details["param"] != tt.wantParam || body.Error.Details["value"] != tt.wantValue {
+				t.Errorf("details = %v, want param %q and value %q", body.Error.Details, tt.wantParam, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestQueryPositiveInt(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{"", 10, false},
+		{"?n=5", 5, false},
+		{"?n=100", 100, false},
+		{"?n=101", 0, true},
+		{"?n=0", 0, true},
+		{"?n=x", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := queryPositiveInt(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil), "n", 10, 100)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("queryPositiveInt(%q) = %d, %v, want %d, error %t", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package webapi
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket refilled at rate tokens per second up to burst
+type RateLimiter struct {
+	mux    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewRateLimiter returns nil for a rate <= 0, a nil limiter allows everything.
+// A burst <= 0 defaults to one second worth of tokens.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now(), now: time.Now}
+}
+
+// Allow takes a token, without one it reports how long until the next is available
+func (l *RateLimiter) Allow() (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,86 @@
+package webapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"submit_service/internal/metrics"
+	"submit_service/internal/repository"
+	"submit_service/internal/services"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewRateLimiter(10, 3)
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	for i := range 3 {
+		if ok, _ := l.Allow(); !ok {
+			t.Fatalf("Allow() %d within the burst = false", i+1)
+		}
+	}
+	ok, wait := l.Allow()
+	if ok {
+		t.Fatal("Allow() past the burst = true")
+	}
+	if wait != 100*time.Millisecond {
+		t.Errorf("wait = %s, want 100ms", wait)
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if ok, _ := l.Allow(); !ok {
+		t.Error("Allow() after a refill = false")
+	}
+	// a long pause refills up to the burst only
+	now = now.Add(time.Hour)
+	allowed := 0
+	for range 10 {
+		if ok, _ := l.Allow(); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("%d allowed after a long pause, want the burst of 3", allowed)
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	if l := NewRateLimiter(0, 10); l != nil {
+		t.Errorf("NewRateLimiter(0, 10) = %+v, want nil", l)
+	}
+	var disabled *RateLimiter
+	if ok, _ := disabled.Allow(); !ok {
+		t.Error("a nil limiter does not allow")
+	}
+	if l := NewRateLimiter(2.5, 0); l.burst != 3 {
+		t.Errorf("default burst = %v, want 3", l.burst)
+	}
+}
+
+func TestSubmitBurstPastRateLimit(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+	m := metrics.New(nil)
+	taskBus := &producingBus{}
+	th := NewTaskHandler(services.NewTaskService(repository.NewTaskRepository(nil)), taskBus, m, time.Second, nil, 0, 0, NewRateLimiter(0.001, 2))
+
+	statuses := make(map[int]int)
+	for range 5 {
+		rec := submit(th)
+		statuses[rec.Code]++
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Error("429 without Retry-After")
+		}
+	}
+	if statuses[http.StatusAccepted] != 2 || statuses[http.StatusTooManyRequests] != 3 {
+		t.Errorf("statuses = %v, want 2 accepted and 3 rate limited", statuses)
+	}
+	if len(taskBus.produced) != 2 {
+		t.Errorf("%d tasks enqueued, want 2", len(taskBus.produced))
+	}
+	if got := m.Recorder.GetRateLimitedTotal(); got != 3 {
+		t.Errorf("rate_limited_total = %d, want 3", got)
+	}
+}
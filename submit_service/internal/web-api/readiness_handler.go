@@ -1,26 +1,159 @@
 package webapi
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync/atomic"
+	"time"
 )
 
-var isShuttingDown atomic.Bool
+const _defaultProbeTimeout = time.Second
+
+// serverState is the lifecycle state of the web API
+type serverState int32
+
+const (
+	// stateUnknown is the zero value, the API was not started yet
+	stateUnknown serverState = iota
+	// stateWarmingUp is set by Start and kept until the warmup checks pass
+	stateWarmingUp
+	// stateAccepting serves all traffic
+	stateAccepting
+	// stateDraining fails readiness so no new traffic is routed, but stays alive
+	stateDraining
+	// stateStopping is set once the HTTP server is shutting down
+	stateStopping
+)
+
+func (s serverState) String() string {
+	switch s {
+	case stateWarmingUp:
+		return "warming_up"
+	case stateAccepting:
+		return "accepting"
+	case stateDraining:
+		return "draining"
+	case stateStopping:
+		return "stopping"
+	default:
+		return "unknown"
+	}
+}
+
+var currentState atomic.Int32
+
+func setState(s serverState) {
+	currentState.Store(int32(s))
+}
+
+// finishWarmup moves to accepting unless the API already started shutting down
+func finishWarmup() bool {
+	return currentState.CompareAndSwap(int32(stateWarmingUp), int32(stateAccepting))
+}
+
+func getState() serverState {
+	return serverState(currentState.Load())
+}
+
+// isAccepting reports whether new tasks can be accepted
+func isAccepting() bool {
+	return getState() == stateAccepting
+}
+
+// notAccepting returns the 503 answered while starting up or shutting down
+func notAccepting() error {
+	if s := getState(); s == stateUnknown || s == stateWarmingUp {
+		return ErrStartingUp
+	}
+	return ErrShuttingDown
+}
+
+// dependencyCheck is a dependency which must be reachable to serve traffic
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// run bounds the check by timeout even if it ignores its context
+func (c dependencyCheck) run(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.check(ctx)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%s check: %w", c.name, ctx.Err())
+	}
+}
 
 type ReadinessHandler struct {
+	probeTimeout time.Duration
+	checks       []dependencyCheck
 }
 
-func NewReadinessHandler() *ReadinessHandler {
-	return &ReadinessHandler{}
+func NewReadinessHandler(probeTimeout time.Duration) *ReadinessHandler {
+	if probeTimeout <= 0 {
+		probeTimeout = _defaultProbeTimeout
+	}
+	return &ReadinessHandler{probeTimeout: probeTimeout}
 }
 
+// HandleReadiness fails while warming up and as soon as the API starts draining
 func (rh *ReadinessHandler) HandleReadiness(w http.ResponseWriter, r *http.Request) {
-	if isShuttingDown.Load() {
+	state := getState()
+	if state != stateAccepting {
 		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte("shutting down"))
+		w.Write([]byte(state.String()))
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
+
+// HandleLiveness stays healthy while draining, so the pod is not killed mid-drain
+func (rh *ReadinessHandler) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	state := getState()
+	if state == stateStopping {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(state.String()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(state.String()))
+}
+
+// HandleHealth reports the state and dependency checks, each check is bounded by
+// the probe timeout so a hung dependency fails the probe instead of hanging it
+func (rh *ReadinessHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	state := getState()
+	status := http.StatusOK
+	if state == stateStopping {
+		status = http.StatusServiceUnavailable
+	}
+
+	checks := make(map[string]string, len(rh.checks))
+	for _, c := range rh.checks {
+		if err := c.run(r.Context(), rh.probeTimeout); err != nil {
+			checks[c.name] = err.Error()
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		checks[c.name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"state":  state.String(),
+		"checks": checks,
+	})
+}
@@ -0,0 +1,125 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"submit_service/internal/repository"
+)
+
+func TestZeroStateIsUnknown(t *testing.T) {
+	var s serverState
+	if s != stateUnknown {
+		t.Fatalf("zero state = %s, want %s", s, stateUnknown)
+	}
+	if s.String() != "unknown" {
+		t.Errorf("String() = %q, want %q", s.String(), "unknown")
+	}
+}
+
+func TestWarmupTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		conf time.Duration
+		want time.Duration
+	}{
+		{"unset uses the default", 0, _defaultWarmupTimeout},
+		{"negative uses the default", -time.Second, _defaultWarmupTimeout},
+		{"configured", 5 * time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := New(context.Background(), &Config{WarmupTimeout: tt.conf}, nil, nil, nil, nil, nil, log.New())
+			if api.warmupTimeout != tt.want {
+				t.Errorf("warmupTimeout = %s, want %s", api.warmupTimeout, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbesByState(t *testing.T) {
+	t.Cleanup(func() { setState(stateUnknown) })
+	rh := NewReadinessHandler(0)
+
+	tests := []struct {
+		state         serverState
+		wantReadiness int
+		wantLiveness  int
+		wantHealth    int
+	}{
+		{stateWarmingUp, http.StatusServiceUnavailable, http.StatusOK, http.StatusOK},
+		{stateAccepting, http.StatusOK, http.StatusOK, http.StatusOK},
+		// draining stops new traffic but must not get the pod killed
+		{stateDraining, http.StatusServiceUnavailable, http.StatusOK, http.StatusOK},
+		{stateStopping, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state.String(), func(t *testing.T) {
+			setState(tt.state)
+			for _, probe := range []struct {
+				name    string
+				handler http.HandlerFunc
+				want    int
+			}{
+				{"readiness", rh.HandleReadiness, tt.wantReadiness},
+				{"liveness", rh.HandleLiveness, tt.wantLiveness},
+				{"health", rh.HandleHealth, tt.wantHealth},
+			} {
+				rec := httptest.NewRecorder()
+				probe.handler(rec, httptest.NewRequest(http.MethodGet, "/"+probe.name, nil))
+				if rec.Code != probe.want {
+					t.Errorf("%s status = %d, want %d", probe.name, rec.Code, probe.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthWithSlowClickHouse(t *testing.T) {
+	t.Cleanup(func() { setState(stateUnknown) })
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	client, err := repository.NewClient(context.Background(), &repository.Config{DSN: srv.Listener.Addr().String()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const probeTimeout = 50 * time.Millisecond
+	api := New(context.Background(), &Config{ProbeTimeout: probeTimeout}, nil, nil, nil, nil, nil, log.New())
+	api.loadCancel()
+	api.AddWarmupCheck("clickhouse", client.Ping)
+	setState(stateAccepting)
+
+	startedAt := time.Now()
+	rec := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, _healthzPath, nil))
+	if elapsed := time.Since(startedAt); elapsed > probeTimeout+500*time.Millisecond {
+		t.Errorf("probe took %s, want it bounded by %s", elapsed, probeTimeout)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	var body struct {
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if got := body.Checks["clickhouse"]; !strings.Contains(got, context.DeadlineExceeded.Error()) {
+		t.Errorf("clickhouse check = %q, want a timeout", got)
+	}
+}
@@ -0,0 +1,66 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// router registers handlers on the mux under a common path prefix
+type router struct {
+	mux    *http.ServeMux
+	prefix string
+	// disabled paths are not registered and respond with 404
+	disabled map[string]struct{}
+	// timeout bounds non-streaming handlers, 0 disables it
+	timeout time.Duration
+}
+
+func newRouter(prefix string, disabled []string, timeout time.Duration) *router {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	rt := &router{mux: http.NewServeMux(), prefix: prefix, disabled: make(map[string]struct{}, len(disabled)), timeout: timeout}
+	for _, path := range disabled {
+		rt.disabled[path] = struct{}{}
+	}
+	return rt
+}
+
+// handle accepts both "/path" and "METHOD /path" patterns, a handler running
+// longer than the router timeout is answered with 503
+func (rt *router) handle(pattern string, handler http.HandlerFunc) {
+	if rt.timeout <= 0 {
+		rt.register(pattern, handler)
+		return
+	}
+	rt.register(pattern, http.TimeoutHandler(handler, rt.timeout, timeoutBody).ServeHTTP)
+}
+
+// handleStream registers a streaming handler, it is not bound by the router timeout
+func (rt *router) handleStream(pattern string, handler http.HandlerFunc) {
+	rt.register(pattern, handler)
+}
+
+func (rt *router) register(pattern string, handler http.HandlerFunc) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	if _, ok := rt.disabled[path]; ok {
+		return
+	}
+	pattern = rt.prefix + path
+	if method != "" {
+		pattern = method + " " + pattern
+	}
+	rt.mux.HandleFunc(pattern, handler)
+}
+
+// timeoutBody is the error envelope sent by http.TimeoutHandler, it cannot set a content type
+var timeoutBody = func() string {
+	body, _ := json.Marshal(errorEnvelope{Error: errorBody{Code: codeTimeout, Message: "request timed out"}})
+	return string(body)
+}()
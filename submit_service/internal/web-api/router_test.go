@@ -0,0 +1,110 @@
+package webapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRouterPrefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		path       string
+		wantStatus int
+	}{
+		{"no prefix", "", "/ping", http.StatusNoContent},
+		{"prefixed path", "/shortcut", "/shortcut/ping", http.StatusNoContent},
+		{"unprefixed path", "/shortcut", "/ping", http.StatusNotFound},
+		{"prefix without a leading slash", "shortcut/", "/shortcut/ping", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newRouter(tt.prefix, nil, 0)
+			rt.handle("GET /ping", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) })
+			rec := httptest.NewRecorder()
+			rt.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("GET %s status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	api := New(context.Background(), &Config{PathPrefix: "/shortcut"}, nil, nil, nil, nil, nil, log.New())
+	api.loadCancel()
+
+	for path, want := range map[string]int{
+		"/shortcut" + _cpuLoadPath + "?workers=1&seconds=1": http.StatusAccepted,
+		_cpuLoadPath + "?workers=1&seconds=1":               http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != want {
+			t.Errorf("POST %s status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestDisabledEndpoints(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+	api := New(context.Background(), &Config{DisabledEndpoints: []string{_cpuLoadPath, _memoryLoadPath}}, nil, nil, nil, nil, nil, log.New())
+	api.loadCancel()
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{http.MethodPost, _cpuLoadPath + "?workers=1&seconds=1", http.StatusNotFound},
+		{http.MethodPost, _memoryLoadPath + "?mb=1&seconds=1", http.StatusNotFound},
+		{http.MethodGet, _readinessPath, http.StatusOK},
+		{http.MethodGet, _livezPath, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestRouterTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	rt := newRouter("", nil, timeout)
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * timeout):
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	rt.handle("GET /slow", slow)
+	rt.handleStream("GET /stream", slow)
+
+	startedAt := time.Now()
+	rec := httptest.NewRecorder()
+	rt.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /slow status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if elapsed := time.Since(startedAt); elapsed > timeout+100*time.Millisecond {
+		t.Errorf("GET /slow took %s, want about %s", elapsed, timeout)
+	}
+	if got := errorCode(t, rec); got != codeTimeout {
+		t.Errorf("error code = %q, want %q", got, codeTimeout)
+	}
+
+	rec = httptest.NewRecorder()
+	rt.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /stream status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,157 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"submit_service/internal/bus"
+	"submit_service/internal/domain"
+)
+
+const (
+	// _maxStreamDuration closes streams of tasks which are never picked up
+	_maxStreamDuration = 10 * time.Minute
+	// _streamCheckInterval is how often a stream checks for a task ended without its done event
+	_streamCheckInterval = 5 * time.Second
+)
+
+// SubmitStream submits a task like SubmitTask and streams its log lines as
+// server-sent events until the worker reports the task done. Errors before the
+// stream starts are answered like SubmitTask does.
+func (th *TaskHandler) SubmitStream(w http.ResponseWriter, r *http.Request) error {
+	if err := th.acceptSubmit(r.Context()); err != nil {
+		return err
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return Internal("streaming is not supported", nil)
+	}
+
+	sub, err := readSubmission(w, r)
+	if err != nil {
+		return submissionError(err)
+	}
+	if sub.Payload == "" {
+		return InvalidParam("Payload is required")
+	}
+	tags, err := parseTags(r)
+	if err != nil {
+		return ErrInvalidParam.withMessage(err.Error())
+	}
+	timeout, err := th.parseTimeout(r)
+	if err != nil {
+		return ErrInvalidParam.withMessage(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), _maxStreamDuration)
+	defer cancel()
+	task := &domain.Task{
+		ID: uuid.New(), Status: domain.StatusProcessing, Payload: &sub.Payload, File: sub.File,
+		Tags: tags, Timeout: timeout, StreamLogs: true,
+	}
+	// subscribe before enqueueing, a fast worker must not publish into the void
+	lines, unsubscribe, err := th.bus.SubscribeTaskLogs(ctx, task.ID)
+	if err != nil {
+		return Internal("Failed to subscribe to task logs", err)
+	}
+	defer unsubscribe()
+
+	select {
+	case th.sem <- struct{}{}:
+	default:
+		return th.queueFull()
+	}
+	hash, err := th.dedup(ctx, task)
+	if err != nil {
+		<-th.sem
+		return err
+	}
+	if err := th.startTaskProcessing(ctx, task); err != nil {
+		th.releasePayload(ctx, hash)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	th.recordStatus(http.StatusOK)
+	writeEvent(w, "submitted", fmt.Sprintf(`{"id":%q}`, task.ID))
+	flusher.Flush()
+
+	check := time.NewTicker(_streamCheckInterval)
+	defer check.Stop()
+	var lastState string
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-check.C:
+			event, ended := th.endedWithoutEvent(ctx, task.ID, &lastState)
+			if ended {
+				writeEvent(w, "done", event)
+				flusher.Flush()
+				return nil
+			}
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			var event struct {
+				Event string `json:"event"`
+			}
+			if err := json.Unmarshal([]byte(line), &event); err != nil || event.Event == "" {
+				event.Event = "log"
+			}
+			writeEvent(w, event.Event, line)
+			flusher.Flush()
+			if event.Event == "done" {
+				return nil
+			}
+		}
+	}
+}
+
+// endedWithoutEvent returns the final event of a task whose done event will not arrive: it
+// reached a final state on two checks in a row, so the event was lost, or no workers run anymore.
+func (th *TaskHandler) endedWithoutEvent(ctx context.Context, taskID uuid.UUID, lastState *string) (string, bool) {
+	state, err := th.bus.TaskFinalState(ctx, taskID)
+	if err != nil {
+		return "", false
+	}
+	// the done event is published right after the final state is set, give it a check to arrive
+	if state != "" && state == *lastState {
+		return doneEvent(finalStatus(state), ""), true
+	}
+	*lastState = state
+	if errors.Is(th.bus.WorkersAlive(ctx), bus.ErrNoWorkers) {
+		return doneEvent("worker_lost", "no workers are running"), true
+	}
+	return "", false
+}
+
+// finalStatus maps a task state to the status of the done event published by the workers
+func finalStatus(state string) string {
+	if state == "done" {
+		return "processed"
+	}
+	return state
+}
+
+func doneEvent(status, reason string) string {
+	event := map[string]any{"event": "done", "status": status, "time": time.Now().UTC()}
+	if reason != "" {
+		event["error"] = reason
+	}
+	b, _ := json.Marshal(event)
+	return string(b)
+}
+
+func writeEvent(w http.ResponseWriter, event, data string) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,179 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"submit_service/internal/bus"
+	"submit_service/internal/domain"
+	"submit_service/internal/repository"
+	"submit_service/internal/services"
+)
+
+// fakeBus implements the TaskBus methods the tests need, the others panic
+type fakeBus struct {
+	TaskBus
+	state      string
+	workersErr error
+	// cancelled is the answer to CancelTask
+	cancelled bool
+}
+
+func (b *fakeBus) CancelTask(context.Context, uuid.UUID) (bool, error) {
+	return b.cancelled, nil
+}
+
+func (b *fakeBus) TaskFinalState(context.Context, uuid.UUID) (string, error) {
+	return b.state, nil
+}
+
+func (b *fakeBus) WorkersAlive(context.Context) error {
+	return b.workersErr
+}
+
+func TestEndedWithoutEvent(t *testing.T) {
+	tests := []struct {
+		name       string
+		states     []string
+		workersErr error
+		wantEnded  []bool
+		wantStatus string
+	}{
+		{"running task", []string{"", "", ""}, nil, []bool{false, false, false}, ""},
+		{"final state seen twice", []string{"failed", "failed"}, nil, []bool{false, true}, "failed"},
+		{"done maps to processed", []string{"", "done", "done"}, nil, []bool{false, false, true}, "processed"},
+		{"cancelled", []string{"cancelled", "cancelled"}, nil, []bool{false, true}, "cancelled"},
+		{"no workers", []string{""}, bus.ErrNoWorkers, []bool{true}, "worker_lost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := &fakeBus{workersErr: tt.workersErr}
+			th := &TaskHandler{bus: fb}
+			var lastState, event string
+			for i, state := range tt.states {
+				fb.state = state
+				var ended bool
+				event, ended = th.endedWithoutEvent(context.Background(), uuid.New(), &lastState)
+				if ended != tt.wantEnded[i] {
+					t.Fatalf("check %d ended = %v, want %v", i+1, ended, tt.wantEnded[i])
+				}
+			}
+			if tt.wantStatus == "" {
+				return
+			}
+			var got struct {
+				Event  string `json:"event"`
+				Status string `json:"status"`
+			}
+			if err := json.Unmarshal([]byte(event), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Event != "done" || got.Status != tt.wantStatus {
+				t.Errorf("event = %s, want done with status %s", event, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// logBus publishes lines to the subscriber of a task once it is produced, like a worker would
+type logBus struct {
+	producingBus
+	lines []string
+	ch    chan string
+}
+
+func (b *logBus) SubscribeTaskLogs(context.Context, uuid.UUID) (<-chan string, func() error, error) {
+	b.ch = make(chan string, len(b.lines))
+	return b.ch, func() error { return nil }, nil
+}
+
+func (b *logBus) ProduceTask(ctx context.Context, task *domain.Task) error {
+	if err := b.producingBus.ProduceTask(ctx, task); err != nil {
+		return err
+	}
+	go func() {
+		for _, line := range b.lines {
+			b.ch <- line
+		}
+	}()
+	return nil
+}
+
+type sseEvent struct {
+	event, data string
+}
+
+// readEvents splits a server-sent events body into its events
+func readEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var e sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			field, value, ok := strings.Cut(line, ": ")
+			if !ok {
+				t.Fatalf("malformed event line %q", line)
+			}
+			switch field {
+			case "event":
+				e.event = value
+			case "data":
+				e.data = value
+			}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestSubmitStream(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	taskBus := &logBus{lines: []string{
+		`{"event":"log","level":"info","message":"calling external API"}`,
+		`{"event":"log","level":"info","message":"external API answered"}`,
+		`{"event":"done","status":"processed"}`,
+	}}
+	// a task repository without a service stores nothing, ClickHouse is not needed
+	taskSrv := services.NewTaskService(repository.NewTaskRepository(nil))
+	api := New(context.Background(), &Config{}, taskSrv, taskBus, nil, nil, nil, log.New())
+	t.Cleanup(api.loadCancel)
+
+	rec := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/submit/stream?payload=x", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+	if len(taskBus.produced) != 1 || !taskBus.produced[0].StreamLogs {
+		t.Fatalf("produced %+v, want one task streaming its logs", taskBus.produced)
+	}
+
+	events := readEvents(t, rec.Body.String())
+	want := []sseEvent{
+		{"submitted", fmt.Sprintf(`{"id":%q}`, taskBus.produced[0].ID)},
+		{"log", taskBus.lines[0]},
+		{"log", taskBus.lines[1]},
+		{"done", taskBus.lines[2]},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %+v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
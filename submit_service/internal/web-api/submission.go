@@ -0,0 +1,168 @@
+package webapi
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// _maxUploadBytes caps a file attached to a multipart submit
+	_maxUploadBytes = 1 << 20
+	// _maxSubmitBodyBytes caps the whole submit body, leaving room for form fields
+	_maxSubmitBodyBytes = _maxUploadBytes + 64<<10
+	_uploadField        = "file"
+)
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported content type")
+	errUnsupportedEncoding  = errors.New("content encoding must be gzip or identity")
+	errUploadTooLarge       = fmt.Errorf("file must be at most %d bytes", _maxUploadBytes)
+	errBodyTooLarge         = fmt.Errorf("body must be at most %d bytes", _maxSubmitBodyBytes)
+)
+
+// submission is the task data sent to /submit
+type submission struct {
+	Payload string `json:"payload"`
+	File    []byte `json:"-"`
+}
+
+// readSubmission parses the submit body according to its Content-Type. JSON,
+// form-encoded and multipart bodies are accepted, requests without a body
+// fall back to the query string.
+func readSubmission(w http.ResponseWriter, r *http.Request) (*submission, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return &submission{Payload: r.FormValue("payload")}, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errUnsupportedMediaType
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, _maxSubmitBodyBytes)
+	if err := decompressBody(r); err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case "application/json":
+		sub := &submission{}
+		if err := json.NewDecoder(r.Body).Decode(sub); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		return sub, nil
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		return &submission{Payload: r.FormValue("payload")}, nil
+	case "multipart/form-data":
+		return readMultipart(r)
+	default:
+		return nil, errUnsupportedMediaType
+	}
+}
+
+// decompressBody replaces a gzip encoded body with its decompressed content, which is
+// capped at _maxSubmitBodyBytes as well, so a small compressed body can not blow up memory
+func decompressBody(r *http.Request) error {
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+	default:
+		return errUnsupportedEncoding
+	}
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return fmt.Errorf("invalid gzip body: %w", err)
+	}
+	r.Body = &decompressedBody{Reader: zr, body: r.Body, left: _maxSubmitBodyBytes}
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return nil
+}
+
+// decompressedBody reads the decompressed body and fails with *http.MaxBytesError past its limit
+type decompressedBody struct {
+	*gzip.Reader
+	body io.Closer
+	left int64
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		// a read past the limit tells a body of exactly the limit from a bigger one
+		if n, _ := b.Reader.Read(make([]byte, 1)); n > 0 {
+			return 0, &http.MaxBytesError{Limit: _maxSubmitBodyBytes}
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.Reader.Read(p)
+	b.left -= int64(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("invalid gzip body: %w", err)
+	}
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+func readMultipart(r *http.Request) (*submission, error) {
+	if err := r.ParseMultipartForm(_maxSubmitBodyBytes); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, errUploadTooLarge
+		}
+		return nil, err
+	}
+	sub := &submission{Payload: r.FormValue("payload")}
+
+	file, header, err := r.FormFile(_uploadField)
+	if errors.Is(err, http.ErrMissingFile) {
+		return sub, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if header.Size > _maxUploadBytes {
+		return nil, errUploadTooLarge
+	}
+	sub.File, err = io.ReadAll(io.LimitReader(file, _maxUploadBytes))
+	if err != nil {
+		return nil, err
+	}
+	// an uploaded file is a valid payload on its own
+	if sub.Payload == "" {
+		sub.Payload = header.Filename
+	}
+	return sub, nil
+}
+
+// submissionError maps readSubmission errors to the API errors answered
+func submissionError(err error) error {
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		return &APIError{Status: http.StatusUnsupportedMediaType, Code: codeUnsupportedMediaType, Message: "Content-Type must be application/json, application/x-www-form-urlencoded or multipart/form-data"}
+	case errors.Is(err, errUnsupportedEncoding):
+		return &APIError{Status: http.StatusUnsupportedMediaType, Code: codeUnsupportedMediaType, Message: err.Error()}
+	case errors.Is(err, errUploadTooLarge):
+		return &APIError{Status: http.StatusRequestEntityTooLarge, Code: codeTooLarge, Message: err.Error()}
+	case errors.As(err, new(*http.MaxBytesError)):
+		return &APIError{Status: http.StatusRequestEntityTooLarge, Code: codeTooLarge, Message: errBodyTooLarge.Error()}
+	default:
+		return ErrInvalidParam.withMessage(err.Error())
+	}
+}
@@ -0,0 +1,195 @@
+package webapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"submit_service/internal/services"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressBody(t *testing.T) {
+	payload := []byte(`{"payload":"x"}`)
+	atLimit := bytes.Repeat([]byte{'a'}, _maxSubmitBodyBytes)
+	bomb := bytes.Repeat([]byte{0}, 100*_maxSubmitBodyBytes)
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     []byte
+		wantErr  error
+		// wantMaxBytes expects reading the body to fail with *http.MaxBytesError
+		wantMaxBytes bool
+	}{
+		{"identity", "", payload, payload, nil, false},
+		{"gzip", "gzip", gzipped(t, payload), payload, nil, false},
+		{"x-gzip", "X-Gzip", gzipped(t, payload), payload, nil, false},
+		{"gzip at the limit", "gzip", gzipped(t, atLimit), atLimit, nil, false},
+		{"gzip bomb", "gzip", gzipped(t, bomb), nil, nil, true},
+		{"unsupported encoding", "br", payload, nil, errUnsupportedEncoding, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			err := decompressBody(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decompressBody() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := r.Header.Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q after decompressing, want it removed", got)
+			}
+
+			got, err := io.ReadAll(r.Body)
+			var maxErr *http.MaxBytesError
+			if tt.wantMaxBytes {
+				if !errors.As(err, &maxErr) {
+					t.Fatalf("reading the body = %v, want *http.MaxBytesError", err)
+				}
+				if maxErr.Limit != _maxSubmitBodyBytes {
+					t.Errorf("limit = %d, want %d", maxErr.Limit, _maxSubmitBodyBytes)
+				}
+				if len(got) > _maxSubmitBodyBytes {
+					t.Errorf("read %d bytes, want at most %d", len(got), _maxSubmitBodyBytes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reading the body = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("body has %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestDecompressBodyInvalidGzip(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/submit", bytes.NewReader([]byte("not gzip")))
+	r.Header.Set("Content-Encoding", "gzip")
+	if err := decompressBody(r); err == nil {
+		t.Fatal("decompressBody() accepted a body which is not gzip")
+	}
+}
+
+// multipartBody returns a multipart form with the payload field, if not empty, and a file part
+func multipartBody(t *testing.T, payload, filename string, file []byte) (io.Reader, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if payload != "" {
+		if err := mw.WriteField("payload", payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile(_uploadField, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf, mw.FormDataContentType()
+}
+
+func TestReadSubmission(t *testing.T) {
+	file := []byte("file content")
+	withFile, withFileType := multipartBody(t, "", "report.csv", file)
+	withPayload, withPayloadType := multipartBody(t, "x", "report.csv", file)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        io.Reader
+		want        submission
+	}{
+		{"json", "application/json", strings.NewReader(`{"payload":"x"}`), submission{Payload: "x"}},
+		{"form", "application/x-www-form-urlencoded", strings.NewReader(url.Values{"payload": {"x y"}}.Encode()), submission{Payload: "x y"}},
+		{"multipart file as payload", withFileType, withFile, submission{Payload: "report.csv", File: file}},
+		{"multipart with payload", withPayloadType, withPayload, submission{Payload: "x", File: file}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit", tt.body)
+			r.Header.Set("Content-Type", tt.contentType)
+			got, err := readSubmission(httptest.NewRecorder(), r)
+			if err != nil {
+				t.Fatalf("readSubmission() = %v", err)
+			}
+			if got.Payload != tt.want.Payload || !bytes.Equal(got.File, tt.want.File) {
+				t.Errorf("readSubmission() = %q with a %d byte file, want %q with a %d byte file",
+					got.Payload, len(got.File), tt.want.Payload, len(tt.want.File))
+			}
+		})
+	}
+}
+
+func TestSubmitBodyErrors(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	overUpload, overUploadType := multipartBody(t, "x", "big.bin", bytes.Repeat([]byte{'a'}, _maxUploadBytes+1))
+	overBody, overBodyType := multipartBody(t, "x", "huge.bin", bytes.Repeat([]byte{'a'}, 2*_maxSubmitBodyBytes))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        io.Reader
+		wantStatus  int
+		wantCode    string
+	}{
+		{"file over the upload limit", overUploadType, overUpload, http.StatusRequestEntityTooLarge, codeTooLarge},
+		{"body over the size limit", overBodyType, overBody, http.StatusRequestEntityTooLarge, codeTooLarge},
+		{"unsupported content type", "text/plain", strings.NewReader("x"), http.StatusUnsupportedMediaType, codeUnsupportedMediaType},
+		{"malformed content type", "multipart/", strings.NewReader("x"), http.StatusUnsupportedMediaType, codeUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskBus := &producingBus{}
+			th := NewTaskHandler(services.NewTaskService(nil), taskBus, nil, 0, nil, 0, 0, nil)
+			r := httptest.NewRequest(http.MethodPost, "/submit", tt.body)
+			r.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			withErrors(th.withStatus(th.SubmitTask))(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := errorCode(t, rec); got != tt.wantCode {
+				t.Errorf("code = %q, want %q", got, tt.wantCode)
+			}
+			if len(taskBus.produced) != 0 {
+				t.Errorf("produced %d tasks, want none", len(taskBus.produced))
+			}
+		})
+	}
+}
@@ -2,121 +2,526 @@ package webapi
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"submit_service/internal/bus"
 	"submit_service/internal/domain"
 	"submit_service/internal/metrics"
 	"submit_service/internal/services"
 
 	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	_taskTimeoutHeader     = "X-Task-Timeout"
+	_defaultMaxTaskTimeout = time.Minute
+
+	_taskTagsHeader = "X-Task-Tags"
+	_maxTaskTags    = 16
+	_maxTagLength   = 64
+
+	_taskDependsOnHeader = "X-Task-Depends-On"
+	_maxTaskDependencies = 32
+
+	_taskRunAtHeader = "X-Task-Run-At"
+	_taskDelayHeader = "X-Task-Delay"
+	// _maxTaskDelay keeps delayed tasks within the task state TTL, so a cancel still applies when they run
+	_maxTaskDelay = 24 * time.Hour
+
+	// _defaultListCount and _maxListCount bound n of the admin listing endpoints
+	_defaultListCount = 10
+	_maxListCount     = 1000
+
+	// _queueFullRetryAfter is the Retry-After in seconds sent when the queue is full
+	_queueFullRetryAfter = "1"
 )
 
 type TaskBus interface {
 	ProduceTask(ctx context.Context, task *domain.Task) error
+	CancelTask(ctx context.Context, taskID uuid.UUID) (bool, error)
+	SubscribeTaskLogs(ctx context.Context, taskID uuid.UUID) (<-chan string, func() error, error)
+	TaskFinalState(ctx context.Context, taskID uuid.UUID) (string, error)
+	WorkersAlive(ctx context.Context) error
+	ClaimPayload(ctx context.Context, hash string, taskID uuid.UUID, window time.Duration) (uuid.UUID, bool, error)
+	ReleasePayload(ctx context.Context, hash string) error
+	QueueStats(ctx context.Context) (bus.QueueStats, error)
+	Peek(ctx context.Context, n int) ([]uuid.UUID, error)
+	RecentCompletions(ctx context.Context, n int) ([]bus.Completion, error)
 }
 
 type TaskHandler struct {
-	bus    TaskBus
+	bus         TaskBus
 	taskService *services.TaskService
 	sem         chan struct{}
 	metrics     *metrics.Service
+	// maxTaskTimeout clamps timeouts requested with X-Task-Timeout
+	maxTaskTimeout time.Duration
+	// overflow receives tasks when the queue is full, nil drops them with 503
+	overflow *OverflowForwarder
+	// dedupWindow rejects payloads seen within the window with 409, 0 disables it
+	dedupWindow time.Duration
+	// queueFullStatus answers submits when the queue is full, 503 or 429
+	queueFullStatus int
+	// limiter caps the submit rate regardless of queue space, nil disables it. It is
+	// replaced when the rate limit is reloaded.
+	limiter atomic.Pointer[RateLimiter]
+	// awaitWorkers answers submits with 503 until workers were seen once, readiness
+	// does not depend on it
+	awaitWorkers atomic.Bool
 }
 
-func NewTaskHandler(taskService *services.TaskService, taskBus TaskBus, m *metrics.Service) *TaskHandler {
-	return &TaskHandler{
-		bus:         taskBus,
-		taskService: taskService,
-		sem:         make(chan struct{}, 100), // Ограничение на 100 одновременных задач
-		metrics:     m,
+func NewTaskHandler(taskService *services.TaskService, taskBus TaskBus, m *metrics.Service, maxTaskTimeout time.Duration, overflow *OverflowForwarder, dedupWindow time.Duration, queueFullStatus int, limiter *RateLimiter) *TaskHandler {
+	if maxTaskTimeout <= 0 {
+		maxTaskTimeout = _defaultMaxTaskTimeout
+	}
+	if queueFullStatus != http.StatusTooManyRequests {
+		queueFullStatus = http.StatusServiceUnavailable
 	}
+	th := &TaskHandler{
+		bus:             taskBus,
+		taskService:     taskService,
+		sem:             make(chan struct{}, 100), // Ограничение на 100 одновременных задач
+		metrics:         m,
+		maxTaskTimeout:  maxTaskTimeout,
+		overflow:        overflow,
+		dedupWindow:     dedupWindow,
+		queueFullStatus: queueFullStatus,
+	}
+	th.limiter.Store(limiter)
+	return th
 }
 
-func (th *TaskHandler) SubmitTask(w http.ResponseWriter, r *http.Request) {
-	if isShuttingDown.Load() {
-		http.Error(w, "shutting down", http.StatusServiceUnavailable)
-		return
+// SetLimiter replaces the submit rate limiter, nil disables the limit.
+func (th *TaskHandler) SetLimiter(limiter *RateLimiter) {
+	th.limiter.Store(limiter)
+}
+
+// rateLimit returns ErrRateLimited with Retry-After when the submit rate limit is exceeded
+func (th *TaskHandler) rateLimit() error {
+	ok, wait := th.limiter.Load().Allow()
+	if ok {
+		return nil
+	}
+	if th.metrics != nil {
+		th.metrics.Recorder.IncRateLimited()
+	}
+	err := *ErrRateLimited
+	err.RetryAfter = strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	return &err
+}
+
+// queueFull rejects a submit which does not fit into the queue with the configured status
+func (th *TaskHandler) queueFull() error {
+	err := *ErrQueueFull
+	err.Status, err.RetryAfter = th.queueFullStatus, _queueFullRetryAfter
+	return &err
+}
+
+// acceptSubmit checks that a submit may be taken at all
+func (th *TaskHandler) acceptSubmit(ctx context.Context) error {
+	if !isAccepting() {
+		return notAccepting()
+	}
+	if err := th.workersStarted(ctx); err != nil {
+		return err
+	}
+	if err := th.rateLimit(); err != nil {
+		return err
+	}
+	if th.bus == nil || th.taskService == nil {
+		return Internal("task submission is not configured", nil)
+	}
+	return nil
+}
+
+// withStatus counts the status of the errors h returns, successful responses count their own
+func (th *TaskHandler) withStatus(h func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		err := h(w, r)
+		if err != nil {
+			th.recordStatus(StatusOf(err))
+		}
+		return err
+	}
+}
+
+// recordStatus counts the response status, it is a no-op without metrics
+func (th *TaskHandler) recordStatus(status int) {
+	if th.metrics != nil {
+		th.metrics.Recorder.IncHTTPResponseStatus(status)
+	}
+}
+
+// workersStarted returns ErrStartingUp until the process service reported running
+// workers, after the first success it is not checked anymore
+func (th *TaskHandler) workersStarted(ctx context.Context) error {
+	if !th.awaitWorkers.Load() {
+		return nil
+	}
+	if err := th.bus.WorkersAlive(ctx); err != nil {
+		return ErrStartingUp
+	}
+	th.awaitWorkers.Store(false)
+	return nil
+}
+
+func (th *TaskHandler) SubmitTask(w http.ResponseWriter, r *http.Request) error {
+	if err := th.acceptSubmit(r.Context()); err != nil {
+		return err
 	}
-	
-	status := http.StatusAccepted
 
 	select {
 	case th.sem <- struct{}{}:
-		taskStatus := domain.StatusProcessing
-		payload := r.FormValue("payload")
-		if payload == "" {
-			http.Error(w, "Payload is required", http.StatusBadRequest)
-			th.metrics.Recorder.IncHTTPResponseStatus(http.StatusBadRequest)
-			return
-		}
-		th.startTaskProcessing(r.Context(), w, &domain.Task{
-			ID: uuid.New(), Status: taskStatus, Payload: &payload,
-		})
 	default:
-		status = http.StatusServiceUnavailable
-		http.Error(w, "Task queue is full, try again later", status)
-		th.metrics.Recorder.IncHTTPResponseStatus(status)
-		return
+		if th.overflow != nil && !forwarded(r) {
+			if sub, err := readSubmission(w, r); err == nil && sub.Payload != "" && th.overflow.Forward(r.Context(), r, sub) == nil {
+				// forwarded, answer 202 as for a local submit
+				th.recordStatus(http.StatusAccepted)
+				w.WriteHeader(http.StatusAccepted)
+				return nil
+			}
+		}
+		return th.queueFull()
+	}
+
+	task, err := readTask(w, r, th.parseTimeout)
+	if err != nil {
+		<-th.sem
+		return err
+	}
+	hash, err := th.dedup(r.Context(), task)
+	if err != nil {
+		<-th.sem
+		return err
+	}
+	if err := th.startTaskProcessing(r.Context(), task); err != nil {
+		th.releasePayload(r.Context(), hash)
+		return err
+	}
+	th.recordStatus(http.StatusAccepted)
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// readTask reads a submitted task with its options from the request
+func readTask(w http.ResponseWriter, r *http.Request, parseTimeout func(*http.Request) (time.Duration, error)) (*domain.Task, error) {
+	sub, err := readSubmission(w, r)
+	if err != nil {
+		return nil, submissionError(err)
+	}
+	if sub.Payload == "" {
+		return nil, InvalidParam("Payload is required")
+	}
+	tags, err := parseTags(r)
+	if err != nil {
+		return nil, ErrInvalidParam.withMessage(err.Error())
+	}
+	timeout, err := parseTimeout(r)
+	if err != nil {
+		return nil, ErrInvalidParam.withMessage(err.Error())
 	}
-	th.metrics.Recorder.IncHTTPResponseStatus(status)
+	dependsOn, err := parseDependsOn(r)
+	if err != nil {
+		return nil, ErrInvalidParam.withMessage(err.Error())
+	}
+	runAt, err := parseRunAt(r)
+	if err != nil {
+		return nil, ErrInvalidParam.withMessage(err.Error())
+	}
+	return &domain.Task{
+		ID: uuid.New(), Status: domain.StatusProcessing, Payload: &sub.Payload, File: sub.File, Tags: tags, Timeout: timeout,
+		DependsOn: dependsOn, RunAt: runAt,
+	}, nil
+}
+
+// dedup claims the task's payload hash within the dedup window. Duplicates are answered
+// with 409 and the original task id. Redis errors let the task through.
+func (th *TaskHandler) dedup(ctx context.Context, task *domain.Task) (string, error) {
+	if th.dedupWindow <= 0 {
+		return "", nil
+	}
+	hash := bus.PayloadHash(*task.Payload, task.File)
+	ownerID, claimed, err := th.bus.ClaimPayload(ctx, hash, task.ID, th.dedupWindow)
+	if err != nil {
+		log.WithError(err).Warn("payload dedup unavailable, accepting task")
+		return "", nil
+	}
+	if !claimed {
+		return "", &APIError{Status: http.StatusConflict, Code: codeDuplicate, Message: "Task with the same payload was already submitted",
+			Details: map[string]any{"task_id": ownerID}}
+	}
+	return hash, nil
+}
 
-	w.WriteHeader(status)
+// releasePayload lets the payload of a task which was not queued be submitted again
+func (th *TaskHandler) releasePayload(ctx context.Context, hash string) {
+	if hash == "" {
+		return
+	}
+	if err := th.bus.ReleasePayload(ctx, hash); err != nil {
+		log.WithError(err).Warn("failed to release payload hash")
+	}
 }
 
-func (th *TaskHandler) startTaskProcessing(ctx context.Context, w http.ResponseWriter, task *domain.Task) {
+// startTaskProcessing stores and enqueues the task, it releases the submit slot taken by the caller
+func (th *TaskHandler) startTaskProcessing(ctx context.Context, task *domain.Task) error {
 	defer func() { <-th.sem }()
 	if err := th.taskService.InsertTask(task); err != nil {
-		http.Error(w, "Failed to insert task", http.StatusInternalServerError)
-		th.metrics.Recorder.IncHTTPResponseStatus(http.StatusInternalServerError)
-		return
+		return Internal("Failed to insert task", err)
 	}
 	if err := th.bus.ProduceTask(ctx, task); err != nil {
+		// the produce error is the one answered, a failed status update is only logged
 		if err := th.taskService.UpdateTaskStatus(task.ID, domain.StatusPending); err != nil {
-			http.Error(w, "Failed to update task status to failed", http.StatusInternalServerError)
-			th.metrics.Recorder.IncHTTPResponseStatus(http.StatusInternalServerError)
+			log.WithError(err).WithField("taskId", task.ID.String()).Error("failed to update status of a task not queued")
 		}
-		http.Error(w, "Failed to produce task", http.StatusInternalServerError)
-		th.metrics.Recorder.IncHTTPResponseStatus(http.StatusInternalServerError)
-		return
+		switch {
+		case errors.Is(err, bus.ErrQueueClosed):
+			return ErrShuttingDown
+		case errors.Is(err, bus.ErrQueueFull):
+			return th.queueFull()
+		default:
+			return Internal("Failed to produce task", err)
+		}
+	}
+	if th.metrics != nil {
+		th.metrics.Recorder.IncTaggedTask(task.Tags)
 	}
-	
+	return nil
 }
 
-func (th *TaskHandler) ResumeTask(w http.ResponseWriter, r *http.Request) {
-	if isShuttingDown.Load() {
-		http.Error(w, "shutting down", http.StatusServiceUnavailable)
-		return
+func (th *TaskHandler) ResumeTask(w http.ResponseWriter, r *http.Request) error {
+	if !isAccepting() {
+		return notAccepting()
 	}
 	taskIDStr := r.URL.Query().Get("id")
 	taskID, err := uuid.Parse(taskIDStr)
 	if err != nil {
-		http.Error(w, "Invalid task ID", http.StatusBadRequest)
-		return
+		return InvalidParam("Invalid task ID")
 	}
 	task, err := th.taskService.GetTaskByID(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get task", http.StatusInternalServerError)
-		return
+		return Internal("Failed to get task", err)
 	}
 	if task == nil {
-		http.Error(w, "Task not found", http.StatusNotFound)
-		return
+		return ErrNotFound.withMessage("Task not found")
 	}
 	switch task.Status {
 	case domain.StatusFailed, domain.StatusPending:
+		// the slot is taken first, a full queue must not leave the task pending with nothing queued
+		select {
+		case th.sem <- struct{}{}:
+		default:
+			return th.queueFull()
+		}
 		if err := th.taskService.UpdateTaskStatus(task.ID, domain.StatusPending); err != nil {
-			http.Error(w, "Failed to update task status", http.StatusInternalServerError)
-			return
+			<-th.sem
+			return Internal("Failed to update task status", err)
+		}
+		if err := th.startTaskProcessing(r.Context(), task); err != nil {
+			return err
 		}
-		th.startTaskProcessing(r.Context(), w, task)
 		w.WriteHeader(http.StatusAccepted)
+		return nil
 	case domain.StatusProcessing:
-		http.Error(w, "Task is already in progress or pending", http.StatusBadRequest)
-		return
+		return InvalidParam("Task is already in progress or pending")
 	case domain.StatusProcessed:
-		http.Error(w, "Task is already processed", http.StatusBadRequest)
-		return
+		return InvalidParam("Task is already processed")
 	default:
-		http.Error(w, "Only failed tasks can be resumed", http.StatusBadRequest)
-		return
+		return InvalidParam("Only failed tasks can be resumed")
+	}
+}
+
+// CancelTask cancels a task which is still waiting in the queue.
+func (th *TaskHandler) CancelTask(w http.ResponseWriter, r *http.Request) error {
+	taskID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return InvalidParam("Invalid task ID")
+	}
+	// the bus would mark any id cancelled, unknown tasks must not get a state
+	task, err := th.taskService.GetTaskByID(taskID)
+	if err != nil {
+		return Internal("Failed to get task", err)
+	}
+	if task == nil {
+		return ErrNotFound.withMessage("Task not found")
+	}
+	cancelled, err := th.bus.CancelTask(r.Context(), taskID)
+	if err != nil {
+		return Internal("Failed to cancel task", err)
+	}
+	if !cancelled {
+		return ErrConflict.withMessage("Task is already active or done")
+	}
+	if err := th.taskService.UpdateTaskStatus(taskID, domain.StatusCancelled); err != nil {
+		return Internal("Failed to update task status", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":     taskID,
+		"status": domain.StatusCancelled,
+	})
+	return nil
+}
+
+// PeekQueue lists the ids of the next n tasks in the queue without consuming them
+func (th *TaskHandler) PeekQueue(w http.ResponseWriter, r *http.Request) error {
+	n, err := queryPositiveInt(r, "n", _defaultListCount, _maxListCount)
+	if err != nil {
+		return err
+	}
+	ids, err := th.bus.Peek(r.Context(), n)
+	if err != nil {
+		return Internal("Failed to peek the queue", err)
+	}
+	if ids == nil {
+		// an empty queue is listed as [], not null
+		ids = []uuid.UUID{}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ids)
+	return nil
+}
+
+// RecentCompletions lists the latest n task completions with their timestamps, newest first
+func (th *TaskHandler) RecentCompletions(w http.ResponseWriter, r *http.Request) error {
+	n, err := queryPositiveInt(r, "n", _defaultListCount, _maxListCount)
+	if err != nil {
+		return err
+	}
+	completions, err := th.bus.RecentCompletions(r.Context(), n)
+	if err != nil {
+		return Internal("Failed to read recent completions", err)
+	}
+	if completions == nil {
+		completions = []bus.Completion{}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(completions)
+	return nil
+}
+
+// parseTags reads "key=value,key2=value2" tags from the X-Task-Tags header or the tags form field
+func parseTags(r *http.Request) (map[string]string, error) {
+	raw := r.Header.Get(_taskTagsHeader)
+	if raw == "" {
+		raw = r.FormValue("tags")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("tags must be comma separated key=value pairs")
+		}
+		if len(key) > _maxTagLength || len(value) > _maxTagLength {
+			return nil, fmt.Errorf("tag keys and values must be at most %d characters", _maxTagLength)
+		}
+		tags[key] = value
+	}
+	if len(tags) > _maxTaskTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", _maxTaskTags)
+	}
+	return tags, nil
+}
+
+// parseDependsOn reads the comma separated ids of tasks which must succeed before this one runs
+func parseDependsOn(r *http.Request) ([]uuid.UUID, error) {
+	raw := r.Header.Get(_taskDependsOnHeader)
+	if raw == "" {
+		raw = r.FormValue("depends_on")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(raw, ",")
+	if len(parts) > _maxTaskDependencies {
+		return nil, fmt.Errorf("at most %d dependencies are allowed", _maxTaskDependencies)
+	}
+	ids := make([]uuid.UUID, 0, len(parts))
+	for _, part := range parts {
+		id, err := uuid.Parse(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("depends_on must be comma separated task ids")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseRunAt reads the time to queue the task at from X-Task-Run-At or run_at (RFC3339),
+// or from X-Task-Delay or delay_seconds as whole seconds from now. A time in the past queues right away.
+func parseRunAt(r *http.Request) (time.Time, error) {
+	runAt := r.Header.Get(_taskRunAtHeader)
+	if runAt == "" {
+		runAt = r.FormValue("run_at")
+	}
+	delay := r.Header.Get(_taskDelayHeader)
+	if delay == "" {
+		delay = r.FormValue("delay_seconds")
+	}
+
+	var at time.Time
+	switch {
+	case runAt != "" && delay != "":
+		return time.Time{}, fmt.Errorf("run_at and delay_seconds are exclusive")
+	case runAt != "":
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(runAt))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("run_at must be an RFC3339 time like 2025-01-02T15:04:05Z")
+		}
+		at = t
+	case delay != "":
+		seconds, err := strconv.Atoi(strings.TrimSpace(delay))
+		if err != nil || seconds < 0 {
+			return time.Time{}, fmt.Errorf("delay_seconds must be a non-negative number of seconds")
+		}
+		at = time.Now().Add(time.Duration(seconds) * time.Second)
+	default:
+		return time.Time{}, nil
+	}
+	if time.Until(at) > _maxTaskDelay {
+		return time.Time{}, fmt.Errorf("tasks can be delayed by at most %s", _maxTaskDelay)
+	}
+	return at, nil
+}
+
+// parseTimeout reads X-Task-Timeout as a duration ("90s") or whole seconds ("90"),
+// values above the configured maximum are clamped to it
+func (th *TaskHandler) parseTimeout(r *http.Request) (time.Duration, error) {
+	raw := strings.TrimSpace(r.Header.Get(_taskTimeoutHeader))
+	if raw == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			return 0, fmt.Errorf("%s must be a duration like 30s or a number of seconds", _taskTimeoutHeader)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%s must be positive", _taskTimeoutHeader)
 	}
+	return min(timeout, th.maxTaskTimeout), nil
 }
@@ -0,0 +1,812 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	chproto "github.com/ClickHouse/ch-go/proto"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/column"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/proto"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"submit_service/internal/bus"
+	"submit_service/internal/domain"
+	"submit_service/internal/metrics"
+	"submit_service/internal/repository"
+	"submit_service/internal/services"
+)
+
+// submit sends a JSON submit through the handler as registered by the API
+func submit(th *TaskHandler) *httptest.ResponseRecorder {
+	return submitWith(th, func(*http.Request) {})
+}
+
+// submitWith is submit with the request changed by modify, e.g. to add headers
+func submitWith(th *TaskHandler, modify func(r *http.Request)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(`{"payload":"x"}`))
+	r.Header.Set("Content-Type", "application/json")
+	modify(r)
+	rec := httptest.NewRecorder()
+	withErrors(th.withStatus(th.SubmitTask))(rec, r)
+	return rec
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body errorEnvelope
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Error.Code
+}
+
+func TestSubmitTaskErrors(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	tests := []struct {
+		name           string
+		handler        func() *TaskHandler
+		state          serverState
+		wantStatus     int
+		wantCode       string
+		wantRetryAfter bool
+	}{
+		{
+			name: "shutting down",
+			handler: func() *TaskHandler {
+				return NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, 0, nil, 0, 0, nil)
+			},
+			state:      stateStopping,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   codeShuttingDown,
+		},
+		{
+			name: "not started",
+			handler: func() *TaskHandler {
+				return NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, 0, nil, 0, 0, nil)
+			},
+			state:      stateUnknown,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   codeStartingUp,
+		},
+		{
+			name: "starting up",
+			handler: func() *TaskHandler {
+				return NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, 0, nil, 0, 0, nil)
+			},
+			state:      stateWarmingUp,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   codeStartingUp,
+		},
+		{
+			name: "queue full",
+			handler: func() *TaskHandler {
+				th := NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, 0, nil, 0, http.StatusTooManyRequests, nil)
+				th.sem = make(chan struct{})
+				return th
+			},
+			state:          stateAccepting,
+			wantStatus:     http.StatusTooManyRequests,
+			wantCode:       codeQueueFull,
+			wantRetryAfter: true,
+		},
+		{
+			name: "rate limited",
+			handler: func() *TaskHandler {
+				limiter := NewRateLimiter(0.001, 1)
+				limiter.Allow()
+				return NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, time.Second, nil, 0, 0, limiter)
+			},
+			state:          stateAccepting,
+			wantStatus:     http.StatusTooManyRequests,
+			wantCode:       codeTooManyRequests,
+			wantRetryAfter: true,
+		},
+		{
+			name:       "not configured",
+			handler:    func() *TaskHandler { return NewTaskHandler(nil, nil, nil, 0, nil, 0, 0, nil) },
+			state:      stateAccepting,
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   codeInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setState(tt.state)
+			rec := submit(tt.handler())
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := errorCode(t, rec); got != tt.wantCode {
+				t.Errorf("code = %q, want %q", got, tt.wantCode)
+			}
+			if got := rec.Header().Get("Retry-After") != ""; got != tt.wantRetryAfter {
+				t.Errorf("Retry-After set = %v, want %v", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestQueueFullStatus(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	tests := []struct {
+		name       string
+		configured int
+		wantStatus int
+	}{
+		{"default", 0, http.StatusServiceUnavailable},
+		{"service unavailable", http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{"too many requests", http.StatusTooManyRequests, http.StatusTooManyRequests},
+		{"unsupported status", http.StatusTeapot, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := metrics.New(nil)
+			th := NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, m, 0, nil, 0, tt.configured, nil)
+			th.sem = make(chan struct{})
+
+			rec := submit(th)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != _queueFullRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, _queueFullRetryAfter)
+			}
+			if got := errorCode(t, rec); got != codeQueueFull {
+				t.Errorf("code = %q, want %q", got, codeQueueFull)
+			}
+			counted := map[int]uint64{
+				http.StatusServiceUnavailable: m.Recorder.GetUnavailableTotal(),
+				http.StatusTooManyRequests:    m.Recorder.GetTooManyRequestsTotal(),
+			}
+			for status, got := range counted {
+				want := uint64(0)
+				if status == tt.wantStatus {
+					want = 1
+				}
+				if got != want {
+					t.Errorf("responses counted with status %d = %d, want %d", status, got, want)
+				}
+			}
+
+			// shutting down is not a full queue, it stays 503 whatever is configured
+			setState(stateStopping)
+			defer setState(stateAccepting)
+			if rec := submit(th); rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status while shutting down = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// produceErrBus fails every ProduceTask with err
+type produceErrBus struct {
+	fakeBus
+	err error
+}
+
+func (b *produceErrBus) ProduceTask(context.Context, *domain.Task) error {
+	return b.err
+}
+
+func TestSubmitProduceErrors(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	stopped := bus.NewProducer(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}), nil, 1)
+	if err := stopped.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		bus        TaskBus
+		wantStatus int
+		wantCode   string
+	}{
+		{"stopped producer", stopped, http.StatusServiceUnavailable, codeShuttingDown},
+		{"queue closed", &produceErrBus{err: bus.ErrQueueClosed}, http.StatusServiceUnavailable, codeShuttingDown},
+		{"queue full", &produceErrBus{err: fmt.Errorf("%w: OOM", bus.ErrQueueFull)}, http.StatusServiceUnavailable, codeQueueFull},
+		{"other", &produceErrBus{err: errors.New("connection reset")}, http.StatusInternalServerError, codeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := NewTaskHandler(services.NewTaskService(repository.NewTaskRepository(nil)), tt.bus, nil, time.Second, nil, 0, 0, nil)
+			rec := submit(th)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := errorCode(t, rec); got != tt.wantCode {
+				t.Errorf("code = %q, want %q", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSetSubmitRateLimit(t *testing.T) {
+	th := NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, time.Second, nil, 0, 0, NewRateLimiter(0.001, 1))
+	api := &API{tasks: th}
+	if err := th.rateLimit(); err != nil {
+		t.Fatalf("first submit: %v", err)
+	}
+	if err := th.rateLimit(); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("second submit error = %v, want ErrRateLimited", err)
+	}
+
+	api.SetSubmitRateLimit(0, 0)
+	for i := 0; i < 10; i++ {
+		if err := th.rateLimit(); err != nil {
+			t.Fatalf("submit %d without a limit: %v", i, err)
+		}
+	}
+}
+
+func TestRequireWorkers(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	fb := &fakeBus{workersErr: bus.ErrNoWorkers}
+	th := NewTaskHandler(services.NewTaskService(nil), fb, nil, time.Second, nil, 0, 0, nil)
+	th.awaitWorkers.Store(true)
+
+	rec := submit(th)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status without workers = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := errorCode(t, rec); got != codeStartingUp {
+		t.Errorf("code without workers = %q, want %q", got, codeStartingUp)
+	}
+
+	fb.workersErr = nil
+	if err := th.workersStarted(context.Background()); err != nil {
+		t.Fatalf("workersStarted with workers = %v", err)
+	}
+	// workers seen once, losing them later is not a startup condition
+	fb.workersErr = bus.ErrNoWorkers
+	if err := th.workersStarted(context.Background()); err != nil {
+		t.Errorf("workersStarted after workers were seen = %v", err)
+	}
+}
+
+// writeBlock answers a query of a test ClickHouse with the rows
+func writeBlock(t *testing.T, w http.ResponseWriter, columns [][2]string, rows ...[]any) {
+	t.Helper()
+	block := proto.NewBlock()
+	for _, col := range columns {
+		if err := block.AddColumn(col[0], column.Type(col[1])); err != nil {
+			t.Fatalf("AddColumn() = %v", err)
+		}
+	}
+	for _, row := range rows {
+		if err := block.Append(row...); err != nil {
+			t.Fatalf("Append() = %v", err)
+		}
+	}
+	buf := new(chproto.Buffer)
+	if err := block.Encode(buf, 54460); err != nil {
+		t.Fatalf("Encode() = %v", err)
+	}
+	w.Write(buf.Buf)
+}
+
+// taskStore is a test ClickHouse keeping tasks, it records the status updates it gets
+type taskStore struct {
+	mux         sync.Mutex
+	tasks       []*domain.Task
+	updates     []string
+	failUpdates bool
+	// failing is the id of a task whose lookup answers with a ClickHouse error
+	failing uuid.UUID
+}
+
+func (s *taskStore) handle(t *testing.T, w http.ResponseWriter, query string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch {
+	case strings.Contains(query, "displayName()"):
+		writeBlock(t, w, [][2]string{{"displayName()", "String"}, {"version()", "String"}, {"revision()", "UInt32"}, {"timezone()", "String"}},
+			[]any{"test", "24.8.1", uint32(54460), "UTC"})
+	case strings.HasPrefix(query, "ALTER TABLE tasks UPDATE"):
+		if s.failUpdates {
+			http.Error(w, "Code: 241. DB::Exception: Memory limit exceeded", http.StatusInternalServerError)
+			return
+		}
+		s.updates = append(s.updates, query)
+	case !strings.HasPrefix(query, "SELECT"):
+	case strings.Contains(query, s.failing.String()):
+		http.Error(w, "Code: 241. DB::Exception: Memory limit exceeded", http.StatusInternalServerError)
+	default:
+		for _, task := range s.tasks {
+			if strings.Contains(query, task.ID.String()) {
+				writeBlock(t, w, [][2]string{{"toString(id)", "String"}, {"status", "String"}, {"payload", "String"}, {"ts", "DateTime64(9)"}},
+					[]any{task.ID.String(), string(task.Status), *task.Payload, time.Now()})
+			}
+		}
+	}
+}
+
+func (s *taskStore) statusUpdates() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return slices.Clone(s.updates)
+}
+
+// testTaskService returns a task service reading and updating the tasks of store
+func testTaskService(t *testing.T, store *taskStore) *services.TaskService {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			query += string(body)
+		}
+		store.handle(t, w, query)
+	}))
+	t.Cleanup(srv.Close)
+	repo, err := repository.NewService(context.Background(), &repository.Config{DSN: srv.Listener.Addr().String()}, nil, nil, make(chan error, 1))
+	if err != nil {
+		t.Fatalf("NewService() = %v", err)
+	}
+	return services.NewTaskService(repository.NewTaskRepository(repo))
+}
+
+// cancelBus records the tasks it was asked to cancel
+type cancelBus struct {
+	fakeBus
+	asked []uuid.UUID
+}
+
+func (b *cancelBus) CancelTask(ctx context.Context, taskID uuid.UUID) (bool, error) {
+	b.asked = append(b.asked, taskID)
+	return b.fakeBus.CancelTask(ctx, taskID)
+}
+
+func TestCancelTask(t *testing.T) {
+	payload := "x"
+	queued := &domain.Task{ID: uuid.New(), Status: domain.StatusProcessing, Payload: &payload}
+	failing := uuid.New()
+	taskSrv := testTaskService(t, &taskStore{tasks: []*domain.Task{queued}, failing: failing})
+
+	tests := []struct {
+		name       string
+		id         string
+		cancelled  bool
+		wantStatus int
+		wantCode   string
+		wantAsked  bool
+	}{
+		{"cancelled", queued.ID.String(), true, http.StatusOK, "", true},
+		{"invalid id", "not-a-uuid", false, http.StatusBadRequest, codeBadRequest, false},
+		{"unknown id", uuid.NewString(), true, http.StatusNotFound, codeNotFound, false},
+		{"lookup fails", failing.String(), true, http.StatusInternalServerError, codeInternal, false},
+		// the bus does not cancel, like a task a worker already picked up
+		{"too late", queued.ID.String(), false, http.StatusConflict, codeConflict, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskBus := &cancelBus{fakeBus: fakeBus{cancelled: tt.cancelled}}
+			th := NewTaskHandler(taskSrv, taskBus, nil, 0, nil, 0, 0, nil)
+			r := httptest.NewRequest(http.MethodDelete, "/tasks/"+tt.id, nil)
+			r.SetPathValue("id", tt.id)
+			rec := httptest.NewRecorder()
+			withErrors(th.CancelTask)(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantCode != "" {
+				if got := errorCode(t, rec); got != tt.wantCode {
+					t.Errorf("code = %q, want %q", got, tt.wantCode)
+				}
+			}
+			if asked := len(taskBus.asked) > 0; asked != tt.wantAsked {
+				t.Errorf("bus asked to cancel = %t, want %t", asked, tt.wantAsked)
+			}
+		})
+	}
+}
+
+func TestResumeTask(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+	payload := "x"
+
+	tests := []struct {
+		name        string
+		queueFull   bool
+		failUpdates bool
+		wantStatus  int
+		wantUpdated bool
+		wantQueued  bool
+	}{
+		{"resumed", false, false, http.StatusAccepted, true, true},
+		// the task stays failed, nothing was queued for it
+		{"queue full", true, false, http.StatusServiceUnavailable, false, false},
+		{"status update fails", false, true, http.StatusInternalServerError, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failed := &domain.Task{ID: uuid.New(), Status: domain.StatusFailed, Payload: &payload}
+			store := &taskStore{tasks: []*domain.Task{failed}, failUpdates: tt.failUpdates}
+			taskBus := &producingBus{}
+			th := NewTaskHandler(testTaskService(t, store), taskBus, nil, 0, nil, 0, 0, nil)
+			if tt.queueFull {
+				th.sem = make(chan struct{})
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/tasks/resume?id="+failed.ID.String(), nil)
+			rec := httptest.NewRecorder()
+			withErrors(th.ResumeTask)(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if updated := len(store.statusUpdates()) > 0; updated != tt.wantUpdated {
+				t.Errorf("status updated = %t, want %t", updated, tt.wantUpdated)
+			}
+			if queued := len(taskBus.produced) > 0; queued != tt.wantQueued {
+				t.Errorf("task queued = %t, want %t", queued, tt.wantQueued)
+			}
+			if len(th.sem) != 0 {
+				t.Errorf("%d queue slots still taken, want the slot released", len(th.sem))
+			}
+		})
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		form    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "header", header: "customer=acme, job = nightly", want: map[string]string{"customer": "acme", "job": "nightly"}},
+		{name: "form field", form: "customer=acme", want: map[string]string{"customer": "acme"}},
+		{name: "header wins over the form", header: "customer=acme", form: "customer=other", want: map[string]string{"customer": "acme"}},
+		{name: "missing value", header: "customer=", wantErr: true},
+		{name: "not a pair", header: "customer", wantErr: true},
+		{name: "too long", header: "customer=" + strings.Repeat("a", _maxTagLength+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader("tags="+tt.form))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				r.Header.Set(_taskTagsHeader, tt.header)
+			}
+			got, err := parseTags(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTags() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("parseTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubmitWithoutDependencies(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	tests := []struct {
+		name    string
+		service *services.TaskService
+		bus     TaskBus
+	}{
+		{"no task service", nil, &fakeBus{}},
+		{"no task bus", services.NewTaskService(nil), nil},
+		{"neither", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := logtest.NewNullLogger()
+			api := New(context.Background(), &Config{}, tt.service, tt.bus, nil, nil, nil, logger)
+			api.loadCancel()
+			if len(hook.AllEntries()) == 0 {
+				t.Error("missing dependencies are not logged at startup")
+			}
+
+			// without metrics too, the request must not panic
+			rec := submit(api.tasks)
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+			}
+			if got := errorCode(t, rec); got != codeInternal {
+				t.Errorf("code = %q, want %q", got, codeInternal)
+			}
+		})
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	th := NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, 5*time.Minute, nil, 0, 0, nil)
+	tests := []struct {
+		name    string
+		header  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "duration", header: "90s", want: 90 * time.Second},
+		{name: "seconds", header: "120", want: 2 * time.Minute},
+		{name: "clamped to the max", header: "1h", want: 5 * time.Minute},
+		{name: "invalid", header: "soon", wantErr: true},
+		{name: "not positive", header: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+			if tt.header != "" {
+				r.Header.Set(_taskTimeoutHeader, tt.header)
+			}
+			got, err := th.parseTimeout(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimeout() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRunAt(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		query   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "delay header", header: _taskDelayHeader, value: "30", want: 30 * time.Second},
+		{name: "delay query", query: "delay_seconds=60", want: time.Minute},
+		{name: "run at header", header: _taskRunAtHeader, value: now.Add(time.Hour).Format(time.RFC3339), want: time.Hour},
+		{name: "run at query", query: "run_at=" + now.Add(2*time.Hour).UTC().Format(time.RFC3339), want: 2 * time.Hour},
+		{name: "run at in the past", header: _taskRunAtHeader, value: now.Add(-time.Hour).Format(time.RFC3339), want: -time.Hour},
+		{name: "both", header: _taskDelayHeader, value: "30", query: "run_at=" + now.Format(time.RFC3339), wantErr: true},
+		{name: "invalid run at", header: _taskRunAtHeader, value: "tomorrow", wantErr: true},
+		{name: "invalid delay", header: _taskDelayHeader, value: "soon", wantErr: true},
+		{name: "negative delay", header: _taskDelayHeader, value: "-5", wantErr: true},
+		{name: "beyond the max delay", header: _taskDelayHeader, value: "90000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit?"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			got, err := parseRunAt(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRunAt() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want == 0 {
+				if !got.IsZero() {
+					t.Errorf("parseRunAt() = %s, want the zero time", got)
+				}
+				return
+			}
+			// RFC3339 drops the sub-second part, so allow a little slack
+			if diff := got.Sub(now) - tt.want; diff < -2*time.Second || diff > 2*time.Second {
+				t.Errorf("parseRunAt() = %s from now, want %s", got.Sub(now), tt.want)
+			}
+		})
+	}
+}
+
+func TestSubmitDelayedTask(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	taskBus := &producingBus{}
+	// a task repository without a service stores nothing, ClickHouse is not needed
+	th := NewTaskHandler(services.NewTaskService(repository.NewTaskRepository(nil)), taskBus, nil, 0, nil, 0, 0, nil)
+	rec := submitWith(th, func(r *http.Request) { r.Header.Set(_taskDelayHeader, "60") })
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	if len(taskBus.produced) != 1 {
+		t.Fatalf("produced %d tasks, want 1", len(taskBus.produced))
+	}
+	if until := time.Until(taskBus.produced[0].RunAt); until < 58*time.Second || until > time.Minute {
+		t.Errorf("run at is %s from now, want about a minute", until)
+	}
+}
+
+func TestSubmitInvalidTimeout(t *testing.T) {
+	setState(stateAccepting)
+	t.Cleanup(func() { setState(stateUnknown) })
+
+	th := NewTaskHandler(services.NewTaskService(nil), &fakeBus{}, nil, time.Minute, nil, 0, 0, nil)
+	rec := submitWith(th, func(r *http.Request) { r.Header.Set(_taskTimeoutHeader, "soon") })
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if got := errorCode(t, rec); got != codeBadRequest {
+		t.Errorf("code = %q, want %q", got, codeBadRequest)
+	}
+}
+
+// dedupBus keeps payload claims in memory with the expiry of the redis keys
+type dedupBus struct {
+	fakeBus
+	claims map[string]payloadClaim
+}
+
+type payloadClaim struct {
+	owner uuid.UUID
+	until time.Time
+}
+
+func (b *dedupBus) ClaimPayload(_ context.Context, hash string, taskID uuid.UUID, window time.Duration) (uuid.UUID, bool, error) {
+	if claim, ok := b.claims[hash]; ok && time.Now().Before(claim.until) {
+		return claim.owner, false, nil
+	}
+	b.claims[hash] = payloadClaim{owner: taskID, until: time.Now().Add(window)}
+	return taskID, true, nil
+}
+
+func (b *dedupBus) ReleasePayload(_ context.Context, hash string) error {
+	delete(b.claims, hash)
+	return nil
+}
+
+func TestDedupWindow(t *testing.T) {
+	const window = 50 * time.Millisecond
+	th := NewTaskHandler(nil, &dedupBus{claims: make(map[string]payloadClaim)}, nil, 0, nil, window, 0, nil)
+	payload := "x"
+	newTask := func() *domain.Task { return &domain.Task{ID: uuid.New(), Payload: &payload} }
+
+	first := newTask()
+	if _, err := th.dedup(context.Background(), first); err != nil {
+		t.Fatalf("dedup() of the first submit = %v", err)
+	}
+
+	_, err := th.dedup(context.Background(), newTask())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict || apiErr.Code != codeDuplicate {
+		t.Fatalf("dedup() within the window = %v, want a 409 %s", err, codeDuplicate)
+	}
+	if got := apiErr.Details["task_id"]; got != first.ID {
+		t.Errorf("duplicate task_id = %v, want the first task %s", got, first.ID)
+	}
+
+	other := "y"
+	if _, err := th.dedup(context.Background(), &domain.Task{ID: uuid.New(), Payload: &other}); err != nil {
+		t.Errorf("dedup() of another payload = %v, want nil", err)
+	}
+
+	time.Sleep(window)
+	if _, err := th.dedup(context.Background(), newTask()); err != nil {
+		t.Errorf("dedup() after the window = %v, want nil", err)
+	}
+}
+
+// peekBus is a queue of task ids which Peek lists without consuming
+type peekBus struct {
+	fakeBus
+	queue []uuid.UUID
+}
+
+func (b *peekBus) Peek(_ context.Context, n int) ([]uuid.UUID, error) {
+	return slices.Clone(b.queue[:min(n, len(b.queue))]), nil
+}
+
+func TestPeekQueue(t *testing.T) {
+	queued := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	tests := []struct {
+		name       string
+		queue      []uuid.UUID
+		auth       string
+		query      string
+		wantStatus int
+		want       string
+	}{
+		{"next tasks", queued, "Bearer secret", "?n=2", http.StatusOK, `["` + queued[0].String() + `","` + queued[1].String() + `"]`},
+		{"default count", queued, "Bearer secret", "", http.StatusOK, `["` + queued[0].String() + `","` + queued[1].String() + `","` + queued[2].String() + `"]`},
+		{"empty queue", nil, "Bearer secret", "", http.StatusOK, `[]`},
+		{"invalid count", queued, "Bearer secret", "?n=0", http.StatusBadRequest, ""},
+		{"count above the max", queued, "Bearer secret", "?n=1001", http.StatusBadRequest, ""},
+		{"without the admin token", queued, "", "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskBus := &peekBus{queue: tt.queue}
+			api := New(context.Background(), &Config{AdminToken: "secret"}, nil, taskBus, nil, nil, nil, log.New())
+			peek := func() *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "/queue/peek"+tt.query, nil)
+				if tt.auth != "" {
+					r.Header.Set("Authorization", tt.auth)
+				}
+				rec := httptest.NewRecorder()
+				api.server.Handler.ServeHTTP(rec, r)
+				return rec
+			}
+
+			rec := peek()
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.want == "" {
+				return
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+			// peeking does not consume, a second peek sees the same tasks
+			if got := strings.TrimSpace(peek().Body.String()); got != tt.want {
+				t.Errorf("second peek = %s, want %s", got, tt.want)
+			}
+			if len(taskBus.queue) != len(tt.queue) {
+				t.Errorf("%d tasks left in the queue, want %d", len(taskBus.queue), len(tt.queue))
+			}
+		})
+	}
+}
+
+// completionsBus lists recent completions, newest first
+type completionsBus struct {
+	fakeBus
+	completions []bus.Completion
+}
+
+func (b *completionsBus) RecentCompletions(_ context.Context, n int) ([]bus.Completion, error) {
+	return slices.Clone(b.completions[:min(n, len(b.completions))]), nil
+}
+
+func TestRecentCompletions(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	first, second := uuid.New(), uuid.New()
+	completions := []bus.Completion{{TaskID: second, CompletedAt: at.Add(time.Second)}, {TaskID: first, CompletedAt: at}}
+	tests := []struct {
+		name        string
+		completions []bus.Completion
+		query       string
+		want        string
+	}{
+		{"newest first", completions, "", `[{"task_id":"` + second.String() + `","completed_at":"2026-01-02T03:04:06Z"},{"task_id":"` + first.String() + `","completed_at":"2026-01-02T03:04:05Z"}]`},
+		{"limited", completions, "?n=1", `[{"task_id":"` + second.String() + `","completed_at":"2026-01-02T03:04:06Z"}]`},
+		{"none", nil, "", `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := New(context.Background(), &Config{AdminToken: "secret"}, nil, &completionsBus{completions: tt.completions}, nil, nil, nil, log.New())
+			r := httptest.NewRequest(http.MethodGet, "/admin/recent"+tt.query, nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			api.server.Handler.ServeHTTP(rec, r)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,71 +2,262 @@ package webapi
 
 import (
 	"context"
+	"errors"
+	"io/fs"
+	"net"
 	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 
+	"submit_service/internal/chaos"
+	"submit_service/internal/domain"
 	"submit_service/internal/metrics"
 	"submit_service/internal/services"
 )
 
 const (
 	_readinessPath    = "/readiness"
+	_healthzPath      = "/healthz"
+	_livezPath        = "/livez"
 	_submitPath       = "/submit"
+	_submitStreamPath = "GET /submit/stream"
 	_metricsPath      = "/metrics"
+	_metricsResetPath = "POST /metrics/reset"
 	_cpuLoadPath      = "/load/cpu"
 	_memoryLoadPath   = "/load/memory"
+	_recentLogsPath   = "/logs/recent"
+	_adminStacksPath  = "/admin/stacks"
+	_chaosPath        = "/chaos"
+	_cancelTaskPath   = "DELETE /tasks/{id}"
+	_queuePeekPath    = "GET /queue/peek"
+	_adminRecentPath  = "GET /admin/recent"
 	_readinessTimeout = 5 * time.Second
+
+	_warmupCheckTimeout  = 2 * time.Second
+	_warmupRetryInterval = time.Second
+	// _defaultWarmupTimeout is used when warmup_timeout is not set
+	_defaultWarmupTimeout = 30 * time.Second
+
+	_queueDepthSampleInterval = 5 * time.Second
 )
 
 type Config struct {
-	Addr string `mapstructure:"addr"`
+	Addr         string `mapstructure:"addr"`
+	AdminToken   string `mapstructure:"admin_token"`
+	ChaosEnabled bool   `mapstructure:"chaos_enabled"`
+	// MaxLoadMB caps a single /load/memory request, MaxTotalLoadMB caps all running memory loads
+	MaxLoadMB      int `mapstructure:"max_load_mb"`
+	MaxTotalLoadMB int `mapstructure:"max_total_load_mb"`
+	// PathPrefix is prepended to every route, e.g. "/shortcut" serves "/shortcut/submit"
+	PathPrefix string `mapstructure:"path_prefix"`
+	// MaxTaskTimeout is the upper bound for timeouts requested with X-Task-Timeout
+	MaxTaskTimeout time.Duration `mapstructure:"max_task_timeout"`
+	// OverflowForwardURL receives submits which do not fit into the queue, empty rejects them with 503
+	OverflowForwardURL string `mapstructure:"overflow_forward_url"`
+	// DisabledEndpoints lists paths without prefix which are not served, e.g. "/load/cpu"
+	DisabledEndpoints []string `mapstructure:"disabled_endpoints"`
+	// WarmupTimeout bounds waiting for the warmup checks, after it the API accepts traffic anyway, 0 uses 30s
+	WarmupTimeout time.Duration `mapstructure:"warmup_timeout"`
+	// ProbeTimeout bounds dependency checks done by /healthz
+	ProbeTimeout time.Duration `mapstructure:"probe_timeout"`
+	// DedupWindow rejects a payload submitted again within the window with 409, 0 disables it
+	DedupWindow time.Duration `mapstructure:"dedup_window"`
+	// UnixSocket is a path the API is also served on for co-located clients, empty disables it
+	UnixSocket string `mapstructure:"unix_socket"`
+	// WarmupTasks is the number of synthetic tasks sent through the queue before
+	// accepting traffic to prime connections, 0 disables it
+	WarmupTasks int `mapstructure:"warmup_tasks"`
+	// HandlerTimeout answers non-streaming requests running longer with 503, 0 disables it
+	HandlerTimeout time.Duration `mapstructure:"handler_timeout"`
+	// RequireWorkers keeps submits answered with 503 until the process service reports running workers
+	RequireWorkers bool `mapstructure:"require_workers"`
+	// QueueFullStatus is 503 (default) or 429 for submits rejected because the queue is full,
+	// shutting down is always answered with 503
+	QueueFullStatus int `mapstructure:"queue_full_status"`
+	// SubmitRateLimit caps accepted submits per second regardless of queue space, excess ones
+	// get 429 with Retry-After, 0 disables it. SubmitBurst defaults to one second worth.
+	SubmitRateLimit float64 `mapstructure:"submit_rate_limit"`
+	SubmitBurst     int     `mapstructure:"submit_burst"`
+	// CORS lets browser clients on other origins call the API, it is off by default
+	CORS CORSConfig `mapstructure:"cors"`
 }
 
 type API struct {
+	ctx    context.Context
 	logger *log.Logger
 	server *http.Server
+	// unixServer serves the same handler on unixSocket, nil when it is not configured
+	unixServer *http.Server
+	unixSocket string
+
+	warmupTimeout time.Duration
+	warmupTasks   int
+	readiness     *ReadinessHandler
+	tasks         *TaskHandler
+	taskBus       TaskBus
+	metrics       *metrics.Service
+	// loadCancel stops synthetic load started by the load handlers
+	loadCancel context.CancelFunc
+	started    atomic.Bool
+	stopOnce   sync.Once
+	stopErr    error
 }
 
-func New(ctx context.Context, conf *Config, taskSrv *services.TaskService, taskBus TaskBus, m *metrics.Service, logger *log.Logger) *API {
-	cpuLoadHandler := NewCPULoadHandler()
-	readinessHandler := NewReadinessHandler()
-	memoryLoadHandler := NewMemoryLoadHandler()
+func New(ctx context.Context, conf *Config, taskSrv *services.TaskService, taskBus TaskBus, m *metrics.Service, logs RecentLogsReader, chaosState *chaos.State, logger *log.Logger) *API {
+	if taskSrv == nil {
+		logger.Error("web api: task service is nil, /submit will respond with 500")
+	}
+	if taskBus == nil {
+		logger.Error("web api: task bus is nil, /submit will respond with 500")
+	}
+	if m == nil {
+		logger.Warn("web api: metrics service is nil, request metrics are disabled")
+	}
+	if conf.QueueFullStatus != 0 && conf.QueueFullStatus != http.StatusServiceUnavailable && conf.QueueFullStatus != http.StatusTooManyRequests {
+		logger.WithField("status", conf.QueueFullStatus).Warn("web api: queue_full_status must be 503 or 429, using 503")
+	}
+
+	loadCtx, loadCancel := context.WithCancel(ctx)
+	cpuLoadHandler := NewCPULoadHandler(loadCtx)
+	readinessHandler := NewReadinessHandler(conf.ProbeTimeout)
+	memoryLoadHandler := NewMemoryLoadHandler(loadCtx, conf.MaxLoadMB, conf.MaxTotalLoadMB)
 	metricsHandler := NewMetricsHandler(taskSrv, m)
-	tasksHandler := NewTaskHandler(taskSrv, taskBus, m)
+	var overflow *OverflowForwarder
+	if conf.OverflowForwardURL != "" {
+		overflow = NewOverflowForwarder(conf.OverflowForwardURL)
+	}
+	tasksHandler := NewTaskHandler(taskSrv, taskBus, m, conf.MaxTaskTimeout, overflow, conf.DedupWindow, conf.QueueFullStatus, NewRateLimiter(conf.SubmitRateLimit, conf.SubmitBurst))
+	tasksHandler.awaitWorkers.Store(conf.RequireWorkers)
+	logsHandler := NewLogsHandler(logs)
+	adminHandler := NewAdminHandler(conf.AdminToken)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc(_submitPath, tasksHandler.SubmitTask)
-	mux.HandleFunc(_readinessPath, readinessHandler.HandleReadiness)
-	mux.HandleFunc(_metricsPath, metricsHandler.LogMetrics)
-	mux.HandleFunc(_cpuLoadPath, cpuLoadHandler.CPULoadHandler)
-	mux.HandleFunc(_memoryLoadPath, memoryLoadHandler.MemoryLoadHandler)
+	rt := newRouter(conf.PathPrefix, conf.DisabledEndpoints, conf.HandlerTimeout)
+	if len(conf.DisabledEndpoints) > 0 {
+		logger.WithField("endpoints", conf.DisabledEndpoints).Info("web api: endpoints are disabled")
+	}
+	rt.handle(_submitPath, withErrors(tasksHandler.withStatus(tasksHandler.SubmitTask)))
+	rt.handleStream(_submitStreamPath, withErrors(tasksHandler.withStatus(tasksHandler.SubmitStream)))
+	rt.handle(_cancelTaskPath, withErrors(tasksHandler.CancelTask))
+	rt.handle(_readinessPath, readinessHandler.HandleReadiness)
+	rt.handle(_healthzPath, readinessHandler.HandleHealth)
+	rt.handle(_livezPath, readinessHandler.HandleLiveness)
+	rt.handle(_metricsPath, withErrors(metricsHandler.LogMetrics))
+	rt.handle(_metricsResetPath, adminHandler.RequireToken(withErrors(metricsHandler.ResetMetrics)))
+	rt.handle(_cpuLoadPath, withErrors(cpuLoadHandler.CPULoadHandler))
+	rt.handle(_memoryLoadPath, withErrors(memoryLoadHandler.MemoryLoadHandler))
+	rt.handle(_recentLogsPath, adminHandler.RequireToken(withErrors(logsHandler.RecentLogs)))
+	rt.handle(_adminStacksPath, adminHandler.RequireToken(adminHandler.Stacks))
+	rt.handle(_queuePeekPath, adminHandler.RequireToken(withErrors(tasksHandler.PeekQueue)))
+	rt.handle(_adminRecentPath, adminHandler.RequireToken(withErrors(tasksHandler.RecentCompletions)))
+	if conf.ChaosEnabled {
+		chaosHandler := NewChaosHandler(chaosState)
+		rt.handle(_chaosPath, adminHandler.RequireToken(chaosHandler.HandleChaos))
+	}
 
 	server := &http.Server{
 		Addr:    conf.Addr,
-		Handler: mux,
+		Handler: instrument(gzipResponses(withCORS(rt.mux, conf.CORS)), m),
+	}
+
+	var unixServer *http.Server
+	if conf.UnixSocket != "" {
+		unixServer = &http.Server{Handler: server.Handler}
+	}
+
+	warmupTimeout := conf.WarmupTimeout
+	if warmupTimeout <= 0 {
+		warmupTimeout = _defaultWarmupTimeout
 	}
 
 	return &API{
-		logger: logger,
-		server: server,
+		ctx:           ctx,
+		logger:        logger,
+		server:        server,
+		unixServer:    unixServer,
+		unixSocket:    conf.UnixSocket,
+		loadCancel:    loadCancel,
+		warmupTimeout: warmupTimeout,
+		warmupTasks:   conf.WarmupTasks,
+		readiness:     readinessHandler,
+		tasks:         tasksHandler,
+		taskBus:       taskBus,
+		metrics:       m,
 	}
 }
 
+// SetSubmitRateLimit applies a reloaded submit_rate_limit and submit_burst, the
+// token bucket starts full again.
+func (api *API) SetSubmitRateLimit(rate float64, burst int) {
+	api.tasks.SetLimiter(NewRateLimiter(rate, burst))
+}
+
+// AddWarmupCheck registers a dependency check, readiness fails until all checks pass
+// and /healthz reports it. It must be called before Start.
+func (api *API) AddWarmupCheck(name string, check func(ctx context.Context) error) {
+	api.readiness.checks = append(api.readiness.checks, dependencyCheck{name: name, check: check})
+}
+
+// Start serves HTTP in a goroutine, calls after the first one are no-ops.
 func (api *API) Start() {
+	if !api.started.CompareAndSwap(false, true) {
+		api.logger.Warn("web api is already started")
+		return
+	}
 	api.logger.Infof("Server started on %s", api.server.Addr)
 	api.logger.Info("Try: hey -n 15000 -c 100 http://localhost:8080/submit")
+	setState(stateWarmingUp)
+	go api.warmup()
+	if api.taskBus != nil && api.metrics != nil {
+		go sampleQueueDepth(api.ctx, api.taskBus, api.metrics.Recorder, _queueDepthSampleInterval)
+	}
 	go func() {
 		err := api.server.ListenAndServe()
 		if err != nil {
 			api.logger.WithError(err).Error("HTTP server error")
 		}
 	}()
+	if api.unixServer != nil {
+		api.serveUnix()
+	}
+}
+
+// serveUnix serves the API on the unix socket, a stale socket file of a previous run is replaced
+func (api *API) serveUnix() {
+	if err := os.Remove(api.unixSocket); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		api.logger.WithError(err).WithField("socket", api.unixSocket).Error("failed to remove stale unix socket")
+		return
+	}
+	ln, err := net.Listen("unix", api.unixSocket)
+	if err != nil {
+		api.logger.WithError(err).WithField("socket", api.unixSocket).Error("failed to listen on unix socket")
+		return
+	}
+	api.logger.Infof("Server started on unix socket %s", api.unixSocket)
+	go func() {
+		// Serve closes the listener, which removes the socket file
+		if err := api.unixServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			api.logger.WithError(err).Error("unix socket server error")
+		}
+	}()
 }
 
+// Stop drains and shuts down the server. It is safe to call more than once and
+// concurrently, later calls wait for the first one and return its result.
 func (api *API) Stop(ctx context.Context) error {
-	isShuttingDown.Store(true)
+	api.stopOnce.Do(func() {
+		api.stopErr = api.stop(ctx)
+	})
+	return api.stopErr
+}
+
+func (api *API) stop(ctx context.Context) error {
+	setState(stateDraining)
+	api.loadCancel()
 	api.logger.Info("Readiness probe set to unhealthy, waiting for traffic to drain...")
 
 	// Give some time for LB/Kubernetes to detect the probe failure and stop sending new traffic.
@@ -76,6 +267,12 @@ func (api *API) Stop(ctx context.Context) error {
 	case <-ctx.Done():
 	}
 
+	setState(stateStopping)
+	if api.unixServer != nil {
+		if err := api.unixServer.Shutdown(ctx); err != nil {
+			api.logger.WithError(err).Error("Failed to shut down unix socket server")
+		}
+	}
 	err := api.server.Shutdown(ctx)
 	if err != nil {
 		api.logger.WithError(err).Error("Failed to shut down server")
@@ -84,3 +281,58 @@ func (api *API) Stop(ctx context.Context) error {
 	api.logger.Info("Server shut down")
 	return nil
 }
+
+// warmup retries the warmup checks until all of them pass and then starts accepting traffic
+func (api *API) warmup() {
+	ctx, cancel := context.WithTimeout(api.ctx, api.warmupTimeout)
+	defer cancel()
+
+	pending := api.readiness.checks
+	for len(pending) > 0 {
+		failed := pending[:0:0]
+		for _, c := range pending {
+			if err := c.run(ctx, _warmupCheckTimeout); err != nil {
+				api.logger.WithError(err).WithField("check", c.name).Warn("warmup check failed, retrying")
+				failed = append(failed, c)
+			}
+		}
+		pending = failed
+		if len(pending) == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			if api.ctx.Err() != nil {
+				return
+			}
+			api.logger.WithField("timeout", api.warmupTimeout).Error("warmup timed out, accepting traffic anyway")
+			pending = nil
+		case <-time.After(_warmupRetryInterval):
+		}
+	}
+
+	api.submitWarmupTasks(api.ctx)
+
+	if finishWarmup() {
+		api.logger.Info("Warmup finished, accepting traffic")
+	}
+}
+
+// submitWarmupTasks enqueues the synthetic warmup tasks, failures are logged and do not block startup
+func (api *API) submitWarmupTasks(ctx context.Context) {
+	if api.warmupTasks <= 0 || api.taskBus == nil {
+		return
+	}
+	payload := "warmup"
+	submitted := 0
+	for range api.warmupTasks {
+		task := &domain.Task{ID: uuid.New(), Status: domain.StatusPending, Payload: &payload, Warmup: true}
+		if err := api.taskBus.ProduceTask(ctx, task); err != nil {
+			api.logger.WithError(err).Warn("failed to submit warmup task, skipping the rest")
+			break
+		}
+		submitted++
+	}
+	api.logger.WithField("tasks", submitted).Info("warmup tasks submitted")
+}
@@ -0,0 +1,219 @@
+package webapi
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"submit_service/internal/domain"
+	"submit_service/internal/repository"
+	"submit_service/internal/services"
+)
+
+func TestStopEndsLoad(t *testing.T) {
+	t.Cleanup(func() { setState(stateUnknown) })
+	before := runtime.NumGoroutine()
+
+	api := New(context.Background(), &Config{}, nil, nil, nil, nil, nil, log.New())
+	for _, path := range []string{_cpuLoadPath + "?workers=4&seconds=3600", _memoryLoadPath + "?mb=1&seconds=3600"} {
+		rec := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("POST %s status = %d, want %d", path, rec.Code, http.StatusAccepted)
+		}
+	}
+	if runtime.NumGoroutine() <= before {
+		t.Fatal("no load goroutines are running")
+	}
+
+	// a done context skips the drain wait, the load must not wait for its hour either
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	api.Stop(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running after Stop, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	t.Cleanup(func() { setState(stateUnknown) })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	logger, hook := logtest.NewNullLogger()
+	api := New(context.Background(), &Config{Addr: addr}, nil, nil, nil, nil, nil, logger)
+	api.Start()
+	api.Start()
+	t.Cleanup(func() {
+		// a done context skips the drain wait
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		api.Stop(ctx)
+	})
+
+	// a second listener on the same address would fail and log a server error
+	deadline := time.Now().Add(time.Second)
+	for {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server is not listening on %s", addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	warnings := 0
+	for _, entry := range hook.AllEntries() {
+		switch entry.Message {
+		case "web api is already started":
+			warnings++
+		case "HTTP server error":
+			t.Errorf("second Start served again: %v", entry.Data[log.ErrorKey])
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("%d already started warnings, want 1", warnings)
+	}
+}
+
+func TestConcurrentStop(t *testing.T) {
+	t.Cleanup(func() { setState(stateUnknown) })
+	logger, hook := logtest.NewNullLogger()
+	api := New(context.Background(), &Config{}, nil, nil, nil, nil, nil, logger)
+
+	// a done context skips the drain wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- api.Stop(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Stop() = %v, want nil", err)
+		}
+	}
+	shutdowns := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Server shut down" {
+			shutdowns++
+		}
+	}
+	if shutdowns != 1 {
+		t.Errorf("server shut down %d times, want 1", shutdowns)
+	}
+}
+
+// producingBus records the tasks it is given
+type producingBus struct {
+	fakeBus
+	mux      sync.Mutex
+	produced []*domain.Task
+}
+
+func (b *producingBus) ProduceTask(_ context.Context, task *domain.Task) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.produced = append(b.produced, task)
+	return nil
+}
+
+func TestSubmitOverUnixSocket(t *testing.T) {
+	t.Cleanup(func() { setState(stateUnknown) })
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	taskBus := &producingBus{}
+	// a task repository without a service stores nothing, ClickHouse is not needed
+	taskSrv := services.NewTaskService(repository.NewTaskRepository(nil))
+	api := New(context.Background(), &Config{Addr: "127.0.0.1:0", UnixSocket: socket}, taskSrv, taskBus, nil, nil, nil, log.New())
+	api.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for !isAccepting() {
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %s, want %s", getState(), stateAccepting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Post("http://unix"+_submitPath, "application/json", strings.NewReader(`{"payload":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	taskBus.mux.Lock()
+	if len(taskBus.produced) != 1 {
+		t.Errorf("%d tasks enqueued, want 1", len(taskBus.produced))
+	}
+	taskBus.mux.Unlock()
+
+	// a done context skips the drain wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	api.Stop(ctx)
+	if _, err := os.Stat(socket); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket file after Stop: %v, want it removed", err)
+	}
+}
+
+func TestSubmitWarmupTasks(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	taskBus := &producingBus{}
+	api := New(context.Background(), &Config{Addr: "127.0.0.1:0", WarmupTasks: 3}, nil, taskBus, nil, nil, nil, logger)
+
+	api.submitWarmupTasks(context.Background())
+
+	if len(taskBus.produced) != 3 {
+		t.Fatalf("%d warmup tasks enqueued, want 3", len(taskBus.produced))
+	}
+	for _, task := range taskBus.produced {
+		if !task.Warmup {
+			t.Errorf("task %s is not flagged as warmup", task.ID)
+		}
+		if task.Status != domain.StatusPending {
+			t.Errorf("task %s status = %s, want %s", task.ID, task.Status, domain.StatusPending)
+		}
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "warmup tasks submitted" || entry.Data["tasks"] != 3 {
+		t.Errorf("last log entry = %v, want warmup tasks submitted with 3 tasks", entry)
+	}
+}
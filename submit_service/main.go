@@ -13,6 +13,7 @@ import (
 	"go.uber.org/dig"
 
 	"submit_service/internal/bus"
+	"submit_service/internal/chaos"
 	"submit_service/internal/config"
 	"submit_service/internal/metrics"
 	"submit_service/internal/repository"
@@ -27,11 +28,16 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	container := dig.New()
 
 	container.Provide(ProvideConfig)
 	container.Provide(ProvideErrorsChan)
 	container.Provide(ProvideBaseContext)
+	container.Provide(chaos.New)
 	container.Provide(ProvideLogger)
 	container.Provide(ProvideRepository)
 	container.Provide(ProvideRedisClient)
@@ -43,15 +49,25 @@ func main() {
 	// the dependencies will stop in the order they were registered in the stoppables group
 	// should stop them in this order to ensure no data loss:
 	// webapi.API: Stop receiving new traffic (using the readiness logic we just added).
+	// bus.Producer: Close the queue once no submit is in flight.
+	// repository.Service: Let the last ClickHouse writes finish.
 	// metrics.Service: Stop the metrics server only after everything else is done.
 	container.Provide(func(api *webapi.API) Stoppable { return api }, dig.Group("stoppables"))
+	container.Provide(func(p *bus.Producer) Stoppable { return p }, dig.Group("stoppables"))
+	container.Provide(func(repo *repository.Service) Stoppable { return repo }, dig.Group("stoppables"))
 	container.Provide(func(m *metrics.Service) Stoppable { return m }, dig.Group("stoppables"))
 
 	if err := container.Invoke(func(ctx context.Context, args RunArgs) {
 		defer stop(ctx, args.Stop)
 
 		args.Repo.Start()
+		args.M.API.AddHealthCheck("clickhouse", metrics.CheckHealth(args.Repo.Client.Ping))
+		args.M.API.AddHealthCheck("redis", metrics.CheckHealth(args.Producer.Ping))
 		args.API.Start()
+		args.Producer.StartScheduler(args.Conf.RedisConf.ScheduleInterval)
+		if config.RemoteConfigured() {
+			go config.WatchRemote(ctx, config.DefaultRemoteWatchInterval, hotReload(args.Conf, args.API))
+		}
 
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
@@ -74,10 +90,12 @@ func main() {
 
 type RunArgs struct {
 	dig.In
-	Repo   *repository.Service
-	M    *metrics.Service
-	API  *webapi.API
-	Stop StopArgs
+	Repo     *repository.Service
+	M        *metrics.Service
+	API      *webapi.API
+	Producer *bus.Producer
+	Conf     *config.AppConfig
+	Stop     StopArgs
 }
 
 type Stoppable interface {
@@ -104,6 +122,21 @@ func stop(ctx context.Context, args StopArgs) {
 	}
 }
 
+// hotReload applies the fields of a re-read config which can change at runtime,
+// currently the submit rate limit. The applied settings belong to the watcher,
+// the config shared with the rest of the service is not written.
+func hotReload(current *config.AppConfig, api *webapi.API) func(*config.AppConfig) {
+	rate, burst := current.WebAPI.SubmitRateLimit, current.WebAPI.SubmitBurst
+	return func(conf *config.AppConfig) {
+		if conf.WebAPI == nil || (conf.WebAPI.SubmitRateLimit == rate && conf.WebAPI.SubmitBurst == burst) {
+			return
+		}
+		rate, burst = conf.WebAPI.SubmitRateLimit, conf.WebAPI.SubmitBurst
+		log.WithFields(log.Fields{"rate": rate, "burst": burst}).Info("submit rate limit changed, reloading")
+		api.SetSubmitRateLimit(rate, burst)
+	}
+}
+
 func ProvideBaseContext() context.Context {
 	return context.Background()
 }
@@ -113,6 +146,7 @@ func ProvideConfig() *config.AppConfig {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	log.WithFields(conf.Summary()).Info("Config loaded")
 	return conf
 }
 
@@ -120,14 +154,19 @@ func ProvideErrorsChan() chan error {
 	return make(chan error, 1)
 }
 
-func ProvideRepository(ctx context.Context, conf *config.AppConfig, m *metrics.Service, errCh chan error) (*repository.Service, error) {
-	return repository.NewService(ctx, conf.RepoConf, m, errCh)
+func ProvideRepository(ctx context.Context, conf *config.AppConfig, m *metrics.Service, chaosState *chaos.State, errCh chan error) (*repository.Service, error) {
+	return repository.NewService(ctx, conf.RepoConf, m, chaosState, errCh)
 }
 
-func ProvideLogger(ch *repository.Service) *log.Logger {
+func ProvideLogger(conf *config.AppConfig, ch *repository.Service) *log.Logger {
 	logger := log.New()
 	logger.SetLevel(log.DebugLevel)
-	hook := repository.NewLogHook(ch.Client)
+	// without shipping the hook still keeps recent logs in memory
+	client := ch.Client
+	if !conf.RepoConf.ShipLogs() {
+		client = nil
+	}
+	hook := repository.NewLogHook(client, ch.RecentLogs)
 	logger.AddHook(hook)
 	return logger
 }
@@ -143,14 +182,16 @@ func ProvideRedisClient(conf *config.AppConfig) *redis.Client {
 	return redis.NewClient(&redis.Options{Addr: conf.RedisConf.RedisAddr})
 }
 
-func ProvideTaskProducer(redisClient *redis.Client) *bus.Producer {
-	return bus.NewProducer(redisClient)
+func ProvideTaskProducer(conf *config.AppConfig, redisClient *redis.Client, chaosState *chaos.State) *bus.Producer {
+	return bus.NewProducer(redisClient, chaosState, conf.RedisConf.Shards)
 }
 
 func ProvideTaskService(repo *repository.Service) *services.TaskService {
 	return services.NewTaskService(repository.NewTaskRepository(repo))
 }
 
-func ProvideWebAPI(ctx context.Context, conf *config.AppConfig, taskSrv *services.TaskService, producer *bus.Producer, m *metrics.Service, logger *log.Logger) *webapi.API {
-	return webapi.New(ctx, conf.WebAPI, taskSrv, producer, m, logger)
+func ProvideWebAPI(ctx context.Context, conf *config.AppConfig, taskSrv *services.TaskService, producer *bus.Producer, m *metrics.Service, repo *repository.Service, chaosState *chaos.State, logger *log.Logger) *webapi.API {
+	api := webapi.New(ctx, conf.WebAPI, taskSrv, producer, m, repo.RecentLogs, chaosState, logger)
+	api.AddWarmupCheck("clickhouse", repo.Client.Ping)
+	return api
 }
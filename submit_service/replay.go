@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"submit_service/internal/config"
+	"submit_service/internal/repository"
+)
+
+const _replayProgressEvery = 1000
+
+// runReplay backfills ClickHouse from a JSONL file written while ClickHouse was unavailable,
+// usage: submit_service replay --file logs.jsonl --table logs
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := fs.String("file", "", "JSONL file to replay")
+	table := fs.String("table", "logs", "target table, logs or metrics")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		log.Error("replay: --file is required")
+		return 2
+	}
+
+	conf, err := config.GetConf()
+	if err != nil {
+		log.WithError(err).Error("replay: failed to load config")
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	client, err := repository.NewClient(ctx, conf.RepoConf, nil)
+	if err != nil {
+		log.WithError(err).Error("replay: failed to create ClickHouse client")
+		return 1
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.WithError(err).Error("replay: failed to open file")
+		return 1
+	}
+	defer f.Close()
+
+	stats, err := client.Replay(ctx, *table, f, _replayProgressEvery, func(s repository.ReplayStats) {
+		log.WithFields(log.Fields{"lines": s.Lines, "inserted": s.Inserted, "skipped": s.Skipped, "failed": s.Failed}).Info("replay progress")
+	})
+	entry := log.WithFields(log.Fields{"file": *file, "table": *table, "lines": stats.Lines, "inserted": stats.Inserted, "skipped": stats.Skipped, "failed": stats.Failed})
+	if err != nil {
+		entry.WithError(err).Error("replay stopped")
+		return 1
+	}
+	entry.Info("replay finished")
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}